@@ -0,0 +1,459 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrNotInFlight is returned by Ack and Nack for a message that is not
+// awaiting acknowledgement, for instance one acknowledged already
+var ErrNotInFlight = errors.New("message not in flight")
+
+// AckBatchError is returned by AckBatch when some handles were no longer
+// in flight. The others were acknowledged. It matches ErrNotInFlight.
+type AckBatchError struct {
+	Name   string      // Queue name
+	Failed []AckHandle // Handles that were not acknowledged, in batch order
+}
+
+func (e *AckBatchError) Error() string {
+	return "nabd ack " + e.Name + ": " + strconv.Itoa(len(e.Failed)) + " messages not in flight"
+}
+
+// Is reports whether target is ErrNotInFlight
+func (e *AckBatchError) Is(target error) bool {
+	return target == ErrNotInFlight
+}
+
+// DefaultVisibilityTimeout is how long a message popped with PopNoAck
+// stays hidden before it is delivered again, unless WithVisibilityTimeout
+// says otherwise
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// AckHandle identifies a message popped with PopNoAck
+type AckHandle struct {
+	pos        uint64 // ring position of the message
+	deliveries uint64 // times it was delivered, this one included
+}
+
+// Position returns the ring position of the message, which identifies it
+// in logs
+func (m AckHandle) Position() uint64 {
+	return m.pos
+}
+
+// Redeliveries returns how often the message was delivered before this
+// time, after a Nack or a visibility timeout. It is 0 on first delivery,
+// so a consumer can dead-letter a message once it reaches a limit.
+func (m AckHandle) Redeliveries() int {
+	return int(m.deliveries) - 1
+}
+
+// The in-flight table lives in a shared-memory file next to the queue,
+// <name>.ack, so a restarted consumer picks up where the crashed one
+// stopped. It holds a 64-byte header followed by one entry per slot:
+//
+//	header: magic, capacity, deliver (next position never delivered),
+//	        heartbeat (Unix ns of the consumer's latest call)
+//	entry:  position + 1 (0 if unused), visibility deadline in Unix ns,
+//	        deliveries so far, ackNacked if a Nack set the deadline
+//
+// A deadline of ackDone marks a message acknowledged but not yet
+// released, because an older one is still in flight.
+const (
+	ackMagic   = 0x324b43414442414e // "NABDACK2" in little-endian order
+	ackHeader  = 64
+	ackEntry   = 32
+	ackDone    = ^uint64(0)
+	ackDeliver = 16
+	ackBeat    = 24
+	ackNacked  = 1
+)
+
+// ackState is a consumer's view of the in-flight table
+type ackState struct {
+	mu sync.Mutex
+	table
+	cap     uint64
+	timeout time.Duration
+}
+
+// ackPath returns the in-flight table file of queue name
+func ackPath(name string) string {
+	return sideDir + name + ".ack"
+}
+
+// openAck maps the in-flight table of q, creating it if needed
+func openAck(name string, capacity uint64, timeout time.Duration) (*ackState, error) {
+	size := int64(ackHeader + ackEntry*capacity)
+	t, err := openTable("open ack", name, ackPath(name), ackMagic, size, func(t table) {
+		t.store(8, capacity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(t)) != size || t.load(8) != capacity {
+		t.close()
+		return nil, &QueueError{Op: "open ack", Name: name, Errno: syscall.EINVAL}
+	}
+	return &ackState{table: t, cap: capacity, timeout: timeout}, nil
+}
+
+// entry returns the offset of the table entry for position pos
+func (a *ackState) entry(pos uint64) uint64 {
+	return ackHeader + ackEntry*(pos%a.cap)
+}
+
+// inFlight reports whether pos has an entry, and returns its deadline
+func (a *ackState) inFlight(pos uint64) (uint64, bool) {
+	e := a.entry(pos)
+	if a.load(e) != pos+1 {
+		return 0, false
+	}
+	return a.load(e + 8), true
+}
+
+func (a *ackState) mark(pos, deadline uint64) {
+	e := a.entry(pos)
+	a.store(e+8, deadline)
+	a.store(e+24, 0)
+	a.store(e, pos+1)
+}
+
+// deliver marks pos in flight until deadline for one more delivery and
+// returns its delivery count
+func (a *ackState) deliver(pos, deadline uint64) uint64 {
+	e := a.entry(pos)
+	var n uint64 = 1
+	if _, ok := a.inFlight(pos); ok {
+		n = a.load(e+16) + 1
+	}
+	a.store(e+16, n)
+	a.mark(pos, deadline)
+	return n
+}
+
+// holds reports whether m is the latest delivery of a message still in
+// flight. A handle whose visibility timeout ran out no longer holds its
+// message once it was delivered again.
+func (a *ackState) holds(m AckHandle) bool {
+	deadline, ok := a.inFlight(m.pos)
+	return ok && deadline != ackDone && a.load(a.entry(m.pos)+16) == m.deliveries
+}
+
+// nacked reports whether the deadline of pos was set by Nack
+func (a *ackState) nacked(pos uint64) bool {
+	return a.load(a.entry(pos)+24) == ackNacked
+}
+
+// beat records that the acking consumer is alive
+func (a *ackState) beat() {
+	a.store(ackBeat, uint64(time.Now().UnixNano()))
+}
+
+// acks returns the handle's in-flight table, mapping it on first use
+func (q *Queue) acks(h *C.nabd_t) (*ackState, error) {
+	q.ackMu.Lock()
+	defer q.ackMu.Unlock()
+
+	if q.ack != nil {
+		return q.ack, nil
+	}
+	var stats C.nabd_stats_t
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return nil, failure("stats", q.name, ret, nil)
+	}
+	a, err := openAck(q.name, uint64(stats.capacity), q.visibility)
+	if err != nil {
+		return nil, err
+	}
+	q.ack = a
+	return a, nil
+}
+
+// PopNoAck returns the next message without consuming it, for
+// at-least-once delivery. The message stays in the ring, hidden from
+// further PopNoAck calls, until Ack consumes it. If it is neither acked
+// nor nacked within the visibility timeout it is delivered again, to this
+// or a restarted consumer. Expired messages are redelivered before new
+// ones.
+//
+// In-flight state is kept in a shared-memory file next to the queue, so it
+// survives a consumer crash. Unacked messages occupy ring slots, so a
+// producer sees ErrFull once capacity messages are in flight or queued.
+// A queue supports one acking consumer at a time, which must not mix in
+// plain pops. PopNoAck fails with EINVAL on Broadcast and overwrite
+// queues.
+func (q *Queue) PopNoAck(maxLen int) ([]byte, AckHandle, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, AckHandle{}, err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return nil, AckHandle{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.beat()
+
+	for {
+		var stats C.nabd_stats_t
+		if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+			return nil, AckHandle{}, failure("stats", q.name, ret, nil)
+		}
+		tail, head := uint64(stats.tail), uint64(stats.head)
+		deliver := max(a.load(ackDeliver), tail)
+		now := uint64(time.Now().UnixNano())
+
+		// Redeliver a timed out message, or else deliver a new one
+		pos, found := deliver, false
+		for p := tail; p < deliver; p++ {
+			if deadline, ok := a.inFlight(p); !ok || deadline <= now {
+				pos, found = p, true
+				break
+			}
+		}
+		if !found && deliver == head {
+			return nil, AckHandle{}, ErrEmpty
+		}
+
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek_at(h, C.uint64_t(pos), &data, &size)
+		if ret == C.NABD_EMPTY {
+			return nil, AckHandle{}, ErrEmpty
+		} else if ret != C.NABD_OK {
+			return nil, AckHandle{}, failure("pop", q.name, ret, nil)
+		}
+		if int(size) > maxLen+q.env.size() {
+			return nil, AckHandle{}, ErrTooBig
+		}
+		raw := C.GoBytes(data, C.int(size))
+
+		// Messages are delivered in order, so one not due holds back the rest
+		if !found && q.env.holdback(raw) > 0 {
+			return nil, AckHandle{}, ErrEmpty
+		}
+		if !q.env.fits(raw, maxLen) {
+			return nil, AckHandle{}, ErrTooBig
+		}
+		if !found {
+			a.store(ackDeliver, pos+1)
+		}
+		msg, st, err := q.env.open(raw, maxLen)
+		if err != nil {
+			q.deadLetter(DeadCorrupt, raw)
+			q.settle(h, a, pos)
+			return nil, AckHandle{}, err
+		}
+		if q.env.expired(st) {
+			q.expire(msg)
+			q.settle(h, a, pos)
+			continue
+		}
+
+		n := a.deliver(pos, now+uint64(a.timeout))
+		q.latency.observe(st)
+		return msg, AckHandle{pos: pos, deliveries: n}, nil
+	}
+}
+
+// Ack consumes a message popped with PopNoAck. Its slot is handed back to
+// the producer once every older message has been acked too. A handle
+// whose message was delivered again after its visibility timeout gets
+// ErrNotInFlight, so only the latest delivery settles the message.
+func (q *Queue) Ack(m AckHandle) error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.beat()
+
+	if !a.holds(m) {
+		return ErrNotInFlight
+	}
+	q.settle(h, a, m.pos)
+	return nil
+}
+
+// AckBatch consumes several messages popped with PopNoAck at once, taking
+// the table lock and releasing slots to the producer in a single pass
+//
+// A handle that is no longer in flight, because it was acked already or
+// its message was redelivered since, does not stop the others: they are all acknowledged and a *AckBatchError lists the ones
+// that were not.
+func (q *Queue) AckBatch(handles []AckHandle) error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.beat()
+
+	var failed []AckHandle
+	for _, m := range handles {
+		if !a.holds(m) {
+			failed = append(failed, m)
+			continue
+		}
+		a.mark(m.pos, ackDone)
+	}
+	q.releaseDone(h, a)
+
+	if failed != nil {
+		return &AckBatchError{Name: q.name, Failed: failed}
+	}
+	return nil
+}
+
+// Nack hands a message popped with PopNoAck back for redelivery once
+// delay has passed, for failures worth retrying later. A zero delay makes
+// it visible again at once; a delay keeps a poison message from being
+// redelivered in a tight loop.
+//
+// The delay replaces what is left of the visibility timeout, whether it
+// is shorter or longer, and ReclaimStale leaves a nacked message alone,
+// so it is not redelivered before the delay is up. Like a message pushed
+// with PushDelayed it still holds its ring slot while it waits, and the
+// handle's Redeliveries count grows with each delivery.
+func (q *Queue) Nack(m AckHandle, delay time.Duration) error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.beat()
+
+	if !a.holds(m) {
+		return ErrNotInFlight
+	}
+	var until uint64
+	if delay > 0 {
+		until = uint64(time.Now().Add(delay).UnixNano())
+	}
+	e := a.entry(m.pos)
+	a.store(e+8, until)
+	a.store(e+24, ackNacked)
+	return nil
+}
+
+// Heartbeat tells ReclaimStale that this consumer is alive without popping
+// or acknowledging anything. PopNoAck, Ack and Nack do the same, so only a
+// handler that holds a message for longer than the reclaim timeout needs
+// to call it.
+func (q *Queue) Heartbeat() error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return err
+	}
+	a.beat()
+	return nil
+}
+
+// ReclaimStale makes the messages held by a dead acking consumer visible
+// again at once, rather than after their visibility timeout, and returns
+// their handles for logging
+//
+// The consumer counts as dead when its latest PopNoAck, Ack, Nack or
+// Heartbeat is more than timeout ago. Pick a timeout well above the
+// longest a handler takes, or a slow consumer loses its messages to a
+// redelivery. ReclaimStale does not count as a heartbeat, so it can run
+// from a supervisor, or from a restarted consumer before its first
+// PopNoAck. Messages waiting out a Nack delay are left alone. The
+// returned handles can still be passed to Ack.
+func (q *Queue) ReclaimStale(timeout time.Duration) ([]AckHandle, error) {
+	if timeout <= 0 {
+		return nil, &QueueError{Op: "reclaim", Name: q.name, Errno: syscall.EINVAL}
+	}
+	h, err := q.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	a, err := q.acks(h)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := uint64(time.Now().UnixNano())
+	if beat := a.load(ackBeat); beat > now || now-beat <= uint64(timeout) {
+		return nil, nil
+	}
+
+	var stats C.nabd_stats_t
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return nil, failure("stats", q.name, ret, nil)
+	}
+	var reclaimed []AckHandle
+	for p := uint64(stats.tail); p < a.load(ackDeliver); p++ {
+		if deadline, ok := a.inFlight(p); ok && deadline != ackDone && deadline > now && !a.nacked(p) {
+			e := a.entry(p)
+			a.store(e+8, 0)
+			reclaimed = append(reclaimed, AckHandle{pos: p, deliveries: a.load(e + 16)})
+		}
+	}
+	return reclaimed, nil
+}
+
+// settle marks pos done and releases every done message at the tail
+func (q *Queue) settle(h *C.nabd_t, a *ackState, pos uint64) {
+	a.mark(pos, ackDone)
+	q.releaseDone(h, a)
+}
+
+// releaseDone releases every done message at the tail
+func (q *Queue) releaseDone(h *C.nabd_t, a *ackState) {
+	var stats C.nabd_stats_t
+	if C.nabd_stats(h, &stats) != C.NABD_OK {
+		return
+	}
+	for tail := uint64(stats.tail); ; tail++ {
+		if deadline, ok := a.inFlight(tail); !ok || deadline != ackDone {
+			return
+		}
+		a.store(a.entry(tail), 0)
+		C.nabd_release(h)
+	}
+}
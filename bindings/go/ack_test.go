@@ -0,0 +1,341 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAck(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithVisibilityTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	for _, m := range []string{"a", "b", "c"} {
+		q.Push([]byte(m))
+	}
+
+	_, ha, err := q.PopNoAck(8)
+	if err != nil {
+		t.Fatalf("PopNoAck failed: %v", err)
+	}
+	msg, hb, _ := q.PopNoAck(8)
+	if string(msg) != "b" {
+		t.Errorf("Expected b, got %s", msg)
+	}
+
+	// Acking out of order frees slots only once the oldest is acked
+	if err := q.Ack(hb); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if err := q.Ack(hb); err != ErrNotInFlight {
+		t.Errorf("Expected ErrNotInFlight on double Ack, got %v", err)
+	}
+	if n, _ := q.Len(); n != 3 {
+		t.Errorf("Expected 3 slots held, got %d", n)
+	}
+
+	// Nack makes a visible again at once
+	if err := q.Nack(ha, 0); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+	msg, ha, _ = q.PopNoAck(8)
+	if string(msg) != "a" {
+		t.Errorf("Expected a redelivered after Nack, got %s", msg)
+	}
+	q.Ack(ha)
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("Expected 1 message left, got %d", n)
+	}
+
+	// An unacked message comes back after the visibility timeout
+	msg, _, _ = q.PopNoAck(8)
+	if string(msg) != "c" {
+		t.Errorf("Expected c, got %s", msg)
+	}
+	if _, _, err := q.PopNoAck(8); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty while c is in flight, got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	msg, hc, err := q.PopNoAck(8)
+	if err != nil || string(msg) != "c" {
+		t.Fatalf("Expected c redelivered, got %q, %v", msg, err)
+	}
+	q.Ack(hc)
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected empty queue, got %d", n)
+	}
+}
+
+func TestAckStaleHandle(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithVisibilityTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("a"))
+	_, stale, err := q.PopNoAck(8)
+	if err != nil {
+		t.Fatalf("PopNoAck failed: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	_, fresh, err := q.PopNoAck(8)
+	if err != nil || fresh.Position() != stale.Position() {
+		t.Fatalf("Expected a redelivered, got %v", err)
+	}
+
+	// The worker that timed out can no longer settle the message
+	if err := q.Ack(stale); err != ErrNotInFlight {
+		t.Errorf("Expected ErrNotInFlight for a stale Ack, got %v", err)
+	}
+	if err := q.Nack(stale, 0); err != ErrNotInFlight {
+		t.Errorf("Expected ErrNotInFlight for a stale Nack, got %v", err)
+	}
+	if err := q.AckBatch([]AckHandle{stale}); !errors.Is(err, ErrNotInFlight) {
+		t.Errorf("Expected ErrNotInFlight for a stale AckBatch, got %v", err)
+	}
+
+	// The new holder's Nack still counts, and so does the next Ack
+	if err := q.Nack(fresh, 0); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+	_, last, err := q.PopNoAck(8)
+	if err != nil || last.Redeliveries() != 2 {
+		t.Fatalf("Expected a third delivery, got %d, %v", last.Redeliveries(), err)
+	}
+	if err := q.Ack(last); err != nil {
+		t.Errorf("Ack failed: %v", err)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected empty queue, got %d", n)
+	}
+}
+
+func TestAckBatch(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for _, m := range []string{"a", "b", "c", "d"} {
+		q.Push([]byte(m))
+	}
+	var handles []AckHandle
+	for i := 0; i < 3; i++ {
+		_, m, err := q.PopNoAck(8)
+		if err != nil {
+			t.Fatalf("PopNoAck failed: %v", err)
+		}
+		handles = append(handles, m)
+	}
+
+	// One handle acked already is reported; the rest still go through
+	if err := q.Ack(handles[1]); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	err = q.AckBatch(handles)
+	var be *AckBatchError
+	if !errors.As(err, &be) || len(be.Failed) != 1 || be.Failed[0] != handles[1] {
+		t.Fatalf("Expected an AckBatchError for handle 1, got %v", err)
+	}
+	if !errors.Is(err, ErrNotInFlight) {
+		t.Errorf("Expected the error to match ErrNotInFlight, got %v", err)
+	}
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("Expected the batch to free 3 slots, got %d left", n)
+	}
+
+	msg, hd, err := q.PopNoAck(8)
+	if err != nil || string(msg) != "d" {
+		t.Fatalf("Expected d, got %q, %v", msg, err)
+	}
+	if err := q.AckBatch([]AckHandle{hd}); err != nil {
+		t.Errorf("AckBatch failed: %v", err)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected empty queue, got %d", n)
+	}
+}
+
+func TestAckSurvivesRestart(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	p.Push([]byte("job"))
+
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithVisibilityTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	if _, _, err := c.PopNoAck(8); err != nil {
+		t.Fatalf("PopNoAck failed: %v", err)
+	}
+	c.Close() // crash before Ack
+
+	// The message is still in flight for the restarted consumer
+	c, err = OpenWithOptions(TestQueue, WithFlags(Consumer), WithVisibilityTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	if _, _, err := c.PopNoAck(8); err != ErrEmpty {
+		t.Errorf("Expected in-flight message to stay hidden, got %v", err)
+	}
+	if n, _ := c.Len(); n != 1 {
+		t.Errorf("Expected the message to stay queued, got len %d", n)
+	}
+}
+
+func TestReclaimStale(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	p.Push([]byte("a"))
+	p.Push([]byte("b"))
+	p.Push([]byte("c"))
+
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithVisibilityTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.PopNoAck(8); err != nil {
+			t.Fatalf("PopNoAck failed: %v", err)
+		}
+	}
+	_, m, err := c.PopNoAck(8)
+	if err != nil {
+		t.Fatalf("PopNoAck failed: %v", err)
+	}
+	c.Ack(m)
+
+	// A supervisor leaves a consumer with a recent heartbeat alone
+	s, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open supervisor failed: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.ReclaimStale(0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for a zero timeout, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Heartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if got, err := s.ReclaimStale(10 * time.Millisecond); err != nil || len(got) != 0 {
+		t.Errorf("Expected nothing reclaimed from a live consumer, got %v, %v", got, err)
+	}
+
+	c.Close() // crash holding a and b
+	time.Sleep(20 * time.Millisecond)
+	got, err := s.ReclaimStale(10 * time.Millisecond)
+	if err != nil || len(got) != 2 || got[0].Position() != 0 || got[1].Position() != 1 {
+		t.Fatalf("Expected positions 0 and 1 reclaimed, got %v, %v", got, err)
+	}
+	if got, err := s.ReclaimStale(10 * time.Millisecond); err != nil || len(got) != 0 {
+		t.Errorf("Expected nothing left to reclaim, got %v, %v", got, err)
+	}
+
+	// The restarted consumer gets them long before the visibility timeout
+	c, err = OpenWithOptions(TestQueue, WithFlags(Consumer), WithVisibilityTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	for _, want := range []string{"a", "b"} {
+		msg, m, err := c.PopNoAck(8)
+		if err != nil || string(msg) != want {
+			t.Fatalf("Expected %s redelivered, got %q, %v", want, msg, err)
+		}
+		c.Ack(m)
+	}
+	if n, _ := c.Len(); n != 0 {
+		t.Errorf("Expected an empty queue, got len %d", n)
+	}
+}
+
+func TestNackDelay(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithVisibilityTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+
+	_, ha, err := q.PopNoAck(8)
+	if err != nil || ha.Redeliveries() != 0 {
+		t.Fatalf("Expected a first delivery, got %d redeliveries, %v", ha.Redeliveries(), err)
+	}
+	if err := q.Nack(ha, 50*time.Millisecond); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+
+	// a stays hidden for the delay, even from ReclaimStale
+	if msg, _, err := q.PopNoAck(8); err != nil || string(msg) != "b" {
+		t.Fatalf("Expected b while a waits, got %q, %v", msg, err)
+	}
+	time.Sleep(time.Millisecond)
+	got, err := q.ReclaimStale(time.Nanosecond)
+	if err != nil || len(got) != 1 || got[0].Position() != 1 {
+		t.Fatalf("Expected only b reclaimed, got %v, %v", got, err)
+	}
+	if msg, hb, err := q.PopNoAck(8); err != nil || string(msg) != "b" || hb.Redeliveries() != 1 {
+		t.Fatalf("Expected b redelivered once, got %q, %v", msg, err)
+	}
+	if _, _, err := q.PopNoAck(8); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty before the delay is up, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	msg, ha, err := q.PopNoAck(8)
+	if err != nil || string(msg) != "a" {
+		t.Fatalf("Expected a after the delay, got %q, %v", msg, err)
+	}
+	if ha.Redeliveries() != 1 {
+		t.Errorf("Expected 1 redelivery, got %d", ha.Redeliveries())
+	}
+	q.Nack(ha, 0)
+	if _, ha, _ = q.PopNoAck(8); ha.Redeliveries() != 2 {
+		t.Errorf("Expected 2 redeliveries, got %d", ha.Redeliveries())
+	}
+}
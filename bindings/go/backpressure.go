@@ -0,0 +1,148 @@
+package nabd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// capacityWatch delivers the OnFull and OnDrained callbacks of a handle.
+// Pushes only flip flags and poke signal; the callbacks run on a watcher
+// goroutine started by the first of them.
+type capacityWatch struct {
+	mu        sync.Mutex
+	onFull    func()
+	onDrained func()
+	running   <-chan struct{} // done channel the watcher serves, nil if none
+
+	armed  atomic.Bool   // a callback is set
+	full   atomic.Bool   // ErrFull was reported and no push succeeded since
+	signal chan struct{} // wakes the watcher; one slot, so signals coalesce
+}
+
+// OnFull sets a callback run when a push through q finds the ring full
+//
+// It fires once per saturation: after it has run, further ErrFull results
+// are ignored until a push through q succeeds again. A nil cb removes the
+// callback. Only pushes made through this handle are observed, not those
+// of other producers.
+//
+// The callback runs on a goroutine of its own, never inside Push, so a
+// slow callback does not stall the producer. Callbacks are best-effort: a
+// saturation that comes and goes while the previous callback still runs
+// may be coalesced with it. The goroutine lives until Close, and Reopen
+// starts it again.
+func (q *Queue) OnFull(cb func()) {
+	q.watch.mu.Lock()
+	q.watch.onFull = cb
+	q.watch.mu.Unlock()
+	q.startWatch()
+}
+
+// OnDrained sets a callback run when the ring empties after a push through
+// q found it full, which completes the signal OnFull starts
+//
+// Once a saturation is seen the watcher goroutine polls the depth at the
+// pace of the handle's wait strategy until it reaches zero, then runs cb.
+// A nil cb removes the callback. Like OnFull it is best-effort: several
+// saturations before the ring empties yield a single OnDrained.
+func (q *Queue) OnDrained(cb func()) {
+	q.watch.mu.Lock()
+	q.watch.onDrained = cb
+	q.watch.mu.Unlock()
+	q.startWatch()
+}
+
+// startWatch starts the watcher goroutine for the current done channel
+func (q *Queue) startWatch() {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.ptr != nil {
+		q.watch.start(q, q.done)
+	}
+}
+
+// start runs the watcher for done unless one already serves it or no
+// callback is set. The caller holds q.mu.
+func (w *capacityWatch) start(q *Queue, done <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.armed.Store(w.onFull != nil || w.onDrained != nil)
+	if !w.armed.Load() {
+		w.full.Store(false)
+		return
+	}
+	if w.running == done {
+		return
+	}
+	if w.signal == nil {
+		w.signal = make(chan struct{}, 1)
+	}
+	w.running = done
+	go w.run(q, done)
+}
+
+// note records the outcome of a push. It is on the push path, so it costs
+// one atomic load while no callback is set.
+func (w *capacityWatch) note(full bool) {
+	if !w.armed.Load() {
+		return
+	}
+	if !full {
+		if w.full.Load() {
+			w.full.Store(false)
+		}
+		return
+	}
+	if w.full.CompareAndSwap(false, true) {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// call runs the callback pick selects, if one is set
+func (w *capacityWatch) call(pick func(w *capacityWatch) func()) {
+	w.mu.Lock()
+	cb := pick(w)
+	w.mu.Unlock()
+	if cb != nil {
+		cb()
+	}
+}
+
+func fullCallback(w *capacityWatch) func()    { return w.onFull }
+func drainedCallback(w *capacityWatch) func() { return w.onDrained }
+
+// run is the watcher goroutine. It exits when done is closed.
+func (w *capacityWatch) run(q *Queue, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-w.signal:
+		}
+		w.call(fullCallback)
+
+		// Poll until the consumer has emptied the ring
+		pace := newWaiter(context.Background(), -1, q.wait)
+		for {
+			if n, err := q.Len(); err != nil {
+				return
+			} else if n == 0 {
+				break
+			}
+			select {
+			case <-w.signal:
+				w.call(fullCallback)
+			default:
+			}
+			if pace.wait(done) != nil {
+				return
+			}
+		}
+		w.call(drainedCallback)
+	}
+}
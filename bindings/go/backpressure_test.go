@@ -0,0 +1,88 @@
+package nabd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnFullOnDrained(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	full := make(chan struct{}, 8)
+	drained := make(chan struct{}, 8)
+	p.OnFull(func() { full <- struct{}{} })
+	p.OnDrained(func() { drained <- struct{}{} })
+
+	expect := func(ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+	fill := func() {
+		t.Helper()
+		for {
+			err := p.Push([]byte("x"))
+			if err == ErrFull {
+				break
+			} else if err != nil {
+				t.Fatalf("Push failed: %v", err)
+			}
+		}
+		// Further ErrFull results belong to the same saturation
+		for i := 0; i < 3; i++ {
+			if err := p.Push([]byte("x")); err != ErrFull {
+				t.Fatalf("Expected ErrFull, got %v", err)
+			}
+		}
+	}
+	drain := func() {
+		t.Helper()
+		for {
+			if _, err := c.Pop(64); err == ErrEmpty {
+				return
+			} else if err != nil {
+				t.Fatalf("Pop failed: %v", err)
+			}
+		}
+	}
+
+	fill()
+	expect(full, "OnFull")
+	drain()
+	expect(drained, "OnDrained")
+	if len(full) != 0 || len(drained) != 0 {
+		t.Errorf("Expected one callback each, got %d more OnFull and %d more OnDrained",
+			len(full), len(drained))
+	}
+
+	// A successful push re-arms OnFull
+	fill()
+	expect(full, "OnFull after re-arming")
+	drain()
+	expect(drained, "OnDrained after re-arming")
+
+	// Without callbacks nothing fires
+	p.OnFull(nil)
+	p.OnDrained(nil)
+	fill()
+	drain()
+	time.Sleep(10 * time.Millisecond)
+	if len(full) != 0 || len(drained) != 0 {
+		t.Errorf("Expected no callbacks once removed, got %d and %d", len(full), len(drained))
+	}
+}
@@ -0,0 +1,492 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// PushBatch pushes as many messages as fit with a single cgo call
+//
+// It returns the number of messages accepted. ErrFull means the ring
+// filled before the batch was done. ErrTooBig means msgs[n] exceeds the
+// slot size; every message before it was pushed. Under RejectEmpty,
+// ErrEmptyMessage means msgs[n] is empty and was not pushed. Either
+// rejected message goes to the dead-letter queue, if one is set. Under a
+// rate limit, ErrRateLimited means the tokens ran out after n messages.
+func (q *Queue) PushBatch(msgs [][]byte) (n int, err error) {
+	allowed, err := q.admit(len(msgs))
+	if err != nil {
+		return 0, err
+	}
+	var limited error
+	if allowed < len(msgs) {
+		msgs, limited = msgs[:allowed], ErrRateLimited
+	}
+	defer func() { q.limit.refund(allowed - n) }()
+
+	q.lockPush()
+	defer q.unlockPush()
+	if err := q.enterPush(); err != nil {
+		return 0, err
+	}
+	defer q.exitPush()
+
+	h, err := q.acquirePush()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	var rejected error
+	if q.empty == RejectEmpty {
+		for i, m := range msgs {
+			if len(m) == 0 {
+				msgs, rejected = msgs[:i], ErrEmptyMessage
+				q.deadLetter(DeadEmpty, m)
+				break
+			}
+		}
+	}
+	if rejected == nil {
+		rejected = limited
+	}
+	if len(msgs) == 0 {
+		return 0, rejected
+	}
+
+	orig := msgs
+	flat, lens := q.packBatch(h, msgs)
+	var pushed C.size_t
+	ret := C.nabd_push_batch(h, unsafe.Pointer(unsafe.SliceData(flat)),
+		&lens[0], C.size_t(len(msgs)), &pushed)
+	if q.env.sequence {
+		C.nabd_seq_advance(h, C.uint64_t(pushed))
+	}
+
+	if pushed > 0 {
+		q.watch.note(false)
+	}
+	switch ret {
+	case C.NABD_OK:
+		return int(pushed), rejected
+	case C.NABD_FULL:
+		q.watch.note(true)
+		return int(pushed), ErrFull
+	case C.NABD_TOOBIG:
+		q.deadLetter(DeadTooBig, orig[pushed])
+		return int(pushed), ErrTooBig
+	}
+	return int(pushed), failure("push batch", q.name, ret, nil)
+}
+
+// packBatch seals msgs and packs them so C sees one flat buffer without
+// Go pointers
+func (q *Queue) packBatch(h *C.nabd_t, msgs [][]byte) ([]byte, []C.size_t) {
+	if q.env.size() > 0 {
+		var next uint64
+		if q.env.sequence {
+			next = uint64(C.nabd_seq(h))
+		}
+		sealed := make([][]byte, len(msgs))
+		for i, m := range msgs {
+			sealed[i] = q.env.seal(m, q.env.newStamp(next+uint64(i), 0))
+		}
+		msgs = sealed
+	}
+	size := 0
+	for _, m := range msgs {
+		size += len(m)
+	}
+	flat := make([]byte, 0, size+1)
+	lens := make([]C.size_t, len(msgs))
+	for i, m := range msgs {
+		flat = append(flat, m...)
+		lens[i] = C.size_t(len(m))
+	}
+	return flat, lens
+}
+
+// PushBatchAtomic pushes every message of msgs or none of them, for
+// messages that only make sense together
+//
+// Each message is checked against the slot size and the ring against the
+// batch size before the first slot is written, and the batch is then
+// published with a single head update, so a consumer never sees part of
+// it. ErrFull means there was no room for the whole batch, ErrTooBig that
+// some message exceeds the slot size, and under RejectEmpty
+// ErrEmptyMessage that one is empty; in each case nothing was pushed and
+// nothing goes to the dead-letter queue, since the caller still holds the
+// batch. Under a rate limit the batch needs a token per message or fails
+// with ErrRateLimited. A batch larger than the capacity never fits and
+// fails with EINVAL.
+func (q *Queue) PushBatchAtomic(msgs [][]byte) error {
+	allowed, err := q.admit(len(msgs))
+	if err != nil {
+		return err
+	}
+	pushed := 0
+	defer func() { q.limit.refund(allowed - pushed) }()
+	if allowed < len(msgs) {
+		return ErrRateLimited
+	}
+
+	q.lockPush()
+	defer q.unlockPush()
+	if err := q.enterPush(); err != nil {
+		return err
+	}
+	defer q.exitPush()
+
+	h, err := q.acquirePush()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	if len(msgs) == 0 {
+		return nil
+	}
+	if q.empty == RejectEmpty {
+		for _, m := range msgs {
+			if len(m) == 0 {
+				return ErrEmptyMessage
+			}
+		}
+	}
+
+	flat, lens := q.packBatch(h, msgs)
+	ret := C.nabd_push_batch_atomic(h, unsafe.Pointer(unsafe.SliceData(flat)),
+		&lens[0], C.size_t(len(msgs)))
+	switch ret {
+	case C.NABD_OK:
+		pushed = len(msgs)
+		if q.env.sequence {
+			C.nabd_seq_advance(h, C.uint64_t(len(msgs)))
+		}
+		q.watch.note(false)
+		return nil
+	case C.NABD_FULL:
+		q.watch.note(true)
+		return ErrFull
+	case C.NABD_TOOBIG:
+		return ErrTooBig
+	}
+	return failure("push batch", q.name, ret, nil)
+}
+
+// PopBatch pops up to maxMsgs messages of at most maxLen bytes with a
+// single cgo call
+//
+// Each returned slice is an independent copy. Fewer than maxMsgs messages
+// are returned without error when that is all the queue holds; ErrEmpty is
+// returned only if none were available. A message larger than maxLen ends
+// the batch and stays queued, so the next call reports ErrTooBig.
+//
+// On a Broadcast queue a lapped consumer gets ErrLapped together with the
+// messages read before the gap. On a queue opened WithChecksum, messages
+// that fail verification are left out and ErrCorrupt is returned with the
+// rest. Expired messages are left out silently.
+func (q *Queue) PopBatch(maxMsgs, maxLen int) ([][]byte, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if maxMsgs <= 0 || maxLen <= 0 {
+		return nil, ErrTooBig
+	}
+
+	slot := maxLen + q.env.size()
+	buf := make([]byte, maxMsgs*slot)
+	lens := make([]C.size_t, maxMsgs)
+
+	for {
+		count, err := q.batchable(h, maxMsgs, func(int) int { return maxLen })
+		if err != nil {
+			return nil, err
+		}
+		start := q.cursor(h)
+		var popped C.size_t
+		ret := C.nabd_pop_batch(h, unsafe.Pointer(&buf[0]), C.size_t(slot),
+			&lens[0], C.size_t(count), &popped)
+
+		switch ret {
+		case C.NABD_OK:
+		case C.NABD_LAPPED:
+			err = ErrLapped
+		case C.NABD_EMPTY:
+			return nil, ErrEmpty
+		case C.NABD_TOOBIG:
+			return nil, ErrTooBig
+		default:
+			return nil, failure("pop batch", q.name, ret, nil)
+		}
+
+		msgs, cerr := q.openBatch(h, start, buf, slot, lens[:popped])
+		if err == nil {
+			err = cerr
+		}
+
+		// A batch that was all expired says nothing about what follows
+		if len(msgs) > 0 || err != nil {
+			return msgs, err
+		}
+	}
+}
+
+// batchable returns how many of the next n messages one batch can pop
+// without delivering one early or consuming one that opens to more than
+// limit(i) bytes: those up to the first that is not due or does not fit.
+// If that is the very first, it returns ErrEmpty or ErrTooBig instead.
+func (q *Queue) batchable(h *C.nabd_t, n int, limit func(int) int) (int, error) {
+	if !q.env.delay && q.env.codec == nil {
+		return n, nil
+	}
+	tail := C.uint64_t(q.cursor(h))
+	for i := 0; i < n; i++ {
+		var data unsafe.Pointer
+		var size C.size_t
+		switch C.nabd_peek_at(h, tail+C.uint64_t(i), &data, &size) {
+		case C.NABD_OK:
+		case C.NABD_EMPTY, C.NABD_INVALID:
+			// Broadcast readers cannot peek, so openBatch unpops for them
+			return n, nil
+		case C.NABD_TOOBIG:
+			// A split message is never delayed or compressed, but what
+			// follows it is not at the next position
+			return i + 1, nil
+		default:
+			// Let the pop report whatever is wrong
+			return max(i, 1), nil
+		}
+		msg := unsafe.Slice((*byte)(data), int(size))
+		switch {
+		case q.env.holdback(msg) > 0 && i == 0:
+			return 0, ErrEmpty
+		case !q.env.fits(msg, limit(i)) && i == 0:
+			return 0, ErrTooBig
+		case q.env.holdback(msg) > 0, !q.env.fits(msg, limit(i)):
+			return i, nil
+		}
+	}
+	return n, nil
+}
+
+// openBatch unwraps the messages a batch pop from position start left in
+// buf, slot bytes apart, copying each out. Corrupt and expired messages
+// are left out; the first corrupt one is reported with the rest. One that
+// opens to more than fits the slot is unpopped together with those after
+// it, and ends the batch with ErrTooBig if nothing came before it.
+func (q *Queue) openBatch(h *C.nabd_t, start uint64, buf []byte, slot int, lens []C.size_t) ([][]byte, error) {
+	var err error
+	msgs := make([][]byte, 0, len(lens))
+	for i, n := range lens {
+		off := i * slot
+		raw := buf[off : off+int(n)]
+		data, st, cerr := q.env.open(raw, slot-q.env.size())
+		if cerr == ErrTooBig {
+			q.unpop(h, start+uint64(i))
+			if err == nil && len(msgs) == 0 {
+				err = cerr
+			}
+			return msgs, err
+		} else if cerr != nil {
+			q.deadLetter(DeadCorrupt, raw)
+			if err == nil {
+				err = cerr
+			}
+			continue
+		}
+		if q.env.expired(st) {
+			q.expire(data)
+			continue
+		}
+		q.latency.observe(st)
+		msgs = append(msgs, append([]byte(nil), data...))
+	}
+	return msgs, err
+}
+
+// PopExactly pops n messages of at most maxLen bytes, or none at all
+//
+// Every message is checked to be present and to fit before the first is
+// consumed, so a caller with a minimum batch size never gets a partial
+// one: ErrEmpty means fewer than n messages were queued, or due under
+// WithDelay, and ErrTooBig that one of the first n exceeds maxLen, and
+// either way the queue is untouched. The n messages are then popped with
+// a single cgo call.
+// Expired and corrupt messages count towards n but are left out of the
+// result, as in PopBatch, with ErrCorrupt reported for the latter.
+//
+// n must be between 1 and the capacity, since a larger batch could never
+// fill. PopExactly fails with EINVAL on Broadcast and overwrite queues,
+// and a message split over several slots counts as too big.
+func (q *Queue) PopExactly(n, maxLen int) ([][]byte, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if maxLen <= 0 {
+		return nil, ErrTooBig
+	}
+	var stats C.nabd_stats_t
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return nil, failure("stats", q.name, ret, nil)
+	}
+	if n <= 0 || n > int(stats.capacity) {
+		return nil, &QueueError{Op: "pop", Name: q.name, Errno: syscall.EINVAL}
+	}
+
+	// This handle is the only consumer, so what is checked here is still
+	// there when the batch pops it
+	slot := maxLen + q.env.size()
+	for i := 0; i < n; i++ {
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek_at(h, stats.tail+C.uint64_t(i), &data, &size)
+		switch {
+		case ret == C.NABD_EMPTY:
+			return nil, ErrEmpty
+		case ret == C.NABD_TOOBIG, ret == C.NABD_OK && int(size) > slot:
+			return nil, ErrTooBig
+		case ret != C.NABD_OK:
+			return nil, failure("pop", q.name, ret, nil)
+		}
+		msg := unsafe.Slice((*byte)(data), int(size))
+		if q.env.holdback(msg) > 0 {
+			return nil, ErrEmpty
+		} else if !q.env.fits(msg, maxLen) {
+			return nil, ErrTooBig
+		}
+	}
+
+	buf := make([]byte, n*slot)
+	lens := make([]C.size_t, n)
+	var popped C.size_t
+	ret := C.nabd_pop_batch(h, unsafe.Pointer(&buf[0]), C.size_t(slot),
+		&lens[0], C.size_t(n), &popped)
+	if ret != C.NABD_OK {
+		return nil, failure("pop batch", q.name, ret, nil)
+	}
+	return q.openBatch(h, uint64(stats.tail), buf, slot, lens[:popped])
+}
+
+// DrainInto pops up to len(bufs) messages with a single cgo call, copying
+// message i into the caller's buffer bufs[i]
+//
+// Each buffer's capacity is its size, and on return the first n entries
+// are resliced to the length of the message they received, so the same
+// buffers can be passed again. A message that does not fit its buffer
+// ends the batch and stays queued: DrainInto then returns ErrTooBig and
+// bufs[n] is the buffer that was too small. ErrEmpty is returned only if
+// no message was available. Lapped, corrupt and expired messages are
+// handled as in PopBatch; when one is dropped, the buffers after it move
+// up by one, so bufs keeps the same buffers in a different order.
+func (q *Queue) DrainInto(bufs [][]byte) (int, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	// Envelope fields need room of their own, so pop into scratch space
+	extra := q.env.size()
+	dsts := make([][]byte, len(bufs))
+	for i, b := range bufs {
+		if extra > 0 {
+			dsts[i] = make([]byte, cap(b)+extra)
+		} else {
+			dsts[i] = b[:cap(b)]
+		}
+	}
+
+	// C receives an array of pointers into Go memory, which must be pinned
+	var pin runtime.Pinner
+	defer pin.Unpin()
+	var none [1]byte
+	ptrs := make([]unsafe.Pointer, len(dsts))
+	sizes := make([]C.size_t, len(dsts))
+	for i, d := range dsts {
+		p := unsafe.Pointer(&none[0])
+		if len(d) > 0 {
+			p = unsafe.Pointer(&d[0])
+		}
+		pin.Pin(p)
+		ptrs[i], sizes[i] = p, C.size_t(len(d))
+	}
+	lens := make([]C.size_t, len(dsts))
+
+	n := 0
+	for n == 0 {
+		count, err := q.batchable(h, len(dsts), func(i int) int { return cap(bufs[i]) })
+		if err != nil {
+			return 0, err
+		}
+		start := q.cursor(h)
+		var popped C.size_t
+		ret := C.nabd_pop_batchv(h, &ptrs[0], &sizes[0], &lens[0], C.size_t(count), &popped)
+
+		switch ret {
+		case C.NABD_OK:
+		case C.NABD_LAPPED:
+			err = ErrLapped
+		case C.NABD_EMPTY:
+			return 0, ErrEmpty
+		case C.NABD_TOOBIG:
+			err = ErrTooBig
+		default:
+			return 0, failure("pop batch", q.name, ret, nil)
+		}
+
+		// Move filled buffers ahead of those whose message was dropped
+		big := int(popped)
+		for i := 0; i < int(popped); i++ {
+			raw := dsts[i][:lens[i]]
+			if extra == 0 {
+				bufs[i] = raw
+			} else {
+				data, st, cerr := q.env.open(raw, cap(bufs[i]))
+				if cerr == ErrTooBig {
+					q.unpop(h, start+uint64(i))
+					big, err = i, cerr
+					break
+				} else if cerr != nil {
+					q.deadLetter(DeadCorrupt, raw)
+					if err == nil {
+						err = cerr
+					}
+					continue
+				}
+				if q.env.expired(st) {
+					q.expire(data)
+					continue
+				}
+				q.latency.observe(st)
+				bufs[i] = append(bufs[i][:0], data...)
+			}
+			bufs[n], bufs[i] = bufs[i], bufs[n]
+			n++
+		}
+		if err == ErrTooBig {
+			bufs[n], bufs[big] = bufs[big], bufs[n]
+		}
+
+		// A batch that was all expired says nothing about what follows
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
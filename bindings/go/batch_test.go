@@ -0,0 +1,315 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPushBatch(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	msgs := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}
+	n, err := p.PushBatch(msgs)
+	if err != ErrFull || n != 4 {
+		t.Fatalf("Expected 4 pushed with ErrFull, got %d, %v", n, err)
+	}
+	for i := 0; i < 4; i++ {
+		out, err := c.Pop(128)
+		if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		if string(out) != string(msgs[i]) {
+			t.Errorf("Expected %s, got %s", msgs[i], out)
+		}
+	}
+
+	n, err = p.PushBatch([][]byte{[]byte("ok"), make([]byte, 128), []byte("never")})
+	if err != ErrTooBig || n != 1 {
+		t.Errorf("Expected failure at index 1 with ErrTooBig, got %d, %v", n, err)
+	}
+}
+
+func TestPushBatchAtomic(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	five := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}
+	if err := q.PushBatchAtomic(five); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for a batch over capacity, got %v", err)
+	}
+	if err := q.PushBatchAtomic(five[:2]); err != nil {
+		t.Fatalf("PushBatchAtomic failed: %v", err)
+	}
+
+	// Neither a batch that does not fit nor one with a late oversized
+	// message leaves anything behind
+	if err := q.PushBatchAtomic(five[2:]); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	if err := q.PushBatchAtomic([][]byte{[]byte("ok"), make([]byte, 128)}); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("Expected only the first batch queued, got %d", n)
+	}
+
+	if err := q.PushBatchAtomic(five[2:4]); err != nil {
+		t.Fatalf("PushBatchAtomic failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		msg, seq, err := q.PopSeq(8)
+		if err != nil || string(msg) != string(five[i]) || seq != uint64(i) {
+			t.Errorf("Expected %s with sequence %d, got %q, %d, %v", five[i], i, msg, seq, err)
+		}
+	}
+}
+
+const benchBatch = 64
+
+func benchQueues(b *testing.B) (*Queue, *Queue) {
+	Unlink(TestQueue)
+	p, err := Open(TestQueue, 1024, 128, Create|Producer)
+	if err != nil {
+		b.Fatalf("Producer open failed: %v", err)
+	}
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		b.Fatalf("Consumer open failed: %v", err)
+	}
+	b.Cleanup(func() {
+		p.Close()
+		c.Close()
+		Unlink(TestQueue)
+	})
+	return p, c
+}
+
+func BenchmarkPushLoop(b *testing.B) {
+	p, c := benchQueues(b)
+	msg := make([]byte, 32)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBatch; j++ {
+			p.Push(msg)
+		}
+		b.StopTimer()
+		for j := 0; j < benchBatch; j++ {
+			c.Pop(128)
+		}
+		b.StartTimer()
+	}
+	b.SetBytes(int64(benchBatch * len(msg)))
+}
+
+func BenchmarkPushBatch(b *testing.B) {
+	p, c := benchQueues(b)
+	msgs := make([][]byte, benchBatch)
+	for i := range msgs {
+		msgs[i] = make([]byte, 32)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.PushBatch(msgs)
+		b.StopTimer()
+		for j := 0; j < benchBatch; j++ {
+			c.Pop(128)
+		}
+		b.StartTimer()
+	}
+	b.SetBytes(int64(benchBatch * 32))
+}
+
+func TestPopExactly(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for _, n := range []int{0, 9} {
+		if _, err := q.PopExactly(n, 32); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("Expected EINVAL for a batch of %d, got %v", n, err)
+		}
+	}
+
+	// A partial batch stays queued
+	q.PushBatch([][]byte{[]byte("a"), []byte("b")})
+	if _, err := q.PopExactly(3, 32); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty for 2 of 3, got %v", err)
+	}
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("Expected 2 messages untouched, got %d", n)
+	}
+
+	// So does a batch with a message that does not fit
+	q.Push([]byte("too long"))
+	if _, err := q.PopExactly(3, 4); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	if n, _ := q.Len(); n != 3 {
+		t.Errorf("Expected 3 messages untouched, got %d", n)
+	}
+
+	out, err := q.PopExactly(3, 32)
+	if err != nil || len(out) != 3 {
+		t.Fatalf("Expected 3 messages, got %d, %v", len(out), err)
+	}
+	for i, want := range []string{"a", "b", "too long"} {
+		if string(out[i]) != want {
+			t.Errorf("Expected %s, got %s", want, out[i])
+		}
+	}
+
+	// The wait variant gives up without consuming, or fills as pushes come
+	q.Push([]byte("c"))
+	if _, err := q.PopExactlyWait(2, 32, 5*time.Millisecond); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty on timeout, got %v", err)
+	}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		q.Push([]byte("d"))
+	}()
+	out, err = q.PopExactlyWait(2, 32, 5*time.Second)
+	if err != nil || len(out) != 2 || string(out[0]) != "c" || string(out[1]) != "d" {
+		t.Errorf("Expected c and d, got %q, %v", out, err)
+	}
+}
+
+func TestPopBatch(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.PopBatch(4, 32); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	in := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+	if _, err := p.PushBatch(in); err != nil {
+		t.Fatalf("PushBatch failed: %v", err)
+	}
+
+	out, err := c.PopBatch(2, 32)
+	if err != nil || len(out) != 2 {
+		t.Fatalf("Expected 2 messages, got %d, %v", len(out), err)
+	}
+	out2, err := c.PopBatch(8, 32)
+	if err != nil || len(out2) != 1 {
+		t.Fatalf("Expected 1 message, got %d, %v", len(out2), err)
+	}
+	out = append(out, out2...)
+	for i := range in {
+		if string(out[i]) != string(in[i]) {
+			t.Errorf("Expected %s, got %s", in[i], out[i])
+		}
+	}
+}
+
+func TestDrainInto(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	small, large := make([]byte, 4), make([]byte, 32)
+	bufs := [][]byte{large, small, make([]byte, 32)}
+	if _, err := q.DrainInto(bufs); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	q.Push([]byte("a longer one"))
+	q.Push([]byte("too long"))
+
+	// The second message does not fit the small buffer and stays queued
+	n, err := q.DrainInto(bufs)
+	if n != 1 || err != ErrTooBig {
+		t.Fatalf("Expected 1, ErrTooBig, got %d, %v", n, err)
+	}
+	if string(bufs[0]) != "a longer one" || &bufs[0][0] != &large[0] {
+		t.Errorf("Expected the message in the caller's buffer, got %q", bufs[0])
+	}
+	if cap(bufs[1]) != cap(small) {
+		t.Errorf("Expected bufs[1] to be the small buffer, got cap %d", cap(bufs[1]))
+	}
+
+	// A resliced buffer passed back is filled up to its capacity again
+	n, err = q.DrainInto(bufs[:1])
+	if n != 1 || err != nil {
+		t.Fatalf("Expected 1, nil, got %d, %v", n, err)
+	}
+	if string(bufs[0]) != "too long" {
+		t.Errorf("Expected too long, got %q", bufs[0])
+	}
+}
+
+func TestDrainIntoChecksum(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("one"))
+	q.Push([]byte("two"))
+
+	bufs := [][]byte{make([]byte, 8), make([]byte, 8), make([]byte, 8)}
+	n, err := q.DrainInto(bufs)
+	if n != 2 || err != nil {
+		t.Fatalf("Expected 2, nil, got %d, %v", n, err)
+	}
+	if string(bufs[0]) != "one" || string(bufs[1]) != "two" {
+		t.Errorf("Expected one, two, got %q, %q", bufs[0], bufs[1])
+	}
+}
@@ -0,0 +1,92 @@
+package nabd
+
+import (
+	"context"
+	"syscall"
+)
+
+// FullPolicy selects what Bridge and Tee do when a destination queue is
+// full
+type FullPolicy int
+
+const (
+	// BlockWhenFull waits for the destination to free a slot, so no
+	// message is lost and the source backs up instead. This is the
+	// default.
+	BlockWhenFull FullPolicy = iota
+
+	// DropWhenFull discards a message the destination has no room for
+	// and counts it in BridgeStats.Dropped
+	DropWhenFull
+)
+
+// BridgeStats counts the messages a Bridge handled
+type BridgeStats struct {
+	Relayed uint64 // Messages pushed to the destination
+	Dropped uint64 // Messages the destination rejected (full, too big, empty)
+}
+
+// Bridge moves messages from src to dst until ctx is cancelled, then
+// returns what it relayed together with ctx.Err()
+//
+// Messages are popped into one reused buffer, so relaying allocates
+// nothing per message. Envelope fields are checked and stripped by src
+// and added afresh by dst. When dst is full, policy decides whether to
+// wait or drop; a message dst rejects with ErrTooBig or ErrEmptyMessage
+// is always dropped. A message popped but still waiting for room when ctx
+// is cancelled is lost. Bridge also stops, returning the error, when
+// either queue is closed or an operation fails for another reason.
+func Bridge(ctx context.Context, src, dst *Queue, policy FullPolicy) (BridgeStats, error) {
+	stats, err := Tee(ctx, src, policy, dst)
+	return stats[0], err
+}
+
+// Tee copies every message popped from src into each of dsts, in order,
+// until ctx is cancelled. It returns one BridgeStats per destination.
+//
+// It behaves like Bridge with several destinations. Under BlockWhenFull a
+// full destination holds up all of them, so every consumer sees every
+// message. Under DropWhenFull a full destination is skipped for that
+// message and its Dropped count grows, which shows which consumer lags,
+// while the others carry on. Tee without destinations fails with EINVAL.
+func Tee(ctx context.Context, src *Queue, policy FullPolicy, dsts ...*Queue) ([]BridgeStats, error) {
+	stats := make([]BridgeStats, max(len(dsts), 1))
+	if len(dsts) == 0 {
+		return stats, &QueueError{Op: "tee", Name: src.name, Errno: syscall.EINVAL}
+	}
+
+	n, err := src.maxMessage()
+	if err != nil {
+		return stats, err
+	}
+	buf := make([]byte, n)
+
+	for {
+		var size int
+		err := src.retry(ctx, -1, ErrEmpty, func() (err error) {
+			size, err = src.PopInto(buf)
+			return err
+		})
+		if err == ErrLapped || err == ErrCorrupt {
+			continue
+		} else if err != nil {
+			return stats, err
+		}
+
+		for i, dst := range dsts {
+			if policy == DropWhenFull {
+				err = dst.Push(buf[:size])
+			} else {
+				err = dst.PushContext(ctx, buf[:size])
+			}
+			switch err {
+			case nil:
+				stats[i].Relayed++
+			case ErrFull, ErrTooBig, ErrEmptyMessage:
+				stats[i].Dropped++
+			default:
+				return stats, err
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package nabd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const (
+	testBridgeQueue = "/nabd_go_bridge_test"
+	testTeeQueue    = "/nabd_go_tee_test"
+)
+
+func TestBridge(t *testing.T) {
+	Unlink(TestQueue)
+	Unlink(testBridgeQueue)
+	defer Unlink(TestQueue)
+	defer Unlink(testBridgeQueue)
+
+	src, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Source open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(testBridgeQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Destination open failed: %v", err)
+	}
+	defer dst.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		stats BridgeStats
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		stats, err := Bridge(ctx, src, dst, BlockWhenFull)
+		done <- result{stats, err}
+	}()
+
+	for _, s := range []string{"a", "b", "c"} {
+		src.Push([]byte(s))
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		out, err := dst.PopWait(64, time.Second)
+		if err != nil || string(out) != want {
+			t.Fatalf("Expected %q, got %q, %v", want, out, err)
+		}
+	}
+
+	cancel()
+	r := <-done
+	if r.err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", r.err)
+	}
+	if r.stats.Relayed != 3 || r.stats.Dropped != 0 {
+		t.Errorf("Expected 3 relayed, 0 dropped, got %+v", r.stats)
+	}
+}
+
+func TestBridgeDropWhenFull(t *testing.T) {
+	Unlink(TestQueue)
+	Unlink(testBridgeQueue)
+	defer Unlink(TestQueue)
+	defer Unlink(testBridgeQueue)
+
+	src, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Source open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(testBridgeQueue, 2, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Destination open failed: %v", err)
+	}
+	defer dst.Close()
+
+	for i := 0; i < 5; i++ {
+		src.Push([]byte{byte(i)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for n, _ := src.Len(); n > 0; n, _ = src.Len() {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	stats, _ := Bridge(ctx, src, dst, DropWhenFull)
+
+	if stats.Relayed != 2 || stats.Dropped != 3 {
+		t.Errorf("Expected 2 relayed, 3 dropped, got %+v", stats)
+	}
+	if n, _ := dst.Len(); n != 2 {
+		t.Errorf("Expected 2 messages in the destination, got %d", n)
+	}
+}
+
+func TestTee(t *testing.T) {
+	Unlink(TestQueue)
+	Unlink(testBridgeQueue)
+	Unlink(testTeeQueue)
+	defer Unlink(TestQueue)
+	defer Unlink(testBridgeQueue)
+	defer Unlink(testTeeQueue)
+
+	src, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Source open failed: %v", err)
+	}
+	defer src.Close()
+	fast, err := Open(testBridgeQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Destination open failed: %v", err)
+	}
+	defer fast.Close()
+	slow, err := Open(testTeeQueue, 2, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Destination open failed: %v", err)
+	}
+	defer slow.Close()
+
+	for i := 0; i < 5; i++ {
+		src.Push([]byte{byte(i)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for n, _ := src.Len(); n > 0; n, _ = src.Len() {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	stats, err := Tee(ctx, src, DropWhenFull, fast, slow)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// The slow consumer lags without holding up the fast one
+	if stats[0].Relayed != 5 || stats[0].Dropped != 0 {
+		t.Errorf("Expected 5 relayed to the fast queue, got %+v", stats[0])
+	}
+	if stats[1].Relayed != 2 || stats[1].Dropped != 3 {
+		t.Errorf("Expected 2 relayed, 3 dropped for the slow queue, got %+v", stats[1])
+	}
+
+	if _, err := Tee(context.Background(), src, BlockWhenFull); err == nil {
+		t.Error("Expected an error without destinations")
+	}
+}
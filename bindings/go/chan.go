@@ -0,0 +1,89 @@
+package nabd
+
+import (
+	"context"
+)
+
+// Consume starts a goroutine that pops messages of at most maxLen bytes
+// and delivers them on the returned channel, which has room for chanSize
+// messages.
+//
+// The goroutine waits with backoff instead of busy-polling. It stops when
+// ctx is cancelled, the queue is closed or a pop fails, and then closes the
+// channel; messages already buffered in the channel stay readable, so a
+// range loop drains them before it ends. A message popped but not yet
+// handed over when ctx is cancelled is dropped.
+func (q *Queue) Consume(ctx context.Context, maxLen, chanSize int) <-chan []byte {
+	ch := make(chan []byte, chanSize)
+
+	go func() {
+		defer close(ch)
+		for {
+			msg, err := q.PopContext(ctx, maxLen)
+			if err != nil {
+				return
+			}
+
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			case <-q.done:
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Produce starts a goroutine that pushes every message sent on the
+// returned channel, which has room for chanSize messages.
+//
+// When the ring is full the goroutine blocks until space frees up, so
+// senders see backpressure through the channel instead of losing data.
+// Messages that cannot be pushed are passed to onError together with the
+// reason; onError may be nil and runs on the producer goroutine.
+//
+// The goroutine exits when the channel is closed or ctx is cancelled. On
+// cancellation it makes one non-blocking attempt to push every message
+// still buffered in the channel and reports the ones that did not fit to
+// onError. Stop sending once ctx is cancelled; later sends may block.
+func (q *Queue) Produce(ctx context.Context, chanSize int, onError func(msg []byte, err error)) chan<- []byte {
+	ch := make(chan []byte, chanSize)
+	report := func(msg []byte, err error) {
+		if onError != nil {
+			onError(msg, err)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := q.PushContext(ctx, msg); err != nil {
+					report(msg, err)
+				}
+			case <-ctx.Done():
+				for {
+					select {
+					case msg, ok := <-ch:
+						if !ok {
+							return
+						}
+						if err := q.Push(msg); err != nil {
+							report(msg, err)
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,95 @@
+package nabd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConsume(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Consume(ctx, 64, 4)
+
+	want := []string{"a", "b", "c"}
+	for _, m := range want {
+		p.Push([]byte(m))
+	}
+	for _, m := range want {
+		select {
+		case out := <-ch:
+			if string(out) != m {
+				t.Errorf("Expected %s, got %s", m, out)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s", m)
+		}
+	}
+
+	// Cancellation closes the channel
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("Expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Channel not closed after cancel")
+	}
+}
+
+func TestProduce(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	lost := make(chan error, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := p.Produce(ctx, 8, func(msg []byte, err error) { lost <- err })
+
+	// More messages than the ring holds: the bridge waits for the consumer
+	for _, m := range []string{"a", "b", "c", "d"} {
+		ch <- []byte(m)
+	}
+	for _, m := range []string{"a", "b", "c", "d"} {
+		out, err := c.PopWait(64, time.Second)
+		if err != nil {
+			t.Fatalf("PopWait failed: %v", err)
+		}
+		if string(out) != m {
+			t.Errorf("Expected %s, got %s", m, out)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-lost:
+		t.Errorf("Unexpected loss: %v", err)
+	default:
+	}
+}
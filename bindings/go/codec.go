@@ -0,0 +1,124 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+)
+
+// codecBuffers recycles encode and decode buffers across gob calls
+var codecBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// maxPayload returns the largest message the queue accepts, or ErrClosed
+func (q *Queue) maxPayload() (int, error) {
+	slotSize := q.SlotSize()
+	if slotSize == 0 {
+		return 0, ErrClosed
+	}
+	return slotSize - SlotHeaderSize - q.env.size(), nil
+}
+
+// maxMessage is like maxPayload, but covers a whole ring of chunks when
+// the handle pushes WithChunking, or returns the WithMaxMessage limit
+func (q *Queue) maxMessage() (int, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+	return q.messageLimit(h), nil
+}
+
+// messageLimit returns maxMessage for h, which the caller holds
+func (q *Queue) messageLimit(h *C.nabd_t) int {
+	if q.maxMsg > 0 {
+		return q.maxMsg
+	}
+	var stats C.nabd_stats_t
+	C.nabd_stats(h, &stats)
+	n := int(stats.slot_size) - SlotHeaderSize
+	if q.chunked {
+		n *= int(stats.capacity)
+	}
+	return n - q.env.size()
+}
+
+// PushJSON encodes v with encoding/json and pushes it as one message. An
+// encoding longer than the handle can push returns ErrTooBig.
+func (q *Queue) PushJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return q.Push(b)
+}
+
+// PopJSON pops the next message and decodes it into v with encoding/json.
+// The buffer holds a whole slot, the whole ring on a handle opened
+// WithChunking, or the WithMaxMessage limit, so any message fits. If decoding fails the message has
+// already been removed from the queue.
+func (q *Queue) PopJSON(v any) error {
+	n, err := q.maxMessage()
+	if err != nil {
+		return err
+	}
+	b, err := q.Pop(n)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// PushGob encodes v with encoding/gob and pushes it as one message. An
+// encoding longer than the handle can push returns ErrTooBig.
+//
+// Every message is a self-contained gob stream that carries its own type
+// definitions. A persistent encoder would send them only once, but then a
+// consumer that attaches late, is lapped on a Broadcast queue or shares
+// the ring with other consumers could never decode what it receives. The
+// encode buffer is pooled instead, so steady-state pushes do not allocate
+// one per message. Because of the repeated type definitions, small values
+// encode larger and slower than with PushJSON (compare BenchmarkGob and
+// BenchmarkJSON); gob pays off for types JSON cannot represent.
+//
+// As with any gob stream, concrete types stored in interface fields must
+// be registered with gob.Register in both processes.
+func (q *Queue) PushGob(v any) error {
+	buf := codecBuffers.Get().(*bytes.Buffer)
+	defer codecBuffers.Put(buf)
+	buf.Reset()
+
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	return q.Push(buf.Bytes())
+}
+
+// PopGob pops the next message and decodes it into v with encoding/gob.
+// The receive buffer is pooled and sized as in PopJSON. If decoding fails
+// the message has already been removed from the queue.
+func (q *Queue) PopGob(v any) error {
+	n, err := q.maxMessage()
+	if err != nil {
+		return err
+	}
+
+	buf := codecBuffers.Get().(*bytes.Buffer)
+	defer codecBuffers.Put(buf)
+	buf.Reset()
+	buf.Grow(n)
+
+	b := buf.AvailableBuffer()[:n]
+	m, err := q.PopInto(b)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b[:m])).Decode(v)
+}
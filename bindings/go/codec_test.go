@@ -0,0 +1,171 @@
+package nabd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type codecOrder struct {
+	ID    int
+	Items []codecItem
+	Meta  map[string]string
+}
+
+type codecItem struct {
+	SKU string
+	Qty int
+}
+
+func TestJSON(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 256, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	want := codecOrder{
+		ID:    42,
+		Items: []codecItem{{"A-1", 2}, {"B-7", 1}},
+		Meta:  map[string]string{"desk": "fx"},
+	}
+	if err := p.PushJSON(want); err != nil {
+		t.Fatalf("PushJSON failed: %v", err)
+	}
+
+	var got codecOrder
+	if err := c.PopJSON(&got); err != nil {
+		t.Fatalf("PopJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	big := codecOrder{Meta: map[string]string{"pad": strings.Repeat("x", 300)}}
+	if err := p.PushJSON(big); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	if err := c.PopJSON(&got); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestGob(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 512, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	want := codecOrder{
+		ID:    7,
+		Items: []codecItem{{"C-3", 5}},
+		Meta:  map[string]string{"src": "gob"},
+	}
+
+	// Each message decodes on its own, even after skipping one
+	for i := 0; i < 3; i++ {
+		want.ID = i
+		if err := p.PushGob(want); err != nil {
+			t.Fatalf("PushGob failed: %v", err)
+		}
+	}
+	c.Pop(512)
+
+	for i := 1; i < 3; i++ {
+		var got codecOrder
+		if err := c.PopGob(&got); err != nil {
+			t.Fatalf("PopGob failed: %v", err)
+		}
+		want.ID = i
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestCodecChunked(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChunking())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Both encodings span several slots
+	want := codecOrder{ID: 3, Meta: map[string]string{"pad": strings.Repeat("x", 200)}}
+	if err := q.PushJSON(want); err != nil {
+		t.Fatalf("PushJSON failed: %v", err)
+	}
+	var got codecOrder
+	if err := q.PopJSON(&got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("PopJSON returned %+v, %v", got, err)
+	}
+
+	if err := q.PushGob(want); err != nil {
+		t.Fatalf("PushGob failed: %v", err)
+	}
+	got = codecOrder{}
+	if err := q.PopGob(&got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("PopGob returned %+v, %v", got, err)
+	}
+}
+
+func benchmarkCodec(b *testing.B, push func(*Queue, any) error, pop func(*Queue, any) error) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 1024, 512, Create|Producer|Consumer)
+	if err != nil {
+		b.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	msg := codecOrder{
+		ID:    1,
+		Items: []codecItem{{"A-1", 2}, {"B-7", 1}, {"C-3", 5}},
+		Meta:  map[string]string{"desk": "fx", "book": "spot"},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := push(q, msg); err != nil {
+			b.Fatalf("push failed: %v", err)
+		}
+		var got codecOrder
+		if err := pop(q, &got); err != nil {
+			b.Fatalf("pop failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkJSON(b *testing.B) {
+	benchmarkCodec(b, (*Queue).PushJSON, (*Queue).PopJSON)
+}
+
+func BenchmarkGob(b *testing.B) {
+	benchmarkCodec(b, (*Queue).PushGob, (*Queue).PopGob)
+}
@@ -0,0 +1,238 @@
+package nabd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Codec compresses message payloads for WithCompression
+//
+// Both methods append their output to dst and return the extended slice,
+// like the append-style APIs of the standard library. Decompress must fail
+// rather than produce more than limit bytes, which the envelope sets to
+// the length recorded at push time, so a crafted payload cannot inflate
+// without bound. Methods may be called from several goroutines at once.
+// Any algorithm fits, so a zstd package can be plugged in with a small
+// adapter.
+type Codec interface {
+	Compress(dst, src []byte) ([]byte, error)
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+// errInflate is returned by the built-in codecs for malformed input or
+// output beyond the limit
+var errInflate = errors.New("nabd: invalid compressed data")
+
+// flateCodec is the Codec returned by NewFlateCodec
+type flateCodec struct {
+	level   int
+	writers sync.Pool // *flate.Writer, which is costly to allocate
+}
+
+// NewFlateCodec returns a Codec using DEFLATE from compress/flate at the
+// given level, such as flate.BestSpeed. It needs no dependencies beyond
+// the standard library. An invalid level makes every Compress fail, so
+// messages are pushed uncompressed.
+func NewFlateCodec(level int) Codec {
+	return &flateCodec{level: level}
+}
+
+func (c *flateCodec) Compress(dst, src []byte) ([]byte, error) {
+	out := bytes.NewBuffer(dst)
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(out, c.level); err != nil {
+			return dst, err
+		}
+	} else {
+		w.Reset(out)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *flateCodec) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	// One byte past the limit tells a full output from an oversized one
+	out := bytes.NewBuffer(dst)
+	n, err := io.Copy(out, io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return dst, err
+	}
+	if n > int64(limit) {
+		return dst, errInflate
+	}
+	return out.Bytes(), nil
+}
+
+// LZ4 block format parameters; see the lz4_Block_format document of the
+// reference implementation
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 14
+	lz4MaxOffset    = 1<<16 - 1
+	lz4LastLiterals = 5  // the block always ends with this many literals
+	lz4MatchLimit   = 12 // and no match starts this close to its end
+)
+
+// lz4Codec is the Codec returned by NewLZ4Codec
+type lz4Codec struct {
+	tables sync.Pool // *[1 << lz4HashLog]int32 match finder tables
+}
+
+// NewLZ4Codec returns a Codec using the LZ4 block format, which trades
+// some compression ratio for much faster compression and decompression
+// than DEFLATE. It is implemented in this package, so it needs no
+// dependency. Blocks carry no frame header and are readable by any LZ4
+// block decoder told the uncompressed size.
+func NewLZ4Codec() Codec {
+	return &lz4Codec{}
+}
+
+func (c *lz4Codec) Compress(dst, src []byte) ([]byte, error) {
+	table, _ := c.tables.Get().(*[1 << lz4HashLog]int32)
+	if table == nil {
+		table = new([1 << lz4HashLog]int32)
+	} else {
+		clear(table[:])
+	}
+	defer c.tables.Put(table)
+
+	// Greedy parse: a table maps a hash of each 4-byte sequence to the
+	// position after it was last seen, 0 for never
+	anchor := 0
+	for i := 0; i+lz4MatchLimit < len(src); {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := seq * 2654435761 >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		n := lz4MinMatch
+		for end := len(src) - lz4LastLiterals; i+n < end && src[ref+n] == src[i+n]; n++ {
+		}
+		dst = lz4Sequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+	return lz4Sequence(dst, src[anchor:], 0, 0), nil
+}
+
+// lz4Sequence appends literals followed by a match of length n at offset
+// back, or the final literals alone when n is 0
+func lz4Sequence(dst, literals []byte, offset, n int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if n > 0 {
+		token |= byte(min(n-lz4MinMatch, 15))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4Length(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if n-lz4MinMatch >= 15 {
+		dst = lz4Length(dst, n-lz4MinMatch-15)
+	}
+	return dst
+}
+
+// lz4Length appends the extension bytes of a length field
+func lz4Length(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+func (c *lz4Codec) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	base := len(dst)
+	for i := 0; ; {
+		if i >= len(src) {
+			return dst, errInflate
+		}
+		token := src[i]
+		i++
+
+		n := int(token >> 4)
+		if n == 15 {
+			var ok bool
+			if n, i, ok = lz4ReadLength(src, i, n); !ok {
+				return dst, errInflate
+			}
+		}
+		if n > len(src)-i || n > limit-(len(dst)-base) {
+			return dst, errInflate
+		}
+		dst = append(dst, src[i:i+n]...)
+		if i += n; i == len(src) {
+			return dst, nil
+		}
+
+		if len(src)-i < 2 {
+			return dst, errInflate
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst)-base {
+			return dst, errInflate
+		}
+		n = int(token & 15)
+		if n == 15 {
+			var ok bool
+			if n, i, ok = lz4ReadLength(src, i, n); !ok {
+				return dst, errInflate
+			}
+		}
+		n += lz4MinMatch
+		if n > limit-(len(dst)-base) {
+			return dst, errInflate
+		}
+
+		// A match may overlap the bytes it produces, repeating a pattern
+		start := len(dst) - offset
+		if offset >= n {
+			dst = append(dst, dst[start:start+n]...)
+		} else {
+			for k := 0; k < n; k++ {
+				dst = append(dst, dst[start+k])
+			}
+		}
+	}
+}
+
+// lz4ReadLength adds the extension bytes of a length field at src[i:] to
+// n, returning the position after them
+func lz4ReadLength(src []byte, i, n int) (int, int, bool) {
+	for {
+		if i >= len(src) || n > 1<<30 {
+			return 0, 0, false
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, true
+		}
+	}
+}
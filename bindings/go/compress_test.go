@@ -0,0 +1,351 @@
+package nabd
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWithCompression(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(128),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithMaxMessage(1024),
+		WithCompression(NewFlateCodec(flate.BestSpeed)))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Larger than a slot, but compresses well below it
+	text := []byte(strings.Repeat(`{"level":"info","msg":"ok"}`, 20))
+	n, err := q.PushN(text)
+	if err != nil {
+		t.Fatalf("PushN failed: %v", err)
+	}
+
+	// Incompressible data is stored as is
+	noise := make([]byte, 64)
+	rand.Read(noise)
+	if m, err := q.PushN(noise); err != nil || m != len(noise) {
+		t.Fatalf("PushN returned %d, %v, want %d", m, err, len(noise))
+	}
+
+	// A handle without the codec sees the stored bytes
+	raw, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer raw.Close()
+	stored, err := raw.Peek(128)
+	if err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	if len(stored) >= len(text)/4 {
+		t.Errorf("Expected %d bytes compressed to under a quarter, got %d", len(text), len(stored))
+	}
+	if n != len(stored)-q.env.size() {
+		t.Errorf("PushN returned %d, stored %d with the envelope", n, len(stored))
+	}
+
+	// The uncompressed length bounds the pop, not the stored one
+	if _, err := q.Pop(128); err != ErrTooBig || queued(q) != 2 {
+		t.Errorf("Expected ErrTooBig leaving both queued, got %v with %d", err, queued(q))
+	}
+	out, err := q.Pop(len(text))
+	if err != nil || !bytes.Equal(out, text) {
+		t.Errorf("Round trip failed: %v", err)
+	}
+	out, err = q.Pop(128)
+	if err != nil || !bytes.Equal(out, noise) {
+		t.Errorf("Round trip of incompressible data failed: %v", err)
+	}
+}
+
+func TestCompressionTooBig(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithMaxMessage(1024),
+		WithCompression(NewLZ4Codec()))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	text := []byte(strings.Repeat("abcd", 50))
+	if err := q.Push(text); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	// Every pop path refuses a buffer too small and leaves the message
+	small := len(text) - 1
+	if _, err := q.PopInto(make([]byte, small)); err != ErrTooBig {
+		t.Errorf("PopInto: expected ErrTooBig, got %v", err)
+	}
+	if _, err := q.PopBatch(4, small); err != ErrTooBig {
+		t.Errorf("PopBatch: expected ErrTooBig, got %v", err)
+	}
+	if _, err := q.PopExactly(1, small); err != ErrTooBig {
+		t.Errorf("PopExactly: expected ErrTooBig, got %v", err)
+	}
+	bufs := [][]byte{make([]byte, small)}
+	if _, err := q.DrainInto(bufs); err != ErrTooBig {
+		t.Errorf("DrainInto: expected ErrTooBig, got %v", err)
+	}
+	if _, _, err := q.PopNoAck(small); err != ErrTooBig {
+		t.Errorf("PopNoAck: expected ErrTooBig, got %v", err)
+	}
+	if n := queued(q); n != 1 {
+		t.Fatalf("Expected the message still queued, got %d", n)
+	}
+
+	// A batch stops before the message that does not fit
+	q.Push([]byte("x"))
+	msgs, err := q.PopBatch(4, len(text))
+	if err != nil || len(msgs) != 2 || !bytes.Equal(msgs[0], text) {
+		t.Errorf("PopBatch returned %d messages, %v", len(msgs), err)
+	}
+	q.Push([]byte("x"))
+	q.Push(text)
+	msgs, err = q.PopBatch(4, small)
+	if err != nil || len(msgs) != 1 || queued(q) != 1 {
+		t.Errorf("Expected one message and one left, got %d, %v, %d", len(msgs), err, queued(q))
+	}
+	bufs = [][]byte{make([]byte, small), make([]byte, len(text))}
+	if _, err := q.DrainInto(bufs); err != ErrTooBig || queued(q) != 1 {
+		t.Errorf("DrainInto: expected ErrTooBig, got %v with %d left", err, queued(q))
+	}
+	bufs[0], bufs[1] = bufs[1], bufs[0]
+	if n, err := q.DrainInto(bufs); n != 1 || err != nil || !bytes.Equal(bufs[0], text) {
+		t.Errorf("DrainInto returned %d, %v", n, err)
+	}
+}
+
+func TestCompressionBroadcast(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Broadcast),
+		WithMaxMessage(1024),
+		WithCompression(NewLZ4Codec()))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithCompression(NewLZ4Codec()))
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	// A reader that cannot take the message rewinds to it
+	text := []byte(strings.Repeat("abcd", 50))
+	p.Push(text)
+	p.Push([]byte("x"))
+	if _, err := c.Pop(16); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	if msgs, err := c.PopBatch(4, 16); err != ErrTooBig || len(msgs) != 0 {
+		t.Errorf("Expected ErrTooBig, got %d messages, %v", len(msgs), err)
+	}
+	msgs, err := c.PopBatch(4, len(text))
+	if err != nil || len(msgs) != 2 || !bytes.Equal(msgs[0], text) {
+		t.Errorf("PopBatch returned %d messages, %v", len(msgs), err)
+	}
+}
+
+func TestCompressionOverwrite(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithOverwrite(),
+		WithCompression(NewLZ4Codec())); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL creating an overwrite queue, got %v", err)
+	}
+
+	// An existing overwrite queue is refused on attach as well
+	p, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithOverwrite())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Consumer),
+		WithCompression(NewLZ4Codec())); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL attaching to an overwrite queue, got %v", err)
+	}
+}
+
+func TestCompressionChunked(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChunking(),
+		WithCompression(NewFlateCodec(flate.BestSpeed)))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Too big for a slot even compressed, so it is split uncompressed
+	noise := make([]byte, 200)
+	rand.Read(noise)
+	text := append(noise, strings.Repeat("abcd", 100)...)
+	if n, err := q.PushN(text); err != nil || n != len(text) {
+		t.Fatalf("PushN returned %d, %v", n, err)
+	}
+	if _, err := q.Pop(len(text) - 1); err != ErrTooBig || queued(q) == 0 {
+		t.Errorf("Expected ErrTooBig leaving the message, got %v", err)
+	}
+	if out, err := q.Pop(len(text)); err != nil || !bytes.Equal(out, text) {
+		t.Errorf("Round trip failed: %v", err)
+	}
+}
+
+func TestCompressionHelpers(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(128),
+		WithFlags(Create|Producer|Consumer),
+		WithMaxMessage(1024),
+		WithCompression(NewFlateCodec(flate.BestSpeed)))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Each value is longer than a slot but compresses to fit one
+	want := map[string]string{"pad": strings.Repeat("x", 400)}
+	if err := q.PushJSON(want); err != nil {
+		t.Fatalf("PushJSON failed: %v", err)
+	}
+	var got map[string]string
+	if err := q.PopJSON(&got); err != nil || got["pad"] != want["pad"] {
+		t.Errorf("PopJSON failed: %v", err)
+	}
+	if err := q.PushGob(want); err != nil {
+		t.Fatalf("PushGob failed: %v", err)
+	}
+	got = nil
+	if err := q.PopGob(&got); err != nil || got["pad"] != want["pad"] {
+		t.Errorf("PopGob failed: %v", err)
+	}
+
+	body := bytes.Repeat([]byte("frame"), 80)
+	if err := q.PushFrame(Header{Type: 5}, body); err != nil {
+		t.Fatalf("PushFrame failed: %v", err)
+	}
+	if hdr, b, err := q.PopFrame(); err != nil || hdr.Type != 5 || !bytes.Equal(b, body) {
+		t.Errorf("PopFrame returned %+v with %d bytes, %v", hdr, len(b), err)
+	}
+
+	q.Push(body)
+	var frames bytes.Buffer
+	if n, err := q.WriteTo(&frames); err != nil || n != int64(frameHeaderSize+len(body)) {
+		t.Fatalf("WriteTo returned %d, %v", n, err)
+	}
+	if !bytes.Equal(frames.Bytes()[frameHeaderSize:], body) || queued(q) != 0 {
+		t.Errorf("WriteTo wrote %d bytes, %d left queued", frames.Len(), queued(q))
+	}
+
+	// The limit bounds pushes before compression
+	if err := q.Push(make([]byte, 1025)); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig past the limit, got %v", err)
+	}
+}
+
+func TestCompressionDefaultLimit(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(128),
+		WithFlags(Create|Producer|Consumer),
+		WithCompression(NewFlateCodec(flate.BestSpeed)))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Without WithMaxMessage a message the helpers could not pop is refused
+	if err := q.PushJSON(strings.Repeat("x", 400)); err != ErrTooBig || queued(q) != 0 {
+		t.Errorf("Expected ErrTooBig with nothing queued, got %v", err)
+	}
+	if n := q.MaxMessage(); n != 128-SlotHeaderSize-q.env.size() {
+		t.Errorf("Expected the slot payload as MaxMessage, got %d", n)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	noise := make([]byte, 3000)
+	rand.Read(noise)
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("short, nothing repeats"),
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("ab"), 17),
+		[]byte(strings.Repeat(`{"level":"info","msg":"ok"}`, 40)),
+		noise,
+		append(append(noise[:100:100], bytes.Repeat([]byte("xyz"), 300)...), noise[100:400]...),
+	}
+	for name, codec := range map[string]Codec{
+		"flate": NewFlateCodec(flate.BestSpeed),
+		"lz4":   NewLZ4Codec(),
+	} {
+		for i, in := range inputs {
+			c, err := codec.Compress([]byte("prefix"), in)
+			if err != nil || !bytes.HasPrefix(c, []byte("prefix")) {
+				t.Fatalf("%s: Compress %d failed: %v", name, i, err)
+			}
+			c = c[len("prefix"):]
+			out, err := codec.Decompress(nil, c, len(in))
+			if err != nil || !bytes.Equal(out, in) {
+				t.Errorf("%s: round trip %d failed: %v", name, i, err)
+			}
+			if len(in) > 0 {
+				if _, err := codec.Decompress(nil, c, len(in)-1); err == nil {
+					t.Errorf("%s: expected input %d to fail past the limit", name, i)
+				}
+			}
+
+			// Truncated or damaged input fails or decodes, but never panics
+			for k := range c {
+				codec.Decompress(nil, c[:k], len(in))
+				d := bytes.Clone(c)
+				d[k] ^= 0xff
+				codec.Decompress(nil, d, len(in))
+			}
+		}
+	}
+}
+
+// queued returns the number of messages in q, 0 on failure
+func queued(q *Queue) int {
+	n, _ := q.Len()
+	return n
+}
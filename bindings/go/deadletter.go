@@ -0,0 +1,83 @@
+package nabd
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// DeadReason says why a message was sent to a dead-letter queue
+type DeadReason uint8
+
+// Dead-letter reasons. Values are part of the header format and never
+// change meaning.
+const (
+	DeadTooBig  DeadReason = 1 // Push rejected it with ErrTooBig
+	DeadEmpty   DeadReason = 2 // Push rejected it under RejectEmpty
+	DeadExpired DeadReason = 3 // Its TTL passed before it was popped
+	DeadCorrupt DeadReason = 4 // It failed checksum verification
+)
+
+func (r DeadReason) String() string {
+	switch r {
+	case DeadTooBig:
+		return "too big"
+	case DeadEmpty:
+		return "empty"
+	case DeadExpired:
+		return "expired"
+	case DeadCorrupt:
+		return "corrupt"
+	}
+	return "DeadReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// DeadLetterHeaderSize is the size of the header in front of every
+// dead-lettered message: a version byte (1) followed by the DeadReason.
+// For DeadCorrupt the payload is the raw slot contents, envelope
+// included, since the original could not be recovered.
+const DeadLetterHeaderSize = 2
+
+// deadLetterVersion is the header version written by this package
+const deadLetterVersion = 1
+
+// ParseDeadLetter splits a message popped from a dead-letter queue into
+// its reason and original payload. A message without a valid header
+// returns ErrCorrupt.
+func ParseDeadLetter(msg []byte) (DeadReason, []byte, error) {
+	if len(msg) < DeadLetterHeaderSize || msg[0] != deadLetterVersion {
+		return 0, nil, ErrCorrupt
+	}
+	return DeadReason(msg[1]), msg[DeadLetterHeaderSize:], nil
+}
+
+// deadLetters counts what a handle routed to its dead-letter queue
+type deadLetters struct {
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// deadLetter routes data to the handle's dead-letter queue, if any. When
+// the dead-letter queue rejects it, for instance because it is full, the
+// message is dropped and counted.
+func (q *Queue) deadLetter(reason DeadReason, data []byte) {
+	if q.dlq == nil {
+		return
+	}
+
+	msg := make([]byte, DeadLetterHeaderSize+len(data))
+	msg[0] = deadLetterVersion
+	msg[1] = byte(reason)
+	copy(msg[DeadLetterHeaderSize:], data)
+
+	if q.dlq.Push(msg) != nil {
+		q.dead.dropped.Add(1)
+		return
+	}
+	q.dead.sent.Add(1)
+}
+
+// expire accounts for an expired message the handle discarded
+func (q *Queue) expire(data []byte) {
+	q.expired.Add(1)
+	q.deadLetter(DeadExpired, data)
+}
@@ -0,0 +1,73 @@
+package nabd
+
+import (
+	"testing"
+	"time"
+)
+
+const testDeadQueue = "/nabd_go_dlq_test"
+
+func TestDeadLetter(t *testing.T) {
+	Unlink(TestQueue)
+	Unlink(testDeadQueue)
+	defer Unlink(TestQueue)
+	defer Unlink(testDeadQueue)
+
+	dlq, err := Open(testDeadQueue, 2, 256, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer dlq.Close()
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithExpiry(),
+		WithDeadLetter(dlq))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	big := make([]byte, 100)
+	if err := q.Push(big); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	q.PushTTL([]byte("late"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := q.Pop(64); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	want := []struct {
+		reason DeadReason
+		size   int
+	}{{DeadTooBig, 100}, {DeadExpired, 4}}
+	for _, w := range want {
+		msg, err := dlq.Pop(256)
+		if err != nil {
+			t.Fatalf("Pop from dead-letter queue failed: %v", err)
+		}
+		reason, data, err := ParseDeadLetter(msg)
+		if err != nil {
+			t.Fatalf("ParseDeadLetter failed: %v", err)
+		}
+		if reason != w.reason || len(data) != w.size {
+			t.Errorf("Expected %v with %d bytes, got %v with %d", w.reason, w.size, reason, len(data))
+		}
+	}
+
+	// A full dead-letter queue drops and counts
+	for i := 0; i < 3; i++ {
+		q.Push(big)
+	}
+	s, _ := q.Stats()
+	if s.DeadLetters != 4 || s.DeadLetterDrops != 1 {
+		t.Errorf("Expected 4 sent and 1 dropped, got %d and %d", s.DeadLetters, s.DeadLetterDrops)
+	}
+
+	if _, _, err := ParseDeadLetter([]byte{9, 1}); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt for unknown version, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package nabd
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrDuplicate is returned by PushDedup for an id pushed recently
+var ErrDuplicate = errors.New("duplicate message")
+
+// DefaultDedupWindow is how many recent ids PushDedup remembers, unless
+// WithDedupWindow says otherwise
+const DefaultDedupWindow = 1024
+
+// The id window lives in a shared-memory file next to the queue,
+// <name>.dedup, so every producer sees the ids the others pushed. The
+// 24-byte header is followed by one 64-bit id hash per entry, used as a
+// ring:
+//
+//	header: magic, window, next (entries ever recorded)
+const (
+	dedupMagic  = 0x3150554444424e41 // "ANBDDUP1" in little-endian order
+	dedupHeader = 24
+	dedupNext   = 16
+)
+
+// dedupState is a producer's view of the id window
+type dedupState struct {
+	table
+	window uint64
+}
+
+// dedupPath returns the id window file of queue name
+func dedupPath(name string) string {
+	return sideDir + name + ".dedup"
+}
+
+// openDedup maps the id window of queue name, creating it if needed
+func openDedup(name string, window uint64) (*dedupState, error) {
+	size := int64(dedupHeader + 8*window)
+	t, err := openTable("open dedup", name, dedupPath(name), dedupMagic, size, func(t table) {
+		t.store(8, window)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(t)) != size || t.load(8) != window {
+		t.close()
+		return nil, &QueueError{Op: "open dedup", Name: name, Errno: syscall.EINVAL}
+	}
+	return &dedupState{table: t, window: window}, nil
+}
+
+// seen reports whether key is among the last window recorded ids
+func (d *dedupState) seen(key uint64) bool {
+	for i := uint64(0); i < d.window; i++ {
+		if d.load(dedupHeader+8*i) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// record adds key to the window, evicting the oldest id once it is full
+func (d *dedupState) record(key uint64) {
+	n := atomic.AddUint64(d.word(dedupNext), 1) - 1
+	d.store(dedupHeader+8*(n%d.window), key)
+}
+
+// dedupKey hashes id, or data when id is empty. Zero marks a free entry,
+// so it is never returned.
+func dedupKey(id string, data []byte) uint64 {
+	h := fnv.New64a()
+	if id != "" {
+		h.Write([]byte(id))
+	} else {
+		h.Write(data)
+	}
+	return max(h.Sum64(), 1)
+}
+
+// PushDedup pushes data unless a message with the same id was pushed
+// recently, in which case it returns ErrDuplicate
+//
+// An empty id uses a hash of data instead, so identical payloads count as
+// duplicates. Ids are remembered for the last DefaultDedupWindow pushes
+// made with PushDedup, or the size set WithDedupWindow, across every
+// producer of the queue; plain pushes are not tracked. Detection is best
+// effort in two ways: an id that has aged out of the window is accepted
+// again, and a producer that crashes between the push and recording its
+// id lets a retry through. Ids are compared by 64-bit hash, so distinct
+// ids collide with negligible but nonzero probability.
+func (q *Queue) PushDedup(id string, data []byte) error {
+	q.dedupMu.Lock()
+	defer q.dedupMu.Unlock()
+
+	key := dedupKey(id, data)
+	if dup, err := q.dedupCheck(key); err != nil {
+		return err
+	} else if dup {
+		return ErrDuplicate
+	}
+	if err := q.Push(data); err != nil {
+		return err
+	}
+
+	// The message is out even if Close ran meanwhile and the id is lost
+	if _, err := q.acquire(); err == nil {
+		q.dedup.record(key)
+		q.mu.RUnlock()
+	}
+	return nil
+}
+
+// dedupCheck reports whether key is in the id window, mapping the window
+// on first use
+func (q *Queue) dedupCheck(key uint64) (bool, error) {
+	if _, err := q.acquire(); err != nil {
+		return false, err
+	}
+	defer q.mu.RUnlock()
+
+	if q.dedup == nil {
+		d, err := openDedup(q.name, uint64(q.dedupWindow))
+		if err != nil {
+			return false, err
+		}
+		q.dedup = d
+	}
+	return q.dedup.seen(key), nil
+}
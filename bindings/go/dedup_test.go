@@ -0,0 +1,66 @@
+package nabd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPushDedup(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithDedupWindow(2))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushDedup("a", []byte("first")); err != nil {
+		t.Fatalf("PushDedup failed: %v", err)
+	}
+	if err := q.PushDedup("a", []byte("retry")); err != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+
+	// Another handle shares the window
+	c, err := q.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if err := c.PushDedup("a", []byte("retry")); err != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate through the clone, got %v", err)
+	}
+	c.Close()
+
+	// An empty id falls back to the payload
+	if err := q.PushDedup("", []byte("same")); err != nil {
+		t.Fatalf("PushDedup failed: %v", err)
+	}
+	if err := q.PushDedup("", []byte("same")); err != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate for the same payload, got %v", err)
+	}
+
+	// Two newer ids push "a" out of the window
+	if err := q.PushDedup("b", []byte("second")); err != nil {
+		t.Fatalf("PushDedup failed: %v", err)
+	}
+	if err := q.PushDedup("a", []byte("again")); err != nil {
+		t.Errorf("Expected an aged-out id to be accepted, got %v", err)
+	}
+
+	if n, _ := q.Len(); n != 4 {
+		t.Errorf("Expected 4 messages, got %d", n)
+	}
+	if _, err := os.Stat(dedupPath(TestQueue)); err != nil {
+		t.Errorf("Expected the id window file: %v", err)
+	}
+	q.Close()
+	Unlink(TestQueue)
+	if _, err := os.Stat(dedupPath(TestQueue)); !os.IsNotExist(err) {
+		t.Errorf("Expected Unlink to remove the id window, got %v", err)
+	}
+}
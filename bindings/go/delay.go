@@ -0,0 +1,69 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// PushDelayed pushes data so that it is not delivered before delay has
+// passed. It needs a queue opened WithDelay and a non-negative delay, and
+// otherwise fails with EINVAL. A delayed message is never split over
+// several slots, so under WithChunking one larger than a slot fails with
+// ErrTooBig.
+//
+// The delivery time is checked against the consumer's wall clock, so
+// producer and consumer clocks must agree to within the precision you
+// need.
+func (q *Queue) PushDelayed(data []byte, delay time.Duration) error {
+	if !q.env.delay || delay < 0 {
+		return &QueueError{Op: "push", Name: q.name, Errno: syscall.EINVAL}
+	}
+	_, err := q.push(data, 0, time.Now().Add(delay).UnixNano())
+	return err
+}
+
+// PopReady pops the next message if it is due, like Pop. When the next
+// message is not due yet it returns ErrEmpty together with the time until
+// it is, so the caller can sleep that long; the duration is 0 when the
+// queue is empty. It needs a queue opened WithDelay and otherwise fails
+// with EINVAL.
+func (q *Queue) PopReady(maxLen int) ([]byte, time.Duration, error) {
+	if !q.env.delay {
+		return nil, 0, &QueueError{Op: "pop", Name: q.name, Errno: syscall.EINVAL}
+	}
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer q.mu.RUnlock()
+
+	if maxLen <= 0 {
+		return nil, 0, ErrTooBig
+	}
+	data, _, err := q.popBuf(h, make([]byte, maxLen+q.env.size()))
+	if err == ErrEmpty {
+		return nil, q.holdback(h), err
+	}
+	return data, 0, err
+}
+
+// holdback returns how long until the message at the tail is due. It is 0
+// when the message is due, when there is none, and when it cannot be
+// inspected, such as a message split over several slots or one failing
+// its checksum; the pop then handles it as usual.
+func (q *Queue) holdback(h *C.nabd_t) time.Duration {
+	if !q.env.delay {
+		return 0
+	}
+	var data unsafe.Pointer
+	var size C.size_t
+	if C.nabd_peek(h, &data, &size) != C.NABD_OK {
+		return 0
+	}
+	return q.env.holdback(unsafe.Slice((*byte)(data), int(size)))
+}
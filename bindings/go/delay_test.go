@@ -0,0 +1,147 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPushDelayed(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithDelay())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Push([]byte("now")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if err := q.PushDelayed([]byte("soon"), 20*time.Millisecond); err != nil {
+		t.Fatalf("PushDelayed failed: %v", err)
+	}
+	if err := q.PushDelayed([]byte("later"), 40*time.Millisecond); err != nil {
+		t.Fatalf("PushDelayed failed: %v", err)
+	}
+	if err := q.Push([]byte("after")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	if out, err := q.Pop(64); err != nil || string(out) != "now" {
+		t.Fatalf("Expected now, got %q, %v", out, err)
+	}
+
+	// A message that is not due stays queued and holds back the rest
+	out, wait, err := q.PopReady(64)
+	if err != ErrEmpty || wait <= 0 || wait > 20*time.Millisecond {
+		t.Fatalf("Expected ErrEmpty with a wait up to 20ms, got %q, %v, %v", out, wait, err)
+	}
+	if n, _ := q.Len(); n != 3 {
+		t.Errorf("Expected 3 queued messages, got %d", n)
+	}
+	if err := q.WaitReadable(0); err != ErrTimeout {
+		t.Errorf("Expected the queue not to be readable, got %v", err)
+	}
+
+	// Once due they come out in push order
+	for _, want := range []string{"soon", "later", "after"} {
+		out, err := q.PopWait(64, time.Second)
+		if err != nil || string(out) != want {
+			t.Fatalf("Expected %s, got %q, %v", want, out, err)
+		}
+	}
+	if _, wait, err := q.PopReady(64); err != ErrEmpty || wait != 0 {
+		t.Errorf("Expected ErrEmpty with no wait, got %v, %v", wait, err)
+	}
+}
+
+func TestPushDelayedEveryPop(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithDelay())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("first"))
+	q.PushDelayed([]byte("held"), time.Hour)
+
+	// A batch stops short of the message that is not due
+	msgs, err := q.PopBatch(4, 64)
+	if err != nil || len(msgs) != 1 || string(msgs[0]) != "first" {
+		t.Fatalf("Expected [first], got %q, %v", msgs, err)
+	}
+
+	calls := map[string]func() error{
+		"Pop":         func() error { _, err := q.Pop(64); return err },
+		"PopInto":     func() error { _, err := q.PopInto(make([]byte, 64)); return err },
+		"PopBatch":    func() error { _, err := q.PopBatch(4, 64); return err },
+		"PopExactly":  func() error { _, err := q.PopExactly(1, 64); return err },
+		"DrainInto":   func() error { _, err := q.DrainInto([][]byte{make([]byte, 64)}); return err },
+		"Peek":        func() error { _, err := q.Peek(64); return err },
+		"PopZeroCopy": func() error { _, err := q.PopZeroCopy(); return err },
+		"PopNoAck":    func() error { _, _, err := q.PopNoAck(64); return err },
+	}
+	for name, call := range calls {
+		if err := call(); err != ErrEmpty {
+			t.Errorf("%s: expected ErrEmpty, got %v", name, err)
+		}
+	}
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("Expected the held message to stay queued, got %d", n)
+	}
+	if i, err := Select([]*Queue{q}, 0); err != ErrTimeout {
+		t.Errorf("Expected Select to find nothing ready, got %d, %v", i, err)
+	}
+}
+
+func TestPushDelayedNeedsDelay(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushDelayed([]byte("x"), time.Second); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+	if _, _, err := q.PopReady(64); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+}
+
+func TestWithDelayBroadcast(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer|Broadcast), WithDelay()); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL creating a Broadcast queue, got %v", err)
+	}
+
+	// An existing Broadcast queue is refused on attach as well
+	p, err := Open(TestQueue, 16, 64, Create|Producer|Broadcast)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithDelay()); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL attaching to a Broadcast queue, got %v", err)
+	}
+}
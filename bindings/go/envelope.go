@@ -0,0 +1,236 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"time"
+)
+
+// castagnoli is the CRC32C table; hash/crc32 uses the SSE4.2 and ARMv8
+// CRC instructions for it when the CPU has them
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// envelope describes the fields a handle stores in front of each payload,
+// in this order: CRC32C (4 bytes, covering everything after it), sequence
+// number (8 bytes), push time in Unix nanoseconds (8 bytes), expiry
+// deadline in Unix nanoseconds (8 bytes, 0 for none), delivery time in
+// Unix nanoseconds (8 bytes, 0 for at once), then a compression flag
+// (1 byte, 1 if codec compressed the payload). A compressed payload starts
+// with its uncompressed length as a uvarint, so a pop can tell whether it
+// fits before consuming it. Every handle on a queue must agree on the
+// envelope; the ring itself does not record what was chosen.
+type envelope struct {
+	checksum  bool
+	sequence  bool
+	timestamp bool
+	expiry    bool
+	delay     bool
+	codec     Codec
+}
+
+// stamp holds the envelope fields carried by one message
+type stamp struct {
+	seq        uint64
+	time       int64
+	deadline   int64
+	ready      int64
+	compressed bool
+}
+
+// newStamp returns the fields for a message pushed now as number seq
+func (e envelope) newStamp(seq uint64, deadline int64) stamp {
+	st := stamp{seq: seq, deadline: deadline}
+	if e.timestamp {
+		st.time = time.Now().UnixNano()
+	}
+	return st
+}
+
+// size returns the bytes the envelope takes from each slot
+func (e envelope) size() int {
+	n := 0
+	if e.checksum {
+		n += 4
+	}
+	if e.sequence {
+		n += 8
+	}
+	if e.timestamp {
+		n += 8
+	}
+	if e.expiry {
+		n += 8
+	}
+	if e.delay {
+		n += 8
+	}
+	if e.codec != nil {
+		n++
+	}
+	return n
+}
+
+// seal returns data prefixed with the envelope fields, compressing it
+// first when that makes it smaller
+func (e envelope) seal(data []byte, st stamp) []byte {
+	if e.codec != nil {
+		c := binary.AppendUvarint(nil, uint64(len(data)))
+		if c, err := e.codec.Compress(c, data); err == nil && len(c) < len(data) {
+			data, st.compressed = c, true
+		}
+	}
+	return e.wrap(data, st)
+}
+
+// wrap stores data uncompressed behind the envelope fields of st
+func (e envelope) wrap(data []byte, st stamp) []byte {
+	msg := make([]byte, e.size()+len(data))
+	copy(msg[e.size():], data)
+	e.sealHeader(msg, st)
+	return msg
+}
+
+// sealHeader fills in the envelope fields of msg, whose payload is
+// already in place after them
+func (e envelope) sealHeader(msg []byte, st stamp) {
+	off := 0
+	if e.checksum {
+		off += 4
+	}
+	if e.sequence {
+		binary.BigEndian.PutUint64(msg[off:], st.seq)
+		off += 8
+	}
+	if e.timestamp {
+		binary.BigEndian.PutUint64(msg[off:], uint64(st.time))
+		off += 8
+	}
+	if e.expiry {
+		binary.BigEndian.PutUint64(msg[off:], uint64(st.deadline))
+		off += 8
+	}
+	if e.delay {
+		binary.BigEndian.PutUint64(msg[off:], uint64(st.ready))
+		off += 8
+	}
+	if e.codec != nil {
+		msg[off] = 0
+		if st.compressed {
+			msg[off] = 1
+		}
+	}
+	if e.checksum {
+		binary.BigEndian.PutUint32(msg, crc32.Checksum(msg[4:], castagnoli))
+	}
+}
+
+// open verifies msg and returns the payload and fields inside it. A
+// compressed payload recording more than limit bytes fails with ErrTooBig
+// before it is inflated; a negative limit takes any recorded length.
+func (e envelope) open(msg []byte, limit int) ([]byte, stamp, error) {
+	var st stamp
+	if len(msg) < e.size() {
+		return nil, st, ErrCorrupt
+	}
+
+	off := 0
+	if e.checksum {
+		if binary.BigEndian.Uint32(msg) != crc32.Checksum(msg[4:], castagnoli) {
+			return nil, st, ErrCorrupt
+		}
+		off += 4
+	}
+	if e.sequence {
+		st.seq = binary.BigEndian.Uint64(msg[off:])
+		off += 8
+	}
+	if e.timestamp {
+		st.time = int64(binary.BigEndian.Uint64(msg[off:]))
+		off += 8
+	}
+	if e.expiry {
+		st.deadline = int64(binary.BigEndian.Uint64(msg[off:]))
+		off += 8
+	}
+	if e.delay {
+		st.ready = int64(binary.BigEndian.Uint64(msg[off:]))
+		off += 8
+	}
+	payload := msg[e.size():]
+	if e.codec != nil {
+		switch msg[off] {
+		case 0:
+		case 1:
+			n, k := binary.Uvarint(payload)
+			if k <= 0 || n > math.MaxInt32 {
+				return nil, st, ErrCorrupt
+			}
+			if limit >= 0 && n > uint64(limit) {
+				return nil, st, ErrTooBig
+			}
+			data, err := e.codec.Decompress(nil, payload[k:], int(n))
+			if err != nil || len(data) != int(n) {
+				return nil, st, ErrCorrupt
+			}
+			payload, st.compressed = data, true
+		default:
+			return nil, st, ErrCorrupt
+		}
+	}
+	return payload, st, nil
+}
+
+// expired reports whether the message's deadline has passed
+func (e envelope) expired(st stamp) bool {
+	return e.expiry && st.deadline != 0 && time.Now().UnixNano() >= st.deadline
+}
+
+// fits reports whether sealed message msg opens to at most limit bytes,
+// read in place from the length a compressed payload records. An
+// uncompressed payload, which the ring already bounds, always fits, and
+// so does one too short or failing its checksum, which open then reports.
+func (e envelope) fits(msg []byte, limit int) bool {
+	if e.codec == nil || len(msg) <= e.size() || msg[e.size()-1] != 1 || !e.intact(msg) {
+		return true
+	}
+	n, k := binary.Uvarint(msg[e.size():])
+	return k <= 0 || n <= uint64(limit)
+}
+
+// intact reports whether msg passes its checksum, if the envelope has one
+func (e envelope) intact(msg []byte) bool {
+	return !e.checksum || len(msg) >= 4 &&
+		binary.BigEndian.Uint32(msg) == crc32.Checksum(msg[4:], castagnoli)
+}
+
+// holdback returns how long until sealed message msg is due, read in
+// place without opening it. It is 0 for a message that is due or has no
+// delivery time, and for one too short or failing its checksum, which
+// open then reports.
+func (e envelope) holdback(msg []byte) time.Duration {
+	if !e.delay || len(msg) < e.size() {
+		return 0
+	}
+	if !e.intact(msg) {
+		return 0
+	}
+	off := 0
+	if e.checksum {
+		off += 4
+	}
+	if e.sequence {
+		off += 8
+	}
+	if e.timestamp {
+		off += 8
+	}
+	if e.expiry {
+		off += 8
+	}
+	ready := int64(binary.BigEndian.Uint64(msg[off:]))
+	if ready == 0 {
+		return 0
+	}
+	return max(time.Until(time.Unix(0, ready)), 0)
+}
@@ -0,0 +1,81 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"syscall"
+)
+
+// QueueError describes a failed call into the C library
+//
+// errors.Is(err, ErrFailed) still reports true for it. It also unwraps to
+// the syscall.Errno behind the failure, so checks such as
+// errors.Is(err, os.ErrNotExist) or errors.Is(err, os.ErrPermission) work.
+type QueueError struct {
+	Op    string        // Operation that failed, such as "open" or "push"
+	Name  string        // Queue name, when known
+	Errno syscall.Errno // Underlying cause
+}
+
+func (e *QueueError) Error() string {
+	if e.Name != "" {
+		return "nabd " + e.Op + " " + e.Name + ": " + e.Errno.Error()
+	}
+	return "nabd " + e.Op + ": " + e.Errno.Error()
+}
+
+// Unwrap returns the underlying errno
+func (e *QueueError) Unwrap() error {
+	return e.Errno
+}
+
+// Is reports whether target is ErrFailed, or ErrVersionMismatch for an
+// EPROTO failure
+func (e *QueueError) Is(target error) bool {
+	return target == ErrFailed || target == ErrVersionMismatch && e.Errno == syscall.EPROTO
+}
+
+// closedError is the type of ErrClosed
+type closedError struct{}
+
+func (closedError) Error() string {
+	return "queue closed"
+}
+
+// Is reports whether target is ErrFailed
+func (closedError) Is(target error) bool {
+	return target == ErrFailed
+}
+
+// failure builds the error for C return code ret. errno is the value a
+// two-value cgo call captured; it is only consulted for NABD_SYSERR.
+func failure(op, name string, ret C.int, errno error) error {
+	if ret == C.NABD_RESIZED {
+		return ErrResized
+	}
+	var no syscall.Errno
+	switch ret {
+	case C.NABD_NOMEM:
+		no = syscall.ENOMEM
+	case C.NABD_INVALID:
+		no = syscall.EINVAL
+	case C.NABD_EXISTS:
+		no = syscall.EEXIST
+	case C.NABD_NOTFOUND:
+		no = syscall.ENOENT
+	case C.NABD_CORRUPTED:
+		no = syscall.EBADMSG
+	case C.NABD_VERSION:
+		no = syscall.EPROTO
+	case C.NABD_PERMISSION:
+		no = syscall.EACCES
+	case C.NABD_SYSERR:
+		no, _ = errno.(syscall.Errno)
+	}
+	if no == 0 {
+		no = syscall.EIO
+	}
+	return &QueueError{Op: op, Name: name, Errno: no}
+}
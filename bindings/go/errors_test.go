@@ -0,0 +1,100 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestOpenMissingError(t *testing.T) {
+	Unlink(TestQueue)
+
+	_, err := Open(TestQueue, 0, 0, Consumer)
+	if err == nil {
+		t.Fatal("Expected Open of a missing queue to fail")
+	}
+	if !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected error to match ErrFailed, got %v", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected error to match os.ErrNotExist, got %v", err)
+	}
+
+	var qe *QueueError
+	if !errors.As(err, &qe) {
+		t.Fatalf("Expected *QueueError, got %T", err)
+	}
+	if qe.Op != "open" || qe.Name != TestQueue || qe.Errno != syscall.ENOENT {
+		t.Errorf("Expected open %s ENOENT, got %s %s %v", TestQueue, qe.Op, qe.Name, qe.Errno)
+	}
+}
+
+func TestOpenInvalidError(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// A handle must be a producer, a consumer or both
+	_, err := Open(TestQueue, 16, 64, Create)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+}
+
+func TestOpenVersionMismatch(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	if info, err := p.Info(); err != nil || info.Version != LayoutVersion {
+		t.Errorf("Expected layout version %#x, got %#x, %v", LayoutVersion, info.Version, err)
+	}
+
+	f, err := os.OpenFile(shmDir+TestQueue, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	// Rewrite the version field as a newer release might, then as 0.1
+	// did before its header grew
+	for _, version := range []uint64{LayoutVersion + 1, 0<<16 | 1} {
+		var v [8]byte
+		binary.NativeEndian.PutUint64(v[:], version)
+		if _, err := f.WriteAt(v[:], 8); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+		_, err = Open(TestQueue, 0, 0, Consumer)
+		if !errors.Is(err, ErrVersionMismatch) || !errors.Is(err, ErrFailed) {
+			t.Errorf("Version %#x: expected ErrVersionMismatch, got %v", version, err)
+		}
+	}
+}
+
+func TestQueueErrorName(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	// The library refuses a push while a reservation is open; the error
+	// names the queue
+	if _, err := q.Reserve(8); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	var qe *QueueError
+	if err := q.Push([]byte("x")); !errors.As(err, &qe) || qe.Op != "push" || qe.Name != TestQueue {
+		t.Errorf("Expected push %s error, got %v", TestQueue, err)
+	}
+}
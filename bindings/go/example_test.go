@@ -0,0 +1,42 @@
+package nabd_test
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+)
+
+// Measure how long messages wait in the ring, bucketed by powers of two
+// microseconds.
+func ExampleQueue_PopTimed() {
+	q, err := nabd.OpenWithOptions("/latency",
+		nabd.WithFlags(nabd.Consumer),
+		nabd.WithTimestamp())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer q.Close()
+
+	var buckets [32]int
+	for i := 0; i < 10000; i++ {
+		_, pushed, err := q.PopTimed(4096)
+		if err == nabd.ErrEmpty {
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			fmt.Println(err)
+			return
+		}
+		us := uint64(time.Since(pushed).Microseconds())
+		buckets[bits.Len64(us)]++
+	}
+
+	for b, n := range buckets {
+		if n > 0 {
+			fmt.Printf("< %6dus: %d\n", 1<<b, n)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// FrameHeaderSize is the size of the header PushFrame puts in front of
+// each body: the content type, the flags, then the body length as a
+// big-endian uint16. It sits inside the payload, after any envelope
+// fields, so framing works together with checksums and sequence numbers.
+const FrameHeaderSize = 4
+
+// Header tags a framed message so a consumer can dispatch on it without
+// looking at the body
+type Header struct {
+	Type  uint8 // Content type, meaning defined by the application
+	Flags uint8 // Application flags
+}
+
+// PushFrame pushes body behind a frame header carrying hdr. The body may
+// be up to FrameHeaderSize bytes shorter than a plain message; anything
+// longer returns ErrTooBig.
+func (q *Queue) PushFrame(hdr Header, body []byte) error {
+	if len(body) > math.MaxUint16 {
+		return ErrTooBig
+	}
+	var b [FrameHeaderSize]byte
+	b[0] = hdr.Type
+	b[1] = hdr.Flags
+	binary.BigEndian.PutUint16(b[2:], uint16(len(body)))
+
+	// PushVectored fills a single slot as is, so a frame that needs
+	// splitting WithChunking or compressing to fit is joined for Push
+	if n, err := q.maxPayload(); err == nil && (q.chunked || q.env.codec != nil) &&
+		FrameHeaderSize+len(body) > n {
+		return q.Push(append(b[:], body...))
+	}
+	return q.PushVectored([][]byte{b[:], body})
+}
+
+// PopFrame pops the next message pushed by PushFrame and splits it into
+// its header and body. The buffer is sized as in PopJSON, so any frame
+// fits. A message too short for a header, or whose length field does not
+// match, has already been removed and returns ErrCorrupt.
+func (q *Queue) PopFrame() (Header, []byte, error) {
+	n, err := q.maxMessage()
+	if err != nil {
+		return Header{}, nil, err
+	}
+	msg, err := q.Pop(n)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	if len(msg) < FrameHeaderSize ||
+		int(binary.BigEndian.Uint16(msg[2:])) != len(msg)-FrameHeaderSize {
+		return Header{}, nil, ErrCorrupt
+	}
+	return Header{Type: msg[0], Flags: msg[1]}, msg[FrameHeaderSize:], nil
+}
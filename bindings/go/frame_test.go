@@ -0,0 +1,76 @@
+package nabd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPushPopFrame(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushFrame(Header{Type: 7, Flags: 0x81}, []byte("payload")); err != nil {
+		t.Fatalf("PushFrame failed: %v", err)
+	}
+	if err := q.PushFrame(Header{Type: 1}, nil); err != nil {
+		t.Fatalf("PushFrame of an empty body failed: %v", err)
+	}
+
+	hdr, body, err := q.PopFrame()
+	if err != nil || hdr != (Header{Type: 7, Flags: 0x81}) || string(body) != "payload" {
+		t.Errorf("Unexpected frame %+v %q, %v", hdr, body, err)
+	}
+	hdr, body, err = q.PopFrame()
+	if err != nil || hdr.Type != 1 || len(body) != 0 {
+		t.Errorf("Unexpected frame %+v %q, %v", hdr, body, err)
+	}
+
+	// The header comes out of the payload budget
+	n, _ := q.maxPayload()
+	if err := q.PushFrame(Header{}, make([]byte, n-FrameHeaderSize+1)); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	q.Push([]byte("no"))
+	if _, _, err := q.PopFrame(); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt for an unframed message, got %v", err)
+	}
+	if _, _, err := q.PopFrame(); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestPushPopFrameChunked(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChunking())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	body := bytes.Repeat([]byte("frame"), 40)
+	if err := q.PushFrame(Header{Type: 2}, body); err != nil {
+		t.Fatalf("PushFrame failed: %v", err)
+	}
+	hdr, got, err := q.PopFrame()
+	if err != nil || hdr.Type != 2 || !bytes.Equal(got, body) {
+		t.Errorf("Unexpected frame %+v of %d bytes, %v", hdr, len(got), err)
+	}
+}
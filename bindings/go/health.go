@@ -0,0 +1,45 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+
+// HealthError explains why Healthy found a queue unusable
+type HealthError struct {
+	Name   string // Queue name
+	Reason string // What is wrong, such as "tail 7 is ahead of head 1"
+}
+
+func (e *HealthError) Error() string {
+	return "nabd unhealthy " + e.Name + ": " + e.Reason
+}
+
+// Healthy checks that the queue is still safe to use
+//
+// It verifies that the handle is open, that the shared header still has
+// the NABD magic and the layout version this library understands, that
+// the ring geometry and segment size match the mapping, and that head and
+// tail are within capacity of each other. A failed check returns false
+// with a *HealthError describing it. After another handle resized the
+// ring it returns ErrResized until Remap is called.
+//
+// Healthy only reads the header, so it is cheap enough for a readiness
+// probe. Unlike Stats it is a consistency check, and it does not look at
+// message payloads; use WithChecksum for those.
+func (q *Queue) Healthy() (bool, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer q.mu.RUnlock()
+
+	var why [128]C.char
+	ret := C.nabd_check(h, &why[0], C.size_t(len(why)))
+	if ret == C.NABD_OK {
+		return true, nil
+	} else if ret == C.NABD_CORRUPTED {
+		return false, &HealthError{Name: q.name, Reason: C.GoString(&why[0])}
+	}
+	return false, failure("check", q.name, ret, nil)
+}
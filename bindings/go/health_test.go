@@ -0,0 +1,52 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHealthy(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if ok, err := q.Healthy(); !ok || err != nil {
+		t.Fatalf("Expected a new queue to be healthy, got %v", err)
+	}
+
+	// Move the tail past the head, as a stray write into the header would
+	f, err := os.OpenFile(shmDir+TestQueue, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	stomp := make([]byte, 8)
+	binary.NativeEndian.PutUint64(stomp, 5)
+	_, err = f.WriteAt(stomp, 128)
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	ok, err := q.Healthy()
+	var he *HealthError
+	if ok || !errors.As(err, &he) {
+		t.Fatalf("Expected a HealthError, got %v, %v", ok, err)
+	}
+	if he.Name != TestQueue || !strings.Contains(he.Reason, "tail 5") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	q.Close()
+	if _, err := q.Healthy(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
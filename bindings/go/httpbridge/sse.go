@@ -0,0 +1,143 @@
+// Package httpbridge connects NABD queues to HTTP clients, so a browser
+// can tail a queue as Server-Sent Events, or exchange messages with a
+// pair of queues over WebSocket, without a custom client.
+//
+// It is a separate package so the core binding does not import net/http.
+package httpbridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+)
+
+// DefaultHeartbeat is how often an idle SSE stream sends a comment to
+// keep proxies from closing the connection
+const DefaultHeartbeat = 15 * time.Second
+
+// SSE serves a queue as a Server-Sent Events stream. Each message becomes
+// one event whose data is the message; a message with several lines
+// becomes one data field per line, which EventSource joins back with
+// newlines. Messages should therefore be text.
+//
+// On a Broadcast queue every client reads through a clone of Queue with a
+// cursor of its own, so each browser sees every message pushed after it
+// connected. A plain queue hands each message to one consumer only, and
+// takes one consumer at a time, so SSE then serves a single client and
+// answers others with 503 Service Unavailable.
+//
+// A client that disconnects, or whose connection fails a write, is dropped
+// and its clone closed. The message being written when that happens is
+// lost to it. A message larger than MaxLen ends the stream, since it stays
+// queued and would block every later one.
+type SSE struct {
+	Queue     *nabd.Queue   // Consumer handle to stream from
+	Heartbeat time.Duration // Idle time before a keep-alive comment, 0 for DefaultHeartbeat
+	MaxLen    int           // Largest message, 0 for the queue's MaxMessage
+
+	busy atomic.Bool // a client is streaming a plain queue
+}
+
+// SSEHandler returns an SSE handler for q with the default heartbeat
+func SSEHandler(q *nabd.Queue) http.Handler {
+	return &SSE{Queue: q}
+}
+
+// ServeHTTP streams messages to the client until it goes away or the
+// queue is closed
+func (s *SSE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	info, err := s.Queue.Info()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := s.Queue
+	if info.Broadcast {
+		if q, err = s.Queue.Clone(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer q.Close()
+	} else {
+		if !s.busy.CompareAndSwap(false, true) {
+			http.Error(w, "queue already has a client", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.busy.Store(false)
+	}
+
+	beat := s.Heartbeat
+	if beat <= 0 {
+		beat = DefaultHeartbeat
+	}
+	maxLen := s.MaxLen
+	if maxLen <= 0 {
+		maxLen = q.MaxMessage()
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	// The first comment sends the headers, so the client knows it is
+	// attached before any message arrives
+	if !writeFlush(w, rc, []byte(": connected\n\n")) {
+		return
+	}
+
+	ctx := r.Context()
+	for {
+		wait, cancel := context.WithTimeout(ctx, beat)
+		msg, err := q.PopContext(wait, maxLen)
+		cancel()
+
+		var event []byte
+		switch {
+		case err == nil:
+			event = formatEvent(msg)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			event = []byte(": heartbeat\n\n")
+		case err == nabd.ErrLapped:
+			event = []byte(": lapped, messages skipped\n\n")
+		case err == nabd.ErrCorrupt:
+			event = []byte(": corrupt message skipped\n\n")
+		case err == nabd.ErrTooBig:
+			// The message stays queued, so every later pop would fail too
+			writeFlush(w, rc, []byte(": message larger than MaxLen, stream stopped\n\n"))
+			return
+		default:
+			return
+		}
+		if !writeFlush(w, rc, event) {
+			return
+		}
+	}
+}
+
+// formatEvent encodes msg as one event, a data field per line
+func formatEvent(msg []byte) []byte {
+	var b bytes.Buffer
+	for _, line := range bytes.Split(msg, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimSuffix(line, []byte("\r")))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// writeFlush writes p and pushes it to the client, reporting whether the
+// connection is still good
+func writeFlush(w http.ResponseWriter, rc *http.ResponseController, p []byte) bool {
+	if _, err := w.Write(p); err != nil {
+		return false
+	}
+	return rc.Flush() == nil
+}
@@ -0,0 +1,139 @@
+package httpbridge
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+)
+
+const testQueue = "/nabd_go_http_test"
+
+// sseClient reads the lines of one event stream
+type sseClient struct {
+	resp  *http.Response
+	lines *bufio.Scanner
+}
+
+func dialSSE(t *testing.T, url string) *sseClient {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("Expected 200, got %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+	c := &sseClient{resp: resp, lines: bufio.NewScanner(resp.Body)}
+	if line := c.next(t); line != ": connected" {
+		t.Fatalf("Expected the connected comment, got %q", line)
+	}
+	return c
+}
+
+// next returns the next non-blank line
+func (c *sseClient) next(t *testing.T) string {
+	t.Helper()
+	for c.lines.Scan() {
+		if line := c.lines.Text(); line != "" {
+			return line
+		}
+	}
+	t.Fatalf("Stream ended: %v", c.lines.Err())
+	return ""
+}
+
+func TestSSEHandler(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+
+	p, err := nabd.Open(testQueue, 16, 64, nabd.Create|nabd.Producer|nabd.Broadcast)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := nabd.Open(testQueue, 0, 0, nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	srv := httptest.NewServer(&SSE{Queue: c, Heartbeat: 20 * time.Millisecond})
+	defer srv.Close()
+
+	// Every client sees every message, a line of data per message line
+	clients := []*sseClient{dialSSE(t, srv.URL), dialSSE(t, srv.URL)}
+	p.Push([]byte("hello"))
+	p.Push([]byte("two\nlines"))
+	for i, cl := range clients {
+		var got []string
+		for len(got) < 3 {
+			if line := cl.next(t); !strings.HasPrefix(line, ":") {
+				got = append(got, line)
+			}
+		}
+		if strings.Join(got, "|") != "data: hello|data: two|data: lines" {
+			t.Errorf("Client %d: unexpected events %q", i, got)
+		}
+	}
+
+	// An idle stream sends heartbeat comments
+	if line := clients[0].next(t); line != ": heartbeat" {
+		t.Errorf("Expected a heartbeat, got %q", line)
+	}
+
+	// Disconnected clients give their clones back
+	attached, _ := c.Attached()
+	for _, cl := range clients {
+		cl.resp.Body.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := c.Attached(); n == attached-2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the clones to close after the clients left")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSSEHandlerSingleClient(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+
+	q, err := nabd.Open(testQueue, 16, 64, nabd.Create|nabd.Producer|nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	srv := httptest.NewServer(SSEHandler(q))
+	defer srv.Close()
+
+	// A plain queue takes one consumer, so a second client is turned away
+	cl := dialSSE(t, srv.URL)
+	defer cl.resp.Body.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a second client, got %s", resp.Status)
+	}
+
+	q.Push([]byte("only"))
+	if line := cl.next(t); line != "data: only" {
+		t.Errorf("Expected data: only, got %q", line)
+	}
+}
@@ -0,0 +1,440 @@
+package httpbridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+)
+
+// WS wires a WebSocket client to two queues: every WebSocket message the
+// client sends is pushed to In as one queue message, and every message
+// popped from Out is sent to the client as one WebSocket message, text if
+// it is valid UTF-8 and binary otherwise. Either queue may be nil for a
+// one-way bridge; client messages are then discarded, or nothing is sent
+// besides pings.
+//
+// A full In is waited out rather than dropped: the bridge stops reading
+// from the socket until a slot frees up, so the client is held back by
+// TCP flow control. Pushes from several clients are serialized, so In
+// needs no WithConcurrentProducers. Out is read as SSE reads its queue:
+// through a clone per client on a Broadcast queue, by one client at a
+// time otherwise.
+//
+// The protocol is RFC 6455 without extensions or subprotocols, served with
+// the standard library alone. A client message larger than MaxLen, or one
+// In rejects as too big, closes the connection with status 1009.
+//
+// Browsers let any page open a WebSocket to any host, with the user's
+// cookies, so by default an upgrade whose Origin header names another
+// host than the request is refused with 403. One without an Origin header
+// comes from outside a browser, which always sends it, and is accepted.
+// Set CheckOrigin to allow other origins.
+type WS struct {
+	In           *nabd.Queue   // Producer handle client messages go to, or nil
+	Out          *nabd.Queue   // Consumer handle streamed to the client, or nil
+	Heartbeat    time.Duration // Idle time before a ping, 0 for DefaultHeartbeat
+	MaxLen       int           // Largest message either way, 0 for each queue's MaxMessage
+	WriteTimeout time.Duration // Longest a frame may take to send, 0 for DefaultWriteTimeout
+
+	// CheckOrigin reports whether to accept the upgrade request r, and
+	// nil accepts only same-origin ones
+	CheckOrigin func(r *http.Request) bool
+
+	busy   atomic.Bool // a client is reading a plain Out queue
+	pushMu sync.Mutex  // serializes pushes to In
+}
+
+// WSHandler returns a WebSocket handler that pushes client messages to in
+// and streams messages popped from out
+func WSHandler(in, out *nabd.Queue) http.Handler {
+	return &WS{In: in, Out: out}
+}
+
+// DefaultWriteTimeout is how long a WebSocket frame may take to reach a
+// client before the connection is dropped, so a client that stops reading
+// cannot hold the bridge
+const DefaultWriteTimeout = 10 * time.Second
+
+// wsGUID is the key suffix of the opening handshake
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes
+const (
+	closeNormal    = 1000
+	closeProtocol  = 1002
+	closeTooBig    = 1009
+	closeInternal  = 1011
+	maxControlSize = 125
+)
+
+// wsClosed is returned by readMessage once the client sent a close frame
+var wsClosed = errors.New("websocket closed by peer")
+
+// wsError is a protocol violation, closed with its status code
+type wsError struct {
+	code   int
+	reason string
+}
+
+func (e *wsError) Error() string {
+	return "websocket: " + e.reason
+}
+
+// ServeHTTP upgrades the connection and bridges it until either side
+// closes it or a queue is closed
+func (s *WS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	check := s.CheckOrigin
+	if check == nil {
+		check = sameOrigin
+	}
+	if !check(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	out := s.Out
+	if out != nil {
+		info, err := out.Info()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if info.Broadcast {
+			if out, err = s.Out.Clone(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer out.Close()
+		} else {
+			if !s.busy.CompareAndSwap(false, true) {
+				http.Error(w, "queue already has a client", http.StatusServiceUnavailable)
+				return
+			}
+			defer s.busy.Store(false)
+		}
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	c := &wsConn{conn: conn, r: brw.Reader, timeout: s.WriteTimeout}
+	if c.timeout <= 0 {
+		c.timeout = DefaultWriteTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The reader pushes client messages until the connection ends
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		defer cancel()
+		s.readLoop(ctx, c)
+	}()
+
+	s.writeLoop(ctx, c, out)
+	cancel()
+	conn.Close()
+	<-read
+}
+
+// readLoop pushes each client message to In, waiting while it is full
+func (s *WS) readLoop(ctx context.Context, c *wsConn) {
+	limit := s.MaxLen
+	if limit <= 0 && s.In != nil {
+		limit = s.In.MaxMessage()
+	}
+	for {
+		msg, err := c.readMessage(limit)
+		var we *wsError
+		if errors.As(err, &we) {
+			c.writeClose(we.code, we.reason)
+			return
+		} else if err == wsClosed {
+			c.writeClose(closeNormal, "")
+			return
+		} else if err != nil {
+			return
+		}
+		if s.In == nil {
+			continue
+		}
+
+		s.pushMu.Lock()
+		err = s.In.PushContext(ctx, msg)
+		s.pushMu.Unlock()
+		if err == nabd.ErrTooBig {
+			c.writeClose(closeTooBig, "message larger than the queue takes")
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				c.writeClose(closeInternal, err.Error())
+			}
+			return
+		}
+	}
+}
+
+// writeLoop sends messages popped from out, or pings while there are none
+func (s *WS) writeLoop(ctx context.Context, c *wsConn, out *nabd.Queue) {
+	beat := s.Heartbeat
+	if beat <= 0 {
+		beat = DefaultHeartbeat
+	}
+	if out == nil {
+		t := time.NewTicker(beat)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if c.writeFrame(opPing, nil) != nil {
+					return
+				}
+			}
+		}
+	}
+
+	maxLen := s.MaxLen
+	if maxLen <= 0 {
+		maxLen = out.MaxMessage()
+	}
+	for {
+		wait, stop := context.WithTimeout(ctx, beat)
+		msg, err := out.PopContext(wait, maxLen)
+		stop()
+
+		switch {
+		case err == nil:
+			op := byte(opBinary)
+			if utf8.Valid(msg) {
+				op = opText
+			}
+			err = c.writeFrame(op, msg)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			err = c.writeFrame(opPing, nil)
+		case err == nabd.ErrLapped || err == nabd.ErrCorrupt:
+			err = nil
+		case ctx.Err() != nil:
+			return
+		case err == nabd.ErrTooBig:
+			c.writeClose(closeTooBig, "queue message larger than MaxLen")
+			return
+		default:
+			c.writeClose(closeInternal, err.Error())
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wsConn is the server side of an upgraded connection
+type wsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration // write deadline for each frame
+	mu      sync.Mutex    // serializes frame writes
+}
+
+// readMessage returns the next data message, reassembled from its
+// fragments, answering pings on the way
+func (c *wsConn) readMessage(limit int) ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame(limit - len(msg))
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return nil, wsClosed
+		case opText, opBinary:
+			if started {
+				return nil, &wsError{closeProtocol, "new message inside a fragmented one"}
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, &wsError{closeProtocol, "continuation without a message"}
+			}
+		default:
+			return nil, &wsError{closeProtocol, "unknown opcode"}
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if msg == nil {
+				msg = []byte{}
+			}
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame whose payload may be at most limit bytes,
+// control frames excepted
+func (c *wsConn) readFrame(limit int) (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, &wsError{closeProtocol, "reserved bits set"}
+	}
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, &wsError{closeProtocol, "client frame not masked"}
+	}
+
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose {
+		if !fin || n > maxControlSize {
+			return false, 0, nil, &wsError{closeProtocol, "bad control frame"}
+		}
+	} else if n > uint64(max(limit, 0)) {
+		return false, 0, nil, &wsError{closeTooBig, "message larger than the queue takes"}
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame sends payload as one unfragmented frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	hdr := make([]byte, 2, 10+len(payload))
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(append(hdr, payload...))
+	return err
+}
+
+// writeClose sends a close frame with status code and reason
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > maxControlSize-2 {
+		reason = reason[:maxControlSize-2]
+	}
+	return c.writeFrame(opClose, append(payload, reason...))
+}
+
+// sameOrigin reports whether r has no Origin header or one naming the
+// host r was sent to
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerHas reports whether the comma-separated header name lists token,
+// ignoring case
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package httpbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+)
+
+const testOutQueue = "/nabd_go_http_out_test"
+
+// wsClient is just enough of a WebSocket client to drive the handler
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWS(t *testing.T, url string) *wsClient {
+	t.Helper()
+	c, resp := upgradeWS(t, url, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %s", resp.Status)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key %q", got)
+	}
+	return c
+}
+
+// upgradeWS sends the opening handshake, with an Origin header unless
+// origin is empty, and returns the connection and the response
+func upgradeWS(t *testing.T, url, origin string) (*wsClient, *http.Response) {
+	t.Helper()
+	host := strings.TrimPrefix(url, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The key and accept value are the example of RFC 6455
+	if origin != "" {
+		origin = "Origin: " + origin + "\r\n"
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\n"+origin+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	return &wsClient{conn: conn, r: r}, resp
+}
+
+// send writes one masked frame
+func (c *wsClient) send(t *testing.T, fin bool, op byte, payload string) {
+	t.Helper()
+	b := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i := 0; i < len(payload); i++ {
+		b = append(b, payload[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+// recv reads one frame, skipping pings unless wantPing is set
+func (c *wsClient) recv(t *testing.T, wantPing bool) (byte, []byte) {
+	t.Helper()
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		n := int(hdr[1] & 0x7f)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(c.r, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if op := hdr[0] & 0x0f; op != opPing || wantPing {
+			return op, payload
+		}
+	}
+}
+
+func TestWSHandler(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+	nabd.Unlink(testOutQueue)
+	defer nabd.Unlink(testOutQueue)
+
+	in, err := nabd.Open(testQueue, 2, 64, nabd.Create|nabd.Producer)
+	if err != nil {
+		t.Fatalf("Open in failed: %v", err)
+	}
+	defer in.Close()
+	pipeline, err := nabd.Open(testQueue, 0, 0, nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open in consumer failed: %v", err)
+	}
+	defer pipeline.Close()
+	results, err := nabd.Open(testOutQueue, 16, 64, nabd.Create|nabd.Producer|nabd.Broadcast)
+	if err != nil {
+		t.Fatalf("Open out failed: %v", err)
+	}
+	defer results.Close()
+	out, err := nabd.Open(testOutQueue, 0, 0, nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open out consumer failed: %v", err)
+	}
+	defer out.Close()
+
+	srv := httptest.NewServer(&WS{In: in, Out: out, Heartbeat: 20 * time.Millisecond})
+	defer srv.Close()
+	c := dialWS(t, srv.URL)
+	defer c.conn.Close()
+
+	// One WebSocket message, fragmented or not, is one queue message
+	c.send(t, true, opText, "hello")
+	c.send(t, false, opText, "ab")
+	c.send(t, true, opContinuation, "cd")
+	for _, want := range []string{"hello", "abcd"} {
+		msg, err := pipeline.PopWait(64, 5*time.Second)
+		if err != nil || string(msg) != want {
+			t.Fatalf("Expected %s, got %q, %v", want, msg, err)
+		}
+	}
+
+	// A full queue holds the client back instead of dropping
+	for _, m := range []string{"1", "2", "3"} {
+		c.send(t, true, opBinary, m)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := pipeline.Len(); n != 2 {
+		t.Errorf("Expected a full queue of 2, got %d", n)
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		msg, err := pipeline.PopWait(64, 5*time.Second)
+		if err != nil || string(msg) != want {
+			t.Fatalf("Expected %s, got %q, %v", want, msg, err)
+		}
+	}
+
+	// Popped messages go out as text or binary frames
+	results.Push([]byte("result"))
+	results.Push([]byte{0xff})
+	if op, p := c.recv(t, false); op != opText || string(p) != "result" {
+		t.Errorf("Expected text frame result, got %d %q", op, p)
+	}
+	if op, p := c.recv(t, false); op != opBinary || string(p) != "\xff" {
+		t.Errorf("Expected binary frame, got %d %q", op, p)
+	}
+
+	// Pings are answered, and an idle connection is pinged
+	c.send(t, true, opPing, "are you there")
+	if op, p := c.recv(t, false); op != opPong || string(p) != "are you there" {
+		t.Errorf("Expected a pong, got %d %q", op, p)
+	}
+	if op, _ := c.recv(t, true); op != opPing {
+		t.Errorf("Expected a heartbeat ping, got %d", op)
+	}
+
+	// A message the queue cannot take closes the connection with 1009
+	c.send(t, true, opText, strings.Repeat("x", 100))
+	op, p := c.recv(t, false)
+	if op != opClose || len(p) < 2 || binary.BigEndian.Uint16(p) != closeTooBig {
+		t.Errorf("Expected close 1009, got %d %q", op, p)
+	}
+}
+
+func TestWSHandlerRejectsPlainRequest(t *testing.T) {
+	srv := httptest.NewServer(WSHandler(nil, nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an upgrade, got %s", resp.Status)
+	}
+}
+
+func TestWSHandlerOrigin(t *testing.T) {
+	ws := &WS{}
+	srv := httptest.NewServer(ws)
+	defer srv.Close()
+
+	// Same-origin and non-browser clients are let in, others refused
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, c := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + host, http.StatusSwitchingProtocols},
+		{"https://attacker.example", http.StatusForbidden},
+		{"http://" + host + ".attacker.example", http.StatusForbidden},
+	} {
+		conn, resp := upgradeWS(t, srv.URL, c.origin)
+		conn.conn.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("Origin %q: expected %d, got %s", c.origin, c.want, resp.Status)
+		}
+	}
+
+	ws.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://app.example"
+	}
+	conn, resp := upgradeWS(t, srv.URL, "https://app.example")
+	conn.conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected CheckOrigin to allow the upgrade, got %s", resp.Status)
+	}
+}
+
+func TestWSHandlerWriteTimeout(t *testing.T) {
+	nabd.Unlink(testOutQueue)
+	defer nabd.Unlink(testOutQueue)
+
+	results, err := nabd.Open(testOutQueue, 16, 1<<16, nabd.Create|nabd.Producer|nabd.Broadcast)
+	if err != nil {
+		t.Fatalf("Open out failed: %v", err)
+	}
+	defer results.Close()
+	out, err := nabd.Open(testOutQueue, 0, 0, nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open out consumer failed: %v", err)
+	}
+	defer out.Close()
+
+	done := make(chan struct{})
+	ws := &WS{Out: out, WriteTimeout: 50 * time.Millisecond}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		ws.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// A client that stops reading is dropped once the socket fills up
+	c := dialWS(t, srv.URL)
+	defer c.conn.Close()
+	msg := make([]byte, 60000)
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			t.Fatal("Expected the handler to give up on a client that never reads")
+		default:
+			results.Push(msg)
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package nabd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// queueWriter maps each Write to one Push
+type queueWriter struct {
+	q *Queue
+}
+
+// Writer returns an io.Writer that pushes every Write as one message
+//
+// Framing is preserved: each Write call becomes exactly one message. A
+// short write never happens; Write either enqueues all of p and returns
+// len(p), or enqueues nothing and returns 0 with ErrFull, ErrTooBig or
+// ErrFailed. An empty p follows the queue's EmptyPolicy.
+func (q *Queue) Writer() io.Writer {
+	return &queueWriter{q: q}
+}
+
+func (w *queueWriter) Write(p []byte) (int, error) {
+	if err := w.q.Push(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// queueReader maps each Read to one Pop
+type queueReader struct {
+	q     *Queue
+	ctx   context.Context
+	block bool
+}
+
+// Reader returns a non-blocking io.Reader that pops one message per Read
+//
+// Message boundaries are preserved: each Read returns exactly one queued
+// message, never part of one and never two merged. When the queue is
+// empty Read returns (0, nil). A message larger than p is left queued and
+// Read returns ErrTooBig.
+func (q *Queue) Reader() io.Reader {
+	return &queueReader{q: q, ctx: context.Background()}
+}
+
+// BlockingReader is like Reader, but Read waits for a message instead of
+// returning (0, nil). Read returns ctx.Err() once ctx is done and
+// ErrClosed if the queue is closed.
+func (q *Queue) BlockingReader(ctx context.Context) io.Reader {
+	return &queueReader{q: q, ctx: ctx, block: true}
+}
+
+func (r *queueReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !r.block {
+		n, err := r.q.PopInto(p)
+		if err == ErrEmpty {
+			return 0, nil
+		}
+		return n, err
+	}
+
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	var n int
+	err := r.q.retry(r.ctx, -1, ErrEmpty, func() (err error) {
+		n, err = r.q.PopInto(p)
+		return err
+	})
+	return n, err
+}
+
+// frameHeaderSize is the length prefix written before each message by
+// WriteTo and expected by ReadFrom: the payload length as a big-endian
+// uint32.
+const frameHeaderSize = 4
+
+// ErrTruncated is returned by ReadFrom when the input ends inside a frame
+var ErrTruncated = errors.New("truncated frame")
+
+// WriteTo implements io.WriterTo. It pops messages until the queue is
+// empty and writes each one to w as a length-prefixed frame, returning
+// the total number of bytes written including prefixes.
+//
+// Finding the queue empty ends the stream and is not an error. If w
+// fails, the message being written is lost and WriteTo returns the write
+// error; everything after it stays queued.
+func (q *Queue) WriteTo(w io.Writer) (int64, error) {
+	n, err := q.maxMessage()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, frameHeaderSize+n)
+
+	var total int64
+	for {
+		n, err := q.PopInto(buf[frameHeaderSize:])
+		if err == ErrEmpty {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+
+		binary.BigEndian.PutUint32(buf, uint32(n))
+		written, err := w.Write(buf[:frameHeaderSize+n])
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom. It reads length-prefixed frames as
+// written by WriteTo and pushes each as one message until r returns
+// io.EOF at a frame boundary. The count is the number of input bytes
+// belonging to frames that were pushed.
+//
+// ReadFrom does not wait for space: on ErrFull it returns immediately,
+// and the frame that did not fit has already been consumed from r. Use
+// ReadFromContext to block instead. Input that ends inside a frame
+// returns ErrTruncated; a frame longer than the handle can push, one slot
+// or under WithChunking the whole ring, returns ErrTooBig.
+func (q *Queue) ReadFrom(r io.Reader) (int64, error) {
+	return q.readFrom(context.Background(), r, 0)
+}
+
+// ReadFromContext is like ReadFrom, but waits for space when the queue is
+// full. It returns ctx.Err() once ctx is done and ErrClosed if the queue
+// is closed meanwhile.
+func (q *Queue) ReadFromContext(ctx context.Context, r io.Reader) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return q.readFrom(ctx, r, -1)
+}
+
+func (q *Queue) readFrom(ctx context.Context, r io.Reader, timeout time.Duration) (int64, error) {
+	n, err := q.maxMessage()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, n)
+
+	var total int64
+	var hdr [frameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, frameError(err)
+		}
+
+		size := binary.BigEndian.Uint32(hdr[:])
+		if uint64(size) > uint64(len(buf)) {
+			return total, ErrTooBig
+		}
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			return total, frameError(err)
+		}
+
+		if err := q.pushWait(ctx, buf[:size], timeout); err != nil {
+			return total, err
+		}
+		total += int64(frameHeaderSize + size)
+	}
+}
+
+// frameError maps a short read inside a frame to ErrTruncated
+func frameError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
@@ -0,0 +1,308 @@
+package nabd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	w := p.Writer()
+	if _, err := fmt.Fprintf(w, "record %d", 1); err != nil {
+		t.Fatalf("Fprintf failed: %v", err)
+	}
+	if n, err := w.Write([]byte("two")); err != nil || n != 3 {
+		t.Errorf("Expected 3 bytes written, got %d, %v", n, err)
+	}
+	if n, err := w.Write([]byte("three")); err != ErrFull || n != 0 {
+		t.Errorf("Expected ErrFull with 0 bytes, got %d, %v", n, err)
+	}
+
+	for _, want := range []string{"record 1", "two"} {
+		out, err := c.Pop(128)
+		if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("Expected %s, got %s", want, out)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	r := c.Reader()
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); n != 0 || err != nil {
+		t.Errorf("Expected (0, nil) on empty queue, got %d, %v", n, err)
+	}
+
+	p.Push([]byte("one"))
+	p.Push([]byte("two"))
+
+	// Each Read returns exactly one message
+	for _, want := range []string{"one", "two"} {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("Expected %s, got %s", want, buf[:n])
+		}
+	}
+
+	p.Push([]byte("too long"))
+	if _, err := r.Read(buf[:2]); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	br := c.BlockingReader(ctx)
+	n, err := br.Read(buf)
+	if err != nil || string(buf[:n]) != "too long" {
+		t.Errorf("Expected too long, got %s, %v", buf[:n], err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Push([]byte("later"))
+	}()
+	n, err = br.Read(buf)
+	if err != nil || string(buf[:n]) != "later" {
+		t.Errorf("Expected later, got %s, %v", buf[:n], err)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	msgs := []string{"alpha", "", "gamma"}
+	for _, m := range msgs {
+		p.Push([]byte(m))
+	}
+
+	var out bytes.Buffer
+	n, err := c.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("Expected %d bytes reported, got %d", out.Len(), n)
+	}
+
+	data := out.Bytes()
+	for _, want := range msgs {
+		size := binary.BigEndian.Uint32(data)
+		got := string(data[frameHeaderSize : frameHeaderSize+size])
+		if got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+		data = data[frameHeaderSize+size:]
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected no trailing bytes, got %d", len(data))
+	}
+
+	// An empty queue writes nothing
+	if n, err := c.WriteTo(&out); n != 0 || err != nil {
+		t.Errorf("Expected 0, nil on empty queue, got %d, %v", n, err)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	// Round-trip through WriteTo
+	msgs := []string{"one", "", "three"}
+	for _, m := range msgs {
+		p.Push([]byte(m))
+	}
+	var frames bytes.Buffer
+	written, err := c.WriteTo(&frames)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := p.ReadFrom(bytes.NewReader(frames.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if n != written {
+		t.Errorf("Expected %d bytes read, got %d", written, n)
+	}
+	for _, want := range msgs {
+		got, err := c.Pop(64)
+		if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+
+	// A frame cut short is an error, not a short push
+	cut := frames.Bytes()[:frames.Len()-2]
+	if _, err := p.ReadFrom(bytes.NewReader(cut)); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	c.Drain()
+
+	// Without room ReadFrom stops at ErrFull
+	var many bytes.Buffer
+	for i := 0; i < 6; i++ {
+		many.Write([]byte{0, 0, 0, 1, 'x'})
+	}
+	n, err = p.ReadFrom(&many)
+	if err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	if n != 4*(frameHeaderSize+1) {
+		t.Errorf("Expected %d bytes read, got %d", 4*(frameHeaderSize+1), n)
+	}
+}
+
+func TestWriteToChunked(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChunking())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Frames longer than a slot survive the round trip both ways
+	long := bytes.Repeat([]byte("chunk"), 40)
+	q.Push(long)
+	q.Push([]byte("short"))
+	var frames bytes.Buffer
+	written, err := q.WriteTo(&frames)
+	if err != nil || written != int64(2*frameHeaderSize+len(long)+5) {
+		t.Fatalf("WriteTo returned %d, %v", written, err)
+	}
+
+	if n, err := q.ReadFrom(&frames); err != nil || n != written {
+		t.Fatalf("ReadFrom returned %d, %v, want %d", n, err, written)
+	}
+	if got, err := q.Pop(len(long)); err != nil || !bytes.Equal(got, long) {
+		t.Errorf("Pop of the long message failed: %v", err)
+	}
+	if got, err := q.Pop(64); err != nil || string(got) != "short" {
+		t.Errorf("Expected short, got %q, %v", got, err)
+	}
+}
+
+func TestReadFromContext(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	var frames bytes.Buffer
+	for i := 0; i < 5; i++ {
+		frames.Write([]byte{0, 0, 0, 1, byte(i)})
+	}
+
+	// The consumer makes room while ReadFromContext blocks
+	got := make(chan []byte, 5)
+	go func() {
+		for i := 0; i < 5; i++ {
+			msg, err := c.PopWait(64, time.Second)
+			if err != nil {
+				break
+			}
+			got <- msg
+		}
+		close(got)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := p.ReadFromContext(ctx, &frames); err != nil {
+		t.Fatalf("ReadFromContext failed: %v", err)
+	}
+
+	i := 0
+	for msg := range got {
+		if msg[0] != byte(i) {
+			t.Errorf("Expected %d, got %d", i, msg[0])
+		}
+		i++
+	}
+	if i != 5 {
+		t.Errorf("Expected 5 messages, got %d", i)
+	}
+}
@@ -0,0 +1,53 @@
+package nabd
+
+import (
+	"context"
+	"iter"
+)
+
+// All returns an iterator that pops messages of at most maxLen bytes until
+// the queue is empty:
+//
+//	for msg, err := range q.All(256) {
+//		if err != nil {
+//			return err
+//		}
+//		handle(msg)
+//	}
+//
+// An empty queue ends the loop without an error. Any other failure, such
+// as ErrTooBig or ErrClosed, is yielded once with a nil message and ends
+// it too. Each iteration is one Pop on the caller's goroutine, so breaking
+// out of the loop leaves nothing running and every later message queued.
+func (q *Queue) All(maxLen int) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			msg, err := q.Pop(maxLen)
+			if err == ErrEmpty {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// AllBlocking returns an iterator like All that waits for new messages
+// instead of stopping at an empty queue, as PopContext does. Cancelling
+// ctx ends the loop without an error; a pop that fails otherwise is
+// yielded once and ends it. As with All nothing runs between iterations,
+// so breaking out of the loop leaks no goroutine.
+func (q *Queue) AllBlocking(ctx context.Context, maxLen int) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for {
+			msg, err := q.PopContext(ctx, maxLen)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package nabd
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for _, m := range []string{"a", "b", "c"} {
+		q.Push([]byte(m))
+	}
+
+	// Breaking out leaves the rest queued
+	for msg, err := range q.All(64) {
+		if err != nil || string(msg) != "a" {
+			t.Fatalf("Expected a, got %q, %v", msg, err)
+		}
+		break
+	}
+	var got []string
+	for msg, err := range q.All(64) {
+		if err != nil {
+			t.Fatalf("All failed: %v", err)
+		}
+		got = append(got, string(msg))
+	}
+	if len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("Expected [b c], got %q", got)
+	}
+
+	// Other errors are yielded once and end the loop
+	q.Push([]byte("too long for the buffer"))
+	n := 0
+	for msg, err := range q.All(4) {
+		n++
+		if msg != nil || err != ErrTooBig {
+			t.Errorf("Expected ErrTooBig, got %q, %v", msg, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("Expected one iteration, got %d", n)
+	}
+}
+
+func TestAllBlocking(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	go func() {
+		for _, m := range []string{"a", "b"} {
+			time.Sleep(10 * time.Millisecond)
+			q.Push([]byte(m))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	goroutines := runtime.NumGoroutine()
+	var got []string
+	for msg, err := range q.AllBlocking(ctx, 64) {
+		if err != nil {
+			t.Fatalf("AllBlocking failed: %v", err)
+		}
+		if got = append(got, string(msg)); len(got) == 2 {
+			break
+		}
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected [a b], got %q", got)
+	}
+
+	// Cancellation ends the loop quietly
+	cancelled, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	for msg, err := range q.AllBlocking(cancelled, 64) {
+		t.Errorf("Expected no iteration, got %q, %v", msg, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("Expected no leftover goroutines, had %d now %d", goroutines, n)
+	}
+}
@@ -0,0 +1,182 @@
+package nabd
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultLatencyBuckets are the histogram bucket bounds used when
+// WithLatencyHistogram is given none: powers of ten from 1µs to 10s
+var DefaultLatencyBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// The histogram lives in a shared-memory file next to the queue,
+// <name>.latency, so every consumer adds to the same counts and any
+// handle can read them. A 64-byte header is followed by the n bucket
+// bounds and then n+1 counts, all 64-bit:
+//
+//	header: magic, n, count, sum of latencies in ns, largest latency in ns
+//	bounds: upper bound of bucket i in ns, strictly increasing
+//	counts: messages with latency <= bound i and > bound i-1; the last
+//	        count holds those above every bound
+const (
+	latencyMagic  = 0x3154414c4442414e // "NABDLAT1" in little-endian order
+	latencyHeader = 64
+	latencyCount  = 16
+	latencySum    = 24
+	latencyMax    = 32
+)
+
+// LatencyHistogram is a snapshot of the enqueue-to-dequeue latencies of
+// a queue opened WithLatencyHistogram
+type LatencyHistogram struct {
+	Bounds []time.Duration // Upper bound of each bucket
+	Counts []uint64        // Messages per bucket, one more than Bounds for the overflow
+	Count  uint64          // Messages observed
+	Sum    time.Duration   // Total latency of those messages
+	Max    time.Duration   // Largest latency observed
+}
+
+// Percentile returns the latency below which a fraction p of the
+// messages waited, such as 0.99 for p99. It is the upper bound of the
+// bucket the percentile falls in, or Max for the overflow bucket, so it
+// errs on the high side by at most one bucket. It returns 0 before any
+// message was observed.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+	rank := max(uint64(math.Ceil(max(min(p, 1), 0)*float64(h.Count))), 1)
+	var seen uint64
+	for i, n := range h.Counts {
+		if seen += n; seen >= rank {
+			if i < len(h.Bounds) {
+				return min(h.Bounds[i], h.Max)
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// Mean returns the average latency, or 0 before any message was observed
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// LatencyPercentile returns s.Latency.Percentile(p), 0 for a queue
+// without a histogram
+func (s Stats) LatencyPercentile(p float64) time.Duration {
+	return s.Latency.Percentile(p)
+}
+
+// latencyState is a handle's view of the histogram file
+type latencyState struct {
+	table
+	bounds []time.Duration
+}
+
+// latencyPath returns the histogram file of queue name
+func latencyPath(name string) string {
+	return sideDir + name + ".latency"
+}
+
+// openLatency maps the histogram of queue name. The first handle creates
+// it with bounds, or DefaultLatencyBuckets when bounds is empty; later
+// handles adopt its layout, and fail with EINVAL if they asked for
+// different bounds.
+func openLatency(name string, bounds []time.Duration) (*latencyState, error) {
+	invalid := &QueueError{Op: "open latency", Name: name, Errno: syscall.EINVAL}
+	for i, b := range bounds {
+		if b <= 0 || i > 0 && b <= bounds[i-1] {
+			return nil, invalid
+		}
+	}
+
+	fill := bounds
+	if len(fill) == 0 {
+		fill = DefaultLatencyBuckets
+	}
+	t, err := openTable("open latency", name, latencyPath(name), latencyMagic, latencySize(len(fill)), func(t table) {
+		t.store(8, uint64(len(fill)))
+		for i, b := range fill {
+			t.store(latencyHeader+8*uint64(i), uint64(b))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t) < latencyHeader || int64(len(t)) != latencySize(int(t.load(8))) {
+		t.close()
+		return nil, invalid
+	}
+	l := &latencyState{table: t, bounds: make([]time.Duration, t.load(8))}
+	for i := range l.bounds {
+		l.bounds[i] = time.Duration(l.load(latencyHeader + 8*uint64(i)))
+	}
+	if len(bounds) > 0 && !slices.Equal(bounds, l.bounds) {
+		l.close()
+		return nil, invalid
+	}
+	return l, nil
+}
+
+// latencySize returns the file size for n bucket bounds
+func latencySize(n int) int64 {
+	return int64(latencyHeader + 8*n + 8*(n+1))
+}
+
+// counts returns the offset of the first bucket count
+func (l *latencyState) counts() uint64 {
+	return latencyHeader + 8*uint64(len(l.bounds))
+}
+
+// observe adds the latency of a message pushed at st.time. It does nothing
+// on a handle without a histogram.
+func (l *latencyState) observe(st stamp) {
+	if l == nil {
+		return
+	}
+	d := max(time.Duration(time.Now().UnixNano()-st.time), 0)
+	i, _ := slices.BinarySearch(l.bounds, d)
+	atomic.AddUint64(l.word(l.counts()+8*uint64(i)), 1)
+	atomic.AddUint64(l.word(latencySum), uint64(d))
+	atomic.AddUint64(l.word(latencyCount), 1)
+	for top := l.word(latencyMax); ; {
+		old := atomic.LoadUint64(top)
+		if uint64(d) <= old || atomic.CompareAndSwapUint64(top, old, uint64(d)) {
+			break
+		}
+	}
+}
+
+// snapshot copies the histogram. The counts are read one at a time, so
+// under concurrent pops they may be off by the pops in progress.
+func (l *latencyState) snapshot() *LatencyHistogram {
+	h := &LatencyHistogram{
+		Bounds: slices.Clone(l.bounds),
+		Counts: make([]uint64, len(l.bounds)+1),
+		Sum:    time.Duration(l.load(latencySum)),
+		Max:    time.Duration(l.load(latencyMax)),
+	}
+	for i := range h.Counts {
+		h.Counts[i] = l.load(l.counts() + 8*uint64(i))
+		h.Count += h.Counts[i]
+	}
+	return h
+}
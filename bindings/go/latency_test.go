@@ -0,0 +1,141 @@
+package nabd
+
+import (
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	bounds := []time.Duration{time.Millisecond, 50 * time.Millisecond}
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithTimestamp(),
+		WithLatencyHistogram(bounds...))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	s, _ := q.Stats()
+	if s.Latency == nil || s.Latency.Count != 0 || s.LatencyPercentile(0.99) != 0 {
+		t.Fatalf("Expected an empty histogram, got %+v", s.Latency)
+	}
+
+	// Three quick pops, then one that waited past the first bound
+	for i := 0; i < 3; i++ {
+		q.Push([]byte("fast"))
+		if _, err := q.Pop(64); err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+	}
+	q.Push([]byte("slow"))
+	time.Sleep(5 * time.Millisecond)
+
+	// Other handles and other pop paths add to the same counts
+	c, err := q.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.PopInto(make([]byte, 64)); err != nil {
+		t.Fatalf("PopInto failed: %v", err)
+	}
+
+	s, _ = q.Stats()
+	h := s.Latency
+	if !slices.Equal(h.Bounds, bounds) {
+		t.Errorf("Expected bounds %v, got %v", bounds, h.Bounds)
+	}
+	if h.Count != 4 || h.Counts[0] != 3 || h.Counts[0]+h.Counts[1]+h.Counts[2] != 4 {
+		t.Errorf("Unexpected counts %v, total %d", h.Counts, h.Count)
+	}
+	if h.Max < 5*time.Millisecond || h.Sum < h.Max {
+		t.Errorf("Expected a max of at least 5ms, got max %v sum %v", h.Max, h.Sum)
+	}
+	if p := s.LatencyPercentile(0.5); p != time.Millisecond {
+		t.Errorf("Expected p50 in the first bucket, got %v", p)
+	}
+	if p := s.LatencyPercentile(0.99); p < 5*time.Millisecond || p > 50*time.Millisecond {
+		t.Errorf("Expected p99 between 5ms and 50ms, got %v", p)
+	}
+
+	// Later handles adopt the bounds and reject different ones
+	o, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithTimestamp(),
+		WithLatencyHistogram(time.Second))
+	if qe, ok := err.(*QueueError); !ok || qe.Errno != syscall.EINVAL {
+		if o != nil {
+			o.Close()
+		}
+		t.Errorf("Expected EINVAL for different bounds, got %v", err)
+	}
+
+	// Without the option a handle reports no histogram
+	plain, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithTimestamp())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer plain.Close()
+	if s, _ := plain.Stats(); s.Latency != nil || s.LatencyPercentile(0.99) != 0 {
+		t.Errorf("Expected no histogram, got %+v", s.Latency)
+	}
+
+	Unlink(TestQueue)
+	if _, err := os.Stat(latencyPath(TestQueue)); !os.IsNotExist(err) {
+		t.Errorf("Expected Unlink to remove the histogram, got %v", err)
+	}
+}
+
+func TestLatencyHistogramNeedsTimestamp(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	for _, opts := range [][]Option{
+		{WithLatencyHistogram()},
+		{WithTimestamp(), WithLatencyHistogram(time.Second, time.Millisecond)},
+		{WithTimestamp(), WithLatencyHistogram(0)},
+	} {
+		opts = append(opts, WithFlags(Create|Producer|Consumer))
+		q, err := OpenWithOptions(TestQueue, opts...)
+		if qe, ok := err.(*QueueError); !ok || qe.Errno != syscall.EINVAL {
+			if q != nil {
+				q.Close()
+			}
+			t.Errorf("Expected EINVAL, got %v", err)
+		}
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	h := &LatencyHistogram{
+		Bounds: []time.Duration{time.Millisecond, 10 * time.Millisecond},
+		Counts: []uint64{90, 9, 1},
+		Count:  100,
+		Sum:    time.Second,
+		Max:    time.Second / 2,
+	}
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.9, time.Millisecond},
+		{0.95, 10 * time.Millisecond},
+		{0.99, 10 * time.Millisecond},
+		{1, time.Second / 2},
+	} {
+		if got := h.Percentile(c.p); got != c.want {
+			t.Errorf("Percentile(%v): expected %v, got %v", c.p, c.want, got)
+		}
+	}
+	if m := h.Mean(); m != 10*time.Millisecond {
+		t.Errorf("Expected a mean of 10ms, got %v", m)
+	}
+}
@@ -0,0 +1,136 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// shmDir is where the system keeps POSIX shared-memory objects
+const shmDir = "/dev/shm"
+
+// List returns the names of the NABD queues on this system, sorted, in the
+// form Open accepts (with a leading slash)
+//
+// Only regular files in /dev/shm whose header carries the NABD magic are
+// reported, so unrelated shared-memory objects and notification FIFOs are
+// left out. Entries that cannot be opened or read are skipped. macOS keeps
+// shared memory outside the filesystem, so there List fails with an error
+// matching os.ErrNotExist.
+func List() ([]string, error) {
+	entries, err := os.ReadDir(shmDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isQueue(filepath.Join(shmDir, e.Name())) {
+			names = append(names, "/"+e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isQueue reports whether the file at path starts with a NABD header
+func isQueue(path string) bool {
+	_, ok := readHeader(path)
+	return ok
+}
+
+// readHeader reads the immutable first line of the control block, which
+// holds the magic, geometry and creator metadata as native-endian words
+func readHeader(path string) (hdr [8]uint64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return hdr, false
+	}
+	defer f.Close()
+
+	var buf [64]byte
+	if _, err := io.ReadFull(f, buf[:]); err != nil {
+		return hdr, false
+	}
+	for i := range hdr {
+		hdr[i] = binary.NativeEndian.Uint64(buf[i*8:])
+	}
+	return hdr, hdr[0] == C.NABD_MAGIC
+}
+
+// TotalMemoryUsage returns the shared memory held by every queue List
+// reports, in bytes, without attaching to any of them
+//
+// Each queue counts as the size of its shared-memory object, which is what
+// MemoryUsage reports for an open handle. Queues removed while it runs are
+// skipped.
+func TotalMemoryUsage() (int64, error) {
+	names, err := List()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, name := range names {
+		fi, err := os.Stat(shmDir + name)
+		if err != nil {
+			continue
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+// Cleanup removes orphaned queues and returns their names, sorted
+//
+// A queue is a candidate when the process that created it no longer
+// exists, or, if maxAge is positive, when it was created more than maxAge
+// ago. Candidates are only removed while no live process has them open
+// (see UnlinkWhenIdle), so an idle queue that someone still holds is
+// kept. Queues made by a release that did not record a creator are only
+// subject to maxAge. It returns the first unlink failure, if any, along
+// with the queues it did remove.
+func Cleanup(maxAge time.Duration) ([]string, error) {
+	names, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	var first error
+	for _, name := range names {
+		hdr, ok := readHeader(shmDir + name)
+		if !ok || !orphaned(hdr[6], hdr[7], maxAge) {
+			continue
+		}
+		ok, err := UnlinkWhenIdle(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) && first == nil {
+			first = err
+		}
+		if ok {
+			removed = append(removed, name)
+		}
+	}
+	return removed, first
+}
+
+// orphaned reports whether a queue with the given creator metadata is a
+// cleanup candidate
+func orphaned(pid, createdNs uint64, maxAge time.Duration) bool {
+	if pid != 0 {
+		err := syscall.Kill(int(pid), 0)
+		if err != nil && err != syscall.EPERM {
+			return true
+		}
+	}
+	return maxAge > 0 && createdNs != 0 && time.Since(time.Unix(0, int64(createdNs))) > maxAge
+}
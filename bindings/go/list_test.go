@@ -0,0 +1,124 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// An unrelated shared-memory object must be ignored
+	other := shmDir + "/nabd_go_test_other"
+	if err := os.WriteFile(other, []byte("not a queue"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	defer os.Remove(other)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	names, err := List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !slices.Contains(names, TestQueue) {
+		t.Errorf("Expected %s in %v", TestQueue, names)
+	}
+	if slices.Contains(names, "/nabd_go_test_other") {
+		t.Errorf("Expected unrelated object to be skipped, got %v", names)
+	}
+}
+
+func TestTotalMemoryUsage(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	before, err := TotalMemoryUsage()
+	if err != nil {
+		t.Fatalf("TotalMemoryUsage failed: %v", err)
+	}
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+	n, err := q.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage failed: %v", err)
+	}
+
+	after, err := TotalMemoryUsage()
+	if err != nil {
+		t.Fatalf("TotalMemoryUsage failed: %v", err)
+	}
+	if after-before != int64(n) {
+		t.Errorf("Expected total to grow by %d, got %d", n, after-before)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// A PID that has just exited stands in for a crashed creator
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a child process: %v", err)
+	}
+	dead := make([]byte, 8)
+	binary.NativeEndian.PutUint64(dead, uint64(cmd.Process.Pid))
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f, err := os.OpenFile(shmDir+TestQueue, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	_, err = f.WriteAt(dead, 48)
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	// Still attached: kept even though its creator is gone
+	removed, err := Cleanup(time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if slices.Contains(removed, TestQueue) {
+		t.Fatal("Cleanup removed a queue with a live attachment")
+	}
+
+	q.Close()
+	removed, err = Cleanup(time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if !slices.Contains(removed, TestQueue) {
+		t.Errorf("Expected %s removed, got %v", TestQueue, removed)
+	}
+	if ok, _ := Exists(TestQueue); ok {
+		t.Error("Queue still exists after Cleanup")
+	}
+}
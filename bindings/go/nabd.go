@@ -9,92 +9,899 @@ package nabd
 import "C"
 import (
 	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"unsafe"
 )
 
+// Flag selects how Open attaches to a queue. Flags combine with |.
+type Flag int
+
 // Flags
 const (
-	Create   = C.NABD_CREATE
-	Producer = C.NABD_PRODUCER
-	Consumer = C.NABD_CONSUMER
+	Create   Flag = C.NABD_CREATE
+	Producer Flag = C.NABD_PRODUCER
+	Consumer Flag = C.NABD_CONSUMER
+
+	// Broadcast, combined with Create, makes every consumer handle receive
+	// every message. The producer never gets ErrFull; a consumer that falls
+	// more than Cap messages behind gets ErrLapped and skips ahead.
+	Broadcast Flag = C.NABD_BROADCAST
 )
 
 // Errors
 var (
-	ErrFull   = errors.New("buffer full")
-	ErrEmpty  = errors.New("buffer empty")
-	ErrTooBig = errors.New("message too big")
-	ErrFailed = errors.New("operation failed")
+	ErrFull    = errors.New("buffer full")
+	ErrEmpty   = errors.New("buffer empty")
+	ErrTooBig  = errors.New("message too big")
+	ErrFailed  = errors.New("operation failed")
+	ErrLapped  = errors.New("consumer lapped")
+	ErrCorrupt = errors.New("message corrupt")
+	ErrResized = errors.New("queue resized")
+
+	// ErrClosed is returned by calls on a closed handle, and by waits the
+	// Close interrupted. errors.Is(err, ErrFailed) reports true for it, as
+	// it did for those calls before the handle tracked being closed.
+	ErrClosed error = closedError{}
+
+	// ErrVersionMismatch is matched by the error Open returns for a queue
+	// whose header magic or layout version this build does not understand
+	ErrVersionMismatch = errors.New("queue layout version mismatch")
+
+	ErrEmptyMessage = errors.New("empty message")
+
+	// ErrConcurrentPush is returned by a push that overlaps another push
+	// on the same handle, or on one of its clones, without
+	// WithConcurrentProducers. The rejected message is not enqueued.
+	ErrConcurrentPush = errors.New("concurrent push without WithConcurrentProducers")
+
+	// ErrWrongRole is returned by a push on a handle opened without
+	// Producer, and by a pop on one opened without Consumer
+	ErrWrongRole = errors.New("operation not allowed for the handle's role")
+)
+
+// EmptyPolicy selects what Push does with a zero-length message
+type EmptyPolicy int
+
+const (
+	// PushEmpty enqueues a genuine zero-length message; the consumer
+	// receives an empty, non-nil slice from Pop. This is the default.
+	PushEmpty EmptyPolicy = iota
+
+	// RejectEmpty makes Push return ErrEmptyMessage and enqueue nothing
+	RejectEmpty
 )
 
 type Queue struct {
-	ptr *C.nabd_t
+	mu    sync.RWMutex // held shared by calls into C, exclusively by Close
+	name  string
+	ptr   *C.nabd_t
+	flags C.int         // flags ptr was opened with, for Reopen
+	role  Flag          // Producer, Consumer or both, checked before cgo
+	done  chan struct{} // closed by Close to wake blocked waiters
+	empty EmptyPolicy
+	env   envelope // per-message fields added by this handle
+
+	onError  HandlerPolicy // what Subscribe does when its handler fails
+	shutting atomic.Bool   // set by Shutdown; pushes fail from then on
+
+	expired atomic.Uint64 // expired messages this handle discarded
+	dlq     *Queue        // dead-letter queue, or nil
+	dead    deadLetters
+	resv    []byte // slot reserved by Reserve, envelope included
+	held    bool   // a PopZeroCopy message awaits Release
+
+	pushMu  *sync.Mutex   // serializes pushes under WithConcurrentProducers
+	pushing *atomic.Bool  // set while a push is in the ring, shared with clones
+	chunked bool          // Push may split messages over several slots
+	maxMsg  int           // WithMaxMessage, 0 for the ring's bound
+	wait    waitStrategy  // paces blocking calls
+	limit   *limiter      // push rate limit, shared with clones
+	watch   capacityWatch // OnFull and OnDrained callbacks
+
+	ackMu      sync.Mutex // guards mapping ack
+	ack        *ackState  // in-flight table, mapped by the first PopNoAck
+	visibility time.Duration
+
+	dedupMu     sync.Mutex  // serializes PushDedup
+	dedup       *dedupState // id window, mapped by the first PushDedup
+	dedupWindow int
+
+	latency *latencyState // pop latency histogram, nil unless enabled
 }
 
 // Open opens or creates a NABD queue
-func Open(name string, capacity, slotSize int, flags int) (*Queue, error) {
+//
+// It is shorthand for OpenWithOptions with WithCapacity, WithSlotSize and
+// WithFlags.
+func Open(name string, capacity, slotSize int, flags Flag) (*Queue, error) {
+	return OpenWithOptions(name, WithCapacity(capacity), WithSlotSize(slotSize), WithFlags(flags))
+}
+
+// OpenWithOptions opens or creates a NABD queue configured by opts
+//
+// The name is checked with ValidName before anything is opened, so a
+// malformed name fails with a *NameError instead of a QueueError. The
+// flags fix the handle's role: pushes on a handle opened without Producer
+// and pops on one opened without Consumer fail with ErrWrongRole.
+func OpenWithOptions(name string, opts ...Option) (*Queue, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.mode&^os.ModePerm != 0 || o.latency && !o.timestamp || o.group != nil && *o.group < 0 || o.maxMsg < 0 ||
+		o.delay && (o.overwrite || o.flags&Broadcast != 0) || o.codec != nil && o.overwrite {
+		return nil, &QueueError{Op: "open", Name: name, Errno: syscall.EINVAL}
+	}
+	gid := ^C.gid_t(0)
+	if o.group != nil {
+		gid = C.gid_t(*o.group)
+	}
+
+	flags := o.flags
+	if o.mlock {
+		flags |= C.NABD_MLOCK
+	}
+	if o.overwrite {
+		flags |= C.NABD_OVERWRITE
+	}
+	if o.unlink {
+		flags |= C.NABD_UNLINK_LAST
+	}
+
 	cName := C.CString(name)
 	defer C.free(unsafe.Pointer(cName))
 
-	q := C.nabd_open(cName, C.size_t(capacity), C.size_t(slotSize), C.int(flags))
+	q, errno := C.nabd_open_group(cName, C.size_t(o.capacity), C.size_t(o.slotSize),
+		C.int(flags), C.mode_t(o.mode), gid)
 	if q == nil {
-		return nil, ErrFailed
+		return nil, failure("open", name, C.NABD_SYSERR, errno)
+	}
+	if o.delay || o.codec != nil {
+		// Checked again for an existing queue, whose mode the flags omit
+		var info C.nabd_info_t
+		if C.nabd_info(q, &info) != C.NABD_OK || o.delay && info.mode&C.NABD_BROADCAST != 0 ||
+			info.mode&C.NABD_OVERWRITE != 0 {
+			C.nabd_close(q)
+			return nil, &QueueError{Op: "open", Name: name, Errno: syscall.EINVAL}
+		}
+	}
+
+	h := &Queue{name: name, ptr: q, flags: C.int(flags), done: make(chan struct{}),
+		empty: o.empty, onError: o.onError}
+	h.role = Flag(flags) & (Producer | Consumer)
+	h.env = envelope{checksum: o.checksum, sequence: o.sequence, timestamp: o.timestamp,
+		expiry: o.expiry, delay: o.delay, codec: o.codec}
+	h.dlq = o.dlq
+	h.pushing = new(atomic.Bool)
+	if o.concurrent {
+		h.pushMu = new(sync.Mutex)
+	}
+	h.visibility = o.visibility
+	h.chunked = o.chunked
+	h.maxMsg = o.maxMsg
+	h.wait = defaultWait
+	if o.wait != nil {
+		h.wait = *o.wait
+	}
+	h.limit = &limiter{policy: o.limitPolicy}
+	h.limit.set(o.rps, o.burst)
+	h.dedupWindow = o.dedupWindow
+	if h.dedupWindow <= 0 {
+		h.dedupWindow = DefaultDedupWindow
+	}
+	if h.visibility <= 0 {
+		h.visibility = DefaultVisibilityTimeout
+	}
+	if o.latency {
+		l, err := openLatency(name, o.latencyBounds)
+		if err != nil {
+			C.nabd_close(q)
+			return nil, err
+		}
+		h.latency = l
+	}
+
+	// Safety net for handles dropped without Close. Every call into C
+	// holds q.mu until it returns, which keeps q reachable meanwhile.
+	runtime.SetFinalizer(h, (*Queue).Close)
+	return h, nil
+}
+
+// acquire returns the C handle with q.mu held shared, or ErrClosed once
+// Close has run. On success the caller must release q.mu.RUnlock.
+func (q *Queue) acquire() (*C.nabd_t, error) {
+	q.mu.RLock()
+	if q.ptr == nil {
+		q.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	return q.ptr, nil
+}
+
+// acquirePush is acquire for calls that publish messages. It also fails
+// with ErrWrongRole on a handle opened without Producer and with
+// ErrShuttingDown once Shutdown has begun.
+func (q *Queue) acquirePush() (*C.nabd_t, error) {
+	if q.role&Producer == 0 {
+		return nil, ErrWrongRole
+	}
+	h, err := q.acquire()
+	if err == nil && q.shutting.Load() {
+		q.mu.RUnlock()
+		return nil, ErrShuttingDown
+	}
+	return h, err
+}
+
+// acquirePop is acquire for calls that consume messages. It fails with
+// ErrWrongRole on a handle opened without Consumer.
+func (q *Queue) acquirePop() (*C.nabd_t, error) {
+	if q.role&Consumer == 0 {
+		return nil, ErrWrongRole
+	}
+	return q.acquire()
+}
+
+// lockPush serializes pushes on handles opened WithConcurrentProducers.
+// It is taken before q.mu so a pusher waiting for it never holds up Close.
+func (q *Queue) lockPush() {
+	if q.pushMu != nil {
+		q.pushMu.Lock()
+	}
+}
+
+// unlockPush releases lockPush
+func (q *Queue) unlockPush() {
+	if q.pushMu != nil {
+		q.pushMu.Unlock()
+	}
+}
+
+// enterPush marks a push as inside the ring. The ring takes one producer
+// at a time, so a push that overlaps another fails with ErrConcurrentPush
+// instead of corrupting the indices.
+func (q *Queue) enterPush() error {
+	if !q.pushing.CompareAndSwap(false, true) {
+		return ErrConcurrentPush
 	}
+	return nil
+}
+
+// exitPush ends enterPush
+func (q *Queue) exitPush() {
+	q.pushing.Store(false)
+}
 
-	return &Queue{ptr: q}, nil
+// Name returns the name the queue was opened with
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Path returns the file backing the queue's shared memory, such as
+// /dev/shm/orders for the queue /orders, for inspecting it with ls or
+// hexdump. It stats the file, so it fails with an error matching
+// os.ErrNotExist once the queue has been unlinked, and on systems that
+// keep shared memory outside the filesystem.
+func (q *Queue) Path() (string, error) {
+	path := filepath.Join(shmDir, strings.TrimLeft(q.name, "/"))
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 // Close closes the queue handle
+//
+// Close is safe to call more than once and from several goroutines. It
+// waits for calls already inside the C library to return; blocked waits
+// wake up, and every later call returns ErrClosed.
+//
+// A Queue that becomes unreachable without Close is closed by the garbage
+// collector eventually, but callers should not rely on that: until it
+// runs the mapping and descriptor stay open.
 func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	if q.ptr != nil {
+		runtime.SetFinalizer(q, nil)
+		close(q.done)
+		if q.ack != nil {
+			q.ack.close()
+		}
+		if q.dedup != nil {
+			q.dedup.close()
+		}
+		if q.latency != nil {
+			q.latency.close()
+		}
 		C.nabd_close(q.ptr)
 		q.ptr = nil
+
+		// The last close may have unlinked the queue; drop its tables too
+		if q.ack != nil || q.dedup != nil || q.latency != nil {
+			if ok, _ := Exists(q.name); !ok {
+				os.Remove(ackPath(q.name))
+				os.Remove(dedupPath(q.name))
+				os.Remove(latencyPath(q.name))
+			}
+		}
 	}
 }
 
+// Reopen attaches a closed handle to the named queue again, with the
+// flags and options it was first opened with
+//
+// Create is left out, so if the queue was unlinked meanwhile Reopen fails
+// with an error matching os.ErrNotExist instead of making an empty one.
+// The handle then behaves like a fresh Open: a pending reservation,
+// unacked PopNoAck messages and a Shutdown are forgotten. Reopen on a
+// handle that is still open does nothing. It must not race with other
+// calls on q, including ones still returning ErrClosed from the Close.
+func (q *Queue) Reopen() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ptr != nil {
+		return nil
+	}
+
+	cName := C.CString(q.name)
+	defer C.free(unsafe.Pointer(cName))
+
+	p, errno := C.nabd_open_mode(cName, 0, 0, q.flags&^C.NABD_CREATE, 0)
+	if p == nil {
+		return failure("open", q.name, C.NABD_SYSERR, errno)
+	}
+	if q.latency != nil {
+		l, err := openLatency(q.name, q.latency.bounds)
+		if err != nil {
+			C.nabd_close(p)
+			return err
+		}
+		q.latency = l
+	}
+
+	q.ptr = p
+	q.done = make(chan struct{})
+	if q.resv != nil {
+		q.resv = nil
+		q.unlockPush()
+	}
+	q.held = false
+	q.shutting.Store(false)
+	q.ackMu.Lock()
+	q.ack = nil
+	q.ackMu.Unlock()
+	q.dedup = nil
+	q.watch.start(q, q.done)
+	runtime.SetFinalizer(q, (*Queue).Close)
+	return nil
+}
+
+// Clone opens another handle to the same queue with the flags and
+// options q was opened with, so each goroutine can own one
+//
+// The clones share the ring, its counters and the attach count, which
+// includes each of them, but nothing kept per C handle: a Broadcast
+// clone starts reading at the live end with its own cursor, and a
+// reservation or unacked PopNoAck message belongs to the handle that made
+// it. The codec and dead-letter queue are shared with q. Each clone needs
+// its own Close. Create is left out, so cloning a queue that was unlinked
+// fails with an error matching os.ErrNotExist.
+//
+// Separate handles do not lift the ring's limits: it still takes one
+// producer and, outside Broadcast mode, one consumer at a time. A typical
+// split is one clone pushing while another pops, or one Broadcast clone
+// per reader. Under WithConcurrentProducers the clones share q's push
+// lock, so pushes through any of them are serialized.
+func (q *Queue) Clone() (*Queue, error) {
+	if _, err := q.acquire(); err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	cName := C.CString(q.name)
+	defer C.free(unsafe.Pointer(cName))
+
+	p, errno := C.nabd_open_mode(cName, 0, 0, q.flags&^C.NABD_CREATE, 0)
+	if p == nil {
+		return nil, failure("open", q.name, C.NABD_SYSERR, errno)
+	}
+
+	c := &Queue{name: q.name, ptr: p, flags: q.flags, done: make(chan struct{}),
+		empty: q.empty, onError: q.onError}
+	c.role = q.role
+	c.env = q.env
+	c.dlq = q.dlq
+	c.pushMu = q.pushMu
+	c.pushing = q.pushing
+	c.visibility = q.visibility
+	c.chunked = q.chunked
+	c.maxMsg = q.maxMsg
+	c.wait = q.wait
+	c.limit = q.limit
+	c.dedupWindow = q.dedupWindow
+	if q.latency != nil {
+		l, err := openLatency(q.name, q.latency.bounds)
+		if err != nil {
+			C.nabd_close(p)
+			return nil, err
+		}
+		c.latency = l
+	}
+	runtime.SetFinalizer(c, (*Queue).Close)
+	return c, nil
+}
+
 // Unlink removes the queue from the system
 func Unlink(name string) error {
 	cName := C.CString(name)
 	defer C.free(unsafe.Pointer(cName))
 
-	if ret := C.nabd_unlink(cName); ret != 0 {
-		return ErrFailed
+	if ret, errno := C.nabd_unlink(cName); ret != 0 {
+		return failure("unlink", name, ret, errno)
 	}
+	os.Remove(ackPath(name))
+	os.Remove(dedupPath(name))
+	os.Remove(latencyPath(name))
 	return nil
 }
 
+// UnlinkWhenIdle removes the queue only if no live process has it open,
+// and reports whether it did. It never waits: a queue still in use is
+// left alone and (false, nil) is returned.
+func UnlinkWhenIdle(name string) (bool, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	ret, errno := C.nabd_unlink_idle(cName)
+	if ret < 0 {
+		return false, failure("unlink", name, ret, errno)
+	}
+	if ret == 1 {
+		os.Remove(ackPath(name))
+		os.Remove(dedupPath(name))
+		os.Remove(latencyPath(name))
+	}
+	return ret == 1, nil
+}
+
+// memberName returns the queue name of member k of a priority queue or
+// shard set called name
+func memberName(name string, k int) string {
+	return name + "." + strconv.Itoa(k)
+}
+
+// unlinkMembers removes the n member queues of set name and returns the
+// first error, carrying on past it
+func unlinkMembers(name string, n int) error {
+	var first error
+	for k := 0; k < n; k++ {
+		if err := Unlink(memberName(name, k)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Exists reports whether a queue with the given name exists, without
+// creating or attaching to it. A missing queue is not an error; failures
+// such as a permission error are returned as a *QueueError.
+func Exists(name string) (bool, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	ret, errno := C.nabd_exists(cName)
+	if ret < 0 {
+		return false, failure("exists", name, ret, errno)
+	}
+	return ret == 1, nil
+}
+
+// SetEmptyPolicy selects how Push treats zero-length messages. It must be
+// called before the queue is shared between goroutines.
+func (q *Queue) SetEmptyPolicy(p EmptyPolicy) {
+	q.empty = p
+}
+
 // Push pushes data to the queue
+//
+// A zero-length message is handled according to the queue's EmptyPolicy.
+// With WithDeadLetter, a message rejected with ErrTooBig or
+// ErrEmptyMessage is also copied to the dead-letter queue.
+//
+// Only one goroutine may push through a handle at a time unless it was
+// opened WithConcurrentProducers; an overlapping push fails with
+// ErrConcurrentPush.
 func (q *Queue) Push(data []byte) error {
-	if len(data) == 0 {
-		return nil
+	_, err := q.push(data, 0, 0)
+	return err
+}
+
+// PushN pushes data like Push and returns the number of payload bytes
+// stored in the queue
+//
+// Without compression this is len(data). With WithCompression it is the
+// compressed size, or len(data) when the message was stored as is. The
+// envelope header added by options such as WithChecksum is not counted.
+func (q *Queue) PushN(data []byte) (int, error) {
+	return q.push(data, 0, 0)
+}
+
+// push pushes data with the given expiry deadline and delivery time in
+// Unix nanoseconds and returns the stored payload size
+func (q *Queue) push(data []byte, deadline, ready int64) (n int, err error) {
+	if _, err = q.admit(1); err != nil {
+		return 0, err
 	}
+	defer func() {
+		if err != nil {
+			q.limit.refund(1)
+		}
+	}()
 
-	// We pass pointer to first element of slice
-	ptr := unsafe.Pointer(&data[0])
-	ret := C.nabd_push(q.ptr, ptr, C.size_t(len(data)))
+	q.lockPush()
+	defer q.unlockPush()
+	if err := q.enterPush(); err != nil {
+		return 0, err
+	}
+	defer q.exitPush()
+
+	h, err := q.acquirePush()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	if len(data) == 0 && q.empty == RejectEmpty {
+		q.deadLetter(DeadEmpty, data)
+		return 0, ErrEmptyMessage
+	}
+	if (q.maxMsg > 0 || q.env.codec != nil) && len(data) > q.messageLimit(h) {
+		q.deadLetter(DeadTooBig, data)
+		return 0, ErrTooBig
+	}
+
+	orig := data
+	if q.env.size() > 0 {
+		var seq uint64
+		if q.env.sequence {
+			seq = uint64(C.nabd_seq(h))
+		}
+		st := q.env.newStamp(seq, deadline)
+		st.ready = ready
+		data = q.env.seal(data, st)
+
+		// Pops cannot peek at a message split over several slots to
+		// check its uncompressed size, so such a message is stored as is
+		var stats C.nabd_stats_t
+		if q.chunked && ready == 0 && q.env.codec != nil && C.nabd_stats(h, &stats) == C.NABD_OK &&
+			len(data) > int(stats.slot_size)-SlotHeaderSize {
+			data = q.env.wrap(orig, st)
+		}
+	}
+
+	// We pass pointer to first element of slice; C needs a valid
+	// pointer even for an empty message
+	var zero byte
+	ptr := unsafe.Pointer(&zero)
+	if len(data) > 0 {
+		ptr = unsafe.Pointer(&data[0])
+	}
+	var ret C.int
+	if q.chunked && ready == 0 {
+		ret = C.nabd_push_large(h, ptr, C.size_t(len(data)))
+	} else {
+		ret = C.nabd_push(h, ptr, C.size_t(len(data)))
+	}
 
 	if ret == C.NABD_OK {
-		return nil
+		if q.env.sequence {
+			C.nabd_seq_advance(h, 1)
+		}
+		q.watch.note(false)
+		return len(data) - q.env.size(), nil
 	} else if ret == C.NABD_FULL {
-		return ErrFull
+		q.watch.note(true)
+		return 0, ErrFull
 	} else if ret == C.NABD_TOOBIG {
-		return ErrTooBig
+		q.deadLetter(DeadTooBig, orig)
+		return 0, ErrTooBig
 	}
-	return ErrFailed
+	return 0, failure("push", q.name, ret, nil)
 }
 
 // Pop pops data from the queue
+//
+// A non-positive maxLen cannot hold any message and returns ErrTooBig.
+// On a queue opened WithChecksum, a message that fails verification is
+// removed and ErrCorrupt is returned.
 func (q *Queue) Pop(maxLen int) ([]byte, error) {
-	buf := make([]byte, maxLen)
-	var size C.size_t = C.size_t(maxLen)
+	data, _, err := q.pop(maxLen)
+	return data, err
+}
 
-	ptr := unsafe.Pointer(&buf[0])
-	ret := C.nabd_pop(q.ptr, ptr, &size)
+// PopSeq pops the next message together with the sequence number the
+// producer stamped on it. It needs a queue opened WithSequence and
+// otherwise fails with EINVAL.
+//
+// Numbers increase by one per message, so a gap means messages were
+// missed, for example by a consumer lapped on a Broadcast queue.
+func (q *Queue) PopSeq(maxLen int) ([]byte, uint64, error) {
+	if !q.env.sequence {
+		return nil, 0, &QueueError{Op: "pop", Name: q.name, Errno: syscall.EINVAL}
+	}
+	data, st, err := q.pop(maxLen)
+	return data, st.seq, err
+}
 
-	if ret == C.NABD_OK {
-		return buf[:size], nil
-	} else if ret == C.NABD_EMPTY {
-		return nil, ErrEmpty
+// PopTimed pops the next message together with the time the producer
+// pushed it, so time.Since shows how long it waited in the ring. It needs
+// a queue opened WithTimestamp and otherwise fails with EINVAL.
+func (q *Queue) PopTimed(maxLen int) ([]byte, time.Time, error) {
+	if !q.env.timestamp {
+		return nil, time.Time{}, &QueueError{Op: "pop", Name: q.name, Errno: syscall.EINVAL}
+	}
+	data, st, err := q.pop(maxLen)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Unix(0, st.time), nil
+}
+
+// pop pops the next message and opens its envelope, skipping expired ones
+func (q *Queue) pop(maxLen int) ([]byte, stamp, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, stamp{}, err
+	}
+	defer q.mu.RUnlock()
+
+	if maxLen <= 0 {
+		return nil, stamp{}, ErrTooBig
+	}
+
+	return q.popBuf(h, make([]byte, maxLen+q.env.size()))
+}
+
+// popBuf pops into buf, which must hold maxLen plus the envelope. The
+// returned message aliases buf.
+func (q *Queue) popBuf(h *C.nabd_t, buf []byte) ([]byte, stamp, error) {
+	limit := len(buf) - q.env.size()
+	for {
+		if err := q.ahead(h, limit); err != nil {
+			return nil, stamp{}, err
+		}
+		var size C.size_t = C.size_t(len(buf))
+
+		ptr := unsafe.Pointer(&buf[0])
+		ret := C.nabd_pop(h, ptr, &size)
+
+		if ret == C.NABD_OK {
+			data, st, err := q.env.open(buf[:size], limit)
+			if err == ErrTooBig {
+				q.unpop(h, q.cursor(h)-1)
+				return nil, stamp{}, err
+			} else if err != nil {
+				q.deadLetter(DeadCorrupt, buf[:size])
+			} else if q.env.expired(st) {
+				q.expire(data)
+				continue
+			}
+			if err == nil {
+				q.latency.observe(st)
+			}
+			return data, st, err
+		} else if ret == C.NABD_EMPTY {
+			return nil, stamp{}, ErrEmpty
+		} else if ret == C.NABD_TOOBIG {
+			return nil, stamp{}, ErrTooBig
+		} else if ret == C.NABD_LAPPED {
+			return nil, stamp{}, ErrLapped
+		}
+		return nil, stamp{}, failure("pop", q.name, ret, nil)
+	}
+}
+
+// ahead checks the message at the tail before a pop consumes it: it
+// returns ErrEmpty if the message is not due yet and ErrTooBig if it opens
+// to more than limit bytes. Broadcast readers cannot peek, so their pops
+// check after consuming and unpop instead.
+func (q *Queue) ahead(h *C.nabd_t, limit int) error {
+	if !q.env.delay && q.env.codec == nil {
+		return nil
+	}
+	var data unsafe.Pointer
+	var size C.size_t
+	if C.nabd_peek(h, &data, &size) != C.NABD_OK {
+		return nil
+	}
+	msg := unsafe.Slice((*byte)(data), int(size))
+	if q.env.holdback(msg) > 0 {
+		return ErrEmpty
+	}
+	if !q.env.fits(msg, limit) {
+		return ErrTooBig
+	}
+	return nil
+}
+
+// cursor returns the position of this handle's next pop
+func (q *Queue) cursor(h *C.nabd_t) uint64 {
+	var stats C.nabd_stats_t
+	C.nabd_stats(h, &stats)
+	return uint64(stats.tail)
+}
+
+// unpop moves a broadcast reader back to pos, so a message it popped but
+// could not deliver is read again. Other queues share their read position,
+// which cannot safely move back, and rely on ahead instead.
+func (q *Queue) unpop(h *C.nabd_t, pos uint64) {
+	if C.nabd_mode(h)&C.NABD_BROADCAST != 0 {
+		C.nabd_seek(h, C.uint64_t(pos))
+	}
+}
+
+// Peek returns a copy of the next message without removing it
+//
+// Peek is not supported on Broadcast queues and fails with EINVAL there.
+// Repeated calls return the same bytes until a Pop advances the cursor.
+// An expired message at the front is discarded rather than returned.
+// When several consumers share the ring, another reader may consume the
+// peeked slot while it is being copied; Peek detects the moved cursor and
+// retries, so the result is always the message at the current tail.
+func (q *Queue) Peek(maxLen int) ([]byte, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	var before, after C.nabd_stats_t
+	for {
+		if q.holdback(h) > 0 {
+			return nil, ErrEmpty
+		}
+		if ret := C.nabd_stats(h, &before); ret != C.NABD_OK {
+			return nil, failure("peek", q.name, ret, nil)
+		}
+
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek(h, &data, &size)
+		if ret == C.NABD_EMPTY {
+			return nil, ErrEmpty
+		} else if ret != C.NABD_OK {
+			return nil, failure("peek", q.name, ret, nil)
+		}
+		if int(size) > maxLen+q.env.size() {
+			return nil, ErrTooBig
+		}
+		buf := C.GoBytes(data, C.int(size))
+
+		// The copy is only valid if nobody consumed the slot meanwhile
+		if ret := C.nabd_stats(h, &after); ret != C.NABD_OK {
+			return nil, failure("peek", q.name, ret, nil)
+		}
+		if after.tail == before.tail {
+			data, st, err := q.env.open(buf, maxLen)
+			if err == nil && q.env.expired(st) {
+				q.dropPeeked(h, data)
+				continue
+			}
+			return data, err
+		}
+	}
+}
+
+// PopInto pops the next message into buf and returns its length
+//
+// If the message is larger than buf, ErrTooBig is returned and the
+// message stays queued so the caller can retry with a bigger buffer.
+func (q *Queue) PopInto(buf []byte) (int, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	if len(buf) == 0 {
+		return 0, ErrTooBig
+	}
+
+	// Envelope fields need room of their own, so pop into scratch space
+	dst := buf
+	if n := q.env.size(); n > 0 {
+		dst = make([]byte, len(buf)+n)
+	}
+
+	for {
+		if err := q.ahead(h, len(buf)); err != nil {
+			return 0, err
+		}
+		var size C.size_t = C.size_t(len(dst))
+		ret := C.nabd_pop(h, unsafe.Pointer(&dst[0]), &size)
+
+		if ret == C.NABD_OK {
+			if q.env.size() == 0 {
+				return int(size), nil
+			}
+			data, st, err := q.env.open(dst[:size], len(buf))
+			if err == ErrTooBig {
+				q.unpop(h, q.cursor(h)-1)
+				return 0, err
+			} else if err != nil {
+				q.deadLetter(DeadCorrupt, dst[:size])
+				return 0, err
+			}
+			if q.env.expired(st) {
+				q.expire(data)
+				continue
+			}
+			q.latency.observe(st)
+			return copy(buf, data), nil
+		} else if ret == C.NABD_EMPTY {
+			return 0, ErrEmpty
+		} else if ret == C.NABD_TOOBIG {
+			return 0, ErrTooBig
+		} else if ret == C.NABD_LAPPED {
+			return 0, ErrLapped
+		}
+		return 0, failure("pop", q.name, ret, nil)
+	}
+}
+
+// PushString pushes s as one message without converting it to a []byte
+//
+// The bytes are read in place through unsafe.StringData. This is sound
+// because Push only reads its argument and copies it into the ring before
+// returning, and s stays reachable for the duration of the call. Nothing
+// keeps a reference to s afterwards.
+func (q *Queue) PushString(s string) error {
+	return q.Push(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// PopString pops the next message of at most maxLen bytes as a string
+//
+// The string aliases the freshly allocated buffer Pop returned, which
+// nothing else refers to, so no second copy is made. The buffer is
+// maxLen bytes, so keep maxLen close to the expected message size when
+// retaining many strings.
+func (q *Queue) PopString(maxLen int) (string, error) {
+	b, err := q.Pop(maxLen)
+	if err != nil {
+		return "", err
+	}
+	return unsafe.String(unsafe.SliceData(b), len(b)), nil
+}
+
+// Drain discards every buffered message in one step and returns how many
+// were dropped. Message bodies are not copied. Messages pushed while Drain
+// runs may or may not be discarded.
+func (q *Queue) Drain() (int, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	var discarded C.uint64_t
+	if ret := C.nabd_drain(h, &discarded); ret != C.NABD_OK {
+		return 0, failure("drain", q.name, ret, nil)
 	}
-	return nil, ErrFailed
+	return int(discarded), nil
 }
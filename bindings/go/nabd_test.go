@@ -1,7 +1,15 @@
 package nabd
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 const TestQueue = "/nabd_go_test"
@@ -17,6 +25,62 @@ func TestOpenClose(t *testing.T) {
 	q.Close()
 }
 
+func TestExists(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	if ok, err := Exists(TestQueue); ok || err != nil {
+		t.Errorf("Expected false, nil before create, got %v, %v", ok, err)
+	}
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	q.Close()
+
+	if ok, err := Exists(TestQueue); !ok || err != nil {
+		t.Errorf("Expected true, nil after create, got %v, %v", ok, err)
+	}
+}
+
+func TestUnlinkWhenIdle(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+
+	if n, err := p.Attached(); n != 2 || err != nil {
+		t.Errorf("Expected 2 attached, got %d, %v", n, err)
+	}
+	if ok, err := UnlinkWhenIdle(TestQueue); ok || err != nil {
+		t.Errorf("Expected false, nil while attached, got %v, %v", ok, err)
+	}
+
+	c.Close()
+	if n, err := p.Attached(); n != 1 || err != nil {
+		t.Errorf("Expected 1 attached, got %d, %v", n, err)
+	}
+	p.Close()
+	if _, err := p.Attached(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	if ok, err := UnlinkWhenIdle(TestQueue); !ok || err != nil {
+		t.Errorf("Expected true, nil once idle, got %v, %v", ok, err)
+	}
+	if ok, _ := Exists(TestQueue); ok {
+		t.Error("Queue still exists after UnlinkWhenIdle")
+	}
+}
+
 func TestPushPop(t *testing.T) {
 	Unlink(TestQueue)
 	defer Unlink(TestQueue)
@@ -52,4 +116,755 @@ func TestPushPop(t *testing.T) {
 	if err != ErrEmpty {
 		t.Errorf("Expected ErrEmpty, got %v", err)
 	}
+
+	if n, err := p.PushN(msg); err != nil || n != len(msg) {
+		t.Errorf("PushN returned %d, %v, want %d", n, err, len(msg))
+	}
+}
+
+func TestWrongRole(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	pushes := map[string]func() error{
+		"Push":      func() error { return c.Push([]byte("x")) },
+		"PushBatch": func() error { _, err := c.PushBatch([][]byte{[]byte("x")}); return err },
+		"Reserve":   func() error { _, err := c.Reserve(1); return err },
+	}
+	for name, fn := range pushes {
+		if err := fn(); err != ErrWrongRole {
+			t.Errorf("%s on a consumer: expected ErrWrongRole, got %v", name, err)
+		}
+	}
+
+	p.Push([]byte("x"))
+	pops := map[string]func() error{
+		"Pop":      func() error { _, err := p.Pop(64); return err },
+		"PopBatch": func() error { _, err := p.PopBatch(4, 64); return err },
+		"PopNoAck": func() error { _, _, err := p.PopNoAck(64); return err },
+		"Peek":     func() error { _, err := p.Peek(64); return err },
+		"Drain":    func() error { _, err := p.Drain(); return err },
+	}
+	for name, fn := range pops {
+		if err := fn(); err != ErrWrongRole {
+			t.Errorf("%s on a producer: expected ErrWrongRole, got %v", name, err)
+		}
+	}
+
+	// Nothing reached the ring, and a handle with both roles does both
+	if n, _ := c.Len(); n != 1 {
+		t.Errorf("Expected 1 message, got %d", n)
+	}
+	clone, err := c.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	defer clone.Close()
+	if err := clone.Push([]byte("x")); err != ErrWrongRole {
+		t.Errorf("Expected the clone to keep the consumer role, got %v", err)
+	}
+	both, err := Open(TestQueue, 0, 0, Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer both.Close()
+	if err := both.Push([]byte("y")); err != nil {
+		t.Errorf("Push failed: %v", err)
+	}
+	if _, err := both.Pop(64); err != nil {
+		t.Errorf("Pop failed: %v", err)
+	}
+}
+
+func TestPushPopString(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	for _, want := range []string{"hello", ""} {
+		if err := p.PushString(want); err != nil {
+			t.Fatalf("PushString failed: %v", err)
+		}
+		got, err := c.PopString(64)
+		if err != nil {
+			t.Fatalf("PopString failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+
+	if _, err := c.PopString(64); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestPushEmpty(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	// Default: a genuine zero-length message round-trips
+	if err := p.Push(nil); err != nil {
+		t.Fatalf("Push(nil) failed: %v", err)
+	}
+	data, err := c.Pop(64)
+	if err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+	if data == nil || len(data) != 0 {
+		t.Errorf("Expected empty non-nil message, got %v", data)
+	}
+
+	p.SetEmptyPolicy(RejectEmpty)
+	if err := p.Push([]byte{}); err != ErrEmptyMessage {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+	n, err := p.PushBatch([][]byte{[]byte("a"), nil, []byte("b")})
+	if n != 1 || err != ErrEmptyMessage {
+		t.Errorf("Expected 1, ErrEmptyMessage, got %d, %v", n, err)
+	}
+	if l, _ := c.Len(); l != 1 {
+		t.Errorf("Expected 1 queued message, got %d", l)
+	}
+}
+
+func TestPopZeroLen(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	p.Push([]byte("kept"))
+
+	for _, n := range []int{0, -1} {
+		if _, err := c.Pop(n); err != ErrTooBig {
+			t.Errorf("Pop(%d): expected ErrTooBig, got %v", n, err)
+		}
+	}
+	if _, err := c.PopInto(nil); err != ErrTooBig {
+		t.Errorf("PopInto(nil): expected ErrTooBig, got %v", err)
+	}
+
+	// The rejected calls must not consume the message
+	data, err := c.Pop(64)
+	if err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+	if string(data) != "kept" {
+		t.Errorf("Expected kept, got %s", data)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Peek(128); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	p.Push([]byte("first"))
+	p.Push([]byte("second"))
+
+	for i := 0; i < 2; i++ {
+		out, err := c.Peek(128)
+		if err != nil {
+			t.Fatalf("Peek failed: %v", err)
+		}
+		if string(out) != "first" {
+			t.Errorf("Expected first, got %s", out)
+		}
+	}
+	if _, err := c.Peek(2); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	out, _ := c.Pop(128)
+	if string(out) != "first" {
+		t.Errorf("Expected first, got %s", out)
+	}
+	out, _ = c.Peek(128)
+	if string(out) != "second" {
+		t.Errorf("Expected second, got %s", out)
+	}
+}
+
+func TestPopInto(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	msg := []byte("reuse me")
+	p.Push(msg)
+
+	// Too small: message stays queued
+	small := make([]byte, 4)
+	if _, err := c.PopInto(small); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := c.PopInto(buf)
+	if err != nil {
+		t.Fatalf("PopInto failed: %v", err)
+	}
+	if string(buf[:n]) != string(msg) {
+		t.Errorf("Expected %s, got %s", msg, buf[:n])
+	}
+
+	if _, err := c.PopInto(buf); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 7; i++ {
+		p.Push([]byte("stale"))
+	}
+	n, err := c.Drain()
+	if err != nil || n != 7 {
+		t.Errorf("Expected 7 discarded, got %d, %v", n, err)
+	}
+	if _, err := c.Pop(128); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	p.Push([]byte("fresh"))
+	out, _ := c.Pop(128)
+	if string(out) != "fresh" {
+		t.Errorf("Expected fresh, got %s", out)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 8, 64, Create|Producer|Broadcast)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	a, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer a.Close()
+
+	b, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := p.Push([]byte{byte(i)}); err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+	}
+
+	// Every consumer receives the full stream
+	for _, c := range []*Queue{a, b} {
+		for i := 0; i < 4; i++ {
+			data, err := c.Pop(64)
+			if err != nil {
+				t.Fatalf("Pop %d failed: %v", i, err)
+			}
+			if data[0] != byte(i) {
+				t.Errorf("Expected %d, got %d", i, data[0])
+			}
+		}
+		if _, err := c.Pop(64); err != ErrEmpty {
+			t.Errorf("Expected ErrEmpty, got %v", err)
+		}
+	}
+
+	// The producer never fills, so a slow consumer gets lapped
+	for i := 0; i < 20; i++ {
+		if err := p.Push([]byte{byte(i)}); err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+	}
+	if lag := a.Lag(); lag != 20 {
+		t.Errorf("Expected lag 20, got %d", lag)
+	}
+	if _, err := a.Pop(64); err != ErrLapped {
+		t.Fatalf("Expected ErrLapped, got %v", err)
+	}
+	if lag := a.Lag(); lag >= a.Cap() {
+		t.Errorf("Expected lag below %d after resync, got %d", a.Cap(), lag)
+	}
+	if _, err := a.Pop(64); err != nil {
+		t.Errorf("Pop after resync failed: %v", err)
+	}
+}
+
+func TestFinalizerClosesHandle(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("needs /proc/self/fd")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	openFds := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		return len(entries)
+	}
+
+	// Open handles and drop them without Close
+	leak := func() {
+		for i := 0; i < 8; i++ {
+			if _, err := Open(TestQueue, 16, 64, Create|Producer); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+		}
+	}
+
+	before := openFds()
+	leak()
+	for i := 0; i < 20 && openFds() > before; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := openFds(); after > before {
+		t.Errorf("Expected %d open fds after GC, got %d", before, after)
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	q.Close()
+	q.Close()
+
+	if err := q.Push([]byte("x")); err != ErrClosed {
+		t.Errorf("Push: expected ErrClosed, got %v", err)
+	}
+	if _, err := q.Pop(64); err != ErrClosed {
+		t.Errorf("Pop: expected ErrClosed, got %v", err)
+	}
+	if _, err := q.Len(); err != ErrClosed {
+		t.Errorf("Len: expected ErrClosed, got %v", err)
+	}
+	if c := q.Cap(); c != 0 {
+		t.Errorf("Cap: expected 0, got %d", c)
+	}
+}
+
+func TestClosedMethods(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// Options some methods require, so they get as far as the closed check
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithSequence(),
+		WithTimestamp(),
+		WithExpiry())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	q.Close()
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	calls := map[string]func() error{
+		"Push":            func() error { return q.Push([]byte("x")) },
+		"PushN":           func() error { _, err := q.PushN([]byte("x")); return err },
+		"PushDedup":       func() error { return q.PushDedup("x", nil) },
+		"PushString":      func() error { return q.PushString("x") },
+		"PushBatch":       func() error { _, err := q.PushBatch([][]byte{[]byte("x")}); return err },
+		"PushBatchAtomic": func() error { return q.PushBatchAtomic([][]byte{[]byte("x")}) },
+		"PushTTL":         func() error { return q.PushTTL([]byte("x"), time.Second) },
+		"PushWait":        func() error { return q.PushWait([]byte("x"), time.Millisecond) },
+		"PushContext":     func() error { return q.PushContext(ctx, []byte("x")) },
+		"PushVectored": func() error {
+			return q.PushVectored([][]byte{[]byte("x")})
+		},
+		"PushJSON":       func() error { return q.PushJSON(1) },
+		"PushFrame":      func() error { return q.PushFrame(Header{}, nil) },
+		"Reserve":        func() error { _, err := q.Reserve(1); return err },
+		"Pop":            func() error { _, err := q.Pop(64); return err },
+		"PopSeq":         func() error { _, _, err := q.PopSeq(64); return err },
+		"PopTimed":       func() error { _, _, err := q.PopTimed(64); return err },
+		"PopInto":        func() error { _, err := q.PopInto(make([]byte, 64)); return err },
+		"PopString":      func() error { _, err := q.PopString(64); return err },
+		"PopBatch":       func() error { _, err := q.PopBatch(4, 64); return err },
+		"PopExactly":     func() error { _, err := q.PopExactly(1, 64); return err },
+		"PopExactlyWait": func() error { _, err := q.PopExactlyWait(1, 64, time.Millisecond); return err },
+		"PopWait":        func() error { _, err := q.PopWait(64, time.Millisecond); return err },
+		"PopContext":     func() error { _, err := q.PopContext(ctx, 64); return err },
+		"PopNoAck":       func() error { _, _, err := q.PopNoAck(64); return err },
+		"PopPooled":      func() error { _, err := q.PopPooled(64); return err },
+		"PopZeroCopy":    func() error { _, err := q.PopZeroCopy(); return err },
+		"PopJSON":        func() error { var v int; return q.PopJSON(&v) },
+		"PopFrame":       func() error { _, _, err := q.PopFrame(); return err },
+		"DrainInto":      func() error { _, err := q.DrainInto([][]byte{make([]byte, 64)}); return err },
+		"Peek":           func() error { _, err := q.Peek(64); return err },
+		"Drain":          func() error { _, err := q.Drain(); return err },
+		"DropExpired":    func() error { _, err := q.DropExpired(); return err },
+		"SeekSeq":        func() error { return q.SeekSeq(0) },
+		"Stats":          func() error { _, err := q.Stats(); return err },
+		"Len":            func() error { _, err := q.Len(); return err },
+		"Flush":          func() error { return q.Flush() },
+		"WaitReadable":   func() error { return q.WaitReadable(time.Millisecond) },
+		"WaitWritable":   func() error { return q.WaitWritable(time.Millisecond) },
+		"FreeSlots":      func() error { _, err := q.FreeSlots(); return err },
+		"Info":           func() error { _, err := q.Info(); return err },
+		"Attached":       func() error { _, err := q.Attached(); return err },
+		"Healthy":        func() error { _, err := q.Healthy(); return err },
+		"AckBatch":       func() error { return q.AckBatch(nil) },
+		"Heartbeat":      func() error { return q.Heartbeat() },
+		"ReclaimStale":   func() error { _, err := q.ReclaimStale(time.Second); return err },
+		"Draining":       func() error { _, err := q.Draining(); return err },
+		"Shutdown":       func() error { return q.Shutdown(ctx) },
+		"Fd":             func() error { _, err := q.Fd(); return err },
+		"Resize":         func() error { return q.Resize(32) },
+		"Remap":          func() error { return q.Remap() },
+		"Snapshot":       func() error { return q.Snapshot(&buf) },
+		"All": func() error {
+			for _, err := range q.All(64) {
+				return err
+			}
+			return nil
+		},
+		"AllBlocking": func() error {
+			for _, err := range q.AllBlocking(ctx, 64) {
+				return err
+			}
+			return nil
+		},
+		"Subscribe":          func() error { _, err := q.Subscribe(func([]byte) error { return nil }); return err },
+		"ResetHighWaterMark": func() error { return q.ResetHighWaterMark() },
+	}
+	for name, call := range calls {
+		if err := call(); err != ErrClosed {
+			t.Errorf("%s: expected ErrClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestNamePath(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	if q.Name() != TestQueue {
+		t.Errorf("Expected name %s, got %s", TestQueue, q.Name())
+	}
+	path, err := q.Path()
+	if err != nil || path != shmDir+TestQueue {
+		t.Fatalf("Expected %s, got %q, %v", shmDir+TestQueue, path, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() < 16*64 {
+		t.Errorf("Expected the segment at %s, got %v", path, err)
+	}
+
+	Unlink(TestQueue)
+	if _, err := q.Path(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist after Unlink, got %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Reopen(); err != nil {
+		t.Errorf("Reopen of an open handle failed: %v", err)
+	}
+	q.Push([]byte("kept"))
+	q.Close()
+
+	if err := q.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	out, seq, err := q.PopSeq(64)
+	if err != nil || string(out) != "kept" || seq != 0 {
+		t.Errorf("Expected kept with sequence 0, got %q, %d, %v", out, seq, err)
+	}
+	if err := q.Push([]byte("again")); err != nil {
+		t.Errorf("Push after Reopen failed: %v", err)
+	}
+
+	// Reopen never recreates a queue that was unlinked
+	q.Close()
+	Unlink(TestQueue)
+	if err := q.Reopen(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+	if err := q.Push([]byte("x")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after a failed Reopen, got %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	c, err := q.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if n, err := q.Attached(); n != 2 || err != nil {
+		t.Errorf("Expected 2 attached, got %d, %v", n, err)
+	}
+
+	// The clone pushes with the same envelope q checks when popping
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := c.PushWait([]byte{byte(i)}, time.Second); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 100; i++ {
+		out, err := q.PopWait(64, time.Second)
+		if err != nil || len(out) != 1 || out[0] != byte(i) {
+			t.Fatalf("Pop %d: got %v, %v", i, out, err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Push through the clone failed: %v", err)
+	}
+
+	c.Close()
+	if n, _ := q.Attached(); n != 1 {
+		t.Errorf("Expected 1 attached after closing the clone, got %d", n)
+	}
+	if err := q.Push([]byte("x")); err != nil {
+		t.Errorf("Push after closing the clone failed: %v", err)
+	}
+
+	q.Close()
+	if _, err := q.Clone(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Workers keep using the queue while several goroutines close it
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := q.Push([]byte("x"))
+				if err == ErrClosed {
+					return
+				}
+				if _, err := q.Pop(64); err == ErrClosed {
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Millisecond)
+			q.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func FuzzPushPop(f *testing.F) {
+	// Fuzz workers are separate processes, so each gets its own queue
+	name := "/nabd_go_fuzz_" + strconv.Itoa(os.Getpid())
+	Unlink(name)
+	q, err := Open(name, 4, 64, Create|Producer|Consumer)
+	if err != nil {
+		f.Fatalf("Open failed: %v", err)
+	}
+	f.Cleanup(func() {
+		q.Close()
+		Unlink(name)
+	})
+
+	// Sizes around the slot boundary, with buffers just big enough or not
+	limit := q.SlotSize() - SlotHeaderSize
+	for _, n := range []int{0, 1, limit - 1, limit, limit + 1} {
+		data := bytes.Repeat([]byte{0xa5}, n)
+		f.Add(data, n)
+		f.Add(data, n-1)
+		f.Add(data, n+1)
+	}
+	f.Add([]byte("hello"), -1)
+
+	f.Fuzz(func(t *testing.T, data []byte, maxLen int) {
+		maxLen = min(maxLen, 1<<16)
+
+		err := q.Push(data)
+		if len(data) > limit {
+			if err != ErrTooBig {
+				t.Fatalf("Push of %d bytes: expected ErrTooBig, got %v", len(data), err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Push of %d bytes failed: %v", len(data), err)
+		}
+
+		// A buffer that is too small leaves the message queued
+		if maxLen <= 0 || maxLen < len(data) {
+			if _, err := q.Pop(maxLen); err != ErrTooBig {
+				t.Fatalf("Pop(%d) of %d bytes: expected ErrTooBig, got %v", maxLen, len(data), err)
+			}
+			if maxLen >= 0 {
+				if _, err := q.PopInto(make([]byte, maxLen)); err != ErrTooBig {
+					t.Fatalf("PopInto(%d) of %d bytes: expected ErrTooBig, got %v", maxLen, len(data), err)
+				}
+			}
+			buf := make([]byte, len(data)+1)
+			n, err := q.PopInto(buf)
+			if err != nil || !bytes.Equal(buf[:n], data) {
+				t.Fatalf("PopInto returned %q, %v, expected %q", buf[:n], err, data)
+			}
+		} else {
+			msg, err := q.Pop(maxLen)
+			if err != nil || !bytes.Equal(msg, data) || msg == nil {
+				t.Fatalf("Pop(%d) returned %q, %v, expected %q", maxLen, msg, err, data)
+			}
+		}
+
+		if n, err := q.Len(); err != nil || n != 0 {
+			t.Fatalf("Expected an empty queue, got %d, %v", n, err)
+		}
+	})
 }
@@ -0,0 +1,55 @@
+package nabd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidName is matched by the *NameError that ValidName and Open
+// return for a name the system would reject
+var ErrInvalidName = errors.New("invalid queue name")
+
+// NameError explains which naming rule a queue name breaks
+type NameError struct {
+	Name   string // The rejected name
+	Reason string // The rule it breaks, such as "must start with a slash"
+}
+
+func (e *NameError) Error() string {
+	return "nabd invalid name " + strconv.Quote(e.Name) + ": " + e.Reason
+}
+
+// Is reports whether target is ErrInvalidName
+func (e *NameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+// ValidName checks name against the POSIX shared-memory naming rules
+//
+// A valid name is a slash followed by 1 to MaxNameLen-1 bytes containing
+// no further slash and no NUL byte, and is not "/." or "/..". It returns
+// nil for a valid name and a *NameError saying which rule was broken
+// otherwise.
+func ValidName(name string) error {
+	reason := ""
+	switch rest := strings.TrimPrefix(name, "/"); {
+	case name == "":
+		reason = "must not be empty"
+	case name[0] != '/':
+		reason = "must start with a slash, as in \"/" + name + "\""
+	case rest == "":
+		reason = "needs at least one character after the slash"
+	case strings.Contains(rest, "/"):
+		reason = "must not contain a slash after the first character"
+	case strings.IndexByte(rest, 0) >= 0:
+		reason = "must not contain a NUL byte"
+	case rest == "." || rest == "..":
+		reason = "must not be \"/.\" or \"/..\""
+	case len(name) > MaxNameLen:
+		reason = "is " + strconv.Itoa(len(name)) + " bytes, the limit is " + strconv.Itoa(MaxNameLen)
+	default:
+		return nil
+	}
+	return &NameError{Name: name, Reason: reason}
+}
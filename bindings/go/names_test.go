@@ -0,0 +1,46 @@
+package nabd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidName(t *testing.T) {
+	if err := ValidName(TestQueue); err != nil {
+		t.Errorf("Expected %s to be valid, got %v", TestQueue, err)
+	}
+	if err := ValidName("/" + strings.Repeat("a", MaxNameLen-1)); err != nil {
+		t.Errorf("Expected a name of MaxNameLen bytes to be valid, got %v", err)
+	}
+
+	bad := []string{
+		"",
+		"nabd_go_test",
+		"/",
+		"/a/b",
+		"/a\x00b",
+		"/.",
+		"/..",
+		"/" + strings.Repeat("a", MaxNameLen),
+	}
+	for _, name := range bad {
+		err := ValidName(name)
+		var ne *NameError
+		if !errors.As(err, &ne) || ne.Reason == "" {
+			t.Errorf("ValidName(%q): expected a *NameError, got %v", name, err)
+		}
+		if !errors.Is(err, ErrInvalidName) {
+			t.Errorf("ValidName(%q): expected ErrInvalidName, got %v", name, err)
+		}
+	}
+
+	// Open rejects the name before calling into C
+	q, err := Open("nabd_go_test", 16, 64, Create|Producer)
+	if !errors.Is(err, ErrInvalidName) {
+		if q != nil {
+			q.Close()
+		}
+		t.Errorf("Expected ErrInvalidName from Open, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package nabd
+
+/*
+#include "nabd/notify.h"
+*/
+import "C"
+
+// Fd returns a file descriptor that is readable while messages are
+// pending, for registering the queue with epoll, kqueue or select
+//
+// Producers in any process signal the descriptor when they push into an
+// empty queue. It stays readable until a Pop on this handle returns
+// ErrEmpty, which drains it, so level-triggered polling works with any
+// number of pops per wakeup. With edge-triggered polling (EPOLLET), pop
+// until ErrEmpty after each wakeup or later messages may not signal.
+// Spurious wakeups are possible.
+//
+// Fd is only available on consumer handles of non-Broadcast queues. The
+// descriptor belongs to the Queue: do not close it, and unregister it
+// before calling Close.
+func (q *Queue) Fd() (uintptr, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	fd, errno := C.nabd_notify_fd(h)
+	if fd < 0 {
+		return 0, failure("fd", q.name, fd, errno)
+	}
+	return uintptr(fd), nil
+}
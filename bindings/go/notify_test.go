@@ -0,0 +1,66 @@
+//go:build linux
+
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func readable(t *testing.T, fd uintptr) bool {
+	var set syscall.FdSet
+	set.Bits[fd/64] |= 1 << (fd % 64)
+	tv := syscall.Timeval{}
+	n, err := syscall.Select(int(fd)+1, &set, nil, nil, &tv)
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	return n == 1
+}
+
+func TestFd(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := p.Fd(); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected ErrFailed on producer handle, got %v", err)
+	}
+
+	fd, err := c.Fd()
+	if err != nil {
+		t.Fatalf("Fd failed: %v", err)
+	}
+	if readable(t, fd) {
+		t.Error("Expected fd not readable on empty queue")
+	}
+
+	if err := p.Push([]byte("wake")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if !readable(t, fd) {
+		t.Fatal("Expected fd readable after push")
+	}
+
+	if _, err := c.Pop(64); err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+	if _, err := c.Pop(64); err != ErrEmpty {
+		t.Fatalf("Expected ErrEmpty, got %v", err)
+	}
+	if readable(t, fd) {
+		t.Error("Expected fd drained after ErrEmpty")
+	}
+}
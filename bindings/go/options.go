@@ -0,0 +1,316 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"os"
+	"time"
+)
+
+// Option configures a queue opened with OpenWithOptions
+type Option func(*options)
+
+// options collects the settings applied by each Option
+type options struct {
+	capacity  int
+	slotSize  int
+	flags     Flag
+	mode      os.FileMode
+	group     *int
+	mlock     bool
+	overwrite bool
+	unlink    bool
+	empty     EmptyPolicy
+	onError   HandlerPolicy
+	checksum  bool
+	sequence  bool
+	timestamp bool
+	expiry    bool
+	delay     bool
+	codec     Codec
+	maxMsg    int
+	dlq       *Queue
+
+	concurrent bool
+	visibility time.Duration
+	chunked    bool
+	wait       *waitStrategy
+
+	rps         int
+	burst       int
+	limitPolicy RateLimitPolicy
+	dedupWindow int
+
+	latency       bool
+	latencyBounds []time.Duration
+}
+
+// WithCapacity sets the number of slots when creating a queue. It is
+// rounded up to a power of two; zero selects the library default.
+// Attaching handles read the capacity from the queue and ignore it.
+func WithCapacity(n int) Option {
+	return func(o *options) { o.capacity = n }
+}
+
+// WithSlotSize sets the size of each slot in bytes, including the slot
+// header, when creating a queue. Zero selects the library default.
+func WithSlotSize(n int) Option {
+	return func(o *options) { o.slotSize = n }
+}
+
+// WithFlags sets the open flags, such as Create|Producer or Consumer. At
+// least one of Producer and Consumer is required.
+func WithFlags(flags Flag) Option {
+	return func(o *options) { o.flags = flags }
+}
+
+// WithMode sets the permission bits of the shared-memory segment, such as
+// 0660 to let a group attach. The mode is applied exactly, regardless of
+// the umask, and only when the queue is created; attaching handles ignore
+// it. Modes with bits outside os.ModePerm make OpenWithOptions fail with
+// EINVAL.
+func WithMode(mode os.FileMode) Option {
+	return func(o *options) { o.mode = mode }
+}
+
+// WithGroup hands a newly created segment to group gid, so consumers
+// running as other users in that group can attach once WithMode grants
+// the group access, typically 0660. The owner stays the creating user.
+// Like the mode it only applies with Create, and only when this handle
+// creates the queue. An unprivileged process can only pick a group it
+// belongs to; otherwise OpenWithOptions fails with an error matching
+// os.ErrPermission and the queue is not created. A negative gid fails
+// with EINVAL.
+func WithGroup(gid int) Option {
+	return func(o *options) { o.group = &gid }
+}
+
+// WithMlock locks the mapped ring into RAM so Push and Pop never take a
+// page fault. It needs CAP_IPC_LOCK or an RLIMIT_MEMLOCK large enough for
+// the whole segment; otherwise OpenWithOptions fails with an error that
+// matches syscall.EPERM or syscall.ENOMEM. Close unlocks the pages.
+func WithMlock() Option {
+	return func(o *options) { o.mlock = true }
+}
+
+// WithUnlinkOnLastClose removes the queue when the last handle attached to
+// it closes, whether or not that handle used the option. Producer and
+// consumer handles in every process count. A process that crashes never
+// closes, so its queue survives until UnlinkWhenIdle or Unlink removes it.
+func WithUnlinkOnLastClose() Option {
+	return func(o *options) { o.unlink = true }
+}
+
+// WithOverwrite makes a newly created queue drop its oldest message
+// instead of failing when full, so Push never returns ErrFull. A consumer
+// that missed messages gets ErrLapped once, then pops resume at the
+// oldest message left. A Pop never returns a slot that was overwritten
+// while it was being copied. It assumes a single consumer handle and
+// cannot be combined with Broadcast.
+func WithOverwrite() Option {
+	return func(o *options) { o.overwrite = true }
+}
+
+// WithEmptyPolicy selects how Push treats zero-length messages, as
+// SetEmptyPolicy does.
+func WithEmptyPolicy(p EmptyPolicy) Option {
+	return func(o *options) { o.empty = p }
+}
+
+// WithHandlerPolicy selects what Subscribe does when its handler returns
+// an error, as SetHandlerPolicy does.
+func WithHandlerPolicy(p HandlerPolicy) Option {
+	return func(o *options) { o.onError = p }
+}
+
+// WithChecksum prefixes each pushed message with a CRC32C of its contents
+// and verifies it on pop, which fails the pop with ErrCorrupt when the
+// slot was overwritten or torn. The checksum takes 4 bytes of each slot.
+// Every handle on the queue must use it, or none.
+func WithChecksum() Option {
+	return func(o *options) { o.checksum = true }
+}
+
+// WithCompression compresses each pushed message with codec and
+// decompresses it on pop, so compressible payloads take less of the ring
+// and, up to WithMaxMessage, may exceed the slot size. A one-byte flag in each slot records
+// whether the message was compressed; one that would not shrink is stored
+// as is, as is one split over several slots WithChunking. Messages written
+// in place with Reserve or PushVectored are never compressed. Every handle
+// on the queue must use a compatible codec, or none. NewFlateCodec and
+// NewLZ4Codec need no dependencies.
+//
+// The uncompressed length is recorded with each message, so a pop into a
+// buffer too small for it returns ErrTooBig and leaves the message
+// queued, as for one that exceeds the slot. A broadcast reader checks
+// after its pop and moves its cursor back. Overwrite queues can do
+// neither, and WithCompression fails with EINVAL on them.
+func WithCompression(codec Codec) Option {
+	return func(o *options) { o.codec = codec }
+}
+
+// WithMaxMessage sets the longest message, counted before compression,
+// that the handle pushes and expects to pop. PopJSON, PopGob, PopFrame,
+// WriteTo, ReadFrom and Subscribe size their buffers from it, as does
+// httpbridge, and Push refuses a longer message with ErrTooBig. It
+// defaults to what the ring stores uncompressed: one slot's payload, or
+// the whole ring WithChunking. Raise it WithCompression for messages that
+// only fit a slot once compressed; consumers should use the same value.
+// Negative values fail with EINVAL.
+func WithMaxMessage(n int) Option {
+	return func(o *options) { o.maxMsg = n }
+}
+
+// WithSequence stamps each pushed message with a 64-bit sequence number,
+// read back with PopSeq. The counter lives in the queue's shared header,
+// so a producer that reconnects continues where the last one stopped. It
+// wraps to 0 after 2^64 - 1, which takes centuries even at a billion
+// messages per second. The number takes 8 bytes of each slot. Every
+// handle on the queue must use it, or none.
+func WithSequence() Option {
+	return func(o *options) { o.sequence = true }
+}
+
+// WithTimestamp stamps each pushed message with the producer's wall-clock
+// time, read back with PopTimed. The stamp is written into the slot with
+// the payload, so it records when the message was enqueued. It takes 8
+// bytes of each slot. Every handle on the queue must use it, or none.
+func WithTimestamp() Option {
+	return func(o *options) { o.timestamp = true }
+}
+
+// WithExpiry reserves room in each message for an expiry deadline, set by
+// PushTTL. Pops skip expired messages. Messages pushed with plain Push
+// never expire. It takes 8 bytes of each slot. Every handle on the queue
+// must use it, or none.
+func WithExpiry() Option {
+	return func(o *options) { o.expiry = true }
+}
+
+// WithDelay reserves room in each message for a delivery time, set by
+// PushDelayed. Messages pushed with plain Push are delivered at once. It
+// takes 8 bytes of each slot. Every handle on the queue must use it, or
+// none.
+//
+// A message that is not due stays in the ring, counted by Len and taking
+// its slot, until its delivery time arrives. The ring is FIFO, so it also
+// holds back the messages pushed after it: every pop, peek and batch pop
+// reports ErrEmpty at it, and WaitReadable and Select treat the queue as
+// not readable. Delayed messages are therefore delivered in push order,
+// each no earlier than its delivery time; push them in the order they fall
+// due for the least waiting. Broadcast and overwrite queues, whose
+// readers cannot look ahead without consuming, fail with EINVAL.
+func WithDelay() Option {
+	return func(o *options) { o.delay = true }
+}
+
+// WithDeadLetter routes messages the handle cannot deliver to dlq instead
+// of dropping them: pushes rejected with ErrTooBig or ErrEmptyMessage,
+// and pops that meet an expired or corrupt message. Each dead letter
+// carries a header naming the reason; see ParseDeadLetter. Calls still
+// return their usual error. If dlq rejects a dead letter, for instance
+// because it is full, the message is dropped and counted in
+// Stats.DeadLetterDrops. dlq must stay open while the handle is in use.
+func WithDeadLetter(dlq *Queue) Option {
+	return func(o *options) { o.dlq = dlq }
+}
+
+// WithConcurrentProducers lets several goroutines push through the same
+// handle. The ring itself supports one producer at a time, so pushes on
+// the handle are serialized with a mutex; without it, a push that overlaps
+// another fails with ErrConcurrentPush. It does not make several producer
+// handles or processes safe to use on one queue.
+func WithConcurrentProducers() Option {
+	return func(o *options) { o.concurrent = true }
+}
+
+// WithWaitStrategy tunes how blocking calls such as PushWait, PopContext
+// and Subscribe wait: they retry spinCount times at once, yield the
+// processor for a while, and then sleep, starting at sleepFloor and
+// doubling up to sleepCap
+//
+// Spinning reacts within nanoseconds but keeps a core busy, while longer
+// sleeps save CPU at the price of latency: a message arriving during a
+// sleep waits up to sleepCap. Spinning only pays off when the other side
+// runs on another core; on a busy or single-core machine it delays the
+// very push it waits for. Latency-critical consumers raise spinCount
+// and lower sleepCap; background workers lower spinCount and raise
+// sleepCap. The defaults are DefaultWaitSpins, DefaultWaitSleepFloor and
+// DefaultWaitSleepCap. A negative spinCount counts as zero, a
+// non-positive sleepFloor picks the default and sleepCap is raised to at
+// least sleepFloor.
+func WithWaitStrategy(spinCount int, sleepFloor, sleepCap time.Duration) Option {
+	if sleepFloor <= 0 {
+		sleepFloor = DefaultWaitSleepFloor
+	}
+	s := &waitStrategy{spins: max(spinCount, 0), floor: sleepFloor, cap: max(sleepCap, sleepFloor)}
+	return func(o *options) { o.wait = s }
+}
+
+// WithRateLimit limits pushes through the handle to rps messages per
+// second, allowing bursts of up to burst messages, with a token bucket
+// kept in the handle. Pushes over the limit fail with ErrRateLimited, or
+// wait under WithRateLimitPolicy(WaitOverLimit). Each message of a batch
+// takes a token, and a push that fails for another reason gives its token
+// back. The limit is per process: other producers have their own, and
+// clones share the handle's. SetRateLimit changes it later.
+func WithRateLimit(rps, burst int) Option {
+	return func(o *options) { o.rps, o.burst = rps, burst }
+}
+
+// WithRateLimitPolicy selects what a push over the rate limit does, as
+// SetRateLimitPolicy does
+func WithRateLimitPolicy(p RateLimitPolicy) Option {
+	return func(o *options) { o.limitPolicy = p }
+}
+
+// WithDedupWindow sets how many recent ids PushDedup remembers. Every
+// producer using PushDedup on the queue must use the same value; the
+// default is DefaultDedupWindow. A larger window catches later retries
+// but makes each PushDedup scan more ids.
+func WithDedupWindow(n int) Option {
+	return func(o *options) { o.dedupWindow = n }
+}
+
+// WithLatencyHistogram records how long each message waited between its
+// push and its pop in a histogram reported by Stats. A message falls in
+// the first bucket whose bound is at least its latency, or in a final
+// overflow bucket above the last bound. The bounds must be positive and
+// increasing; none selects DefaultLatencyBuckets.
+//
+// The histogram is shared by every handle that uses the option, and the
+// first of them fixes the bounds: later handles passing no bounds adopt
+// them, and ones passing different bounds fail with EINVAL. It needs
+// WithTimestamp, as the latency is measured from the push stamp, and
+// opening without it fails with EINVAL. Each pop then also updates a few
+// shared counters.
+func WithLatencyHistogram(bounds ...time.Duration) Option {
+	return func(o *options) {
+		o.latency = true
+		o.latencyBounds = bounds
+	}
+}
+
+// WithVisibilityTimeout sets how long a message popped with PopNoAck
+// stays hidden before it is delivered again. The default is
+// DefaultVisibilityTimeout.
+func WithVisibilityTimeout(d time.Duration) Option {
+	return func(o *options) { o.visibility = d }
+}
+
+// WithChunking lets Push send messages larger than a slot by splitting
+// them over consecutive slots, which are published together so consumers
+// never see part of one. Messages that fit keep the single-slot path.
+// ErrFull means there was no room for every chunk, and ErrTooBig that the
+// message needs more slots than Cap.
+//
+// Only producers need the option: Pop, PopBatch and the calls built on
+// them reassemble chunked messages on any handle, given a maxLen large
+// enough for the whole message. Peek, PopZeroCopy, PopNoAck and Snapshot
+// cannot return one and fail instead. Broadcast and overwrite queues do
+// not support chunking; Push fails there with EINVAL.
+func WithChunking() Option {
+	return func(o *options) { o.chunked = true }
+}
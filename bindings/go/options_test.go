@@ -0,0 +1,574 @@
+package nabd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenWithOptions(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(128),
+		WithFlags(Create|Producer),
+		WithEmptyPolicy(RejectEmpty))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+
+	if c := p.Cap(); c != 8 {
+		t.Errorf("Expected capacity 8, got %d", c)
+	}
+	if s := p.SlotSize(); s != 128 {
+		t.Errorf("Expected slot size 128, got %d", s)
+	}
+	if err := p.Push(nil); err != ErrEmptyMessage {
+		t.Errorf("Expected ErrEmptyMessage, got %v", err)
+	}
+
+	// Attaching handles take the geometry from the queue
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer))
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if s := c.SlotSize(); s != 128 {
+		t.Errorf("Expected slot size 128, got %d", s)
+	}
+}
+
+func TestWithMode(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// Mode bits beyond the permissions are rejected
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithMode(os.ModeSetuid|0600)); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+
+	// The mode is applied exactly, even when the umask would strip it
+	old := syscall.Umask(077)
+	q, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithMode(0640))
+	syscall.Umask(old)
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	fi, err := os.Stat("/dev/shm" + TestQueue)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0640 {
+		t.Errorf("Expected mode 0640, got %o", perm)
+	}
+}
+
+func TestWithGroup(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	if _, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithGroup(-1)); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+
+	// Root may pick any group, others one of their own
+	gid := os.Getgid()
+	if os.Geteuid() == 0 {
+		gid = 4242
+	}
+	q, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithMode(0660), WithGroup(gid))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// The attach table follows, or group members could not attach
+	for _, path := range []string{"/dev/shm" + TestQueue, "/dev/shm" + TestQueue + ".ref"} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if got := int(fi.Sys().(*syscall.Stat_t).Gid); got != gid || fi.Mode().Perm() != 0660 {
+			t.Errorf("%s: expected group %d mode 0660, got %d %o", path, gid, got, fi.Mode().Perm())
+		}
+	}
+
+	// A group the process is not in is refused and nothing is left behind
+	if os.Geteuid() != 0 && os.Getegid() != 0 {
+		q.Close()
+		Unlink(TestQueue)
+		if _, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer), WithGroup(0)); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("Expected a permission error, got %v", err)
+		}
+		if ok, _ := Exists(TestQueue); ok {
+			t.Errorf("Expected the failed create to remove the queue")
+		}
+	}
+}
+
+func TestWithGroupSidecars(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	gid := os.Getgid()
+	if os.Geteuid() == 0 {
+		gid = 4242
+	}
+	q, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer|Consumer), WithMode(0660),
+		WithGroup(gid), WithTimestamp(), WithLatencyHistogram())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+	if err := q.PushDedup("a", []byte("x")); err != nil {
+		t.Fatalf("PushDedup failed: %v", err)
+	}
+	if _, _, err := q.PopNoAck(64); err != nil {
+		t.Fatalf("PopNoAck failed: %v", err)
+	}
+
+	// The binding's own tables follow the segment like the attach table
+	for _, path := range []string{ackPath(TestQueue), dedupPath(TestQueue), latencyPath(TestQueue)} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if got := int(fi.Sys().(*syscall.Stat_t).Gid); got != gid || fi.Mode().Perm() != 0660 {
+			t.Errorf("%s: expected group %d mode 0660, got %d %o", path, gid, got, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestWithMlock(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue, WithFlags(Create|Producer|Consumer), WithCapacity(16), WithMlock())
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOMEM) {
+		t.Skipf("mlock not permitted: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Push([]byte("locked")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if data, err := q.Pop(64); err != nil || string(data) != "locked" {
+		t.Errorf("Expected locked, got %q, %v", data, err)
+	}
+}
+
+func TestWithChecksum(t *testing.T) {
+	if _, err := os.Stat(shmDir); err != nil {
+		t.Skip("needs /dev/shm")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// The checksum takes 4 bytes of each slot
+	if err := q.Push(make([]byte, 64-SlotHeaderSize-3)); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	if err := q.Push([]byte("intact")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if msg, err := q.Pop(6); err != nil || string(msg) != "intact" {
+		t.Errorf("Expected intact, got %q, %v", msg, err)
+	}
+
+	// Stomp on the payload in shared memory behind the handle's back
+	if err := q.Push([]byte("garbage")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	f, err := os.OpenFile("/dev/shm"+TestQueue, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		t.Fatalf("Mmap failed: %v", err)
+	}
+	defer syscall.Munmap(mem)
+	i := bytes.Index(mem, []byte("garbage"))
+	if i < 0 {
+		t.Fatal("Payload not found in segment")
+	}
+	mem[i] ^= 0xff
+
+	if _, err := q.Pop(64); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+	if _, err := q.Pop(64); err != ErrEmpty {
+		t.Errorf("Expected corrupt message to be consumed, got %v", err)
+	}
+}
+
+func TestWithSequence(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	p.Push([]byte("a"))
+	if n, err := p.PushBatch([][]byte{[]byte("b"), []byte("c")}); n != 2 || err != nil {
+		t.Fatalf("PushBatch failed: %d, %v", n, err)
+	}
+	p.Close()
+
+	// A reconnecting producer continues the sequence
+	p, err = OpenWithOptions(TestQueue, WithFlags(Producer), WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+	p.Push([]byte("d"))
+
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer c.Close()
+
+	for i, want := range []string{"a", "b", "c", "d"} {
+		msg, seq, err := c.PopSeq(8)
+		if err != nil {
+			t.Fatalf("PopSeq failed: %v", err)
+		}
+		if string(msg) != want || seq != uint64(i) {
+			t.Errorf("Expected %s with seq %d, got %s with seq %d", want, i, msg, seq)
+		}
+	}
+
+	plain, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer plain.Close()
+	if _, _, err := plain.PopSeq(8); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL without WithSequence, got %v", err)
+	}
+}
+
+func TestWithTimestamp(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithSequence(),
+		WithTimestamp())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	before := time.Now()
+	if err := q.Push([]byte("timed")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	after := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	msg, pushed, err := q.PopTimed(8)
+	if err != nil {
+		t.Fatalf("PopTimed failed: %v", err)
+	}
+	if string(msg) != "timed" {
+		t.Errorf("Expected timed, got %s", msg)
+	}
+	if pushed.Before(before.Truncate(0)) || pushed.After(after) {
+		t.Errorf("Expected push time between %v and %v, got %v", before, after, pushed)
+	}
+	if time.Since(pushed) < 10*time.Millisecond {
+		t.Errorf("Expected at least 10ms in the queue, got %v", time.Since(pushed))
+	}
+}
+
+func TestWithOverwrite(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer),
+		WithOverwrite())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 6; i++ {
+		if err := p.Push([]byte{byte(i)}); err != nil {
+			t.Fatalf("Expected Push to overwrite, got %v", err)
+		}
+	}
+	if _, err := c.Pop(8); err != ErrLapped {
+		t.Errorf("Expected ErrLapped, got %v", err)
+	}
+	if msg, err := c.Pop(8); err != nil || msg[0] != 2 {
+		t.Errorf("Expected oldest surviving message 2, got %v, %v", msg, err)
+	}
+	c.Drain()
+
+	// Every popped message must be whole, never a mix of two pushes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg := make([]byte, 40)
+		for i := 0; i < 200000; i++ {
+			for j := range msg {
+				msg[j] = byte(i)
+			}
+			p.Push(msg)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		msg, err := c.Pop(64)
+		if err == ErrEmpty || err == ErrLapped {
+			continue
+		} else if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		for _, b := range msg {
+			if b != msg[0] {
+				t.Fatalf("Torn message: %v", msg)
+			}
+		}
+	}
+}
+
+func TestWithConcurrentProducers(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	const pushers, each = 8, 500
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(pushers*each),
+		WithSlotSize(32),
+		WithFlags(Create|Producer|Consumer),
+		WithSequence(),
+		WithConcurrentProducers())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < pushers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				var err error
+				if i%10 == 0 {
+					_, err = q.PushBatch([][]byte{{byte(g), byte(i >> 8), byte(i)}})
+				} else {
+					err = q.Push([]byte{byte(g), byte(i >> 8), byte(i)})
+				}
+				if err != nil {
+					t.Errorf("Push failed: %v", err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[[3]byte]bool)
+	for n := uint64(0); ; n++ {
+		msg, seq, err := q.PopSeq(8)
+		if err == ErrEmpty {
+			break
+		} else if err != nil {
+			t.Fatalf("PopSeq failed: %v", err)
+		}
+		if seq != n {
+			t.Fatalf("Expected seq %d, got %d", n, seq)
+		}
+		seen[[3]byte(msg)] = true
+	}
+	if len(seen) != pushers*each {
+		t.Errorf("Expected %d distinct messages, got %d", pushers*each, len(seen))
+	}
+}
+
+func TestSeekSeq(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	// Push 0..5 and consume them all; 3, 4 and 5 are still in the ring
+	for i := 0; i < 6; i++ {
+		q.Push([]byte{byte(i)})
+		q.Pop(8)
+	}
+
+	if err := q.SeekSeq(3); err != nil {
+		t.Fatalf("SeekSeq failed: %v", err)
+	}
+	for want := uint64(3); want < 6; want++ {
+		msg, seq, err := q.PopSeq(8)
+		if err != nil || seq != want || msg[0] != byte(want) {
+			t.Errorf("Expected replay of %d, got %v with seq %d, %v", want, msg, seq, err)
+		}
+	}
+
+	if err := q.SeekSeq(2); err != ErrLapped {
+		t.Errorf("Expected ErrLapped past the replay window, got %v", err)
+	}
+	if err := q.SeekSeq(7); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for a future seq, got %v", err)
+	}
+	if err := q.SeekSeq(4); err != nil {
+		t.Fatalf("SeekSeq failed: %v", err)
+	}
+	if err := q.SeekSeq(6); err != nil {
+		t.Fatalf("SeekSeq to the end failed: %v", err)
+	}
+	if _, _, err := q.PopSeq(8); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty at the end, got %v", err)
+	}
+}
+
+func TestWithChunking(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer),
+		WithChunking(),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+
+	// The consumer needs no option, only a big enough buffer
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithChecksum())
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	big := make([]byte, 300)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	if err := p.Push([]byte("small")); err != nil {
+		t.Fatalf("Push small failed: %v", err)
+	}
+	if err := p.Push(big); err != nil {
+		t.Fatalf("Push big failed: %v", err)
+	}
+	if err := p.Push(big); err != ErrFull {
+		t.Errorf("Expected ErrFull without room for every chunk, got %v", err)
+	}
+	if err := p.Push(make([]byte, 1000)); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig beyond Cap slots, got %v", err)
+	}
+
+	if msg, err := c.Pop(64); err != nil || string(msg) != "small" {
+		t.Errorf("Expected small, got %q, %v", msg, err)
+	}
+	if _, err := c.Pop(64); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig for a short buffer, got %v", err)
+	}
+	if msg, err := c.Pop(len(big)); err != nil || !bytes.Equal(msg, big) {
+		t.Errorf("Reassembled message differs: %d bytes, %v", len(msg), err)
+	}
+	if n, _ := c.Len(); n != 0 {
+		t.Errorf("Expected every chunk consumed, got len %d", n)
+	}
+}
+
+func TestWithUnlinkOnLastClose(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer),
+		WithUnlinkOnLastClose())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+
+	p.Close()
+	if ok, _ := Exists(TestQueue); !ok {
+		t.Fatal("Queue removed while a consumer was still attached")
+	}
+	c.Close()
+	if ok, _ := Exists(TestQueue); ok {
+		t.Error("Queue still exists after the last close")
+	}
+}
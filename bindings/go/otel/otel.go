@@ -0,0 +1,155 @@
+// Package otel adds OpenTelemetry tracing to NABD queues.
+//
+// A traced push starts a producer span and writes its span context into a
+// small header in front of the payload. A traced pop reads the header back
+// and starts a consumer span linked to the producer's, so the hop across
+// shared memory shows up in distributed traces. The package lives in its
+// own module so the core binding stays free of the OpenTelemetry
+// dependency.
+//
+// # Header format
+//
+// Version 1 of the header is 26 bytes, followed by the payload:
+//
+//	offset  size  field
+//	0       1     version (1)
+//	1       1     trace flags
+//	2       16    trace ID
+//	18      8     span ID
+//
+// A zero trace ID means the push had no span context. Trace state and
+// baggage are not carried. Future versions will change the version byte,
+// and readers reject versions they do not know.
+package otel
+
+import (
+	"context"
+	"errors"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// HeaderVersion is the header version written by PushWithContext
+	HeaderVersion = 1
+
+	// HeaderSize is the bytes the header takes from each slot
+	HeaderSize = 26
+)
+
+// ErrHeader is returned by PopWithContext for a message without a valid
+// trace header, such as one pushed with plain Push
+var ErrHeader = errors.New("missing or unknown trace header")
+
+// Queue wraps a nabd.Queue with traced push and pop
+type Queue struct {
+	q      *nabd.Queue
+	tracer trace.Tracer
+}
+
+// Option configures Wrap
+type Option func(*Queue)
+
+// WithTracerProvider selects the provider spans come from. The global
+// provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Queue) {
+		t.tracer = tp.Tracer("github.com/YASSERRMD/nabd/bindings/go/otel")
+	}
+}
+
+// Wrap returns a traced view of q. Untraced calls on q remain available.
+func Wrap(q *nabd.Queue, opts ...Option) *Queue {
+	t := &Queue{q: q}
+	WithTracerProvider(otel.GetTracerProvider())(t)
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// PushWithContext starts a producer span as a child of ctx and pushes data
+// behind a header carrying that span's context. The header counts
+// against the slot size, so the largest payload is HeaderSize bytes
+// smaller than with Push.
+func (t *Queue) PushWithContext(ctx context.Context, data []byte) error {
+	ctx, span := t.tracer.Start(ctx, "nabd.push",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.Int("messaging.message.body.size", len(data))))
+	defer span.End()
+
+	msg := make([]byte, HeaderSize+len(data))
+	encodeHeader(msg, span.SpanContext())
+	copy(msg[HeaderSize:], data)
+
+	err := t.q.Push(msg)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// PopWithContext pops a message pushed by PushWithContext and records a
+// consumer span, child of ctx and linked to the producer's span. It
+// returns ctx carrying the producer's span context as remote parent, so
+// processing spans started from it join the producer's trace.
+//
+// maxLen bounds the payload, not counting the header. A message without
+// a valid header is returned whole together with ErrHeader.
+func (t *Queue) PopWithContext(ctx context.Context, maxLen int) (context.Context, []byte, error) {
+	msg, err := t.q.Pop(HeaderSize + maxLen)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	sc, ok := decodeHeader(msg)
+	if !ok {
+		return ctx, msg, ErrHeader
+	}
+	data := msg[HeaderSize:]
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("messaging.message.body.size", len(data))),
+	}
+	if sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	_, span := t.tracer.Start(ctx, "nabd.pop", opts...)
+	span.End()
+
+	if sc.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	return ctx, data, nil
+}
+
+// encodeHeader writes a version 1 header for sc into buf
+func encodeHeader(buf []byte, sc trace.SpanContext) {
+	tid, sid := sc.TraceID(), sc.SpanID()
+	buf[0] = HeaderVersion
+	buf[1] = byte(sc.TraceFlags())
+	copy(buf[2:18], tid[:])
+	copy(buf[18:26], sid[:])
+}
+
+// decodeHeader parses the header at the start of msg
+func decodeHeader(msg []byte) (trace.SpanContext, bool) {
+	if len(msg) < HeaderSize || msg[0] != HeaderVersion {
+		return trace.SpanContext{}, false
+	}
+
+	var tid trace.TraceID
+	var sid trace.SpanID
+	copy(tid[:], msg[2:18])
+	copy(sid[:], msg[18:26])
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.TraceFlags(msg[1]),
+		Remote:     true,
+	}), true
+}
@@ -0,0 +1,87 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testQueue = "/nabd_go_otel_test"
+
+func TestPropagation(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+
+	q, err := nabd.Open(testQueue, 16, 128, nabd.Create|nabd.Producer|nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	tq := Wrap(q, WithTracerProvider(tp))
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	if err := tq.PushWithContext(ctx, []byte("traced")); err != nil {
+		t.Fatalf("PushWithContext failed: %v", err)
+	}
+	root.End()
+
+	popCtx, data, err := tq.PopWithContext(context.Background(), 64)
+	if err != nil {
+		t.Fatalf("PopWithContext failed: %v", err)
+	}
+	if string(data) != "traced" {
+		t.Errorf("Expected traced, got %s", data)
+	}
+
+	spans := rec.Ended()
+	var push, pop sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "nabd.push":
+			push = s
+		case "nabd.pop":
+			pop = s
+		}
+	}
+	if push == nil || pop == nil {
+		t.Fatalf("Expected push and pop spans, got %d spans", len(spans))
+	}
+	if push.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("Expected push span to be a child of the caller's span")
+	}
+	if len(pop.Links()) != 1 || pop.Links()[0].SpanContext.SpanID() != push.SpanContext().SpanID() {
+		t.Error("Expected pop span to link to the push span")
+	}
+
+	remote := trace.SpanContextFromContext(popCtx)
+	if remote.TraceID() != root.SpanContext().TraceID() || !remote.IsRemote() {
+		t.Error("Expected returned context to carry the producer's trace")
+	}
+}
+
+func TestMissingHeader(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+
+	q, err := nabd.Open(testQueue, 16, 128, nabd.Create|nabd.Producer|nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("plain"))
+	_, data, err := Wrap(q).PopWithContext(context.Background(), 64)
+	if err != ErrHeader {
+		t.Errorf("Expected ErrHeader, got %v", err)
+	}
+	if string(data) != "plain" {
+		t.Errorf("Expected raw message plain, got %s", data)
+	}
+}
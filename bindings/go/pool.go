@@ -0,0 +1,60 @@
+package nabd
+
+import (
+	"sync"
+)
+
+// bufPool holds buffers handed out by PopPooled
+var bufPool sync.Pool
+
+// PooledBuf is a message popped by PopPooled into a pooled buffer
+type PooledBuf struct {
+	data []byte
+	buf  *[]byte
+}
+
+// Bytes returns the message. It is only valid until Release.
+func (b *PooledBuf) Bytes() []byte {
+	return b.data
+}
+
+// Release returns the buffer to the pool. The PooledBuf and anything
+// obtained from Bytes must not be used afterwards; releasing twice is a
+// no-op.
+func (b *PooledBuf) Release() {
+	if b.buf == nil {
+		return
+	}
+	bufPool.Put(b.buf)
+	b.data, b.buf = nil, nil
+}
+
+// PopPooled pops the next message like Pop, but into a buffer drawn from
+// a package-wide sync.Pool, so a consumer that handles each message and
+// drops it allocates almost nothing per message. Call Release when done.
+// A PooledBuf that is never released is simply garbage collected: that
+// costs the reuse, never correctness.
+func (q *Queue) PopPooled(maxLen int) (*PooledBuf, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if maxLen <= 0 {
+		return nil, ErrTooBig
+	}
+
+	size := maxLen + q.env.size()
+	bp, _ := bufPool.Get().(*[]byte)
+	if bp == nil || cap(*bp) < size {
+		b := make([]byte, size)
+		bp = &b
+	}
+	data, _, err := q.popBuf(h, (*bp)[:size])
+	if err != nil {
+		bufPool.Put(bp)
+		return nil, err
+	}
+	return &PooledBuf{data: data, buf: bp}, nil
+}
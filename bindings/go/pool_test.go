@@ -0,0 +1,62 @@
+package nabd
+
+import (
+	"testing"
+)
+
+func TestPopPooled(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(8),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if _, err := q.PopPooled(32); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+	if _, err := q.PopPooled(0); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+
+	for _, m := range []string{"first", "second"} {
+		if err := q.Push([]byte(m)); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		b, err := q.PopPooled(32)
+		if err != nil {
+			t.Fatalf("PopPooled failed: %v", err)
+		}
+		if string(b.Bytes()) != want {
+			t.Errorf("Expected %q, got %q", want, b.Bytes())
+		}
+		b.Release()
+		b.Release()
+		if b.Bytes() != nil {
+			t.Errorf("Expected no data after Release, got %q", b.Bytes())
+		}
+	}
+}
+
+func BenchmarkPopPooled(b *testing.B) {
+	p, c := benchQueues(b)
+	msg := make([]byte, 32)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Push(msg)
+		buf, err := c.PopPooled(128)
+		if err != nil {
+			b.Fatalf("PopPooled failed: %v", err)
+		}
+		buf.Release()
+	}
+}
@@ -0,0 +1,121 @@
+package nabd
+
+import "syscall"
+
+// EvictionPolicy selects what PushPrio does when a band is full
+type EvictionPolicy int
+
+const (
+	// RejectFull makes PushPrio return ErrFull when the band it targets is
+	// full. Other bands are unaffected. This is the default.
+	RejectFull EvictionPolicy = iota
+
+	// EvictLow lets every band but the highest overwrite its oldest
+	// message when full, so bulk traffic never blocks the producer while
+	// the top band still never loses a message. The lower bands are
+	// Broadcast rings, so they must have a single consumer.
+	EvictLow
+)
+
+// PriorityQueue is a set of rings, one per priority band, that pops the
+// highest non-empty band first. Order is FIFO within a band.
+//
+// Band k lives in its own queue named name + "." + k, so bands fill up
+// independently.
+type PriorityQueue struct {
+	name   string
+	bands  []*Queue
+	policy EvictionPolicy
+}
+
+// OpenPriority opens or creates a priority queue with the given number of
+// bands, between 1 and 256. opts apply to every band; capacity is per
+// band. The eviction policy is fixed when the bands are created, and
+// attaching handles follow it whatever they pass.
+func OpenPriority(name string, bands int, policy EvictionPolicy, opts ...Option) (*PriorityQueue, error) {
+	if bands < 1 || bands > 256 {
+		return nil, &QueueError{Op: "open", Name: name, Errno: syscall.EINVAL}
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	pq := &PriorityQueue{name: name, policy: policy}
+	for k := 0; k < bands; k++ {
+		bandOpts := opts
+		if policy == EvictLow && k < bands-1 && o.flags&Create != 0 {
+			bandOpts = append(opts[:len(opts):len(opts)], WithFlags(o.flags|Broadcast))
+		}
+		q, err := OpenWithOptions(memberName(name, k), bandOpts...)
+		if err != nil {
+			pq.Close()
+			return nil, err
+		}
+		pq.bands = append(pq.bands, q)
+	}
+	return pq, nil
+}
+
+// UnlinkPriority removes every band of a priority queue
+func UnlinkPriority(name string, bands int) error {
+	return unlinkMembers(name, bands)
+}
+
+// Bands returns the number of priority bands
+func (pq *PriorityQueue) Bands() int {
+	return len(pq.bands)
+}
+
+// PushPrio pushes data into band prio; higher values are popped first.
+// A prio outside the queue's bands fails with EINVAL. Under RejectFull,
+// ErrFull means band prio is full.
+func (pq *PriorityQueue) PushPrio(data []byte, prio uint8) error {
+	if int(prio) >= len(pq.bands) {
+		return &QueueError{Op: "push", Name: pq.name, Errno: syscall.EINVAL}
+	}
+	return pq.bands[prio].Push(data)
+}
+
+// PopPrio pops the oldest message of the highest non-empty band and
+// returns it with its priority. ErrEmpty means every band was empty.
+//
+// Under EvictLow, messages overwritten before they were read are skipped;
+// a lapped band resumes at its oldest message known to be intact.
+func (pq *PriorityQueue) PopPrio(maxLen int) ([]byte, uint8, error) {
+	for k := len(pq.bands) - 1; k >= 0; k-- {
+		msg, err := pq.bands[k].Pop(maxLen)
+		if err == ErrLapped {
+			msg, err = pq.bands[k].Pop(maxLen)
+		}
+		if err == ErrEmpty {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return msg, uint8(k), nil
+	}
+	return nil, 0, ErrEmpty
+}
+
+// Len returns the number of messages waiting across all bands
+func (pq *PriorityQueue) Len() (int, error) {
+	total := 0
+	for _, q := range pq.bands {
+		n, err := q.Len()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Close closes every band
+func (pq *PriorityQueue) Close() {
+	for _, q := range pq.bands {
+		q.Close()
+	}
+}
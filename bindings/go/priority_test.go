@@ -0,0 +1,97 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+const testPrioQueue = "/nabd_go_prio_test"
+
+func TestPriorityOrder(t *testing.T) {
+	UnlinkPriority(testPrioQueue, 3)
+	defer UnlinkPriority(testPrioQueue, 3)
+
+	pq, err := OpenPriority(testPrioQueue, 3, RejectFull,
+		WithCapacity(2), WithSlotSize(64), WithFlags(Create|Producer|Consumer))
+	if err != nil {
+		t.Fatalf("OpenPriority failed: %v", err)
+	}
+	defer pq.Close()
+
+	pq.PushPrio([]byte("bulk1"), 0)
+	pq.PushPrio([]byte("bulk2"), 0)
+	pq.PushPrio([]byte("ctl1"), 2)
+	pq.PushPrio([]byte("mid"), 1)
+	pq.PushPrio([]byte("ctl2"), 2)
+
+	// Each band fills up on its own
+	if err := pq.PushPrio([]byte("bulk3"), 0); err != ErrFull {
+		t.Errorf("Expected ErrFull for the full band, got %v", err)
+	}
+	err = pq.PushPrio([]byte("x"), 3)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for a missing band, got %v", err)
+	}
+	var qe *QueueError
+	if errors.As(err, &qe) && qe.Name != testPrioQueue {
+		t.Errorf("Expected the error to name %s, got %s", testPrioQueue, qe.Name)
+	}
+	if n, _ := pq.Len(); n != 5 {
+		t.Errorf("Expected 5 messages, got %d", n)
+	}
+
+	want := []struct {
+		msg  string
+		prio uint8
+	}{{"ctl1", 2}, {"ctl2", 2}, {"mid", 1}, {"bulk1", 0}, {"bulk2", 0}}
+	for _, w := range want {
+		msg, prio, err := pq.PopPrio(64)
+		if err != nil {
+			t.Fatalf("PopPrio failed: %v", err)
+		}
+		if string(msg) != w.msg || prio != w.prio {
+			t.Errorf("Expected %s at %d, got %s at %d", w.msg, w.prio, msg, prio)
+		}
+	}
+	if _, _, err := pq.PopPrio(64); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestPriorityEvictLow(t *testing.T) {
+	UnlinkPriority(testPrioQueue, 2)
+	defer UnlinkPriority(testPrioQueue, 2)
+
+	pq, err := OpenPriority(testPrioQueue, 2, EvictLow,
+		WithCapacity(2), WithSlotSize(64), WithFlags(Create|Producer|Consumer))
+	if err != nil {
+		t.Fatalf("OpenPriority failed: %v", err)
+	}
+	defer pq.Close()
+
+	for _, m := range []string{"old1", "old2", "new1", "new2"} {
+		if err := pq.PushPrio([]byte(m), 0); err != nil {
+			t.Fatalf("Expected low band to evict, got %v", err)
+		}
+	}
+	pq.PushPrio([]byte("ctl1"), 1)
+	pq.PushPrio([]byte("ctl2"), 1)
+	if err := pq.PushPrio([]byte("ctl3"), 1); err != ErrFull {
+		t.Errorf("Expected ErrFull for the top band, got %v", err)
+	}
+
+	// The lapped low band resumes at its newest intact message
+	for _, want := range []string{"ctl1", "ctl2", "new2"} {
+		msg, _, err := pq.PopPrio(64)
+		if err != nil {
+			t.Fatalf("PopPrio failed: %v", err)
+		}
+		if string(msg) != want {
+			t.Errorf("Expected %s, got %s", want, msg)
+		}
+	}
+	if _, _, err := pq.PopPrio(64); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
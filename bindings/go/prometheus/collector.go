@@ -0,0 +1,91 @@
+// Package prometheus exports NABD queue metrics to Prometheus.
+//
+// It lives in its own module so the core binding stays free of the
+// Prometheus dependency.
+package prometheus
+
+import (
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector reads the shared header of one queue on every scrape
+type collector struct {
+	q *nabd.Queue
+
+	depth       *prometheus.Desc
+	capacity    *prometheus.Desc
+	highWater   *prometheus.Desc
+	pushes      *prometheus.Desc
+	pops        *prometheus.Desc
+	fullEvents  *prometheus.Desc
+	emptyEvents *prometheus.Desc
+	bytesPushed *prometheus.Desc
+	bytesPopped *prometheus.Desc
+}
+
+// NewCollector returns a prometheus.Collector for q. labels are attached
+// to every series as constant labels, e.g. {"queue": "orders"}.
+//
+// Values are read live from the queue's shared header on each scrape, so
+// they include every attached process, not only the caller. A closed
+// queue yields no samples.
+func NewCollector(q *nabd.Queue, labels prometheus.Labels) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("nabd_queue_"+name, help, nil, labels)
+	}
+	return &collector{
+		q:           q,
+		depth:       desc("depth", "Messages currently buffered."),
+		capacity:    desc("capacity", "Number of slots in the ring."),
+		highWater:   desc("high_water", "Maximum depth observed since the last reset."),
+		pushes:      desc("pushes_total", "Messages pushed."),
+		pops:        desc("tail", "Read position of the ring, the messages consumed including drained ones. Seeks move it back, so it is a gauge."),
+		fullEvents:  desc("full_events_total", "Pushes rejected because the ring was full."),
+		emptyEvents: desc("empty_events_total", "Pops that found the ring empty."),
+		bytesPushed: desc("pushed_bytes_total", "Payload bytes pushed."),
+		bytesPopped: desc("popped_bytes_total", "Payload bytes popped."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.capacity
+	ch <- c.highWater
+	ch <- c.pushes
+	ch <- c.pops
+	ch <- c.fullEvents
+	ch <- c.emptyEvents
+	ch <- c.bytesPushed
+	ch <- c.bytesPopped
+}
+
+// Collect implements prometheus.Collector
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	depth, err := c.q.Len()
+	if err != nil {
+		return
+	}
+	stats, err := c.q.Stats()
+	if err != nil {
+		return
+	}
+
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v))
+	}
+
+	gauge(c.depth, float64(depth))
+	gauge(c.capacity, float64(c.q.Cap()))
+	gauge(c.highWater, float64(c.q.HighWaterMark()))
+	gauge(c.pops, float64(stats.Pops))
+	counter(c.pushes, stats.Pushes)
+	counter(c.fullEvents, stats.FullEvents)
+	counter(c.emptyEvents, stats.EmptyEvents)
+	counter(c.bytesPushed, stats.BytesPushed)
+	counter(c.bytesPopped, stats.BytesPopped)
+}
@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	nabd "github.com/YASSERRMD/nabd/bindings/go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testQueue = "/nabd_go_prom_test"
+
+func TestCollector(t *testing.T) {
+	nabd.Unlink(testQueue)
+	defer nabd.Unlink(testQueue)
+
+	q, err := nabd.Open(testQueue, 4, 64, nabd.Create|nabd.Producer|nabd.Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		q.Push([]byte("abc"))
+	}
+	q.Pop(64)
+
+	c := NewCollector(q, prometheus.Labels{"queue": "orders"})
+	want := `
+# HELP nabd_queue_depth Messages currently buffered.
+# TYPE nabd_queue_depth gauge
+nabd_queue_depth{queue="orders"} 3
+# HELP nabd_queue_full_events_total Pushes rejected because the ring was full.
+# TYPE nabd_queue_full_events_total counter
+nabd_queue_full_events_total{queue="orders"} 1
+# HELP nabd_queue_pushes_total Messages pushed.
+# TYPE nabd_queue_pushes_total counter
+nabd_queue_pushes_total{queue="orders"} 4
+# HELP nabd_queue_tail Read position of the ring, the messages consumed including drained ones. Seeks move it back, so it is a gauge.
+# TYPE nabd_queue_tail gauge
+nabd_queue_tail{queue="orders"} 1
+# HELP nabd_queue_pushed_bytes_total Payload bytes pushed.
+# TYPE nabd_queue_pushed_bytes_total counter
+nabd_queue_pushed_bytes_total{queue="orders"} 12
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(want),
+		"nabd_queue_depth", "nabd_queue_full_events_total",
+		"nabd_queue_pushes_total", "nabd_queue_tail", "nabd_queue_pushed_bytes_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c); n != 9 {
+		t.Errorf("Expected 9 series, got %d", n)
+	}
+}
@@ -0,0 +1,133 @@
+package nabd
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by pushes that exceed the handle's rate limit
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitPolicy selects what a push over the rate limit does
+type RateLimitPolicy int
+
+const (
+	// RejectOverLimit fails the push with ErrRateLimited. This is the
+	// default.
+	RejectOverLimit RateLimitPolicy = iota
+
+	// WaitOverLimit blocks the push until a token is available or the
+	// queue is closed
+	WaitOverLimit
+)
+
+// limiter is a token bucket shared by a handle and its clones
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second, 0 for no limit
+	burst  float64 // bucket size
+	tokens float64
+	last   time.Time
+	policy RateLimitPolicy
+}
+
+// set changes the limit, keeping the tokens already earned up to the new
+// burst. A non-positive rps removes the limit.
+func (l *limiter) set(rps, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	unlimited := l.rate == 0
+	l.rate = float64(max(rps, 0))
+	l.burst = float64(max(burst, 1))
+	if unlimited || l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// refill adds the tokens earned since the last call
+func (l *limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	}
+	l.last = now
+}
+
+// take grants up to n tokens. When none are available it returns how
+// long until the next one is.
+func (l *limiter) take(n int) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return n, 0
+	}
+	l.refill(time.Now())
+	got := min(n, int(l.tokens))
+	if got == 0 && n > 0 {
+		return 0, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens -= float64(got)
+	return got, 0
+}
+
+// refund returns n tokens granted for pushes that did not happen
+func (l *limiter) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+float64(n), l.burst)
+	}
+}
+
+// SetRateLimit limits pushes through this handle, and its clones, to rps
+// messages per second with bursts of up to burst messages. A non-positive
+// rps removes the limit; burst is raised to at least 1. It may be called
+// at any time, also concurrently with pushes.
+func (q *Queue) SetRateLimit(rps, burst int) {
+	q.limit.set(rps, burst)
+}
+
+// SetRateLimitPolicy selects what a push over the rate limit does
+func (q *Queue) SetRateLimitPolicy(p RateLimitPolicy) {
+	q.limit.mu.Lock()
+	q.limit.policy = p
+	q.limit.mu.Unlock()
+}
+
+// admit takes up to n tokens for a push, waiting for the first one under
+// WaitOverLimit. It returns how many messages may be pushed.
+func (q *Queue) admit(n int) (int, error) {
+	var timer *time.Timer
+	for {
+		got, wait := q.limit.take(n)
+		if got > 0 || n == 0 {
+			return got, nil
+		}
+
+		q.limit.mu.Lock()
+		policy := q.limit.policy
+		q.limit.mu.Unlock()
+		if policy != WaitOverLimit {
+			return 0, ErrRateLimited
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(wait)
+			defer timer.Stop()
+		} else {
+			timer.Reset(wait)
+		}
+		select {
+		case <-q.done:
+			return 0, ErrClosed
+		case <-timer.C:
+		}
+	}
+}
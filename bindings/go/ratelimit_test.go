@@ -0,0 +1,101 @@
+package nabd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(2),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithRateLimit(1, 3))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := q.Push([]byte("x")); err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+	}
+
+	// A push rejected for a full ring keeps its token
+	if err := q.Push([]byte("x")); err != ErrFull {
+		t.Fatalf("Expected ErrFull, got %v", err)
+	}
+	q.Pop(64)
+	if err := q.Push([]byte("x")); err != nil {
+		t.Fatalf("Push with the refunded token failed: %v", err)
+	}
+	q.Pop(64)
+	if err := q.Push([]byte("x")); err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	q.Drain()
+
+	// Raising the limit at runtime takes effect at once
+	q.SetRateLimit(1000, 2)
+	time.Sleep(5 * time.Millisecond)
+	if n, err := q.PushBatch([][]byte{[]byte("a"), []byte("b")}); n != 2 || err != nil {
+		t.Fatalf("PushBatch returned %d, %v, want 2, nil", n, err)
+	}
+	q.Drain()
+
+	// The burst caps how much of a batch goes through
+	q.SetRateLimit(1000, 1)
+	time.Sleep(5 * time.Millisecond)
+	if n, err := q.PushBatch([][]byte{[]byte("a"), []byte("b")}); n != 1 || err != ErrRateLimited {
+		t.Fatalf("PushBatch returned %d, %v, want 1, ErrRateLimited", n, err)
+	}
+	q.Drain()
+
+	q.SetRateLimit(0, 0)
+	for i := 0; i < 4; i++ {
+		if err := q.Push([]byte("x")); err != nil {
+			t.Fatalf("Push without a limit failed: %v", err)
+		}
+		q.Pop(64)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithRateLimit(200, 1),
+		WithRateLimitPolicy(WaitOverLimit))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := q.Push([]byte("x")); err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("Expected 5 pushes at 200/s to take about 20ms, took %v", d)
+	}
+
+	// Close wakes a push waiting for a token; the bucket is empty
+	q.SetRateLimit(1, 1)
+	done := make(chan error, 1)
+	go func() { done <- q.Push([]byte("x")) }()
+	time.Sleep(5 * time.Millisecond)
+	q.Close()
+	if err := <-done; err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"syscall"
+)
+
+// Resize changes the number of slots to newCapacity, rounded up to a
+// power of 2, keeping every pending message in order. Growing always
+// works; shrinking needs an empty queue, except on Broadcast queues,
+// which keep the newest messages that fit.
+//
+// The queue must be quiesced: no other process may push or pop while
+// Resize runs. Afterwards every other handle gets ErrResized from its
+// next operation until it calls Remap. Resize fails with EINVAL while
+// this handle holds a Reserve or PopZeroCopy slot, and on queues that
+// use PopNoAck, whose in-flight table is sized for the old capacity.
+// macOS cannot resize shared memory, so growing fails there with EINVAL.
+func (q *Queue) Resize(newCapacity int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ptr == nil {
+		return ErrClosed
+	}
+	if newCapacity <= 0 || q.resv != nil || q.held || q.ack != nil {
+		return &QueueError{Op: "resize", Name: q.name, Errno: syscall.EINVAL}
+	}
+	if ret, errno := C.nabd_resize(q.ptr, C.size_t(newCapacity)); ret != C.NABD_OK {
+		return failure("resize", q.name, ret, errno)
+	}
+	return nil
+}
+
+// Remap maps the queue again after another handle resized it, so calls
+// that returned ErrResized work again. It is a no-op when nothing
+// changed.
+func (q *Queue) Remap() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ptr == nil {
+		return ErrClosed
+	}
+	if q.resv != nil || q.held {
+		return &QueueError{Op: "remap", Name: q.name, Errno: syscall.EINVAL}
+	}
+	if ret, errno := C.nabd_remap(q.ptr); ret != C.NABD_OK {
+		return failure("remap", q.name, ret, errno)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package nabd
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("macOS cannot resize shared memory")
+	}
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 4, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := p.Push([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+	}
+
+	if err := p.Resize(2); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL shrinking a non-empty queue, got %v", err)
+	}
+	if err := p.Resize(16); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	for i := 4; i < 16; i++ {
+		if err := p.Push([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Push %d after grow failed: %v", i, err)
+		}
+	}
+
+	// The consumer keeps failing until it remaps
+	if _, err := c.Pop(64); err != ErrResized {
+		t.Fatalf("Expected ErrResized, got %v", err)
+	}
+	if err := c.Remap(); err != nil {
+		t.Fatalf("Remap failed: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		msg, err := c.Pop(64)
+		if err != nil || string(msg) != strconv.Itoa(i) {
+			t.Fatalf("Expected %d, got %q, %v", i, msg, err)
+		}
+	}
+
+	if err := c.Resize(8); err != nil {
+		t.Fatalf("Shrinking an empty queue failed: %v", err)
+	}
+	if err := p.Push([]byte("x")); err != ErrResized {
+		t.Errorf("Expected ErrResized, got %v", err)
+	}
+	if err := p.Remap(); err != nil {
+		t.Fatalf("Remap failed: %v", err)
+	}
+	if n := p.Cap(); n != 8 {
+		t.Errorf("Expected capacity 8, got %d", n)
+	}
+}
@@ -0,0 +1,111 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"syscall"
+	"unsafe"
+)
+
+// SeekSeq moves the read position so the next pop returns the message
+// stamped with sequence number seq, replaying messages that were already
+// consumed but are still physically in the ring. It needs a queue opened
+// WithSequence. Seeking to the sequence number the producer will stamp
+// next skips to the end.
+//
+// Replay reaches back Cap()-1 messages before the newest one pushed, no
+// matter how many are still unconsumed: older slots may be in the middle
+// of being overwritten. Seeking further back returns ErrLapped, and so may
+// a seek that the producer keeps outpacing; seq numbers not pushed yet
+// fail with EINVAL, as do Broadcast and overwrite queues.
+//
+// The read position is shared, so this affects every consumer of the
+// queue, and the replayed messages take their slots back from the
+// producer until consumed again.
+func (q *Queue) SeekSeq(seq uint64) error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	mode := C.nabd_mode(h)
+	if !q.env.sequence || mode&(C.NABD_BROADCAST|C.NABD_OVERWRITE) != 0 {
+		return &QueueError{Op: "seek", Name: q.name, Errno: syscall.EINVAL}
+	}
+
+	// Open up the whole replay window, then look for seq in it. Once the
+	// tail is back there the producer cannot reuse those slots, but a
+	// push racing the rewind fails it with ErrLapped, so it is retried
+	// from the new head a few times.
+	var stats C.nabd_stats_t
+	var head, oldest uint64
+	for try := 0; ; try++ {
+		if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+			return failure("seek", q.name, ret, nil)
+		}
+		head = uint64(stats.head)
+		if next := uint64(C.nabd_seq(h)); seq == next {
+			return q.seek(h, head)
+		} else if seq > next {
+			return &QueueError{Op: "seek", Name: q.name, Errno: syscall.EINVAL}
+		}
+
+		// A full ring's tail is older still, and its slot intact
+		oldest = 0
+		if head >= uint64(stats.capacity) {
+			oldest = min(head-uint64(stats.capacity)+1, uint64(stats.tail))
+		}
+		err := q.seek(h, oldest)
+		if err == nil {
+			break
+		} else if err != ErrLapped || try == 3 {
+			return err
+		}
+	}
+
+	// Numbers are consecutive when every push was stamped; try the
+	// computed position before scanning
+	first, ok := q.seqAt(h, oldest)
+	if ok && seq < first {
+		q.seek(h, uint64(stats.tail))
+		return ErrLapped
+	}
+	if ok && seq-first < head-oldest {
+		if s, ok := q.seqAt(h, oldest+seq-first); ok && s == seq {
+			return q.seek(h, oldest+seq-first)
+		}
+	}
+	for pos := oldest; pos < head; pos++ {
+		if s, ok := q.seqAt(h, pos); ok && s == seq {
+			return q.seek(h, pos)
+		}
+	}
+
+	q.seek(h, uint64(stats.tail))
+	return ErrLapped
+}
+
+// seek moves the read position to pos
+func (q *Queue) seek(h *C.nabd_t, pos uint64) error {
+	ret := C.nabd_seek(h, C.uint64_t(pos))
+	if ret == C.NABD_OK {
+		return nil
+	} else if ret == C.NABD_LAPPED {
+		return ErrLapped
+	}
+	return failure("seek", q.name, ret, nil)
+}
+
+// seqAt returns the sequence number stamped on the message at pos
+func (q *Queue) seqAt(h *C.nabd_t, pos uint64) (uint64, bool) {
+	var data unsafe.Pointer
+	var size C.size_t
+	if C.nabd_peek_at(h, C.uint64_t(pos), &data, &size) != C.NABD_OK {
+		return 0, false
+	}
+	_, st, err := q.env.open(C.GoBytes(data, C.int(size)), -1)
+	return st.seq, err == nil
+}
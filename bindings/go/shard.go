@@ -0,0 +1,122 @@
+package nabd
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+	"syscall"
+)
+
+// ShardSet spreads one logical stream over several queues. Push routes
+// each message by a hash of its key, so messages with equal keys land on
+// the same shard and keep their order; consumers bind to one shard each
+// and work in parallel.
+//
+// Shard k lives in its own queue named name + "." + k. Each shard is an
+// ordinary ring, so pushing to one shard from several goroutines needs
+// WithConcurrentProducers among the options.
+type ShardSet struct {
+	shards []*Queue
+	hash   func(key []byte) uint64
+	next   atomic.Uint64 // next shard for PushRR
+}
+
+// OpenShards opens or creates a set of n shards, n at least 1. opts apply
+// to every shard; capacity is per shard. Keys are hashed with 64-bit
+// FNV-1a until SetHash says otherwise.
+func OpenShards(name string, n int, opts ...Option) (*ShardSet, error) {
+	if n < 1 {
+		return nil, &QueueError{Op: "open", Name: name, Errno: syscall.EINVAL}
+	}
+
+	s := &ShardSet{hash: fnvHash}
+	for k := 0; k < n; k++ {
+		q, err := OpenWithOptions(memberName(name, k), opts...)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, q)
+	}
+	return s, nil
+}
+
+// UnlinkShards removes every shard of a set
+func UnlinkShards(name string, n int) error {
+	return unlinkMembers(name, n)
+}
+
+// fnvHash is the default key hash
+func fnvHash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// SetHash replaces the function that maps keys to shards. Every producer
+// of the set must use the same one, and it must be called before the
+// first Push.
+func (s *ShardSet) SetHash(hash func(key []byte) uint64) {
+	s.hash = hash
+}
+
+// Shards returns the number of shards
+func (s *ShardSet) Shards() int {
+	return len(s.shards)
+}
+
+// Shard returns shard k, for a consumer that reads only that shard. It
+// returns nil when k is out of range. The Queue belongs to the set; close
+// the set, not the shard.
+func (s *ShardSet) Shard(k int) *Queue {
+	if k < 0 || k >= len(s.shards) {
+		return nil
+	}
+	return s.shards[k]
+}
+
+// ShardFor returns the shard Push routes key to
+func (s *ShardSet) ShardFor(key []byte) int {
+	return int(s.hash(key) % uint64(len(s.shards)))
+}
+
+// Push pushes data to the shard key hashes to. ErrFull means that shard
+// is full; the message is not moved to another one, since that would
+// break the order of its key.
+func (s *ShardSet) Push(key, data []byte) error {
+	return s.shards[s.ShardFor(key)].Push(data)
+}
+
+// PushRR pushes data to the shards in turn, for messages whose order does
+// not matter. A full shard is skipped; ErrFull means every shard was
+// full.
+func (s *ShardSet) PushRR(data []byte) error {
+	n := uint64(len(s.shards))
+	start := s.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		err := s.shards[(start+i)%n].Push(data)
+		if err != ErrFull {
+			return err
+		}
+	}
+	return ErrFull
+}
+
+// Stats returns the statistics of every shard, indexed by shard
+func (s *ShardSet) Stats() ([]Stats, error) {
+	all := make([]Stats, len(s.shards))
+	for k, q := range s.shards {
+		st, err := q.Stats()
+		if err != nil {
+			return nil, err
+		}
+		all[k] = st
+	}
+	return all, nil
+}
+
+// Close closes every shard
+func (s *ShardSet) Close() {
+	for _, q := range s.shards {
+		q.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package nabd
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+const testShardQueue = "/nabd_go_shard_test"
+
+func TestShardSet(t *testing.T) {
+	UnlinkShards(testShardQueue, 4)
+	defer UnlinkShards(testShardQueue, 4)
+
+	if _, err := OpenShards(testShardQueue, 0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for no shards, got %v", err)
+	}
+	s, err := OpenShards(testShardQueue, 4,
+		WithCapacity(16), WithSlotSize(64), WithFlags(Create|Producer|Consumer))
+	if err != nil {
+		t.Fatalf("OpenShards failed: %v", err)
+	}
+	defer s.Close()
+	if s.Shards() != 4 || s.Shard(4) != nil {
+		t.Fatalf("Expected 4 shards, got %d", s.Shards())
+	}
+
+	// Every message of a key lands on one shard, in order
+	keys := []string{"alice", "bob", "carol", "dave", "erin"}
+	for i := 0; i < 3; i++ {
+		for _, key := range keys {
+			if err := s.Push([]byte(key), []byte(key+strconv.Itoa(i))); err != nil {
+				t.Fatalf("Push failed: %v", err)
+			}
+		}
+	}
+	next := make(map[string]int)
+	for k := 0; k < s.Shards(); k++ {
+		for {
+			msg, err := s.Shard(k).Pop(64)
+			if err == ErrEmpty {
+				break
+			} else if err != nil {
+				t.Fatalf("Pop failed: %v", err)
+			}
+			key := string(msg[:len(msg)-1])
+			if s.ShardFor([]byte(key)) != k {
+				t.Errorf("Key %s found on shard %d, expected %d", key, k, s.ShardFor([]byte(key)))
+			}
+			if want := key + strconv.Itoa(next[key]); string(msg) != want {
+				t.Errorf("Expected %s, got %s", want, msg)
+			}
+			next[key]++
+		}
+	}
+	if len(next) != len(keys) {
+		t.Errorf("Expected %d keys, got %v", len(keys), next)
+	}
+
+	// Round-robin takes each shard in turn
+	for i := 0; i < 4; i++ {
+		if err := s.PushRR([]byte("rr")); err != nil {
+			t.Fatalf("PushRR failed: %v", err)
+		}
+	}
+	stats, err := s.Stats()
+	if err != nil || len(stats) != 4 {
+		t.Fatalf("Stats failed: %v, %v", stats, err)
+	}
+	for k := range stats {
+		if n, _ := s.Shard(k).Len(); n != 1 {
+			t.Errorf("Expected 1 message on shard %d, got %d", k, n)
+		}
+	}
+
+	s.SetHash(func(key []byte) uint64 { return uint64(key[0]) })
+	if k := s.ShardFor([]byte{6}); k != 2 {
+		t.Errorf("Expected the custom hash to pick shard 2, got %d", k)
+	}
+}
@@ -0,0 +1,11 @@
+package nabd
+
+// sideDir holds the files the binding keeps next to a queue, such as its
+// ack and dedup tables. macOS has no /dev/shm, so they share /tmp with the
+// notification FIFOs.
+const sideDir = "/tmp"
+
+// MaxNameLen is the longest name ValidName accepts, counting the leading
+// slash. macOS limits shared-memory names to 31 bytes, and the library
+// appends ".ref" for the attach table.
+const MaxNameLen = 31 - len(".ref")
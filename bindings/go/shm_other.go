@@ -0,0 +1,12 @@
+//go:build !darwin
+
+package nabd
+
+// sideDir holds the files the binding keeps next to a queue, such as its
+// ack and dedup tables
+const sideDir = shmDir
+
+// MaxNameLen is the longest name ValidName accepts, counting the leading
+// slash. It leaves room under the system's 255-byte file name limit for the
+// longest suffix the library appends for its side files (".notify").
+const MaxNameLen = 1 + 255 - len(".notify")
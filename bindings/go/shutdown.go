@@ -0,0 +1,95 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// ErrShuttingDown is returned by pushes on a handle once Shutdown has begun
+var ErrShuttingDown = errors.New("queue shutting down")
+
+// ShutdownError is returned by Shutdown when ctx ended before consumers
+// emptied the ring. It unwraps to the context error.
+type ShutdownError struct {
+	Name      string // Queue name
+	Remaining int    // Messages still buffered when Shutdown gave up
+	Err       error  // ctx.Err()
+}
+
+func (e *ShutdownError) Error() string {
+	return "nabd shutdown " + e.Name + ": " + strconv.Itoa(e.Remaining) +
+		" messages undrained: " + e.Err.Error()
+}
+
+// Unwrap returns the context error
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown stops the producer gracefully: it rejects further pushes on
+// this handle with ErrShuttingDown, sets the draining flag consumers see
+// through Draining, waits for them to empty the ring and then closes the
+// handle
+//
+// Pushes already in progress complete first. If ctx ends before the ring
+// is empty, the handle is closed anyway and a *ShutdownError reports how
+// many messages were left; they stay in the queue for a later consumer.
+// Broadcast consumers keep private positions, so on a broadcast queue
+// Shutdown cannot tell when they are done and closes without waiting.
+// The flag stays set until a handle is opened with Producer again.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	h, err := q.acquire()
+	if err != nil {
+		return err
+	}
+	q.shutting.Store(true)
+	C.nabd_set_draining(h, 1)
+	broadcast := C.nabd_mode(h)&C.NABD_BROADCAST != 0
+	q.mu.RUnlock()
+	defer q.Close()
+
+	// Pushes that got past acquirePush hold q.mu until they return
+	q.mu.Lock()
+	q.mu.Unlock()
+
+	if broadcast {
+		return nil
+	}
+
+	w := newWaiter(ctx, -1, q.wait)
+	for {
+		h, err := q.acquire()
+		if err != nil {
+			return err
+		}
+		left := int(C.nabd_lag(h))
+		q.mu.RUnlock()
+		if left == 0 {
+			return nil
+		}
+
+		if err := w.wait(q.done); err == ErrClosed {
+			return err
+		} else if err != nil {
+			return &ShutdownError{Name: q.name, Remaining: left, Err: err}
+		}
+	}
+}
+
+// Draining reports whether a producer has called Shutdown and no producer
+// has attached since. Consumers can use it to finish the backlog and exit
+// once the queue is empty.
+func (q *Queue) Draining() (bool, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer q.mu.RUnlock()
+
+	return C.nabd_draining(h) == 1, nil
+}
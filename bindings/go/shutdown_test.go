@@ -0,0 +1,111 @@
+package nabd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	p.Push([]byte("one"))
+	p.Push([]byte("two"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Shutdown(context.Background())
+	}()
+
+	for {
+		if draining, err := c.Draining(); err != nil {
+			t.Fatalf("Draining failed: %v", err)
+		} else if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Push([]byte("three")); err != ErrShuttingDown {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the ring drained: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Pop(64); err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := p.Push([]byte("four")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after Shutdown, got %v", err)
+	}
+
+	// A producer attaching again clears the flag
+	p2, err := Open(TestQueue, 0, 0, Producer)
+	if err != nil {
+		t.Fatalf("Producer reopen failed: %v", err)
+	}
+	defer p2.Close()
+	if draining, _ := c.Draining(); draining {
+		t.Error("Expected the draining flag to be cleared")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	p.Push([]byte("one"))
+	p.Push([]byte("two"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = p.Shutdown(ctx)
+	var se *ShutdownError
+	if !errors.As(err, &se) || se.Remaining != 2 {
+		t.Fatalf("Expected a ShutdownError with 2 remaining, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the error to wrap DeadlineExceeded, got %v", err)
+	}
+	if err := p.Push([]byte("three")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after Shutdown, got %v", err)
+	}
+
+	// The messages are still there for the next consumer
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+	if out, err := c.Pop(64); err != nil || string(out) != "one" {
+		t.Errorf("Expected one, got %q, %v", out, err)
+	}
+}
@@ -0,0 +1,118 @@
+package nabd
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// openSidecar opens path, a file the binding keeps next to queue name,
+// creating it if needed. It returns holding an exclusive flock on the
+// file, so that of several handles opening it at once only one sees it
+// fresh, that is empty, and sizes and fills it in; the others wait in
+// openSidecar until closeSidecar releases the lock and then find it
+// complete. A fresh file gets the permissions and group of the queue's
+// segment, as the library's attach table does, so every process that may
+// open the queue may open its sidecars too. Where the segment is not a
+// file under shmDir it keeps the creator's umask and group.
+func openSidecar(name, path string) (f *os.File, fresh bool, err error) {
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		closeSidecar(f)
+		return nil, false, err
+	}
+	if fi.Size() != 0 {
+		return f, false, nil
+	}
+
+	if seg, err := os.Stat(shmDir + name); err == nil {
+		f.Chmod(seg.Mode().Perm())
+		if st, ok := seg.Sys().(*syscall.Stat_t); ok {
+			f.Chown(-1, int(st.Gid))
+		}
+	}
+	return f, true, nil
+}
+
+// closeSidecar releases the lock openSidecar took and closes f. The lock
+// is released explicitly, since a mapping of the file would otherwise keep
+// it held.
+func closeSidecar(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// table is a sidecar file mapped shared into this process. Every process
+// attached to the queue reads and writes it as 64-bit words, with atomic
+// operations so none ever sees a word half written.
+type table []byte
+
+// openTable maps the sidecar file path of queue name, creating it if
+// needed. A fresh file is sized to size, filled in by init and then given
+// magic as its first word; an existing one is mapped at its own size and
+// must start with magic, or openTable fails with EINVAL for op. Callers
+// check the rest of the header against what they expect.
+func openTable(op, name, path string, magic uint64, size int64, init func(table)) (table, error) {
+	f, fresh, err := openSidecar(name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSidecar(f)
+
+	if fresh {
+		if err := f.Truncate(size); err != nil {
+			return nil, err
+		}
+	} else {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if size = fi.Size(); size < 8 {
+			return nil, &QueueError{Op: op, Name: name, Errno: syscall.EINVAL}
+		}
+	}
+
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	t := table(mem)
+	if fresh {
+		init(t)
+		t.store(0, magic)
+	} else if t.load(0) != magic {
+		t.close()
+		return nil, &QueueError{Op: op, Name: name, Errno: syscall.EINVAL}
+	}
+	return t, nil
+}
+
+// word returns the 64-bit word at byte offset off, which must be aligned
+func (t table) word(off uint64) *uint64 {
+	return (*uint64)(unsafe.Pointer(&t[off]))
+}
+
+// load reads the word at off
+func (t table) load(off uint64) uint64 {
+	return atomic.LoadUint64(t.word(off))
+}
+
+// store writes v to the word at off
+func (t table) store(off, v uint64) {
+	atomic.StoreUint64(t.word(off), v)
+}
+
+// close unmaps the table
+func (t table) close() {
+	syscall.Munmap(t)
+}
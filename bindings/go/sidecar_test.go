@@ -0,0 +1,53 @@
+package nabd
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestOpenSidecarConcurrent(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// Handles racing to create a table all end up sharing one
+	for round := 0; round < 20; round++ {
+		Unlink(TestQueue)
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := openDedup(TestQueue, 16)
+				if err == nil {
+					d.close()
+				}
+				errs[i] = err
+			}()
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("Round %d, opener %d failed: %v", round, i, err)
+			}
+		}
+	}
+}
+
+func TestOpenTableMagic(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	// A table written by one kind of sidecar is refused as another
+	d, err := openDedup(TestQueue, 16)
+	if err != nil {
+		t.Fatalf("openDedup failed: %v", err)
+	}
+	d.close()
+	_, err = openTable("open ack", TestQueue, dedupPath(TestQueue), ackMagic, 0, nil)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("Expected EINVAL for the wrong magic, got %v", err)
+	}
+}
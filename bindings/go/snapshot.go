@@ -0,0 +1,262 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// SnapshotVersion is the snapshot format written by Snapshot
+//
+// A snapshot is a 64-byte header followed by the messages and a trailer,
+// all big-endian. The header holds the magic "NABDSNAP", the format
+// version (2 bytes), the queue mode (2 bytes), 4 reserved bytes, then
+// capacity, slot size, head, tail, the next sequence number and the
+// message count (8 bytes each). Each message is a 4-byte length and the
+// raw slot payload, envelope included. The trailer is a CRC32C of
+// everything before it.
+const SnapshotVersion = 1
+
+const (
+	snapshotMagic  = "NABDSNAP"
+	snapshotHeader = 64
+)
+
+// snapshot is the queue state captured for Snapshot
+type snapshot struct {
+	mode     uint16
+	capacity uint64
+	slotSize uint64
+	head     uint64
+	tail     uint64
+	nextSeq  uint64
+	msgs     [][]byte
+}
+
+// Snapshot writes the queue's geometry, cursors and every pending message
+// to w, so the queue can be rebuilt after shared memory is gone, for
+// example across a reboot. Messages are written as stored, so envelope
+// fields such as sequence numbers and checksums survive.
+//
+// Producers and consumers may keep running. The snapshot holds what was
+// pending when it started, minus anything consumed while it was being
+// taken. Messages are copied before w is written, so a slow writer does
+// not hold up the queue. Broadcast and overwrite queues are not supported
+// and return EINVAL.
+func (q *Queue) Snapshot(w io.Writer) error {
+	s, err := q.capture()
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.New(castagnoli)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	hdr := make([]byte, snapshotHeader)
+	copy(hdr, snapshotMagic)
+	binary.BigEndian.PutUint16(hdr[8:], SnapshotVersion)
+	binary.BigEndian.PutUint16(hdr[10:], s.mode)
+	binary.BigEndian.PutUint64(hdr[16:], s.capacity)
+	binary.BigEndian.PutUint64(hdr[24:], s.slotSize)
+	binary.BigEndian.PutUint64(hdr[32:], s.head)
+	binary.BigEndian.PutUint64(hdr[40:], s.tail)
+	binary.BigEndian.PutUint64(hdr[48:], s.nextSeq)
+	binary.BigEndian.PutUint64(hdr[56:], uint64(len(s.msgs)))
+	bw.Write(hdr)
+
+	var n [4]byte
+	for _, m := range s.msgs {
+		binary.BigEndian.PutUint32(n[:], uint32(len(m)))
+		bw.Write(n[:])
+		bw.Write(m)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	_, err = w.Write(n[:])
+	return err
+}
+
+// capture copies the pending messages and the state around them
+func (q *Queue) capture() (*snapshot, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if C.nabd_mode(h) != 0 {
+		return nil, &QueueError{Op: "snapshot", Name: q.name, Errno: syscall.EINVAL}
+	}
+
+	var stats C.nabd_stats_t
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return nil, failure("snapshot", q.name, ret, nil)
+	}
+	s := &snapshot{capacity: uint64(stats.capacity), slotSize: uint64(stats.slot_size),
+		head: uint64(stats.head)}
+	start := uint64(stats.tail)
+	maxLen := s.slotSize - C.sizeof_nabd_slot_header_t
+
+	msgs := make([][]byte, 0, s.head-start)
+	for pos := start; pos < s.head; pos++ {
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek_at(h, C.uint64_t(pos), &data, &size)
+		if ret == C.NABD_INVALID || uint64(size) > maxLen {
+			// Consumed meanwhile; dropped below
+			msgs = append(msgs, nil)
+			continue
+		} else if ret != C.NABD_OK {
+			return nil, failure("snapshot", q.name, ret, nil)
+		}
+		msgs = append(msgs, C.GoBytes(data, C.int(size)))
+	}
+	s.nextSeq = uint64(C.nabd_seq(h))
+
+	// Only slots the consumer released during the copy can have been
+	// reused by the producer, so everything from the new tail on is intact
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return nil, failure("snapshot", q.name, ret, nil)
+	}
+	s.tail = min(max(uint64(stats.tail), start), s.head)
+	s.msgs = msgs[s.tail-start:]
+	return s, nil
+}
+
+// Restore creates queue name from a snapshot written by Snapshot and
+// pushes the saved messages back, oldest first. The queue gets the
+// snapshot's capacity and slot size, and the sequence counter continues
+// where it stopped; positions start again from 0. flags are added to
+// Create|Producer, and opts, typically the envelope options the queue
+// was used with, apply to the returned handle.
+//
+// The whole snapshot is read and verified first. A bad magic, checksum or
+// truncated stream returns ErrCorrupt, an unknown format version EPROTO.
+// A queue that already exists is left alone and EEXIST is returned.
+func Restore(name string, r io.Reader, flags Flag, opts ...Option) (*Queue, error) {
+	s, err := readSnapshot(name, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := Exists(name); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, &QueueError{Op: "restore", Name: name, Errno: syscall.EEXIST}
+	}
+
+	opts = append(opts, WithCapacity(int(s.capacity)), WithSlotSize(int(s.slotSize)),
+		WithFlags(flags|Create|Producer))
+	q, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.load(s); err != nil {
+		q.Close()
+		Unlink(name)
+		return nil, err
+	}
+	return q, nil
+}
+
+// load pushes the snapshot's messages as stored, envelope included
+func (q *Queue) load(s *snapshot) error {
+	h, err := q.acquire()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	var zero byte
+	for _, m := range s.msgs {
+		ptr := unsafe.Pointer(&zero)
+		if len(m) > 0 {
+			ptr = unsafe.Pointer(&m[0])
+		}
+		if ret := C.nabd_push(h, ptr, C.size_t(len(m))); ret != C.NABD_OK {
+			return failure("restore", q.name, ret, nil)
+		}
+	}
+	C.nabd_seq_advance(h, C.uint64_t(s.nextSeq))
+	return nil
+}
+
+// readSnapshot parses and verifies a snapshot
+func readSnapshot(name string, r io.Reader) (*snapshot, error) {
+	crc := crc32.New(castagnoli)
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+
+	hdr := make([]byte, snapshotHeader)
+	if _, err := io.ReadFull(tr, hdr); err != nil {
+		return nil, truncated(err)
+	}
+	if string(hdr[:8]) != snapshotMagic {
+		return nil, ErrCorrupt
+	}
+	if binary.BigEndian.Uint16(hdr[8:]) != SnapshotVersion {
+		return nil, &QueueError{Op: "restore", Name: name, Errno: syscall.EPROTO}
+	}
+
+	s := &snapshot{
+		mode:     binary.BigEndian.Uint16(hdr[10:]),
+		capacity: binary.BigEndian.Uint64(hdr[16:]),
+		slotSize: binary.BigEndian.Uint64(hdr[24:]),
+		head:     binary.BigEndian.Uint64(hdr[32:]),
+		tail:     binary.BigEndian.Uint64(hdr[40:]),
+		nextSeq:  binary.BigEndian.Uint64(hdr[48:]),
+	}
+	count := binary.BigEndian.Uint64(hdr[56:])
+	if s.mode != 0 {
+		return nil, &QueueError{Op: "restore", Name: name, Errno: syscall.EINVAL}
+	}
+	if s.capacity == 0 || s.capacity&(s.capacity-1) != 0 || count > s.capacity ||
+		s.slotSize <= C.sizeof_nabd_slot_header_t || s.slotSize > 1<<32 {
+		return nil, ErrCorrupt
+	}
+
+	maxLen := s.slotSize - C.sizeof_nabd_slot_header_t
+	var n [4]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(tr, n[:]); err != nil {
+			return nil, truncated(err)
+		}
+		size := uint64(binary.BigEndian.Uint32(n[:]))
+		if size > maxLen {
+			return nil, ErrCorrupt
+		}
+		m := make([]byte, size)
+		if _, err := io.ReadFull(tr, m); err != nil {
+			return nil, truncated(err)
+		}
+		s.msgs = append(s.msgs, m)
+	}
+
+	sum := crc.Sum32()
+	if _, err := io.ReadFull(br, n[:]); err != nil {
+		return nil, truncated(err)
+	}
+	if binary.BigEndian.Uint32(n[:]) != sum {
+		return nil, ErrCorrupt
+	}
+	return s, nil
+}
+
+// truncated maps a short read to ErrCorrupt
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCorrupt
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package nabd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"syscall"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for _, m := range []string{"a", "b", "c"} {
+		if err := q.Push([]byte(m)); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	if _, err := q.Pop(64); err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := q.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	b := buf.Bytes()
+	if len(b) != snapshotHeader+2*5+4 {
+		t.Fatalf("Expected %d bytes, got %d", snapshotHeader+2*5+4, len(b))
+	}
+	if string(b[:8]) != snapshotMagic || binary.BigEndian.Uint16(b[8:]) != SnapshotVersion {
+		t.Errorf("Bad magic or version: %q", b[:10])
+	}
+	be := binary.BigEndian
+	if be.Uint64(b[16:]) != 8 || be.Uint64(b[32:]) != 3 || be.Uint64(b[40:]) != 1 ||
+		be.Uint64(b[56:]) != 2 {
+		t.Errorf("Unexpected header: %x", b[:snapshotHeader])
+	}
+	if string(b[snapshotHeader+4]) != "b" || string(b[snapshotHeader+9]) != "c" {
+		t.Errorf("Unexpected messages: %q", b[snapshotHeader:len(b)-4])
+	}
+	if crc := crc32.Checksum(b[:len(b)-4], castagnoli); be.Uint32(b[len(b)-4:]) != crc {
+		t.Errorf("Trailer does not match CRC32C %08x", crc)
+	}
+
+	// Snapshots leave the queue alone
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("Expected 2 messages left, got %d", n)
+	}
+}
+
+func TestSnapshotBroadcast(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer|Broadcast)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Snapshot(&bytes.Buffer{}); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(128),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	for _, m := range []string{"one", "two", "", "three", "four"} {
+		if err := q.Push([]byte(m)); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	if _, err := q.Pop(128); err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := q.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	snap := buf.Bytes()
+
+	if _, err := Restore(TestQueue, bytes.NewReader(snap), Consumer); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("Expected EEXIST over a live queue, got %v", err)
+	}
+
+	// Shared memory is gone, as after a reboot
+	q.Close()
+	Unlink(TestQueue)
+
+	r, err := Restore(TestQueue, bytes.NewReader(snap), Consumer, WithChecksum(), WithSequence())
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	defer r.Close()
+
+	if c, s := r.Cap(), r.SlotSize(); c != 16 || s != 128 {
+		t.Errorf("Expected capacity 16 and slot size 128, got %d and %d", c, s)
+	}
+	for i, want := range []string{"two", "", "three", "four"} {
+		msg, seq, err := r.PopSeq(128)
+		if err != nil || string(msg) != want || seq != uint64(i+1) {
+			t.Errorf("Expected %q with seq %d, got %q with seq %d, %v", want, i+1, msg, seq, err)
+		}
+	}
+	if err := r.Push([]byte("five")); err != nil {
+		t.Fatalf("Push after restore failed: %v", err)
+	}
+	if _, seq, err := r.PopSeq(128); err != nil || seq != 5 {
+		t.Errorf("Expected the sequence to continue at 5, got %d, %v", seq, err)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 8, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	q.Push([]byte("hello"))
+	var buf bytes.Buffer
+	if err := q.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	q.Close()
+	Unlink(TestQueue)
+	good := buf.Bytes()
+
+	flip := func(off int) []byte {
+		b := bytes.Clone(good)
+		b[off] ^= 0xff
+		return b
+	}
+	version := bytes.Clone(good)
+	binary.BigEndian.PutUint16(version[8:], SnapshotVersion+1)
+
+	cases := []struct {
+		name string
+		snap []byte
+		want error
+	}{
+		{"magic", flip(0), ErrCorrupt},
+		{"payload", flip(snapshotHeader + 5), ErrCorrupt},
+		{"truncated", good[:len(good)-1], ErrCorrupt},
+		{"version", version, syscall.EPROTO},
+	}
+	for _, c := range cases {
+		if _, err := Restore(TestQueue, bytes.NewReader(c.snap), Consumer); !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+		if ok, _ := Exists(TestQueue); ok {
+			t.Errorf("%s: a rejected snapshot created the queue", c.name)
+			Unlink(TestQueue)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package nabd
+
+/*
+#include "nabd/metrics.h"
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"time"
+)
+
+// SlotHeaderSize is the per-slot header overhead. The largest message a
+// queue accepts is SlotSize() - SlotHeaderSize.
+const SlotHeaderSize = C.sizeof_nabd_slot_header_t
+
+// Stats is a snapshot of the cumulative counters kept in the shared
+// header. They cover every process attached to the queue, not just the
+// caller. Fields are read one by one while producers and consumers keep
+// running, so the snapshot is best effort rather than atomic.
+type Stats struct {
+	Pushes      uint64 // Messages pushed
+	Pops        uint64 // Messages consumed, including drained ones; seeks move it back
+	FullEvents  uint64 // Pushes rejected because the ring was full
+	EmptyEvents uint64 // Pops that found the ring empty
+	BytesPushed uint64 // Payload bytes pushed
+	BytesPopped uint64 // Payload bytes popped
+
+	// Expired counts messages this handle discarded because their TTL
+	// had passed. Unlike the fields above it and the dead-letter counts
+	// are kept per handle.
+	Expired uint64
+
+	DeadLetters     uint64 // Messages routed to the dead-letter queue
+	DeadLetterDrops uint64 // Dead letters lost because it rejected them
+
+	// Latency is the enqueue-to-dequeue histogram shared by every
+	// consumer, nil unless the queue was opened WithLatencyHistogram
+	Latency *LatencyHistogram
+}
+
+// Stats returns the queue counters
+func (q *Queue) Stats() (Stats, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return Stats{}, err
+	}
+	defer q.mu.RUnlock()
+
+	var m C.nabd_metrics_t
+	if ret := C.nabd_get_metrics(h, &m); ret != C.NABD_OK {
+		return Stats{}, failure("stats", q.name, ret, nil)
+	}
+	var latency *LatencyHistogram
+	if q.latency != nil {
+		latency = q.latency.snapshot()
+	}
+	return Stats{
+		Pushes:      uint64(m.total_pushed),
+		Pops:        uint64(m.total_popped),
+		FullEvents:  uint64(m.full_events),
+		EmptyEvents: uint64(m.empty_events),
+		BytesPushed: uint64(m.bytes_pushed),
+		BytesPopped: uint64(m.bytes_popped),
+		Expired:     q.expired.Load(),
+
+		DeadLetters:     q.dead.sent.Load(),
+		DeadLetterDrops: q.dead.dropped.Load(),
+
+		Latency: latency,
+	}, nil
+}
+
+// Len returns the number of messages currently buffered
+//
+// Producer and consumer move the indices concurrently, so the value is a
+// best-effort snapshot that may be stale by the time it is used.
+func (q *Queue) Len() (int, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	var stats C.nabd_stats_t
+	if ret := C.nabd_stats(h, &stats); ret != C.NABD_OK {
+		return 0, failure("stats", q.name, ret, nil)
+	}
+	return int(stats.used), nil
+}
+
+// FreeSlots returns how many more messages can be pushed before ErrFull
+//
+// The result is best effort: concurrent pops and pushes can only make it
+// lower than the true headroom, but another producer may still fill the
+// free slots first. Broadcast and overwrite queues never return ErrFull
+// and report Cap. With WithChunking a large message takes several slots.
+func (q *Queue) FreeSlots() (int, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	return int(C.nabd_free(h)), nil
+}
+
+// Lag returns how many messages this consumer has yet to read
+//
+// On a Broadcast queue this is the handle's own cursor; a value above Cap
+// means the next Pop will report ErrLapped.
+func (q *Queue) Lag() int {
+	h, err := q.acquire()
+	if err != nil {
+		return 0
+	}
+	defer q.mu.RUnlock()
+
+	return int(C.nabd_lag(h))
+}
+
+// LayoutVersion is the header layout version this build creates and
+// accepts, as major<<16 | minor
+const LayoutVersion = C.NABD_LAYOUT_VERSION
+
+// Info is the metadata the creator of a queue recorded in its header
+type Info struct {
+	Version   uint64    // Header layout version, see LayoutVersion
+	PID       int       // Process that created the queue
+	Created   time.Time // When it was created
+	Capacity  int       // Number of slots
+	SlotSize  int       // Bytes per slot, slot header included
+	Broadcast bool      // Created with Broadcast
+	Overwrite bool      // Created WithOverwrite
+}
+
+// Info returns the queue's creation metadata. It works from any handle,
+// so a tool can attach to an orphaned queue as a consumer and find out
+// which process made it and when. PIDs are reused: before concluding the
+// creator is still alive, check that the process started before Created.
+func (q *Queue) Info() (Info, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return Info{}, err
+	}
+	defer q.mu.RUnlock()
+
+	var info C.nabd_info_t
+	if ret := C.nabd_info(h, &info); ret != C.NABD_OK {
+		return Info{}, failure("info", q.name, ret, nil)
+	}
+	return Info{
+		Version:   uint64(info.version),
+		PID:       int(info.creator_pid),
+		Created:   time.Unix(0, int64(info.created_ns)),
+		Capacity:  int(info.capacity),
+		SlotSize:  int(info.slot_size),
+		Broadcast: info.mode&C.NABD_BROADCAST != 0,
+		Overwrite: info.mode&C.NABD_OVERWRITE != 0,
+	}, nil
+}
+
+// Attached returns the number of handles open on the queue across all
+// live processes, including this one. Handles left behind by a process
+// that exited without closing are not counted.
+func (q *Queue) Attached() (int, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	n := C.nabd_attached(h)
+	if n < 0 {
+		return 0, failure("attached", q.name, n, nil)
+	}
+	return int(n), nil
+}
+
+// Cap returns the number of slots in the ring
+//
+// The value comes from the shared header, so it is valid on a consumer
+// handle opened with a capacity of 0.
+func (q *Queue) Cap() int {
+	h, err := q.acquire()
+	if err != nil {
+		return 0
+	}
+	defer q.mu.RUnlock()
+
+	var stats C.nabd_stats_t
+	if C.nabd_stats(h, &stats) != C.NABD_OK {
+		return 0
+	}
+	return int(stats.capacity)
+}
+
+// SlotSize returns the size of each slot in bytes, including the slot
+// header, as recorded by the creator of the queue
+func (q *Queue) SlotSize() int {
+	h, err := q.acquire()
+	if err != nil {
+		return 0
+	}
+	defer q.mu.RUnlock()
+
+	var stats C.nabd_stats_t
+	if C.nabd_stats(h, &stats) != C.NABD_OK {
+		return 0
+	}
+	return int(stats.slot_size)
+}
+
+// MaxMessage returns the longest message the handle pushes and the
+// helpers such as PopJSON pop: the WithMaxMessage limit, or what the ring
+// stores. It returns 0 once the queue is closed. Pass it as maxLen to pop
+// any message the handle could have pushed.
+func (q *Queue) MaxMessage() int {
+	n, _ := q.maxMessage()
+	return n
+}
+
+// MemoryUsage returns the number of bytes of shared memory the queue
+// occupies: the control block plus every slot. It is the size of the
+// mapping, so sidecar tables such as the ack and dedup files are not
+// included. See TotalMemoryUsage for every queue on the system.
+func (q *Queue) MemoryUsage() (int, error) {
+	h, err := q.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	return int(C.nabd_mapped_size(h)), nil
+}
+
+// HighWaterMark returns the maximum depth observed since the queue was
+// created or the mark was last reset. Producers update it on every push.
+func (q *Queue) HighWaterMark() int {
+	h, err := q.acquire()
+	if err != nil {
+		return 0
+	}
+	defer q.mu.RUnlock()
+
+	return int(C.nabd_high_water(h))
+}
+
+// ResetHighWaterMark lowers the high-water mark to the current depth
+func (q *Queue) ResetHighWaterMark() error {
+	h, err := q.acquire()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	if ret := C.nabd_reset_high_water(h); ret != C.NABD_OK {
+		return failure("reset high water", q.name, ret, nil)
+	}
+	return nil
+}
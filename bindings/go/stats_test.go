@@ -0,0 +1,231 @@
+package nabd
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLen(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		p.Push([]byte("x"))
+	}
+	if n, err := c.Len(); err != nil || n != 3 {
+		t.Errorf("Expected Len 3, got %d, %v", n, err)
+	}
+
+	c.Pop(128)
+	if n, err := p.Len(); err != nil || n != 2 {
+		t.Errorf("Expected Len 2, got %d, %v", n, err)
+	}
+}
+
+func TestFreeSlots(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 4, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	for want := 4; want > 0; want-- {
+		if n, err := q.FreeSlots(); n != want || err != nil {
+			t.Fatalf("Expected %d free, got %d, %v", want, n, err)
+		}
+		q.Push([]byte("x"))
+	}
+	if n, _ := q.FreeSlots(); n != 0 {
+		t.Errorf("Expected 0 free when full, got %d", n)
+	}
+	if err := q.Push([]byte("x")); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+
+	q.Close()
+	if _, err := q.FreeSlots(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCapSlotSize(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 32, 256, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if c.Cap() != 32 {
+		t.Errorf("Expected Cap 32, got %d", c.Cap())
+	}
+	if c.SlotSize() != 256 {
+		t.Errorf("Expected SlotSize 256, got %d", c.SlotSize())
+	}
+
+	// The largest message that fits is SlotSize minus the header
+	if err := p.Push(make([]byte, c.SlotSize()-SlotHeaderSize)); err != nil {
+		t.Errorf("Push of max-size message failed: %v", err)
+	}
+	if err := p.Push(make([]byte, c.SlotSize()-SlotHeaderSize+1)); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 32, 256, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	n, err := q.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage failed: %v", err)
+	}
+	if want := 256 + q.Cap()*q.SlotSize(); n != want {
+		t.Errorf("Expected %d bytes, got %d", want, n)
+	}
+
+	q.Close()
+	if _, err := q.MemoryUsage(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	p.Push([]byte("abc"))
+	p.Push([]byte("de"))
+	p.Push([]byte("full"))
+	c.Pop(128)
+	c.Pop(128)
+	c.Pop(128)
+
+	// Counters live in shared memory, so both handles see the same values
+	want := Stats{Pushes: 2, Pops: 2, FullEvents: 1, EmptyEvents: 1, BytesPushed: 5, BytesPopped: 5}
+	for _, q := range []*Queue{p, c} {
+		s, err := q.Stats()
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		if s != want {
+			t.Errorf("Expected %+v, got %+v", want, s)
+		}
+	}
+}
+
+func TestHighWaterMark(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		p.Push([]byte("x"))
+	}
+	for i := 0; i < 4; i++ {
+		c.Pop(128)
+	}
+	if hwm := c.HighWaterMark(); hwm != 5 {
+		t.Errorf("Expected high-water mark 5, got %d", hwm)
+	}
+
+	if err := p.ResetHighWaterMark(); err != nil {
+		t.Fatalf("ResetHighWaterMark failed: %v", err)
+	}
+	if hwm := c.HighWaterMark(); hwm != 1 {
+		t.Errorf("Expected high-water mark 1 after reset, got %d", hwm)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	before := time.Now()
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(128),
+		WithFlags(Create|Producer),
+		WithOverwrite())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	info, err := c.Info()
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.PID != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d", os.Getpid(), info.PID)
+	}
+	if info.Created.Before(before.Truncate(time.Second)) || info.Created.After(time.Now()) {
+		t.Errorf("Creation time %v outside the test run", info.Created)
+	}
+	if info.Capacity != 16 || info.SlotSize != 128 || info.Broadcast || !info.Overwrite {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	c.Close()
+	if _, err := c.Info(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
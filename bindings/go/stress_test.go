@@ -0,0 +1,323 @@
+package nabd
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The stress tests exercise the concurrency model under -race: one
+// producer at a time per ring (several goroutines only through
+// WithConcurrentProducers), one consumer outside Broadcast mode, and any
+// number of Broadcast consumer handles. Messages carry a producer id and
+// a per-producer index so the readers can check what arrived.
+
+const stressTimeout = 30 * time.Second
+
+func stressMessage(id, i int) []byte {
+	msg := make([]byte, 8)
+	binary.LittleEndian.PutUint32(msg, uint32(id))
+	binary.LittleEndian.PutUint32(msg[4:], uint32(i))
+	return msg
+}
+
+func stressFields(msg []byte) (id, i int) {
+	return int(binary.LittleEndian.Uint32(msg)), int(binary.LittleEndian.Uint32(msg[4:]))
+}
+
+// stressPush pushes each message of producer id, retrying while the ring
+// is full or, if busy is set, while another push holds the ring
+func stressPush(t *testing.T, q *Queue, id, each int, busy *int) {
+	for i := 0; i < each; {
+		switch err := q.Push(stressMessage(id, i)); err {
+		case nil:
+			i++
+		case ErrConcurrentPush:
+			if busy == nil {
+				t.Errorf("Producer %d: unexpected %v", id, err)
+				return
+			}
+			*busy++
+			runtime.Gosched()
+		case ErrFull:
+			runtime.Gosched()
+		default:
+			t.Errorf("Producer %d: Push failed: %v", id, err)
+			return
+		}
+	}
+}
+
+// stressDrain pops from c until every producer's messages have arrived,
+// checking that each arrives exactly once and in per-producer order
+func stressDrain(t *testing.T, c *Queue, producers, each int) {
+	next := make([]int, producers)
+	deadline := time.Now().Add(stressTimeout)
+	for got := 0; got < producers*each; {
+		msg, err := c.Pop(8)
+		if err == ErrEmpty {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out with %d of %d messages", got, producers*each)
+			}
+			runtime.Gosched()
+			continue
+		} else if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		id, i := stressFields(msg)
+		if id >= producers || i != next[id] {
+			t.Fatalf("Producer %d: expected message %d, got %d", id, next[id], i)
+		}
+		next[id]++
+		got++
+	}
+	if n, err := c.Len(); err != nil || n != 0 {
+		t.Errorf("Expected an empty queue, got %d, %v", n, err)
+	}
+}
+
+func TestStressConcurrentProducers(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	const each = 2000
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(64),
+		WithSlotSize(32),
+		WithFlags(Create|Producer),
+		WithConcurrentProducers())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+
+	// Clones share the push lock, so they count as the same producer
+	clone, err := p.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	defer clone.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	producers := []*Queue{p, p, p, clone, clone, clone}
+	var wg sync.WaitGroup
+	for id, q := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stressPush(t, q, id, each, nil)
+		}()
+	}
+	stressDrain(t, c, len(producers), each)
+	wg.Wait()
+}
+
+func TestStressConcurrentPushRejected(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	const producers, each = 4, 2000
+	p, err := Open(TestQueue, 64, 32, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	// A push that overlaps another is turned away before touching the ring
+	p.pushing.Store(true)
+	if err := p.Push([]byte("x")); err != ErrConcurrentPush {
+		t.Errorf("Expected ErrConcurrentPush, got %v", err)
+	}
+	if _, err := p.PushBatch([][]byte{[]byte("x")}); err != ErrConcurrentPush {
+		t.Errorf("Expected ErrConcurrentPush from PushBatch, got %v", err)
+	}
+	if _, err := p.Reserve(1); err != ErrConcurrentPush {
+		t.Errorf("Expected ErrConcurrentPush from Reserve, got %v", err)
+	}
+	p.pushing.Store(false)
+	if n, _ := c.Len(); n != 0 {
+		t.Fatalf("Rejected pushes left %d messages", n)
+	}
+
+	// Without WithConcurrentProducers every accepted push still lands once
+	busy := make([]int, producers)
+	var wg sync.WaitGroup
+	for id := 0; id < producers; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stressPush(t, p, id, each, &busy[id])
+		}()
+	}
+	stressDrain(t, c, producers, each)
+	wg.Wait()
+
+	total := 0
+	for _, n := range busy {
+		total += n
+	}
+	t.Logf("%d pushes rejected with ErrConcurrentPush", total)
+}
+
+// stressFollow pops from c until it has seen the last of count messages
+// from a single producer, checking that indices only increase and that
+// every gap is reported with ErrLapped first. It returns the number of
+// laps.
+func stressFollow(t *testing.T, c *Queue, count int) (laps int) {
+	next, lapped := 0, false
+	deadline := time.Now().Add(stressTimeout)
+	for next < count {
+		msg, err := c.Pop(8)
+		if err == ErrEmpty {
+			if time.Now().After(deadline) {
+				t.Errorf("Timed out waiting for message %d of %d", next, count)
+				return laps
+			}
+			runtime.Gosched()
+			continue
+		} else if err == ErrLapped {
+			laps++
+			lapped = true
+			continue
+		} else if err != nil {
+			t.Errorf("Pop failed: %v", err)
+			return laps
+		}
+		_, i := stressFields(msg)
+		if i < next || i > next && !lapped {
+			t.Errorf("Expected message %d, got %d", next, i)
+			return laps
+		}
+		next, lapped = i+1, false
+	}
+	return laps
+}
+
+func TestStressBroadcast(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	const readers, count = 3, 5000
+	p, err := Open(TestQueue, 256, 32, Create|Producer|Broadcast)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+
+	// Attach every reader before the first push so none misses the start
+	var wg sync.WaitGroup
+	laps := make([]int, readers)
+	for r := 0; r < readers; r++ {
+		c, err := Open(TestQueue, 0, 0, Consumer)
+		if err != nil {
+			t.Fatalf("Open consumer failed: %v", err)
+		}
+		defer c.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			laps[r] = stressFollow(t, c, count)
+		}()
+	}
+
+	stressPush(t, p, 0, count, nil)
+	wg.Wait()
+	t.Logf("laps per reader: %v", laps)
+}
+
+func TestStressOverwrite(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	const count = 5000
+	p, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(32),
+		WithFlags(Create|Producer),
+		WithOverwrite())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+	defer c.Close()
+
+	var laps int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		laps = stressFollow(t, c, count)
+	}()
+	stressPush(t, p, 0, count, nil)
+	<-done
+	t.Logf("laps: %d", laps)
+}
+
+func TestStressSeekSeq(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := OpenWithOptions(TestQueue, WithCapacity(4), WithSlotSize(64),
+		WithFlags(Create|Producer), WithSequence())
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := OpenWithOptions(TestQueue, WithFlags(Consumer), WithSequence())
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	// Rewinds race a producer that refills the ring as soon as it can;
+	// a replay that reached an overwritten slot would break the order
+	const each = 20000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stressPush(t, p, 0, each, nil)
+	}()
+
+	next := uint64(0)
+	deadline := time.Now().Add(stressTimeout)
+	for n := 0; next < each; n++ {
+		if n%5 == 4 && next >= 2 {
+			switch err := c.SeekSeq(next - 2); err {
+			case nil:
+				next -= 2
+			case ErrLapped:
+			default:
+				t.Fatalf("SeekSeq failed: %v", err)
+			}
+		}
+		msg, seq, err := c.PopSeq(8)
+		if err == ErrEmpty {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out at message %d", next)
+			}
+			runtime.Gosched()
+			continue
+		} else if err != nil {
+			t.Fatalf("PopSeq failed: %v", err)
+		}
+		if _, i := stressFields(msg); seq != next || uint64(i) != seq {
+			t.Fatalf("Expected message %d, got %d with seq %d", next, i, seq)
+		}
+		next++
+	}
+	<-done
+}
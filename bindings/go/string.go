@@ -0,0 +1,53 @@
+package nabd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// flagNames lists the known flags in the order String renders them
+var flagNames = []struct {
+	flag Flag
+	name string
+}{
+	{Create, "Create"},
+	{Producer, "Producer"},
+	{Consumer, "Consumer"},
+	{Broadcast, "Broadcast"},
+}
+
+// String renders f as a Create|Producer style expression. Unknown bits
+// are appended in hex, e.g. Producer|0x80.
+func (f Flag) String() string {
+	if f == 0 {
+		return "0"
+	}
+
+	var parts []string
+	for _, n := range flagNames {
+		if f&n.flag != 0 {
+			parts = append(parts, n.name)
+			f &^= n.flag
+		}
+	}
+	if f != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", int(f)))
+	}
+	return strings.Join(parts, "|")
+}
+
+// String describes the queue for logs: its name, geometry and current
+// depth, e.g. Queue(/orders cap=1024 slot=256 len=3). A closed handle
+// renders as Queue(/orders closed); any other failure to read the depth
+// is shown in its place.
+func (q *Queue) String() string {
+	n, err := q.Len()
+	if errors.Is(err, ErrClosed) {
+		return fmt.Sprintf("Queue(%s closed)", q.name)
+	}
+	if err != nil {
+		return fmt.Sprintf("Queue(%s error=%v)", q.name, err)
+	}
+	return fmt.Sprintf("Queue(%s cap=%d slot=%d len=%d)", q.name, q.Cap(), q.SlotSize(), n)
+}
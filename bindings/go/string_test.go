@@ -0,0 +1,47 @@
+package nabd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFlagString(t *testing.T) {
+	tests := []struct {
+		flag Flag
+		want string
+	}{
+		{0, "0"},
+		{Consumer, "Consumer"},
+		{Create | Producer, "Create|Producer"},
+		{Create | Producer | Broadcast, "Create|Producer|Broadcast"},
+		{Producer | 0x80, "Producer|0x80"},
+	}
+	for _, tt := range tests {
+		if got := tt.flag.String(); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func TestQueueString(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+
+	want := "Queue(" + TestQueue + " cap=16 slot=64 len=2)"
+	if got := fmt.Sprint(q); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	q.Close()
+	want = "Queue(" + TestQueue + " closed)"
+	if got := q.String(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,135 @@
+package nabd
+
+import (
+	"context"
+	"log"
+	"syscall"
+)
+
+// HandlerPolicy selects what Subscribe does when its handler returns an
+// error
+type HandlerPolicy int
+
+const (
+	// LogAndContinue logs the error with the standard logger and moves on
+	// to the next message. This is the default.
+	LogAndContinue HandlerPolicy = iota
+
+	// StopOnError ends the subscription at the first error
+	StopOnError
+
+	// NackOnError pops with PopNoAck, acks each message the handler
+	// accepts and nacks the ones it fails, so they are redelivered at
+	// once. It needs a queue that supports PopNoAck.
+	NackOnError
+)
+
+// SetHandlerPolicy selects what Subscribe does when its handler returns an
+// error. It must be called before Subscribe.
+func (q *Queue) SetHandlerPolicy(p HandlerPolicy) {
+	q.onError = p
+}
+
+// Subscribe starts a goroutine that pops messages and calls handler for
+// each one, in order, until cancel is called
+//
+// The goroutine waits with backoff instead of busy-polling. What happens
+// when handler returns an error depends on the queue's HandlerPolicy;
+// ErrLapped and ErrCorrupt from the pop itself are treated the same way.
+// The subscription also ends when the queue is closed or a pop, ack or
+// nack fails; under LogAndContinue that error is logged as well.
+//
+// cancel stops waiting for new messages, lets a handler call already in
+// progress finish, and returns once the goroutine has exited. It returns
+// the error that ended the subscription, nil if it ran until cancel. It
+// is safe to call more than once, but not from inside handler.
+func (q *Queue) Subscribe(handler func([]byte) error) (cancel func() error, err error) {
+	if handler == nil {
+		return nil, &QueueError{Op: "subscribe", Name: q.name, Errno: syscall.EINVAL}
+	}
+	info, err := q.Info()
+	if err != nil {
+		return nil, err
+	}
+	if q.onError == NackOnError && (info.Broadcast || info.Overwrite) {
+		return nil, &QueueError{Op: "subscribe", Name: q.name, Errno: syscall.EINVAL}
+	}
+
+	maxLen, err := q.maxMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var end error
+	go func() {
+		defer close(done)
+		end = q.subscribe(ctx, handler, maxLen, q.onError)
+	}()
+
+	return func() error {
+		stop()
+		<-done
+		return end
+	}, nil
+}
+
+// subscribe runs the Subscribe loop until ctx is done or it has to stop,
+// returning the error that stopped it
+func (q *Queue) subscribe(ctx context.Context, handler func([]byte) error, maxLen int, policy HandlerPolicy) error {
+	report := func(err error) bool {
+		if policy == StopOnError {
+			return false
+		}
+		if policy == LogAndContinue {
+			log.Printf("nabd subscribe %s: %v", q.name, err)
+		}
+		return true
+	}
+	fail := func(err error) error {
+		if policy == LogAndContinue {
+			log.Printf("nabd subscribe %s: stopped: %v", q.name, err)
+		}
+		return err
+	}
+
+	for {
+		var msg []byte
+		var m AckHandle
+		var err error
+		if policy == NackOnError {
+			err = q.retry(ctx, -1, ErrEmpty, func() (err error) {
+				msg, m, err = q.PopNoAck(maxLen)
+				return err
+			})
+		} else {
+			msg, err = q.PopContext(ctx, maxLen)
+		}
+
+		if err == ErrLapped || err == ErrCorrupt {
+			if !report(err) {
+				return err
+			}
+			continue
+		} else if err == context.Canceled && ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return fail(err)
+		}
+
+		herr := handler(msg)
+		if policy == NackOnError {
+			if herr != nil {
+				err = q.Nack(m, 0)
+			} else {
+				err = q.Ack(m)
+			}
+			if err != nil {
+				return fail(err)
+			}
+		} else if herr != nil && !report(herr) {
+			return herr
+		}
+	}
+}
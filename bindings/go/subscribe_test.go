@@ -0,0 +1,178 @@
+package nabd
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	got := make(chan string, 16)
+	cancel, err := q.Subscribe(func(msg []byte) error {
+		got <- string(msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	for _, s := range []string{"a", "b", "c"} {
+		q.Push([]byte(s))
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case s := <-got:
+			if s != want {
+				t.Errorf("Expected %q, got %q", want, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+
+	// After cancel returns the handler is never called again
+	if err := cancel(); err != nil {
+		t.Errorf("Expected nil from cancel, got %v", err)
+	}
+	cancel()
+	q.Push([]byte("late"))
+	time.Sleep(20 * time.Millisecond)
+	if len(got) != 0 {
+		t.Errorf("Handler ran after cancel: %q", <-got)
+	}
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("Expected the late message left queued, got len %d", n)
+	}
+
+	if _, err := q.Subscribe(nil); err == nil {
+		t.Error("Expected an error for a nil handler")
+	}
+}
+
+func TestSubscribeStopOnError(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithHandlerPolicy(StopOnError))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+
+	var calls atomic.Int32
+	stopped := make(chan struct{})
+	cancel, err := q.Subscribe(func(msg []byte) error {
+		calls.Add(1)
+		close(stopped)
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	<-stopped
+	if err := cancel(); err == nil || err.Error() != "boom" {
+		t.Errorf("Expected the handler error from cancel, got %v", err)
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 handler call, got %d", n)
+	}
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("Expected 1 message left, got %d", n)
+	}
+}
+
+func TestSubscribeNackOnError(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithHandlerPolicy(NackOnError))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("a"))
+
+	var calls atomic.Int32
+	acked := make(chan struct{})
+	cancel, err := q.Subscribe(func(msg []byte) error {
+		if calls.Add(1) == 1 {
+			return errors.New("retry me")
+		}
+		close(acked)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("Nacked message was not redelivered")
+	}
+	cancel()
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", n)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected the acked message consumed, got len %d", n)
+	}
+}
+
+func TestSubscribeEndsWithError(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	cancel, err := q.Subscribe(func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// Closing the queue ends the subscription; LogAndContinue logs why
+	q.Close()
+	time.Sleep(20 * time.Millisecond)
+	if err := cancel(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed from cancel, got %v", err)
+	}
+	if !strings.Contains(logged.String(), "stopped: queue closed") {
+		t.Errorf("Expected the error logged, got %q", logged.String())
+	}
+}
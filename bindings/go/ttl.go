@@ -0,0 +1,70 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// PushTTL pushes data with a time to live. Once ttl has passed, pops skip
+// the message instead of delivering it. It needs a queue opened
+// WithExpiry and a positive ttl, and otherwise fails with EINVAL.
+//
+// Expiry is checked against the consumer's wall clock, so producer and
+// consumer clocks must agree to within the precision you need.
+func (q *Queue) PushTTL(data []byte, ttl time.Duration) error {
+	if !q.env.expiry || ttl <= 0 {
+		return &QueueError{Op: "push", Name: q.name, Errno: syscall.EINVAL}
+	}
+	_, err := q.push(data, time.Now().Add(ttl).UnixNano(), 0)
+	return err
+}
+
+// DropExpired eagerly discards expired messages at the front of the
+// queue and returns how many it removed. It stops at the first live
+// message, so expired messages queued behind it stay until they reach the
+// front. Pops skip expired messages lazily either way; call DropExpired
+// to free their slots without consuming live messages.
+//
+// It relies on peeking and fails with EINVAL on Broadcast and overwrite
+// queues.
+func (q *Queue) DropExpired() (int, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return 0, err
+	}
+	defer q.mu.RUnlock()
+
+	if !q.env.expiry {
+		return 0, nil
+	}
+
+	n := 0
+	for {
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek(h, &data, &size)
+		if ret == C.NABD_EMPTY {
+			return n, nil
+		} else if ret != C.NABD_OK {
+			return n, failure("drop expired", q.name, ret, nil)
+		}
+
+		payload, st, err := q.env.open(C.GoBytes(data, C.int(size)), -1)
+		if err != nil || !q.env.expired(st) {
+			return n, nil
+		}
+		q.dropPeeked(h, payload)
+		n++
+	}
+}
+
+// dropPeeked discards the peeked message at the front as expired
+func (q *Queue) dropPeeked(h *C.nabd_t, data []byte) {
+	C.nabd_release(h)
+	q.expire(data)
+}
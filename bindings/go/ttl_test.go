@@ -0,0 +1,81 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPushTTL(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(16),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithExpiry())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushTTL([]byte("x"), 0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for zero TTL, got %v", err)
+	}
+
+	q.PushTTL([]byte("stale"), time.Millisecond)
+	q.PushTTL([]byte("live"), time.Hour)
+	q.Push([]byte("forever"))
+	time.Sleep(5 * time.Millisecond)
+
+	if msg, err := q.Pop(16); err != nil || string(msg) != "live" {
+		t.Errorf("Expected live, got %q, %v", msg, err)
+	}
+	if msg, err := q.Pop(16); err != nil || string(msg) != "forever" {
+		t.Errorf("Expected forever, got %q, %v", msg, err)
+	}
+	if s, _ := q.Stats(); s.Expired != 1 {
+		t.Errorf("Expected 1 expired, got %d", s.Expired)
+	}
+
+	// Eager expiry stops at the first live message
+	q.PushTTL([]byte("a"), time.Millisecond)
+	q.PushTTL([]byte("b"), time.Millisecond)
+	q.Push([]byte("c"))
+	q.PushTTL([]byte("d"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if n, err := q.DropExpired(); n != 2 || err != nil {
+		t.Errorf("Expected 2 dropped, got %d, %v", n, err)
+	}
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("Expected 2 left, got %d", n)
+	}
+	if msg, err := q.Peek(16); err != nil || string(msg) != "c" {
+		t.Errorf("Expected c, got %q, %v", msg, err)
+	}
+	q.Pop(16)
+	if _, err := q.Peek(16); err != ErrEmpty {
+		t.Errorf("Expected Peek to skip expired d, got %v", err)
+	}
+	if s, _ := q.Stats(); s.Expired != 4 {
+		t.Errorf("Expected 4 expired, got %d", s.Expired)
+	}
+}
+
+func TestPushTTLWithoutExpiry(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushTTL([]byte("x"), time.Second); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL, got %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Default wait strategy, see WithWaitStrategy. The sleeps mirror
+// nabd_push_wait on the C side.
+const (
+	DefaultWaitSpins      = 10
+	DefaultWaitSleepFloor = 10 * time.Microsecond
+	DefaultWaitSleepCap   = time.Millisecond
+)
+
+// waitYields is how many retries yield the processor between spinning
+// and sleeping
+const waitYields = 100
+
+// waitStrategy paces retries of blocking operations
+type waitStrategy struct {
+	spins int           // retries made at once
+	floor time.Duration // first sleep
+	cap   time.Duration // longest sleep
+}
+
+var defaultWait = waitStrategy{
+	spins: DefaultWaitSpins,
+	floor: DefaultWaitSleepFloor,
+	cap:   DefaultWaitSleepCap,
+}
+
+// ErrTimeout is returned by WaitReadable, WaitWritable and Select when the
+// timeout elapses before a queue is ready
+var ErrTimeout = errors.New("wait timed out")
+
+// waiter paces the retry loop of a blocking operation: it spins and
+// yields for a while, then sleeps with a growing delay until the deadline
+type waiter struct {
+	ctx      context.Context
+	deadline time.Time // zero means wait forever
+	strategy waitStrategy
+	tries    int
+	sleep    time.Duration
+	timer    *time.Timer
+}
+
+func newWaiter(ctx context.Context, timeout time.Duration, s waitStrategy) *waiter {
+	w := &waiter{ctx: ctx, strategy: s, sleep: s.floor}
+	if timeout >= 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+	return w
+}
+
+// wait blocks before the next attempt. It returns ErrTimeout when the
+// deadline has passed, ErrClosed when done is closed and the context
+// error when the context is cancelled.
+func (w *waiter) wait(done <-chan struct{}) error {
+	d := w.sleep
+	if !w.deadline.IsZero() {
+		left := time.Until(w.deadline)
+		if left <= 0 {
+			return ErrTimeout
+		}
+		if d > left {
+			d = left
+		}
+	}
+
+	if w.tries < w.strategy.spins+waitYields {
+		w.tries++
+		select {
+		case <-done:
+			return ErrClosed
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		default:
+		}
+		if w.tries > w.strategy.spins {
+			runtime.Gosched()
+		}
+		return nil
+	}
+
+	if w.timer == nil {
+		w.timer = time.NewTimer(d)
+	} else {
+		w.timer.Reset(d)
+	}
+	select {
+	case <-done:
+		w.timer.Stop()
+		return ErrClosed
+	case <-w.ctx.Done():
+		w.timer.Stop()
+		return w.ctx.Err()
+	case <-w.timer.C:
+	}
+
+	if w.sleep *= 2; w.sleep > w.strategy.cap {
+		w.sleep = w.strategy.cap
+	}
+	return nil
+}
+
+// PushWait pushes data, blocking until a slot frees up or timeout elapses.
+// A zero timeout tries once like Push, a negative timeout blocks forever.
+// Returns ErrFull on timeout and ErrClosed if the queue is closed meanwhile.
+func (q *Queue) PushWait(data []byte, timeout time.Duration) error {
+	return q.pushWait(context.Background(), data, timeout)
+}
+
+// PopWait pops a message, blocking until one arrives or timeout elapses.
+// Timeouts behave as in PushWait. Returns ErrEmpty on timeout and
+// ErrClosed if the queue is closed meanwhile.
+func (q *Queue) PopWait(maxLen int, timeout time.Duration) ([]byte, error) {
+	return q.popWait(context.Background(), maxLen, timeout)
+}
+
+// PushContext pushes data, blocking until a slot frees up, ctx is done
+// or the queue is closed. Returns ctx.Err() on cancellation.
+func (q *Queue) PushContext(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return q.pushWait(ctx, data, -1)
+}
+
+// PopContext pops a message, blocking until one arrives, ctx is done
+// or the queue is closed. Returns ctx.Err() on cancellation.
+func (q *Queue) PopContext(ctx context.Context, maxLen int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return q.popWait(ctx, maxLen, -1)
+}
+
+// PushDeadline pushes data, blocking until a slot frees up or deadline
+// passes. A deadline already in the past tries once like Push. Returns
+// ErrFull once the deadline has passed and ErrClosed if the queue is
+// closed meanwhile.
+func (q *Queue) PushDeadline(data []byte, deadline time.Time) error {
+	return q.pushWait(context.Background(), data, untilDeadline(deadline))
+}
+
+// PopDeadline pops a message, blocking until one arrives or deadline
+// passes. Deadlines behave as in PushDeadline. Returns ErrEmpty once the
+// deadline has passed and ErrClosed if the queue is closed meanwhile.
+func (q *Queue) PopDeadline(maxLen int, deadline time.Time) ([]byte, error) {
+	return q.popWait(context.Background(), maxLen, untilDeadline(deadline))
+}
+
+// PopExactlyWait pops n messages like PopExactly, blocking until n are
+// queued or timeout elapses. Timeouts behave as in PushWait. Returns
+// ErrEmpty on timeout, without consuming anything, and ErrClosed if the
+// queue is closed meanwhile.
+func (q *Queue) PopExactlyWait(n, maxLen int, timeout time.Duration) ([][]byte, error) {
+	var msgs [][]byte
+	err := q.retry(context.Background(), timeout, ErrEmpty, func() (err error) {
+		msgs, err = q.PopExactly(n, maxLen)
+		return err
+	})
+	return msgs, err
+}
+
+// untilDeadline converts deadline to a timeout, zero if it has passed
+func untilDeadline(deadline time.Time) time.Duration {
+	return max(time.Until(deadline), 0)
+}
+
+// retry runs op until it fails with something other than busy, pacing
+// attempts with a waiter. Hitting the timeout reports busy.
+func (q *Queue) retry(ctx context.Context, timeout time.Duration, busy error, op func() error) error {
+	err := op()
+	if err != busy || timeout == 0 {
+		return err
+	}
+
+	w := newWaiter(ctx, timeout, q.wait)
+	for {
+		if werr := w.wait(q.done); werr == ErrTimeout {
+			return busy
+		} else if werr != nil {
+			return werr
+		}
+
+		if err = op(); err != busy {
+			return err
+		}
+	}
+}
+
+// pushWait retries Push until it stops reporting ErrFull
+func (q *Queue) pushWait(ctx context.Context, data []byte, timeout time.Duration) error {
+	return q.retry(ctx, timeout, ErrFull, func() error {
+		return q.Push(data)
+	})
+}
+
+// popWait retries Pop until it stops reporting ErrEmpty
+func (q *Queue) popWait(ctx context.Context, maxLen int, timeout time.Duration) ([]byte, error) {
+	var buf []byte
+	err := q.retry(ctx, timeout, ErrEmpty, func() (err error) {
+		buf, err = q.Pop(maxLen)
+		return err
+	})
+	return buf, err
+}
+
+// Select blocks until one of queues has a message for this handle and
+// returns its index in queues. When several are ready it picks one at
+// random, like a select statement, so a busy queue cannot starve the
+// others. Select does not pop; the caller pops from queues[index], which
+// can still find it empty if another consumer got there first.
+//
+// Timeouts behave as in PushWait. Select returns ErrTimeout on timeout, as
+// WaitReadable does, and the index of a closed queue together with ErrClosed. An empty queues
+// slice fails with EINVAL.
+func Select(queues []*Queue, timeout time.Duration) (int, error) {
+	if len(queues) == 0 {
+		return -1, &QueueError{Op: "select", Errno: syscall.EINVAL}
+	}
+
+	var w *waiter
+	for {
+		index, seen := -1, 0
+		for i, q := range queues {
+			ok, err := q.ready()
+			if err != nil {
+				return i, err
+			}
+			if ok {
+				if seen++; rand.IntN(seen) == 0 {
+					index = i
+				}
+			}
+		}
+		if index >= 0 {
+			return index, nil
+		}
+		if timeout == 0 {
+			return -1, ErrTimeout
+		}
+
+		// Closing any queue is caught by ready on the next round
+		if w == nil {
+			w = newWaiter(context.Background(), timeout, defaultWait)
+		}
+		if err := w.wait(nil); err != nil {
+			return -1, err
+		}
+	}
+}
+
+// WaitReadable blocks until a Pop on this handle would find a message,
+// without popping it. Timeouts behave as in PushWait. It returns
+// ErrTimeout when the timeout elapses first and ErrClosed if the queue is
+// closed meanwhile.
+//
+// Another consumer sharing the ring can still take the message first.
+// WaitReadable polls with the handle's wait strategy; to block in an event
+// loop instead, use Fd.
+func (q *Queue) WaitReadable(timeout time.Duration) error {
+	return q.waitFor(timeout, q.ready)
+}
+
+// WaitWritable blocks until a Push on this handle would find a free slot,
+// without pushing. Timeouts and errors are as in WaitReadable; once
+// Shutdown has begun it returns ErrShuttingDown. Broadcast and overwrite
+// queues are always writable. A rate limit set with SetRateLimit is not
+// taken into account.
+func (q *Queue) WaitWritable(timeout time.Duration) error {
+	return q.waitFor(timeout, q.writable)
+}
+
+// waitFor polls cond until it reports true, paced by the handle's wait
+// strategy
+func (q *Queue) waitFor(timeout time.Duration, cond func() (bool, error)) error {
+	var w *waiter
+	for {
+		if ok, err := cond(); err != nil || ok {
+			return err
+		}
+		if timeout == 0 {
+			return ErrTimeout
+		}
+
+		if w == nil {
+			w = newWaiter(context.Background(), timeout, q.wait)
+		}
+		if err := w.wait(q.done); err != nil {
+			return err
+		}
+	}
+}
+
+// ready reports whether this handle has a message left to read
+func (q *Queue) ready() (bool, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return false, err
+	}
+	defer q.mu.RUnlock()
+
+	return C.nabd_lag(h) > 0 && q.holdback(h) == 0, nil
+}
+
+// writable reports whether a push through this handle would find room
+func (q *Queue) writable() (bool, error) {
+	h, err := q.acquirePush()
+	if err != nil {
+		return false, err
+	}
+	defer q.mu.RUnlock()
+
+	return C.nabd_free(h) > 0, nil
+}
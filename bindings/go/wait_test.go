@@ -0,0 +1,385 @@
+package nabd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPushWait(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	msg := []byte("wait")
+	for i := 0; i < 2; i++ {
+		if err := p.Push(msg); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+
+	// Zero timeout behaves like Push
+	if err := p.PushWait(msg, 0); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+
+	start := time.Now()
+	if err := p.PushWait(msg, 20*time.Millisecond); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("PushWait returned after %v, before timeout", elapsed)
+	}
+
+	// A pop frees a slot while PushWait is blocked
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Pop(128)
+	}()
+	if err := p.PushWait(msg, time.Second); err != nil {
+		t.Errorf("PushWait failed: %v", err)
+	}
+
+	// Close wakes the waiter with an error that still matches ErrFailed
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Close()
+	}()
+	err = p.PushWait(msg, 5*time.Second)
+	if err != ErrClosed || !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected ErrClosed matching ErrFailed, got %v", err)
+	}
+}
+
+func TestPopWait(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.PopWait(128, 0); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+	if _, err := c.PopWait(128, 20*time.Millisecond); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	msg := []byte("late")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Push(msg)
+	}()
+	out, err := c.PopWait(128, time.Second)
+	if err != nil {
+		t.Fatalf("PopWait failed: %v", err)
+	}
+	if string(out) != string(msg) {
+		t.Errorf("Expected %s, got %s", msg, out)
+	}
+}
+
+func TestPushPopContext(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 16, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Producer open failed: %v", err)
+	}
+	defer p.Close()
+
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Consumer open failed: %v", err)
+	}
+	defer c.Close()
+
+	// Already cancelled: returns without touching the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.PushContext(ctx, []byte("x")); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := c.Pop(128); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.PopContext(ctx, 128); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if err := p.PushContext(context.Background(), []byte("ctx")); err != nil {
+		t.Fatalf("PushContext failed: %v", err)
+	}
+	out, err := c.PopContext(context.Background(), 128)
+	if err != nil {
+		t.Fatalf("PopContext failed: %v", err)
+	}
+	if string(out) != "ctx" {
+		t.Errorf("Expected ctx, got %s", out)
+	}
+}
+
+func TestPushPopDeadline(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 2, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	// A past deadline makes a single attempt
+	past := time.Now().Add(-time.Second)
+	if _, err := q.PopDeadline(128, past); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+	if err := q.PushDeadline([]byte("a"), past); err != nil {
+		t.Fatalf("PushDeadline failed: %v", err)
+	}
+	q.Push([]byte("b"))
+
+	deadline := time.Now().Add(20 * time.Millisecond)
+	if err := q.PushDeadline([]byte("c"), deadline); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	if time.Now().Before(deadline) {
+		t.Error("PushDeadline returned before the deadline")
+	}
+
+	out, err := q.PopDeadline(128, time.Now().Add(time.Second))
+	if err != nil || string(out) != "a" {
+		t.Errorf("Expected a, nil, got %q, %v", out, err)
+	}
+}
+
+func TestWithWaitStrategy(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 16, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if q.wait != defaultWait {
+		t.Errorf("Expected the default strategy, got %+v", q.wait)
+	}
+	q.Close()
+
+	// Out-of-range values are clamped
+	q, err = OpenWithOptions(TestQueue, WithFlags(Producer|Consumer),
+		WithWaitStrategy(-1, 0, time.Nanosecond))
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+	want := waitStrategy{floor: DefaultWaitSleepFloor, cap: DefaultWaitSleepFloor}
+	if q.wait != want {
+		t.Errorf("Expected %+v, got %+v", want, q.wait)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		q.Push([]byte("late"))
+	}()
+	if out, err := q.PopWait(64, time.Second); err != nil || string(out) != "late" {
+		t.Errorf("Expected late, got %q, %v", out, err)
+	}
+}
+
+// BenchmarkWaitStrategy compares how soon a blocked PopWait sees a message
+// pushed every 100µs, and the process CPU time spent per message
+func BenchmarkWaitStrategy(b *testing.B) {
+	strategies := []struct {
+		name string
+		opt  Option
+	}{
+		{"spin", WithWaitStrategy(10000, time.Microsecond, 10*time.Microsecond)},
+		{"default", WithWaitStrategy(DefaultWaitSpins, DefaultWaitSleepFloor, DefaultWaitSleepCap)},
+		{"sleep", WithWaitStrategy(0, 100*time.Microsecond, 10*time.Millisecond)},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			Unlink(TestQueue)
+			defer Unlink(TestQueue)
+			q, err := OpenWithOptions(TestQueue, WithCapacity(64), WithSlotSize(64),
+				WithFlags(Create|Producer|Consumer), s.opt)
+			if err != nil {
+				b.Fatalf("OpenWithOptions failed: %v", err)
+			}
+			defer q.Close()
+			c, err := q.Clone()
+			if err != nil {
+				b.Fatalf("Clone failed: %v", err)
+			}
+			defer c.Close()
+
+			go func() {
+				msg := make([]byte, 8)
+				for i := 0; i < b.N; i++ {
+					time.Sleep(100 * time.Microsecond)
+					binary.NativeEndian.PutUint64(msg, uint64(time.Now().UnixNano()))
+					q.PushWait(msg, time.Second)
+				}
+			}()
+
+			var latency time.Duration
+			cpu := cpuTime()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				out, err := c.PopWait(64, time.Second)
+				if err != nil {
+					b.Fatalf("PopWait failed: %v", err)
+				}
+				latency += time.Duration(time.Now().UnixNano() - int64(binary.NativeEndian.Uint64(out)))
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(latency.Nanoseconds())/float64(b.N), "latency-ns/op")
+			b.ReportMetric(float64((cpuTime()-cpu).Nanoseconds())/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
+
+// cpuTime returns the user and system CPU time the process has used
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func TestWaitReadableWritable(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	p, err := Open(TestQueue, 2, 64, Create|Producer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+	c, err := Open(TestQueue, 0, 0, Consumer)
+	if err != nil {
+		t.Fatalf("Open consumer failed: %v", err)
+	}
+
+	if err := c.WaitReadable(0); err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout on an empty queue, got %v", err)
+	}
+	if err := p.WaitWritable(0); err != nil {
+		t.Errorf("Expected an empty queue to be writable, got %v", err)
+	}
+
+	p.Push([]byte("a"))
+	p.Push([]byte("b"))
+	if err := c.WaitReadable(0); err != nil {
+		t.Errorf("Expected a readable queue, got %v", err)
+	}
+	start := time.Now()
+	if err := p.WaitWritable(20 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout on a full queue, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("WaitWritable returned after %v, before its timeout", elapsed)
+	}
+
+	// Waiting consumes nothing, and a pop elsewhere wakes the producer
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Pop(64)
+	}()
+	if err := p.WaitWritable(-1); err != nil {
+		t.Errorf("WaitWritable failed: %v", err)
+	}
+	if n, _ := c.Len(); n != 1 {
+		t.Errorf("Expected 1 message left, got %d", n)
+	}
+
+	c.Pop(64)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Close()
+	}()
+	if err := c.WaitReadable(-1); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var queues []*Queue
+	for i := 0; i < 3; i++ {
+		name := TestQueue + "." + strconv.Itoa(i)
+		Unlink(name)
+		defer Unlink(name)
+
+		q, err := Open(name, 8, 64, Create|Producer|Consumer)
+		if err != nil {
+			t.Fatalf("Open %s failed: %v", name, err)
+		}
+		defer q.Close()
+		queues = append(queues, q)
+	}
+
+	if _, err := Select(nil, 0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL for no queues, got %v", err)
+	}
+	if _, err := Select(queues, 0); err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if _, err := Select(queues, 20*time.Millisecond); err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout after timeout, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queues[2].Push([]byte("late"))
+	}()
+	if i, err := Select(queues, time.Second); err != nil || i != 2 {
+		t.Fatalf("Expected queue 2, got %d, %v", i, err)
+	}
+
+	// Ready queues are picked at random, so each one gets its turn
+	queues[0].Push([]byte("early"))
+	picked := map[int]bool{}
+	for n := 0; n < 100 && len(picked) < 2; n++ {
+		i, err := Select(queues, 0)
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+		picked[i] = true
+	}
+	if !picked[0] || !picked[2] || picked[1] {
+		t.Errorf("Expected queues 0 and 2 to be picked, got %v", picked)
+	}
+
+	queues[1].Close()
+	if i, err := Select(queues, 0); err != ErrClosed || i != 1 {
+		t.Errorf("Expected ErrClosed for queue 1, got %d, %v", i, err)
+	}
+}
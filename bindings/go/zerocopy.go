@@ -0,0 +1,330 @@
+package nabd
+
+/*
+#include "nabd/nabd.h"
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// Zero-copy errors
+var (
+	// ErrNoReservation is returned by Commit and Abort when no slot is
+	// reserved
+	ErrNoReservation = errors.New("no reservation")
+
+	// ErrReleased is returned by Release when no message from
+	// PopZeroCopy is outstanding, for instance on a second Release
+	ErrReleased = errors.New("message already released")
+)
+
+// Reserve claims the next slot for a message of exactly size bytes and
+// returns a slice aliasing it in shared memory, so the caller can
+// serialize straight into the ring. Consumers cannot see the message
+// until Commit publishes it; Abort gives the slot back instead.
+//
+// The slice is valid only until Commit, Abort or Close. Writing to it
+// afterwards corrupts whatever message reuses the slot, or faults once
+// the queue is closed. Only one reservation can be outstanding per
+// handle, and Push and PushBatch fail with EINVAL until it ends.
+// Reserve, Commit and Abort must not race with each other or with
+// pushes on the same handle. Under WithConcurrentProducers the
+// reservation holds the push lock, so other goroutines' pushes wait for
+// Commit or Abort. Broadcast and overwrite queues must make room before
+// the slot is written, which Abort could not undo, so Reserve fails with
+// EINVAL on them.
+func (q *Queue) Reserve(size int) (buf []byte, err error) {
+	if _, err = q.admit(1); err != nil {
+		return nil, err
+	}
+	q.lockPush()
+	defer func() {
+		if err != nil {
+			q.unlockPush()
+			q.limit.refund(1)
+		}
+	}()
+	if err = q.enterPush(); err != nil {
+		return nil, err
+	}
+	defer q.exitPush()
+
+	h, err := q.acquirePush()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if size < 0 {
+		return nil, ErrTooBig
+	}
+
+	full := size + q.env.size()
+	var slot unsafe.Pointer
+	ret := C.nabd_reserve(h, C.size_t(full), &slot)
+	if ret == C.NABD_FULL {
+		q.watch.note(true)
+		return nil, ErrFull
+	} else if ret == C.NABD_TOOBIG {
+		return nil, ErrTooBig
+	} else if ret != C.NABD_OK {
+		return nil, failure("reserve", q.name, ret, nil)
+	}
+
+	q.watch.note(false)
+	q.resv = unsafe.Slice((*byte)(slot), full)
+	return q.resv[q.env.size():], nil
+}
+
+// Commit publishes the reserved slot to consumers. The index is stored
+// with release ordering, so every write to the slot made before Commit is
+// visible to a consumer that sees the message, on any architecture.
+func (q *Queue) Commit() error {
+	if q.resv == nil {
+		return ErrNoReservation
+	}
+	defer q.unlockPush()
+
+	h, err := q.acquire()
+	if err != nil {
+		q.resv = nil
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	if q.env.size() > 0 {
+		var seq uint64
+		if q.env.sequence {
+			seq = uint64(C.nabd_seq(h))
+		}
+		q.env.sealHeader(q.resv, q.env.newStamp(seq, 0))
+	}
+
+	ret := C.nabd_commit(h, C.size_t(len(q.resv)))
+	q.resv = nil
+	if ret != C.NABD_OK {
+		return failure("commit", q.name, ret, nil)
+	}
+	if q.env.sequence {
+		C.nabd_seq_advance(h, 1)
+	}
+	return nil
+}
+
+// Abort gives up the reserved slot without publishing anything. The ring
+// indices are left as they were before Reserve, so the slot is reused by
+// the next push.
+func (q *Queue) Abort() error {
+	if q.resv == nil {
+		return ErrNoReservation
+	}
+	defer q.unlockPush()
+
+	h, err := q.acquire()
+	if err != nil {
+		q.resv = nil
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	q.resv = nil
+	q.limit.refund(1)
+	if ret := C.nabd_abort(h); ret != C.NABD_OK {
+		return failure("abort", q.name, ret, nil)
+	}
+	return nil
+}
+
+// Flush issues a full memory fence, ordering every earlier write before
+// every later one
+//
+// Push, PushBatch and Commit already publish with release ordering and
+// pops read with acquire ordering, so messages never need it. Flush is
+// for callers that share other memory with the consumer, or fill a
+// reserved slot from several goroutines, and want an explicit fence
+// before publishing.
+func (q *Queue) Flush() error {
+	h, err := q.acquire()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	if ret := C.nabd_flush(h); ret != C.NABD_OK {
+		return failure("flush", q.name, ret, nil)
+	}
+	return nil
+}
+
+// PushVectored pushes the concatenation of chunks as one message, copying
+// each chunk straight into the slot so the pieces never have to be joined
+// first. The consumer pops a single contiguous message. ErrTooBig is
+// returned, and nothing is written, when the total does not fit a slot.
+// Broadcast and overwrite queues cannot reserve a slot, so there the
+// chunks are joined after all and pushed with one more copy.
+func (q *Queue) PushVectored(chunks [][]byte) (err error) {
+	if _, err = q.admit(1); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			q.limit.refund(1)
+		}
+	}()
+
+	q.lockPush()
+	defer q.unlockPush()
+	if err := q.enterPush(); err != nil {
+		return err
+	}
+	defer q.exitPush()
+
+	h, err := q.acquirePush()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	size := 0
+	for _, c := range chunks {
+		size += len(c)
+	}
+	if size == 0 && q.empty == RejectEmpty {
+		q.deadLetter(DeadEmpty, nil)
+		return ErrEmptyMessage
+	}
+
+	full := size + q.env.size()
+	var slot unsafe.Pointer
+	ret := C.int(C.NABD_OK)
+	direct := C.nabd_mode(h)&(C.NABD_BROADCAST|C.NABD_OVERWRITE) == 0
+	if direct {
+		ret = C.nabd_reserve(h, C.size_t(full), &slot)
+	} else {
+		slot = unsafe.Pointer(unsafe.SliceData(make([]byte, full+1)))
+	}
+	if ret == C.NABD_FULL {
+		q.watch.note(true)
+		return ErrFull
+	} else if ret == C.NABD_TOOBIG {
+		if q.dlq != nil {
+			q.deadLetter(DeadTooBig, bytes.Join(chunks, nil))
+		}
+		return ErrTooBig
+	} else if ret != C.NABD_OK {
+		return failure("push", q.name, ret, nil)
+	}
+
+	msg := unsafe.Slice((*byte)(slot), full)
+	off := q.env.size()
+	for _, c := range chunks {
+		off += copy(msg[off:], c)
+	}
+	if q.env.size() > 0 {
+		var seq uint64
+		if q.env.sequence {
+			seq = uint64(C.nabd_seq(h))
+		}
+		q.env.sealHeader(msg, q.env.newStamp(seq, 0))
+	}
+
+	if direct {
+		ret = C.nabd_commit(h, C.size_t(full))
+	} else if ret = C.nabd_push(h, slot, C.size_t(full)); ret == C.NABD_TOOBIG {
+		if q.dlq != nil {
+			q.deadLetter(DeadTooBig, bytes.Join(chunks, nil))
+		}
+		return ErrTooBig
+	}
+	if ret != C.NABD_OK {
+		return failure("push", q.name, ret, nil)
+	}
+	if q.env.sequence {
+		C.nabd_seq_advance(h, 1)
+	}
+	q.watch.note(false)
+	return nil
+}
+
+// PopZeroCopy returns the next message as a slice aliasing its slot in
+// shared memory, without copying it. The slot stays owned by the caller,
+// and the producer cannot reuse it, until Release hands it back.
+//
+// The slice is valid only until Release or Close; it must not be written
+// to or kept afterwards, since the producer will overwrite it. Only one
+// message can be outstanding per handle: PopZeroCopy fails with EINVAL
+// until Release, and other pops on the handle must not run meanwhile.
+// It relies on peeking and fails with EINVAL on Broadcast and overwrite
+// queues. A message that fails checksum verification is released and
+// ErrCorrupt returned; expired messages are skipped.
+func (q *Queue) PopZeroCopy() ([]byte, error) {
+	h, err := q.acquirePop()
+	if err != nil {
+		return nil, err
+	}
+	defer q.mu.RUnlock()
+
+	if q.held {
+		return nil, &QueueError{Op: "pop", Name: q.name, Errno: syscall.EINVAL}
+	}
+
+	for {
+		var data unsafe.Pointer
+		var size C.size_t
+		ret := C.nabd_peek(h, &data, &size)
+		if ret == C.NABD_EMPTY {
+			return nil, ErrEmpty
+		} else if ret != C.NABD_OK {
+			return nil, failure("pop", q.name, ret, nil)
+		}
+
+		var msg []byte
+		if size > 0 {
+			msg = unsafe.Slice((*byte)(data), int(size))
+		}
+		if q.env.holdback(msg) > 0 {
+			return nil, ErrEmpty
+		}
+		payload, st, err := q.env.open(msg, -1)
+		if err != nil {
+			q.deadLetter(DeadCorrupt, msg)
+			C.nabd_release(h)
+			return nil, err
+		}
+		if q.env.expired(st) {
+			q.dropPeeked(h, payload)
+			continue
+		}
+
+		q.latency.observe(st)
+		q.held = true
+		if payload == nil {
+			payload = []byte{}
+		}
+		return payload, nil
+	}
+}
+
+// Release hands the slot of the message returned by PopZeroCopy back to
+// the producer, consuming the message. Calling it again, or without an
+// outstanding message, returns ErrReleased and changes nothing.
+func (q *Queue) Release() error {
+	h, err := q.acquirePop()
+	if err != nil {
+		return err
+	}
+	defer q.mu.RUnlock()
+
+	if !q.held {
+		return ErrReleased
+	}
+	q.held = false
+	if ret := C.nabd_release(h); ret != C.NABD_OK {
+		return failure("release", q.name, ret, nil)
+	}
+	return nil
+}
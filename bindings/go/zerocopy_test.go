@@ -0,0 +1,242 @@
+package nabd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestReserveCommit(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.Commit(); err != ErrNoReservation {
+		t.Errorf("Expected ErrNoReservation, got %v", err)
+	}
+
+	buf, err := q.Reserve(5)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	copy(buf, "hello")
+	if err := q.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+
+	// Nothing is visible, and plain pushes wait, until Commit
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected reserved slot to be invisible, got len %d", n)
+	}
+	if err := q.Push([]byte("x")); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL while reserved, got %v", err)
+	}
+	if err := q.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	msg, seq, err := q.PopSeq(64)
+	if err != nil || string(msg) != "hello" || seq != 0 {
+		t.Errorf("Expected hello with seq 0, got %q with seq %d, %v", msg, seq, err)
+	}
+
+	if _, err := q.Reserve(100); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+}
+
+func TestReserveAbort(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := Open(TestQueue, 4, 64, Create|Producer|Consumer)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer q.Close()
+
+	// Reserve the last free slot, then give it back
+	for _, m := range []string{"a", "b", "c"} {
+		if err := q.Push([]byte(m)); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	before, _ := q.Stats()
+	buf, err := q.Reserve(4)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	copy(buf, "junk")
+	if err := q.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if err := q.Abort(); err != ErrNoReservation {
+		t.Errorf("Expected ErrNoReservation, got %v", err)
+	}
+
+	// The ring looks exactly as it did before Reserve
+	after, _ := q.Stats()
+	if after.Pushes != before.Pushes || after.BytesPushed != before.BytesPushed {
+		t.Errorf("Abort changed the counters: %+v, was %+v", after, before)
+	}
+	if n, _ := q.Len(); n != 3 {
+		t.Errorf("Expected 3 messages after Abort, got %d", n)
+	}
+	if free, _ := q.FreeSlots(); free != 1 {
+		t.Errorf("Expected the aborted slot to be free, got %d free", free)
+	}
+
+	if err := q.Push([]byte("real")); err != nil {
+		t.Fatalf("Push after Abort failed: %v", err)
+	}
+	for _, want := range []string{"a", "b", "c", "real"} {
+		if msg, err := q.Pop(64); err != nil || string(msg) != want {
+			t.Errorf("Expected %s, got %q, %v", want, msg, err)
+		}
+	}
+	if _, err := q.Pop(64); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestReserveOverwriting(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithFlags(Create | Producer | Broadcast)},
+		{WithFlags(Create | Producer), WithOverwrite()},
+	} {
+		Unlink(TestQueue)
+		q, err := OpenWithOptions(TestQueue, append(opts, WithCapacity(2), WithSlotSize(64))...)
+		if err != nil {
+			t.Fatalf("OpenWithOptions failed: %v", err)
+		}
+		c, err := Open(TestQueue, 0, 0, Consumer)
+		if err != nil {
+			t.Fatalf("Consumer open failed: %v", err)
+		}
+
+		// A full ring keeps its oldest message, since Abort could not
+		// bring it back once a reservation had evicted it
+		q.Push([]byte("a"))
+		q.Push([]byte("b"))
+		if _, err := q.Reserve(4); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("Expected EINVAL, got %v", err)
+		}
+		if err := q.Abort(); err != ErrNoReservation {
+			t.Errorf("Expected ErrNoReservation, got %v", err)
+		}
+		if msg, err := c.Pop(64); err != nil || string(msg) != "a" {
+			t.Errorf("Expected a, got %q, %v", msg, err)
+		}
+
+		// PushVectored still works, joining the chunks first
+		if err := q.PushVectored([][]byte{[]byte("c"), []byte("d")}); err != nil {
+			t.Errorf("PushVectored failed: %v", err)
+		}
+		if err := q.PushVectored([][]byte{make([]byte, 100)}); err != ErrTooBig {
+			t.Errorf("Expected ErrTooBig, got %v", err)
+		}
+		if msg, err := c.Pop(64); err != nil || string(msg) != "b" {
+			t.Errorf("Expected b, got %q, %v", msg, err)
+		}
+		if msg, err := c.Pop(64); err != nil || string(msg) != "cd" {
+			t.Errorf("Expected cd, got %q, %v", msg, err)
+		}
+		c.Close()
+		q.Close()
+	}
+	Unlink(TestQueue)
+}
+
+func TestPushVectored(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(4),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum(),
+		WithSequence())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	if err := q.PushVectored([][]byte{[]byte("head:"), nil, []byte("body"), []byte(";")}); err != nil {
+		t.Fatalf("PushVectored failed: %v", err)
+	}
+	msg, seq, err := q.PopSeq(64)
+	if err != nil || string(msg) != "head:body;" || seq != 0 {
+		t.Errorf("Expected head:body; with seq 0, got %q with seq %d, %v", msg, seq, err)
+	}
+
+	// The total counts, not the largest chunk
+	big := [][]byte{make([]byte, 30), make([]byte, 30)}
+	if err := q.PushVectored(big); err != ErrTooBig {
+		t.Errorf("Expected ErrTooBig, got %v", err)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Expected nothing pushed, got len %d", n)
+	}
+}
+
+func TestPopZeroCopy(t *testing.T) {
+	Unlink(TestQueue)
+	defer Unlink(TestQueue)
+
+	q, err := OpenWithOptions(TestQueue,
+		WithCapacity(2),
+		WithSlotSize(64),
+		WithFlags(Create|Producer|Consumer),
+		WithChecksum())
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer q.Close()
+
+	q.Push([]byte("first"))
+	q.Push([]byte("second"))
+
+	msg, err := q.PopZeroCopy()
+	if err != nil || string(msg) != "first" {
+		t.Fatalf("Expected first, got %q, %v", msg, err)
+	}
+	if _, err := q.PopZeroCopy(); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Expected EINVAL while a message is held, got %v", err)
+	}
+
+	// The held slot is not free until Release
+	if err := q.Push([]byte("third")); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+	if err := q.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := q.Release(); err != ErrReleased {
+		t.Errorf("Expected ErrReleased on double Release, got %v", err)
+	}
+	if err := q.Push([]byte("third")); err != nil {
+		t.Errorf("Push after Release failed: %v", err)
+	}
+
+	for _, want := range []string{"second", "third"} {
+		msg, err := q.PopZeroCopy()
+		if err != nil || string(msg) != want {
+			t.Fatalf("Expected %s, got %q, %v", want, msg, err)
+		}
+		q.Release()
+	}
+	if _, err := q.PopZeroCopy(); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty, got %v", err)
+	}
+}
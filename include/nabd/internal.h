@@ -108,6 +108,18 @@
 #define NABD_STORE_RELEASE(ptr, val)                                           \
   atomic_store_explicit((ptr), (val), memory_order_release)
 
+/* Bump a counter that only the calling side writes (no RMW needed) */
+#define NABD_COUNTER_ADD(ptr, n)                                               \
+  NABD_STORE_RELAXED((ptr), NABD_LOAD_RELAXED(ptr) + (n))
+
+/* Raise a single-writer counter to at least val */
+#define NABD_COUNTER_MAX(ptr, val)                                             \
+  do {                                                                         \
+    uint64_t _v = (val);                                                       \
+    if (_v > NABD_LOAD_RELAXED(ptr))                                           \
+      NABD_STORE_RELAXED((ptr), _v);                                           \
+  } while (0)
+
 /* Compare-and-swap with acquire-release ordering */
 #define NABD_CAS_ACQ_REL(ptr, expected, desired)                               \
   atomic_compare_exchange_weak_explicit((ptr), (expected), (desired),          \
@@ -163,6 +175,21 @@ NABD_INLINE void nabd_spin_wait(int iterations) {
   }
 }
 
+/*
+ * ============================================================================
+ * Platform
+ * ============================================================================
+ */
+
+/* Directory for notification FIFOs; macOS has no /dev/shm */
+#ifndef NABD_FIFO_DIR
+#ifdef __APPLE__
+#define NABD_FIFO_DIR "/tmp"
+#else
+#define NABD_FIFO_DIR "/dev/shm"
+#endif
+#endif
+
 /*
  * ============================================================================
  * Debug Helpers
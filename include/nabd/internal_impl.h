@@ -29,11 +29,24 @@ struct nabd {
   size_t capacity;  /* Number of slots */
   size_t slot_size; /* Bytes per slot */
   size_t mask;      /* capacity - 1 for fast modulo */
+  uint64_t layout;  /* ctrl->layout this mapping was made for */
 
   /* Zero-copy state */
   int reserved;         /* Whether a slot is reserved */
   uint64_t reserve_pos; /* Reserved slot position */
 
+  /* Broadcast and overwrite state */
+  int broadcast;   /* Queue was created with NABD_BROADCAST */
+  int overwrite;   /* Queue was created with NABD_OVERWRITE */
+  uint64_t cursor; /* Private read position (broadcast), or expected
+                      tail (overwrite) */
+
+  /* Event loop integration (-1 until opened) */
+  int notify_fd; /* Notification FIFO */
+
+  /* Attach table entries, shared by every opener (NULL once closed) */
+  _Atomic uint64_t *refs;
+
   /* Multi-consumer extension (NULL if not used) */
   nabd_multi_consumer_t *multi; /* Multi-consumer control block */
 };
@@ -69,4 +82,40 @@ NABD_INLINE void *nabd_get_slot_payload(struct nabd *q, uint64_t index) {
   return (uint8_t *)nabd_get_slot(q, index) + sizeof(nabd_slot_header_t);
 }
 
+/*
+ * Notification hooks (implemented in notify.c)
+ */
+void nabd_notify_signal(struct nabd *q, uint64_t pos);
+void nabd_notify_rearm(struct nabd *q);
+void nabd_notify_close(struct nabd *q);
+void nabd_notify_unlink(const char *name);
+
+/*
+ * Attach reference counting (implemented in attach.c)
+ */
+int nabd_attach_register(struct nabd *q);
+int nabd_attach_release(struct nabd *q, int count);
+void nabd_attach_unlink(const char *name);
+
+/*
+ * Segment sizing (implemented in nabd.c)
+ */
+int nabd_size_segment(int fd, size_t size);
+
+/*
+ * Helper: Signal waiting consumers after publishing messages from pos
+ */
+NABD_INLINE void nabd_notify_push(struct nabd *q, uint64_t pos) {
+  if (NABD_UNLIKELY(NABD_LOAD_RELAXED(&q->ctrl->notify)))
+    nabd_notify_signal(q, pos);
+}
+
+/*
+ * Helper: Drain and re-check the notification FIFO after an empty pop
+ */
+NABD_INLINE void nabd_notify_empty(struct nabd *q) {
+  if (NABD_UNLIKELY(q->notify_fd >= 0))
+    nabd_notify_rearm(q);
+}
+
 #endif /* NABD_INTERNAL_IMPL_H */
@@ -37,10 +37,13 @@ typedef struct {
   uint64_t slot_size;  /* Bytes per slot */
   uint64_t used_bytes; /* Approximate bytes in use */
   int fill_pct;        /* Fill percentage (0-100) */
+  uint64_t high_water; /* Maximum depth observed since creation/reset */
 
   /* Throughput metrics (if tracking enabled) */
   uint64_t total_pushed; /* Total messages pushed */
   uint64_t total_popped; /* Total messages popped */
+  uint64_t bytes_pushed; /* Total payload bytes pushed */
+  uint64_t bytes_popped; /* Total payload bytes popped */
   uint64_t push_per_sec; /* Recent push rate */
   uint64_t pop_per_sec;  /* Recent pop rate */
 
@@ -82,6 +85,24 @@ typedef struct {
  */
 int nabd_get_metrics(nabd_t *q, nabd_metrics_t *metrics);
 
+/**
+ * Get the high-water mark (maximum depth observed on push)
+ *
+ * @param q  Queue handle
+ *
+ * @return Maximum number of pending messages seen, 0 on error
+ */
+uint64_t nabd_high_water(nabd_t *q);
+
+/**
+ * Reset the high-water mark to the current depth
+ *
+ * @param q  Queue handle
+ *
+ * @return NABD_OK on success
+ */
+int nabd_reset_high_water(nabd_t *q);
+
 /**
  * Take a metrics snapshot (lightweight)
  *
@@ -12,6 +12,7 @@
 
 #include "types.h"
 #include <stddef.h>
+#include <sys/types.h>
 
 #ifdef __cplusplus
 extern "C" {
@@ -29,10 +30,35 @@ extern "C" {
  * @param name      Shared memory name (will be prefixed with /dev/shm/)
  * @param capacity  Number of slots in ring buffer (must be power of 2)
  * @param slot_size Maximum message size per slot (including header)
- * @param flags     NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER
+ * @param flags     NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER,
+ *                  optionally NABD_BROADCAST or NABD_OVERWRITE when
+ *                  creating, NABD_MLOCK to lock the mapping into RAM and
+ *                  NABD_UNLINK_LAST to remove the queue on its last close
  *
  * @return Handle on success, NULL on failure (check errno)
  *
+ * In broadcast mode every consumer handle receives every message through
+ * its own cursor. The producer never blocks; a consumer that falls more
+ * than capacity messages behind gets NABD_LAPPED and skips ahead.
+ *
+ * In overwrite mode a push to a full ring discards the oldest message
+ * instead of failing. The consumer's next pop reports NABD_LAPPED once,
+ * then continues with the oldest message left.
+ *
+ * NABD_MLOCK needs CAP_IPC_LOCK or a large enough RLIMIT_MEMLOCK; if
+ * mlock fails, the open fails with its errno (EPERM or ENOMEM).
+ *
+ * NABD_UNLINK_LAST marks the queue so that whichever handle closes last,
+ * with or without the flag, also unlinks it (see nabd_attached). A crashed
+ * process never closes, so its queue persists until cleaned up.
+ *
+ * Attaching to a segment without the NABD magic, or with a layout version
+ * other than NABD_LAYOUT_VERSION, fails with EPROTO.
+ *
+ * On macOS names are limited to 31 bytes, and the library appends ".ref"
+ * for the attach table, so queue names there must be 27 bytes or fewer.
+ * Notification FIFOs live in /tmp instead of /dev/shm.
+ *
  * Example:
  *   // Producer creates the queue
  *   nabd_t* q = nabd_open("myqueue", 1024, 4096,
@@ -44,6 +70,50 @@ extern "C" {
 nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
                   int flags);
 
+/**
+ * Open or create a NABD queue with explicit permissions
+ *
+ * @param name      Shared memory name
+ * @param capacity  Number of slots in ring buffer (must be power of 2)
+ * @param slot_size Maximum message size per slot (including header)
+ * @param flags     As for nabd_open
+ * @param mode      Permission bits (e.g. 0660) for a newly created segment,
+ *                  or 0 for 0666 filtered by the umask
+ *
+ * @return Handle on success, NULL on failure (check errno)
+ *
+ * A nonzero mode is applied exactly, regardless of the umask, so a queue
+ * can be shared with a group. It is ignored when attaching to an existing
+ * queue. Modes outside 0777 fail with EINVAL. On macOS the umask still
+ * filters a nonzero mode.
+ */
+nabd_t *nabd_open_mode(const char *name, size_t capacity, size_t slot_size,
+                       int flags, mode_t mode);
+
+/**
+ * Open or create a NABD queue with explicit permissions and group
+ *
+ * @param name      Queue name, as for nabd_open
+ * @param capacity  Number of slots, as for nabd_open
+ * @param slot_size Size of each slot in bytes, as for nabd_open
+ * @param flags     NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER, etc.
+ * @param mode      Permission bits for a newly created segment, as for
+ *                  nabd_open_mode
+ * @param gid       Group to give a newly created segment, or (gid_t)-1 to
+ *                  keep the creator's
+ *
+ * @return Handle on success, NULL on failure (check errno)
+ *
+ * The segment is chowned to gid right after it is created, before any
+ * other process can attach; the attach table and notification FIFO follow
+ * its group. Combined with mode 0660 this lets consumers running as other
+ * users in that group attach. An unprivileged creator can only pick a
+ * group it belongs to: otherwise the open fails with errno EPERM and the
+ * segment is removed again. Like mode, gid is ignored when attaching.
+ */
+nabd_t *nabd_open_group(const char *name, size_t capacity, size_t slot_size,
+                        int flags, mode_t mode, gid_t gid);
+
 /**
  * Close a NABD queue
  *
@@ -51,11 +121,45 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
  *
  * @return NABD_OK on success, error code on failure
  *
- * Note: This does NOT unlink the shared memory. Call nabd_unlink()
- *       to remove the shared memory segment.
+ * Note: This does NOT unlink the shared memory, unless the queue was
+ *       opened with NABD_UNLINK_LAST and this is the last handle. Call
+ *       nabd_unlink() to remove the shared memory segment.
  */
 int nabd_close(nabd_t *q);
 
+/**
+ * Change the number of slots of a queue
+ *
+ * @param q         Handle from nabd_open
+ * @param capacity  New number of slots (rounded up to a power of 2)
+ *
+ * @return NABD_OK on success
+ *         NABD_INVALID if shrinking a queue that is not empty, or while a
+ *         slot is reserved
+ *         NABD_NOMEM or NABD_SYSERR if the segment could not be remapped
+ *
+ * Pending messages keep their positions. A broadcast ring keeps the most
+ * recent messages that fit. The queue must be quiesced: no other handle
+ * may be inside a call while it runs. Afterwards every other handle gets
+ * NABD_RESIZED until it calls nabd_remap.
+ *
+ * macOS cannot resize a shared-memory object, so growing a queue fails
+ * there with NABD_SYSERR and errno EINVAL.
+ */
+int nabd_resize(nabd_t *q, size_t capacity);
+
+/**
+ * Map the ring again after another handle resized it
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return NABD_OK on success (also when nothing changed), error code on
+ *         failure
+ *
+ * Pointers from nabd_reserve or nabd_peek are invalid afterwards.
+ */
+int nabd_remap(nabd_t *q);
+
 /**
  * Unlink (remove) shared memory segment
  *
@@ -67,6 +171,52 @@ int nabd_close(nabd_t *q);
  */
 int nabd_unlink(const char *name);
 
+/**
+ * Check whether a shared memory segment exists
+ *
+ * @param name  Shared memory name
+ *
+ * @return 1 if it exists, 0 if not
+ *         NABD_SYSERR if it cannot be checked (e.g. EACCES, see errno)
+ *
+ * Nothing is created or mapped.
+ */
+int nabd_exists(const char *name);
+
+/**
+ * Count the handles attached to a queue
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return Number of open handles across all live processes (at least 1,
+ *         counting q itself), NABD_INVALID if q is NULL
+ *
+ * Every nabd_open registers in a table kept next to the segment and
+ * nabd_close deregisters. Entries left by processes that died without
+ * closing are cleared here once their PID no longer exists. The check is
+ * kill(pid, 0), so a reused PID keeps a stale entry alive until that
+ * process exits, and processes in other PID namespaces are only seen
+ * correctly if they share the caller's.
+ *
+ * At most NABD_ATTACH_SLOTS processes can hold a queue open at once; the
+ * next nabd_open fails with errno ENOSPC.
+ */
+int nabd_attached(nabd_t *q);
+
+/**
+ * Unlink a queue only if no live process has it open
+ *
+ * @param name  Shared memory name
+ *
+ * @return 1 if the queue was removed, 0 if it is still attached,
+ *         error code on failure (as nabd_unlink)
+ *
+ * A process may still open the queue between the check and the unlink;
+ * it keeps working on the removed segment, which the OS frees when it
+ * closes.
+ */
+int nabd_unlink_idle(const char *name);
+
 /*
  * ============================================================================
  * Producer Functions
@@ -88,6 +238,65 @@ int nabd_unlink(const char *name);
  */
 int nabd_push(nabd_t *q, const void *data, size_t len);
 
+/**
+ * Push a message that may be larger than one slot (non-blocking)
+ *
+ * @param q     Handle from nabd_open
+ * @param data  Pointer to message data
+ * @param len   Length of message in bytes
+ *
+ * @return NABD_OK on success
+ *         NABD_FULL if there is no room for every chunk; nothing is
+ *         published
+ *         NABD_TOOBIG if the message needs more slots than capacity
+ *         NABD_INVALID in broadcast or overwrite mode
+ *
+ * A message that fits is pushed exactly like nabd_push. A larger one is
+ * split over consecutive slots, flagged NABD_SLOT_MORE, and published with
+ * a single head update. nabd_pop and nabd_pop_batch reassemble it
+ * transparently; nabd_peek and nabd_peek_at report NABD_TOOBIG for it.
+ */
+int nabd_push_large(nabd_t *q, const void *data, size_t len);
+
+/**
+ * Push several messages in one call (non-blocking)
+ *
+ * @param q       Handle from nabd_open
+ * @param data    Messages packed back to back
+ * @param lens    Array of message lengths
+ * @param count   Number of messages
+ * @param pushed  Output: number of messages pushed
+ *
+ * @return NABD_OK if all messages were pushed
+ *         NABD_FULL if the buffer filled before the batch was done
+ *         NABD_TOOBIG if message *pushed exceeds slot_size
+ *
+ * Messages before the failing one are published. The head is updated
+ * once for the whole batch.
+ */
+int nabd_push_batch(nabd_t *q, const void *data, const size_t *lens,
+                    size_t count, size_t *pushed);
+
+/**
+ * Push several messages in one call, all or none (non-blocking)
+ *
+ * @param q      Handle from nabd_open
+ * @param data   Messages packed back to back
+ * @param lens   Array of message lengths
+ * @param count  Number of messages
+ *
+ * @return NABD_OK if all messages were pushed
+ *         NABD_FULL if the buffer has no room for the whole batch
+ *         NABD_TOOBIG if any message exceeds slot_size
+ *         NABD_INVALID if count exceeds the capacity
+ *
+ * Every length and the free space are checked before the first slot is
+ * written, so on failure nothing is published. Broadcast and overwrite
+ * queues never report NABD_FULL.
+ */
+int nabd_push_batch_atomic(nabd_t *q, const void *data, const size_t *lens,
+                           size_t count);
+
 /**
  * Reserve a slot for zero-copy writing
  *
@@ -98,8 +307,12 @@ int nabd_push(nabd_t *q, const void *data, size_t len);
  * @return NABD_OK on success
  *         NABD_FULL if buffer is full
  *         NABD_TOOBIG if message exceeds slot_size
+ *         NABD_INVALID in broadcast or overwrite mode, or while a slot is
+ *         already reserved
  *
- * Must call nabd_commit() after writing to complete the push.
+ * Must call nabd_commit() after writing to complete the push, or
+ * nabd_abort() to give the slot up. While a slot is reserved, nabd_push
+ * and nabd_push_batch on the same handle fail with NABD_INVALID.
  */
 int nabd_reserve(nabd_t *q, size_t len, void **slot);
 
@@ -113,6 +326,36 @@ int nabd_reserve(nabd_t *q, size_t len, void **slot);
  */
 int nabd_commit(nabd_t *q, size_t len);
 
+/**
+ * Abandon a reserved slot without publishing it
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return NABD_OK on success
+ *         NABD_INVALID if no slot is reserved
+ *
+ * Head is left untouched, so the next push reuses the slot. Nothing else
+ * changed either, since reservations are not available in the modes that
+ * overwrite messages.
+ */
+int nabd_abort(nabd_t *q);
+
+/**
+ * Issue a full memory fence
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return NABD_OK on success
+ *         NABD_INVALID if q is NULL
+ *
+ * Pushes and commits already publish head with a release store, and pops
+ * load it with acquire, so a consumer never sees a slot before its
+ * payload. nabd_flush is for callers that share state outside the ring,
+ * or write a reserved slot from several threads, and want every earlier
+ * store ordered before every later one on weakly ordered CPUs.
+ */
+int nabd_flush(nabd_t *q);
+
 /*
  * ============================================================================
  * Consumer Functions
@@ -129,9 +372,54 @@ int nabd_commit(nabd_t *q, size_t len);
  * @return NABD_OK on success
  *         NABD_EMPTY if buffer is empty
  *         NABD_TOOBIG if message exceeds buffer capacity
+ *         NABD_LAPPED if the consumer was overtaken (broadcast)
  */
 int nabd_pop(nabd_t *q, void *buf, size_t *len);
 
+/**
+ * Pop several messages in one call (non-blocking)
+ *
+ * @param q       Handle from nabd_open
+ * @param buf     Output buffer of count * stride bytes
+ * @param stride  Bytes reserved per message; message i lands at i * stride
+ * @param lens    Output: length of each popped message
+ * @param count   Maximum number of messages to pop
+ * @param popped  Output: number of messages popped
+ *
+ * @return NABD_OK if at least one message was popped
+ *         NABD_EMPTY if buffer is empty
+ *         NABD_TOOBIG if message *popped exceeds stride (left in queue)
+ *         NABD_LAPPED if the consumer was overtaken (broadcast); the
+ *         first *popped messages are still valid
+ *
+ * The tail is updated once for the whole batch.
+ */
+int nabd_pop_batch(nabd_t *q, void *buf, size_t stride, size_t *lens,
+                   size_t count, size_t *popped);
+
+/**
+ * Pop several messages into separate buffers (non-blocking)
+ *
+ * @param q       Handle from nabd_open
+ * @param bufs    Output buffers, one per message
+ * @param sizes   Size of each buffer in bufs
+ * @param lens    Output: length of each popped message
+ * @param count   Maximum number of messages to pop
+ * @param popped  Output: number of messages popped
+ *
+ * @return NABD_OK if at least one message was popped
+ *         NABD_EMPTY if buffer is empty
+ *         NABD_TOOBIG if message *popped does not fit in bufs[*popped]; it
+ *         stays queued and the first *popped messages are still valid
+ *         NABD_LAPPED if the consumer was overtaken (broadcast); the
+ *         first *popped messages are still valid
+ *
+ * Like nabd_pop_batch, but message i lands in bufs[i] and must fit in
+ * sizes[i], so a caller can reuse buffers of different sizes.
+ */
+int nabd_pop_batchv(nabd_t *q, void *const *bufs, const size_t *sizes,
+                    size_t *lens, size_t count, size_t *popped);
+
 /**
  * Peek at next message without removing it
  *
@@ -141,11 +429,29 @@ int nabd_pop(nabd_t *q, void *buf, size_t *len);
  *
  * @return NABD_OK on success
  *         NABD_EMPTY if buffer is empty
+ *         NABD_INVALID in broadcast mode
  *
  * Warning: The returned pointer is only valid until nabd_release() is called.
  */
 int nabd_peek(nabd_t *q, const void **data, size_t *len);
 
+/**
+ * Peek at the unconsumed message at an absolute position
+ *
+ * @param q     Handle from nabd_open
+ * @param pos   Position between tail and head, as in nabd_stats
+ * @param data  Output: pointer to message data (read-only!)
+ * @param len   Output: message length
+ *
+ * @return NABD_OK on success
+ *         NABD_EMPTY if pos is at or past head
+ *         NABD_INVALID if pos was already consumed, or in broadcast and
+ *         overwrite modes
+ *
+ * The pointer stays valid until tail moves past pos.
+ */
+int nabd_peek_at(nabd_t *q, uint64_t pos, const void **data, size_t *len);
+
 /**
  * Release a previously peeked message
  *
@@ -155,6 +461,19 @@ int nabd_peek(nabd_t *q, const void **data, size_t *len);
  */
 int nabd_release(nabd_t *q);
 
+/**
+ * Discard all pending messages
+ *
+ * @param q          Handle from nabd_open
+ * @param discarded  Output: number of messages discarded (may be NULL)
+ *
+ * @return NABD_OK on success
+ *
+ * Moves the tail to the current head in one step without copying.
+ * Messages pushed concurrently may or may not be discarded.
+ */
+int nabd_drain(nabd_t *q, uint64_t *discarded);
+
 /*
  * ============================================================================
  * Utility Functions
@@ -189,6 +508,134 @@ int nabd_empty(nabd_t *q);
  */
 int nabd_full(nabd_t *q);
 
+/**
+ * Get number of free slots
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return Slots a push can still fill before NABD_FULL, 0 if q is NULL
+ *
+ * Tail is read before head, so concurrent pushes and pops can only make
+ * the result lower than the true headroom, never higher. Other producers
+ * may still take the slots first. Broadcast and overwrite queues never
+ * report full and always return the capacity.
+ */
+uint64_t nabd_free(nabd_t *q);
+
+/**
+ * Get number of messages this handle's consumer has not read yet
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return Messages between the consumer position and head
+ *
+ * In broadcast mode this is the handle's private cursor and may exceed
+ * capacity once the consumer has been lapped.
+ */
+uint64_t nabd_lag(nabd_t *q);
+
+/**
+ * Get the size of the shared-memory mapping
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return Bytes mapped for the control block and every slot, 0 if q is
+ *         NULL
+ *
+ * This is the segment's footprint in /dev/shm. After another handle
+ * resized the ring it reports the old size until nabd_remap.
+ */
+size_t nabd_mapped_size(nabd_t *q);
+
+/**
+ * Check the shared header for damage
+ *
+ * @param q     Handle from nabd_open
+ * @param why   Buffer for a description of the problem, may be NULL if
+ *              size is 0
+ * @param size  Size of why in bytes
+ *
+ * @return NABD_OK if the header looks sound,
+ *         NABD_CORRUPTED with the reason in why otherwise,
+ *         NABD_RESIZED if another handle resized the ring (see
+ *         nabd_remap), NABD_INVALID on a NULL handle
+ *
+ * Checks the magic, layout version and ring geometry, that the segment
+ * has not been truncated, and that head and tail are within capacity of
+ * each other. It only reads the header, so it is cheap enough for a
+ * readiness probe, but it cannot detect damage to message payloads.
+ */
+int nabd_check(nabd_t *q, char *why, size_t size);
+
+/**
+ * Get the sequence number for the next stamped message
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return Next sequence number; 0 for a new queue
+ *
+ * The counter lives in the control block, so a producer that reattaches
+ * continues where the previous one stopped. Bindings that stamp messages
+ * read it before pushing and call nabd_seq_advance once the push
+ * succeeded. It wraps to 0 after 2^64 - 1.
+ */
+uint64_t nabd_seq(nabd_t *q);
+
+/**
+ * Advance the message sequence counter
+ *
+ * @param q  Handle from nabd_open
+ * @param n  Number of stamped messages pushed
+ */
+void nabd_seq_advance(nabd_t *q, uint64_t n);
+
+/**
+ * Set or clear the draining flag in the control block
+ *
+ * @param q   Handle from nabd_open
+ * @param on  Nonzero to announce a shutdown, 0 to clear it
+ *
+ * @return NABD_OK on success, NABD_INVALID on a NULL handle
+ *
+ * A producer sets the flag before it stops pushing so consumers know to
+ * finish the backlog. Opening a handle with NABD_PRODUCER clears it.
+ */
+int nabd_set_draining(nabd_t *q, int on);
+
+/**
+ * Check whether a producer announced a shutdown
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return 1 if the draining flag is set, 0 if not, NABD_INVALID on a
+ *         NULL handle
+ */
+int nabd_draining(nabd_t *q);
+
+/**
+ * Get the metadata recorded when the queue was created
+ *
+ * @param q     Handle from nabd_open
+ * @param info  Output: creator, creation time and geometry
+ *
+ * @return NABD_OK on success
+ *
+ * Works on any handle. The creator PID lets tools tell whether the
+ * process that made an orphaned queue is still alive; PIDs are reused, so
+ * compare the creation time with the process start time to be sure.
+ */
+int nabd_info(nabd_t *q, nabd_info_t *info);
+
+/**
+ * Get the mode the queue was created with
+ *
+ * @param q  Handle from nabd_open
+ *
+ * @return NABD_BROADCAST, NABD_OVERWRITE or 0; NABD_INVALID for a NULL
+ *         handle
+ */
+int nabd_mode(nabd_t *q);
+
 /**
  * Get error string for error code
  *
@@ -263,6 +710,28 @@ int nabd_consumer_pop(nabd_consumer_t *c, void *buf, size_t *len);
  */
 int nabd_consumer_peek(nabd_consumer_t *c, const void **data, size_t *len);
 
+/**
+ * Move the consumer position to an absolute position
+ *
+ * @param q    Handle from nabd_open
+ * @param pos  New read position, at most head
+ *
+ * @return NABD_OK on success
+ *         NABD_LAPPED if the slot for pos may already be overwritten
+ *         NABD_INVALID if pos is past head, or in overwrite mode
+ *
+ * Moving backwards replays messages that are consumed but still
+ * physically in the ring: the oldest reachable position is
+ * head - capacity + 1, since a push in progress may be rewriting the slot
+ * of head - capacity. In broadcast mode this moves the handle's private
+ * cursor; otherwise it moves the shared tail, so the producer must not
+ * mind the space being taken back. The tail moves by compare-and-swap and
+ * head is checked again afterwards, so a producer pushing meanwhile makes
+ * a rewind that reached too far back fail with NABD_LAPPED, the tail left
+ * where it was.
+ */
+int nabd_seek(nabd_t *q, uint64_t pos);
+
 /**
  * Release a peeked message for this consumer group
  *
@@ -0,0 +1,46 @@
+/*
+ * NABD - High-Performance Shared Memory IPC
+ *
+ * Event Loop Integration
+ *
+ * Copyright (c) 2025 Mohamed Yasser
+ * Licensed under MIT License
+ */
+
+#ifndef NABD_NOTIFY_H
+#define NABD_NOTIFY_H
+
+#include "nabd.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/**
+ * Get a file descriptor that is readable while messages are pending
+ *
+ * @param q  Consumer handle from nabd_open
+ *
+ * @return File descriptor on success
+ *         NABD_INVALID for producer-only or broadcast handles
+ *         NABD_SYSERR if the notification FIFO cannot be created
+ *
+ * The descriptor is a FIFO shared through the filesystem next to the
+ * segment, so producers in other processes can signal it. Register it
+ * with poll/epoll/kqueue and call nabd_pop when it fires.
+ *
+ * The descriptor stays readable until a pop on this handle returns
+ * NABD_EMPTY, which drains it. Level-triggered polling therefore works
+ * with any number of pops per wakeup; with edge-triggered polling, pop
+ * until NABD_EMPTY before waiting again. Spurious wakeups are possible.
+ *
+ * The descriptor is owned by the handle and closed by nabd_close.
+ * Signalling costs producers nothing until a consumer has asked for it.
+ */
+int nabd_notify_fd(nabd_t *q);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* NABD_NOTIFY_H */
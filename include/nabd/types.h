@@ -12,6 +12,7 @@
 
 #include <stdalign.h>
 #include <stdatomic.h>
+#include <stddef.h>
 #include <stdint.h>
 
 /*
@@ -22,15 +23,23 @@
 
 /*
  * Magic number for shared memory validation
- * ASCII: "NABD" + version marker
+ * ASCII: "NABD" + version marker. Stored as a native-endian uint64, like
+ * every other header field: a segment never leaves the host that made it.
  */
 #define NABD_MAGIC 0x4442414E00010000ULL /* "NABD" + v1.0 */
 
 /*
  * Protocol version
+ *
+ * Stored in the control block as NABD_LAYOUT_VERSION. nabd_open refuses to
+ * attach to a queue with any other value (errno EPROTO), since it would
+ * misread the header. The minor version goes up with every change to the
+ * control block or slot header. 0.2 covers what grew since 0.1, such as the
+ * counters, the creator PID, the attach count and NABD_SLOT_MORE.
  */
 #define NABD_VERSION_MAJOR 0
-#define NABD_VERSION_MINOR 1
+#define NABD_VERSION_MINOR 2
+#define NABD_LAYOUT_VERSION ((NABD_VERSION_MAJOR << 16) | NABD_VERSION_MINOR)
 
 /*
  * Default configuration
@@ -38,12 +47,21 @@
 #define NABD_DEFAULT_SLOT_SIZE 4096
 #define NABD_DEFAULT_CAPACITY 1024
 
+/*
+ * Processes that can hold a queue open at once (see nabd_attached)
+ */
+#define NABD_ATTACH_SLOTS 256
+
 /*
  * Flags for nabd_open
  */
 #define NABD_CREATE 0x01   /* Create new shared memory region */
 #define NABD_PRODUCER 0x02 /* Open as producer */
 #define NABD_CONSUMER 0x04 /* Open as consumer */
+#define NABD_BROADCAST 0x08 /* Fan-out: every consumer sees every message */
+#define NABD_MLOCK 0x10     /* Lock the mapping into RAM (mlock) */
+#define NABD_OVERWRITE 0x20 /* When full, push overwrites the oldest slot */
+#define NABD_UNLINK_LAST 0x40 /* Unlink when the last handle closes */
 
 /*
  * Error codes
@@ -60,7 +78,9 @@ typedef enum {
   NABD_CORRUPTED = -8,   /* Data corruption detected */
   NABD_VERSION = -9,     /* Version mismatch */
   NABD_PERMISSION = -10, /* Permission denied */
-  NABD_SYSERR = -11      /* System error (check errno) */
+  NABD_SYSERR = -11,     /* System error (check errno) */
+  NABD_LAPPED = -12,     /* Consumer overtaken by producer */
+  NABD_RESIZED = -13     /* Ring resized by another handle; remap */
 } nabd_error_t;
 
 /*
@@ -68,15 +88,18 @@ typedef enum {
  *
  * Layout:
  *   [0:1]  length   - payload length (max 65535 bytes)
- *   [2:3]  flags    - reserved for future use
+ *   [2:3]  flags    - NABD_SLOT_* bits
  *   [4:7]  sequence - sequence number for debugging
  */
 typedef struct {
   uint16_t length;   /* Payload length */
-  uint16_t flags;    /* Reserved flags */
+  uint16_t flags;    /* Slot flags */
   uint32_t sequence; /* Sequence number */
 } nabd_slot_header_t;
 
+/* Slot flags */
+#define NABD_SLOT_MORE 0x0001 /* Message continues in the next slot */
+
 /*
  * Control block - located at the start of shared memory
  *
@@ -91,21 +114,31 @@ typedef struct {
   uint64_t capacity;      /* Number of slots */
   uint64_t slot_size;     /* Bytes per slot (including header) */
   uint64_t buffer_offset; /* Offset to ring buffer start */
-  uint64_t reserved_0;    /* Future extensions */
-  uint64_t reserved_1;    /* Future extensions */
-  uint64_t reserved_2;    /* Future extensions */
+  uint64_t mode;          /* Mode flags fixed at creation (BROADCAST etc.) */
+  uint64_t creator_pid;   /* Process that created the queue */
+  uint64_t created_ns;    /* Creation time in Unix nanoseconds */
 
   /* Second cache line (64 bytes) - Producer writes here */
   alignas(NABD_CACHE_LINE_SIZE) _Atomic uint64_t head; /* Next write position */
-  uint64_t head_pad[7]; /* Padding to fill cache line */
+  _Atomic uint64_t full_events;  /* Pushes that found the buffer full */
+  _Atomic uint64_t bytes_pushed; /* Payload bytes pushed */
+  _Atomic uint64_t high_water;   /* Maximum depth observed by the producer */
+  uint64_t head_pad[4];          /* Padding to fill cache line */
 
   /* Third cache line (64 bytes) - Consumer writes here */
   alignas(NABD_CACHE_LINE_SIZE) _Atomic uint64_t tail; /* Next read position */
-  uint64_t tail_pad[7]; /* Padding to fill cache line */
+  _Atomic uint64_t empty_events; /* Pops that found the buffer empty */
+  _Atomic uint64_t bytes_popped; /* Payload bytes popped */
+  uint64_t tail_pad[5];          /* Padding to fill cache line */
 
-  /* Fourth cache line (64 bytes) - Reserved for future */
-  alignas(NABD_CACHE_LINE_SIZE) uint64_t
-      reserved_ext[8]; /* Future extensions */
+  /* Fourth cache line (64 bytes) - Rarely written shared state */
+  alignas(NABD_CACHE_LINE_SIZE) _Atomic uint64_t
+      notify;                /* Nonzero once a consumer uses a notify fd */
+  _Atomic uint64_t next_seq; /* Next message sequence number (bindings) */
+  _Atomic uint64_t layout;   /* Bumped each time the ring is resized */
+  _Atomic uint64_t unlink_last; /* Nonzero once opened with UNLINK_LAST */
+  _Atomic uint64_t draining;    /* Nonzero while a producer shuts down */
+  uint64_t reserved_ext[3];     /* Future extensions */
 
 } nabd_control_t;
 
@@ -179,6 +212,18 @@ typedef struct {
   uint64_t slot_size; /* Bytes per slot */
 } nabd_stats_t;
 
+/*
+ * Creation metadata, read from the control block
+ */
+typedef struct {
+  uint64_t version;     /* Layout version, NABD_LAYOUT_VERSION */
+  uint64_t capacity;    /* Total slots */
+  uint64_t slot_size;   /* Bytes per slot */
+  uint64_t mode;        /* NABD_BROADCAST, NABD_OVERWRITE or 0 */
+  uint64_t creator_pid; /* Process that created the queue */
+  uint64_t created_ns;  /* Creation time in Unix nanoseconds */
+} nabd_info_t;
+
 /*
  * Consumer group statistics
  */
@@ -0,0 +1,222 @@
+/*
+ * NABD - High-Performance Shared Memory IPC
+ *
+ * Attach Reference Counting
+ *
+ * Copyright (c) 2025 Mohamed Yasser
+ * Licensed under MIT License
+ */
+
+#include "../include/nabd/nabd.h"
+#include "../include/nabd/internal_impl.h"
+
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <signal.h>
+#include <stdio.h>
+#include <sys/mman.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+/*
+ * Attach table
+ *
+ * Each queue has a sidecar segment "<name>.ref" holding NABD_ATTACH_SLOTS
+ * entries. An entry packs a PID in the high 32 bits and the number of
+ * handles that process has open in the low 32 bits; zero means free. All
+ * updates are single-word CAS, so a crash never leaves a torn entry, only
+ * a stale one, which the next count reclaims once the PID is gone.
+ */
+
+#define ATTACH_SIZE (NABD_ATTACH_SLOTS * sizeof(_Atomic uint64_t))
+
+/*
+ * Build the table name for a queue name
+ */
+static int attach_path(const char *name, char *path, size_t size) {
+  int n = snprintf(path, size, "%s.ref", name);
+  return (n > 0 && (size_t)n < size) ? 0 : -1;
+}
+
+/*
+ * Map the table, creating it on first use when fd is a queue segment
+ */
+static _Atomic uint64_t *attach_map(const char *name, int seg_fd) {
+  char path[PATH_MAX];
+  if (attach_path(name, path, sizeof(path)) < 0) {
+    errno = ENAMETOOLONG;
+    return NULL;
+  }
+
+  int created = 0;
+  int fd = -1;
+  if (seg_fd >= 0) {
+    fd = shm_open(path, O_RDWR | O_CREAT | O_EXCL, 0666);
+    created = fd >= 0;
+  }
+  if (fd < 0)
+    fd = shm_open(path, O_RDWR, 0666);
+  if (fd < 0)
+    return NULL;
+
+  /* Give the table the same permissions and group as the segment */
+  struct stat st;
+  if (created && fstat(seg_fd, &st) == 0) {
+    fchmod(fd, st.st_mode & 0777);
+    (void)fchown(fd, (uid_t)-1, st.st_gid);
+  }
+
+  /* Every opener sizes it; extending to the same length is a no-op */
+  if (seg_fd >= 0 && nabd_size_segment(fd, ATTACH_SIZE) < 0) {
+    close(fd);
+    return NULL;
+  }
+
+  /* A table still being sized by its creator has no entries yet */
+  if (seg_fd < 0 && (fstat(fd, &st) < 0 || (size_t)st.st_size < ATTACH_SIZE)) {
+    close(fd);
+    errno = ENOENT;
+    return NULL;
+  }
+
+  void *ptr =
+      mmap(NULL, ATTACH_SIZE, PROT_READ | PROT_WRITE, MAP_SHARED, fd, 0);
+  close(fd);
+  return ptr == MAP_FAILED ? NULL : (_Atomic uint64_t *)ptr;
+}
+
+/*
+ * Whether the process owning an entry still exists
+ */
+static int attach_alive(uint32_t pid) {
+  if (pid == (uint32_t)getpid())
+    return 1;
+  return kill((pid_t)pid, 0) == 0 || errno == EPERM;
+}
+
+/*
+ * Count live handles, clearing entries left by dead processes
+ */
+static int attach_scan(_Atomic uint64_t *refs, int *reclaimed) {
+  int total = 0;
+  for (size_t i = 0; i < NABD_ATTACH_SLOTS; i++) {
+    uint64_t e = atomic_load_explicit(&refs[i], memory_order_acquire);
+    if (e == 0)
+      continue;
+    if (attach_alive((uint32_t)(e >> 32))) {
+      total += (int)(uint32_t)e;
+      continue;
+    }
+    /* Fails harmlessly if the entry changed since the load */
+    if (atomic_compare_exchange_strong(&refs[i], &e, 0) && reclaimed)
+      (*reclaimed)++;
+  }
+  return total;
+}
+
+/*
+ * Count this process's new handle in the queue's table
+ */
+int nabd_attach_register(struct nabd *q) {
+  q->refs = attach_map(q->name, q->fd);
+  if (!q->refs)
+    return -1;
+
+  uint64_t pid = (uint64_t)(uint32_t)getpid();
+  for (;;) {
+    _Atomic uint64_t *slot = NULL;
+    for (size_t i = 0; i < NABD_ATTACH_SLOTS; i++) {
+      uint64_t e = atomic_load_explicit(&q->refs[i], memory_order_acquire);
+      if (e == 0) {
+        if (!slot)
+          slot = &q->refs[i];
+        continue;
+      }
+      while (e >> 32 == pid) {
+        if (atomic_compare_exchange_weak(&q->refs[i], &e, e + 1))
+          return 0;
+      }
+    }
+
+    if (slot) {
+      uint64_t zero = 0;
+      if (atomic_compare_exchange_strong(slot, &zero, pid << 32 | 1))
+        return 0;
+      continue;
+    }
+
+    /* Table full: retry only if dead processes gave up their entries */
+    int reclaimed = 0;
+    attach_scan(q->refs, &reclaimed);
+    if (reclaimed == 0) {
+      munmap((void *)q->refs, ATTACH_SIZE);
+      q->refs = NULL;
+      errno = ENOSPC;
+      return -1;
+    }
+  }
+}
+
+/*
+ * Drop this process's handle from the table and unmap it, returning the
+ * handles left attached if count is set (otherwise 0)
+ */
+int nabd_attach_release(struct nabd *q, int count) {
+  if (!q->refs)
+    return 0;
+
+  uint64_t pid = (uint64_t)(uint32_t)getpid();
+  int dropped = 0;
+  for (size_t i = 0; i < NABD_ATTACH_SLOTS && !dropped; i++) {
+    uint64_t e = atomic_load_explicit(&q->refs[i], memory_order_acquire);
+    while (!dropped && e != 0 && e >> 32 == pid) {
+      uint64_t next = (uint32_t)e > 1 ? e - 1 : 0;
+      dropped = atomic_compare_exchange_weak(&q->refs[i], &e, next);
+    }
+  }
+
+  int left = count ? attach_scan(q->refs, NULL) : 0;
+  munmap((void *)q->refs, ATTACH_SIZE);
+  q->refs = NULL;
+  return left;
+}
+
+/*
+ * Remove the table alongside the segment
+ */
+void nabd_attach_unlink(const char *name) {
+  char path[PATH_MAX];
+  if (attach_path(name, path, sizeof(path)) == 0)
+    shm_unlink(path);
+}
+
+/*
+ * Count the handles attached to a queue
+ */
+int nabd_attached(nabd_t *q) {
+  if (!q || !q->refs)
+    return NABD_INVALID;
+  return attach_scan(q->refs, NULL);
+}
+
+/*
+ * Unlink a queue only if no live process has it open
+ */
+int nabd_unlink_idle(const char *name) {
+  if (!name)
+    return NABD_INVALID;
+
+  _Atomic uint64_t *refs = attach_map(name, -1);
+  if (refs) {
+    int n = attach_scan(refs, NULL);
+    munmap((void *)refs, ATTACH_SIZE);
+    if (n > 0)
+      return 0;
+  } else if (errno != ENOENT) {
+    return NABD_SYSERR;
+  }
+
+  int ret = nabd_unlink(name);
+  return ret == NABD_OK ? 1 : ret;
+}
@@ -35,8 +35,12 @@ int nabd_get_metrics(nabd_t *q, nabd_metrics_t *metrics) {
   /* Position metrics */
   metrics->head = NABD_LOAD_RELAXED(&q->ctrl->head);
   metrics->tail = NABD_LOAD_RELAXED(&q->ctrl->tail);
+  if (q->broadcast)
+    metrics->tail = (q->flags & NABD_CONSUMER) ? q->cursor : metrics->head;
   metrics->pending =
       (metrics->head >= metrics->tail) ? (metrics->head - metrics->tail) : 0;
+  if (metrics->pending > q->capacity)
+    metrics->pending = q->capacity;
 
   /* Capacity metrics */
   metrics->capacity = q->capacity;
@@ -46,11 +50,41 @@ int nabd_get_metrics(nabd_t *q, nabd_metrics_t *metrics) {
   if (q->capacity > 0) {
     metrics->fill_pct = (int)((metrics->pending * 100) / q->capacity);
   }
+  metrics->high_water = NABD_LOAD_RELAXED(&q->ctrl->high_water);
 
-  /* Throughput/latency metrics are placeholders */
-  /* Full implementation would require tracking in push/pop */
+  /* Cumulative counters maintained by push/pop in the control block */
   metrics->total_pushed = metrics->head;
   metrics->total_popped = metrics->tail;
+  metrics->bytes_pushed = NABD_LOAD_RELAXED(&q->ctrl->bytes_pushed);
+  metrics->bytes_popped = NABD_LOAD_RELAXED(&q->ctrl->bytes_popped);
+  metrics->full_events = NABD_LOAD_RELAXED(&q->ctrl->full_events);
+  metrics->empty_events = NABD_LOAD_RELAXED(&q->ctrl->empty_events);
+
+  /* Rate/latency metrics are placeholders */
+
+  return NABD_OK;
+}
+
+/*
+ * Get the high-water mark
+ */
+uint64_t nabd_high_water(nabd_t *q) {
+  if (!q)
+    return 0;
+
+  return NABD_LOAD_RELAXED(&q->ctrl->high_water);
+}
+
+/*
+ * Reset the high-water mark to the current depth
+ */
+int nabd_reset_high_water(nabd_t *q) {
+  if (!q)
+    return NABD_INVALID;
+
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+  NABD_STORE_RELAXED(&q->ctrl->high_water, head >= tail ? head - tail : 0);
 
   return NABD_OK;
 }
@@ -8,50 +8,19 @@
  */
 
 #include "../include/nabd/nabd.h"
-#include "../include/nabd/internal.h"
+#include "../include/nabd/internal_impl.h"
 #include "../include/nabd/types.h"
 
 #include <errno.h>
 #include <fcntl.h>
+#include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <sys/mman.h>
 #include <sys/stat.h>
+#include <time.h>
 #include <unistd.h>
 
-/*
- * Internal NABD handle structure
- */
-struct nabd {
-  char *name;           /* Shared memory name */
-  int fd;               /* File descriptor */
-  int flags;            /* Open flags */
-  size_t size;          /* Total mapped size */
-  nabd_control_t *ctrl; /* Control block pointer */
-  uint8_t *buffer;      /* Ring buffer pointer */
-
-  /* Cached values for fast access */
-  size_t capacity;  /* Number of slots */
-  size_t slot_size; /* Bytes per slot */
-  size_t mask;      /* capacity - 1 for fast modulo */
-
-  /* Zero-copy state */
-  int reserved;         /* Whether a slot is reserved */
-  uint64_t reserve_pos; /* Reserved slot position */
-
-  /* Multi-consumer extension (NULL if not used) */
-  nabd_multi_consumer_t *multi; /* Multi-consumer control block */
-};
-
-/*
- * Internal consumer handle structure
- */
-struct nabd_consumer {
-  nabd_t *queue;                /* Parent queue */
-  nabd_consumer_group_t *group; /* Consumer group in shared memory */
-  uint32_t group_id;            /* Group identifier */
-};
-
 /*
  * Helper: Get slot pointer by index (hot path - force inline)
  */
@@ -73,12 +42,276 @@ NABD_INLINE void *get_slot_payload(nabd_t *q, uint64_t index) {
   return (uint8_t *)get_slot(q, index) + sizeof(nabd_slot_header_t);
 }
 
+/*
+ * Helper: Whether another handle resized the ring since this one mapped it
+ */
+NABD_INLINE int layout_stale(nabd_t *q) {
+  return NABD_UNLIKELY(NABD_LOAD_RELAXED(&q->ctrl->layout) != q->layout);
+}
+
+/*
+ * Helper: Atomic access to a slot's sequence number
+ */
+NABD_INLINE uint32_t slot_seq_load(nabd_slot_header_t *hdr,
+                                   memory_order order) {
+  return atomic_load_explicit((_Atomic uint32_t *)&hdr->sequence, order);
+}
+
+NABD_INLINE void slot_seq_store(nabd_slot_header_t *hdr, uint32_t seq) {
+  atomic_store_explicit((_Atomic uint32_t *)&hdr->sequence, seq,
+                        memory_order_relaxed);
+}
+
+/*
+ * ============================================================================
+ * Broadcast (Fan-Out) Support
+ * ============================================================================
+ *
+ * In broadcast mode the producer never waits for consumers and overwrites
+ * the oldest slot once the ring wraps. Each consumer handle keeps a private
+ * cursor and validates slots seqlock style: the producer stamps the new
+ * sequence number before touching the payload, so a reader that sees a
+ * different sequence before or after its copy has been lapped.
+ */
+
+/*
+ * Claim the slot for position pos before writing it
+ */
+NABD_INLINE nabd_slot_header_t *bcast_claim(nabd_t *q, uint64_t pos) {
+  nabd_slot_header_t *hdr = get_slot_header(q, pos);
+  slot_seq_store(hdr, (uint32_t)pos);
+  /* Sequence must be visible before any payload byte changes */
+  NABD_RELEASE();
+  return hdr;
+}
+
+/*
+ * Move a lapped cursor to the oldest slot not about to be overwritten
+ */
+static void bcast_resync(nabd_t *q) {
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+  uint64_t oldest = head >= q->capacity ? head - q->capacity + 1 : 0;
+  if (q->cursor < oldest)
+    q->cursor = oldest;
+}
+
+/*
+ * Read the message at this handle's cursor
+ */
+static int bcast_pop(nabd_t *q, void *buf, size_t *len) {
+  uint64_t pos = q->cursor;
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+
+  if (NABD_UNLIKELY(pos == head)) {
+    return NABD_EMPTY;
+  }
+  if (NABD_UNLIKELY(head - pos > q->capacity)) {
+    bcast_resync(q);
+    return NABD_LAPPED;
+  }
+
+  nabd_slot_header_t *hdr = get_slot_header(q, pos);
+  if (NABD_UNLIKELY(slot_seq_load(hdr, memory_order_acquire) !=
+                    (uint32_t)pos)) {
+    bcast_resync(q);
+    return NABD_LAPPED;
+  }
+
+  /* Length may be torn by a concurrent overwrite; bound it, verify below */
+  size_t msg_len = hdr->length;
+  size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
+  if (msg_len > max_payload)
+    msg_len = max_payload;
+
+  int too_big = msg_len > *len;
+  if (!too_big)
+    memcpy(buf, (uint8_t *)hdr + sizeof(nabd_slot_header_t), msg_len);
+
+  /* Copy must complete before the sequence is checked again */
+  NABD_ACQUIRE();
+  if (NABD_UNLIKELY(slot_seq_load(hdr, memory_order_relaxed) !=
+                    (uint32_t)pos)) {
+    bcast_resync(q);
+    return NABD_LAPPED;
+  }
+
+  *len = msg_len;
+  if (too_big)
+    return NABD_TOOBIG;
+
+  q->cursor = pos + 1;
+  return NABD_OK;
+}
+
+/*
+ * ============================================================================
+ * Overwrite (Drop-Oldest) Support
+ * ============================================================================
+ *
+ * In overwrite mode a producer that finds the ring full moves tail forward
+ * with a CAS before it reuses the oldest slot. The consumer copies a slot
+ * first and only then claims it with a CAS on tail, so a copy that raced
+ * with an overwrite is thrown away: the producer moved tail first and the
+ * consumer's CAS fails. The handle's cursor holds the tail the consumer
+ * expects; finding tail elsewhere means messages were dropped.
+ */
+
+/*
+ * Make room for one message at head by discarding the oldest ones
+ */
+static uint64_t ovw_evict(nabd_t *q, uint64_t head) {
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+  while (head - tail >= q->capacity) {
+    if (atomic_compare_exchange_weak_explicit(
+            &q->ctrl->tail, &tail, head - q->capacity + 1,
+            memory_order_acq_rel, memory_order_acquire)) {
+      NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
+      return head - q->capacity + 1;
+    }
+  }
+  return tail;
+}
+
+/*
+ * Pop the message at tail, unless the producer overwrote it meanwhile
+ */
+static int ovw_pop(nabd_t *q, void *buf, size_t *len) {
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+
+  if (NABD_UNLIKELY(tail != q->cursor)) {
+    q->cursor = tail;
+    return NABD_LAPPED;
+  }
+
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+  if (NABD_UNLIKELY(tail == head)) {
+    NABD_COUNTER_ADD(&q->ctrl->empty_events, 1);
+    nabd_notify_empty(q);
+    return NABD_EMPTY;
+  }
+
+  nabd_slot_header_t *hdr = get_slot_header(q, tail);
+
+  /* Length may be torn by a concurrent overwrite; bound it, verify below */
+  size_t msg_len = hdr->length;
+  size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
+  if (msg_len > max_payload)
+    msg_len = max_payload;
+
+  if (NABD_UNLIKELY(msg_len > *len)) {
+    *len = msg_len;
+    return NABD_TOOBIG;
+  }
+  memcpy(buf, (uint8_t *)hdr + sizeof(nabd_slot_header_t), msg_len);
+
+  /* Claim after the copy; release keeps the copy ordered before it */
+  uint64_t expected = tail;
+  if (NABD_UNLIKELY(!atomic_compare_exchange_strong_explicit(
+          &q->ctrl->tail, &expected, tail + 1, memory_order_acq_rel,
+          memory_order_acquire))) {
+    q->cursor = expected;
+    return NABD_LAPPED;
+  }
+
+  *len = msg_len;
+  NABD_COUNTER_ADD(&q->ctrl->bytes_popped, msg_len);
+  q->cursor = tail + 1;
+  return NABD_OK;
+}
+
+/*
+ * ============================================================================
+ * Chunked Messages
+ * ============================================================================
+ *
+ * A message too large for one slot is split over consecutive slots, and
+ * every slot but the last carries NABD_SLOT_MORE. The chunks are published
+ * with a single head update, so a consumer never sees part of a message
+ * and needs no message id or chunk index to put it back together.
+ */
+
+/*
+ * Count the slots and bytes of the message starting at pos
+ */
+static size_t chunk_span(nabd_t *q, uint64_t pos, uint64_t head,
+                         size_t *total) {
+  size_t n = 0;
+  *total = 0;
+  for (;;) {
+    nabd_slot_header_t *hdr = get_slot_header(q, pos + n);
+    *total += hdr->length;
+    n++;
+    if (!(hdr->flags & NABD_SLOT_MORE) || pos + n == head)
+      return n;
+  }
+}
+
+/*
+ * Copy the chunked message at pos into buf; *slots receives its length
+ * in slots
+ */
+static int chunk_copy(nabd_t *q, uint64_t pos, uint64_t head, void *buf,
+                      size_t *len, size_t *slots) {
+  size_t total;
+  size_t n = chunk_span(q, pos, head, &total);
+  if (total > *len) {
+    *len = total;
+    return NABD_TOOBIG;
+  }
+
+  uint8_t *dst = (uint8_t *)buf;
+  for (size_t i = 0; i < n; i++) {
+    nabd_slot_header_t *hdr = get_slot_header(q, pos + i);
+    memcpy(dst, get_slot_payload(q, pos + i), hdr->length);
+    dst += hdr->length;
+  }
+
+  *len = total;
+  *slots = n;
+  return NABD_OK;
+}
+
 /*
  * Open or create a NABD queue
  */
 nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
                   int flags) {
-  if (!name) {
+  return nabd_open_mode(name, capacity, slot_size, flags, 0);
+}
+
+/*
+ * Size a new or reused shared-memory object
+ *
+ * macOS only lets an object be sized once, so one that is already large
+ * enough is reused as is there.
+ */
+int nabd_size_segment(int fd, size_t size) {
+#ifdef __APPLE__
+  struct stat st;
+  if (fstat(fd, &st) == 0 && st.st_size > 0) {
+    if ((size_t)st.st_size >= size)
+      return 0;
+    errno = EINVAL;
+    return -1;
+  }
+#endif
+  return ftruncate(fd, size);
+}
+
+/*
+ * Open or create a NABD queue with explicit permissions
+ */
+nabd_t *nabd_open_mode(const char *name, size_t capacity, size_t slot_size,
+                       int flags, mode_t mode) {
+  return nabd_open_group(name, capacity, slot_size, flags, mode, (gid_t)-1);
+}
+
+/*
+ * Open or create a NABD queue with explicit permissions and group
+ */
+nabd_t *nabd_open_group(const char *name, size_t capacity, size_t slot_size,
+                        int flags, mode_t mode, gid_t gid) {
+  if (!name || (mode & ~(mode_t)0777)) {
     errno = EINVAL;
     return NULL;
   }
@@ -93,6 +326,12 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
     return NULL;
   }
 
+  /* Broadcast already overwrites; the two modes are exclusive */
+  if ((flags & NABD_BROADCAST) && (flags & NABD_OVERWRITE)) {
+    errno = EINVAL;
+    return NULL;
+  }
+
   /* For create, validate capacity and slot_size */
   if (is_create) {
     if (capacity == 0)
@@ -116,6 +355,7 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
   if (!q) {
     return NULL;
   }
+  q->notify_fd = -1;
 
   q->name = strdup(name);
   if (!q->name) {
@@ -132,7 +372,14 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
     shm_flags |= O_CREAT | O_EXCL;
   }
 
+#ifdef __APPLE__
+  /* fchmod does not apply to shared memory here, so the umask still does */
+  q->fd = shm_open(name, shm_flags, mode ? mode : 0666);
+  mode = 0;
+#else
   q->fd = shm_open(name, shm_flags, 0666);
+#endif
+  int created = q->fd >= 0 && is_create;
   if (q->fd < 0) {
     /* If create failed with EEXIST, try opening existing */
     if (is_create && errno == EEXIST) {
@@ -151,11 +398,32 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
     /* Set size and initialize */
     total_size = sizeof(nabd_control_t) + (capacity * slot_size);
 
-    if (ftruncate(q->fd, total_size) < 0) {
+    /* Apply exact permissions, bypassing the umask */
+    if (created && mode && fchmod(q->fd, mode) < 0) {
+      close(q->fd);
+      shm_unlink(name);
+      free(q->name);
+      free(q);
+      return NULL;
+    }
+
+    /* Hand the segment to a group; the owner stays the creating user */
+    if (created && gid != (gid_t)-1 && fchown(q->fd, (uid_t)-1, gid) < 0) {
+      int err = errno;
       close(q->fd);
       shm_unlink(name);
       free(q->name);
       free(q);
+      errno = err;
+      return NULL;
+    }
+
+    if (nabd_size_segment(q->fd, total_size) < 0) {
+      close(q->fd);
+      if (created)
+        shm_unlink(name);
+      free(q->name);
+      free(q);
       return NULL;
     }
 
@@ -177,10 +445,16 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
     /* Initialize control block */
     memset(q->ctrl, 0, sizeof(nabd_control_t));
     q->ctrl->magic = NABD_MAGIC;
-    q->ctrl->version = (NABD_VERSION_MAJOR << 16) | NABD_VERSION_MINOR;
+    q->ctrl->version = NABD_LAYOUT_VERSION;
     q->ctrl->capacity = capacity;
     q->ctrl->slot_size = slot_size;
     q->ctrl->buffer_offset = sizeof(nabd_control_t);
+    q->ctrl->mode = flags & (NABD_BROADCAST | NABD_OVERWRITE);
+
+    struct timespec now;
+    clock_gettime(CLOCK_REALTIME, &now);
+    q->ctrl->creator_pid = (uint64_t)getpid();
+    q->ctrl->created_ns = (uint64_t)now.tv_sec * 1000000000ULL + now.tv_nsec;
     atomic_store(&q->ctrl->head, 0);
     atomic_store(&q->ctrl->tail, 0);
 
@@ -197,13 +471,14 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
 
     nabd_control_t *ctrl_tmp = (nabd_control_t *)ptr;
 
-    /* Validate magic */
-    if (ctrl_tmp->magic != NABD_MAGIC) {
+    /* Validate magic and layout; a different layout would be misread */
+    if (ctrl_tmp->magic != NABD_MAGIC ||
+        ctrl_tmp->version != NABD_LAYOUT_VERSION) {
       munmap(ptr, sizeof(nabd_control_t));
       close(q->fd);
       free(q->name);
       free(q);
-      errno = EINVAL;
+      errno = EPROTO;
       return NULL;
     }
 
@@ -227,12 +502,54 @@ nabd_t *nabd_open(const char *name, size_t capacity, size_t slot_size,
     q->size = total_size;
   }
 
+  /* Pin the mapping so the hot path never page-faults */
+  if ((flags & NABD_MLOCK) && mlock(q->ctrl, q->size) < 0) {
+    int err = errno;
+    munmap(q->ctrl, q->size);
+    close(q->fd);
+    if (created)
+      shm_unlink(name);
+    free(q->name);
+    free(q);
+    errno = err;
+    return NULL;
+  }
+
   /* Cache values */
   q->capacity = capacity;
   q->slot_size = slot_size;
   q->mask = capacity - 1;
+  q->layout = NABD_LOAD_ACQUIRE(&q->ctrl->layout);
   q->reserved = 0;
 
+  /* Broadcast consumers start at the live end of the stream */
+  q->broadcast = (q->ctrl->mode & NABD_BROADCAST) != 0;
+  q->overwrite = (q->ctrl->mode & NABD_OVERWRITE) != 0;
+  q->cursor = q->overwrite ? NABD_LOAD_ACQUIRE(&q->ctrl->tail)
+                           : NABD_LOAD_ACQUIRE(&q->ctrl->head);
+
+  /* Count this handle so nabd_unlink_idle leaves the queue alone */
+  if (nabd_attach_register(q) < 0) {
+    int err = errno;
+    if (flags & NABD_MLOCK)
+      munlock(q->ctrl, q->size);
+    munmap(q->ctrl, q->size);
+    close(q->fd);
+    if (created)
+      shm_unlink(name);
+    free(q->name);
+    free(q);
+    errno = err;
+    return NULL;
+  }
+
+  if (flags & NABD_UNLINK_LAST)
+    atomic_store(&q->ctrl->unlink_last, 1);
+
+  /* A producer attaching again means the previous shutdown is over */
+  if (flags & NABD_PRODUCER)
+    atomic_store(&q->ctrl->draining, 0);
+
   return q;
 }
 
@@ -243,7 +560,15 @@ int nabd_close(nabd_t *q) {
   if (!q)
     return NABD_INVALID;
 
+  nabd_notify_close(q);
+
+  /* With UNLINK_LAST, the handle that leaves the table empty removes it */
+  int unlink_last = q->ctrl && atomic_load(&q->ctrl->unlink_last);
+  int left = nabd_attach_release(q, unlink_last);
+
   if (q->ctrl) {
+    if (q->flags & NABD_MLOCK)
+      munlock(q->ctrl, q->size);
     munmap(q->ctrl, q->size);
   }
 
@@ -251,12 +576,125 @@ int nabd_close(nabd_t *q) {
     close(q->fd);
   }
 
+  if (unlink_last && left == 0)
+    nabd_unlink(q->name);
+
   free(q->name);
   free(q);
 
   return NABD_OK;
 }
 
+/*
+ * Helper: Replace the mapping with one covering capacity slots
+ */
+static int map_ring(nabd_t *q, size_t capacity) {
+  size_t size = sizeof(nabd_control_t) + capacity * q->slot_size;
+  void *ptr = mmap(NULL, size, PROT_READ | PROT_WRITE, MAP_SHARED, q->fd, 0);
+  if (ptr == MAP_FAILED)
+    return NABD_SYSERR;
+
+  if ((q->flags & NABD_MLOCK) && mlock(ptr, size) < 0) {
+    int err = errno;
+    munmap(ptr, size);
+    errno = err;
+    return NABD_SYSERR;
+  }
+
+  if (q->flags & NABD_MLOCK)
+    munlock(q->ctrl, q->size);
+  munmap(q->ctrl, q->size);
+
+  q->ctrl = (nabd_control_t *)ptr;
+  q->buffer = (uint8_t *)ptr + sizeof(nabd_control_t);
+  q->size = size;
+  q->capacity = capacity;
+  q->mask = capacity - 1;
+  return NABD_OK;
+}
+
+/*
+ * Change the number of slots, keeping pending messages
+ */
+int nabd_resize(nabd_t *q, size_t capacity) {
+  if (!q || q->reserved || q->multi || capacity == 0)
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  if (!nabd_is_power_of_2(capacity))
+    capacity = nabd_next_power_of_2(capacity);
+  if (capacity == q->capacity)
+    return NABD_OK;
+
+  int grow = capacity > q->capacity;
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+  uint64_t tail;
+  if (q->broadcast) {
+    /* Keep as much history as both rings can hold */
+    uint64_t keep = grow ? q->capacity : capacity;
+    tail = head > keep ? head - keep : 0;
+  } else {
+    tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+    if (!grow && head != tail)
+      return NABD_INVALID;
+  }
+
+  /* Slots move to pos & new_mask, so stage them outside the ring */
+  size_t count = head - tail;
+  uint8_t *staged = NULL;
+  if (count) {
+    staged = malloc(count * q->slot_size);
+    if (!staged)
+      return NABD_NOMEM;
+    for (size_t i = 0; i < count; i++)
+      memcpy(staged + i * q->slot_size, get_slot(q, tail + i), q->slot_size);
+  }
+
+  size_t size = sizeof(nabd_control_t) + capacity * q->slot_size;
+  if (grow && ftruncate(q->fd, size) < 0) {
+    free(staged);
+    return NABD_SYSERR;
+  }
+
+  /* On failure a grown segment keeps unused space, which is harmless */
+  int ret = map_ring(q, capacity);
+  if (ret != NABD_OK) {
+    free(staged);
+    return ret;
+  }
+
+  for (size_t i = 0; i < count; i++)
+    memcpy(get_slot(q, tail + i), staged + i * q->slot_size, q->slot_size);
+  free(staged);
+
+  /* Give the freed slots back; failing only wastes the space */
+  if (!grow)
+    (void)ftruncate(q->fd, size);
+
+  q->ctrl->capacity = capacity;
+  q->layout++;
+  NABD_STORE_RELEASE(&q->ctrl->layout, q->layout);
+  return NABD_OK;
+}
+
+/*
+ * Map the ring again after another handle resized it
+ */
+int nabd_remap(nabd_t *q) {
+  if (!q || q->reserved)
+    return NABD_INVALID;
+
+  uint64_t layout = NABD_LOAD_ACQUIRE(&q->ctrl->layout);
+  if (layout == q->layout)
+    return NABD_OK;
+
+  int ret = map_ring(q, q->ctrl->capacity);
+  if (ret == NABD_OK)
+    q->layout = layout;
+  return ret;
+}
+
 /*
  * Unlink shared memory
  */
@@ -267,16 +705,35 @@ int nabd_unlink(const char *name) {
   if (shm_unlink(name) < 0) {
     return NABD_SYSERR;
   }
+  nabd_notify_unlink(name);
+  nabd_attach_unlink(name);
 
   return NABD_OK;
 }
 
+/*
+ * Check whether a shared memory segment exists
+ */
+int nabd_exists(const char *name) {
+  if (!name)
+    return NABD_INVALID;
+
+  int fd = shm_open(name, O_RDONLY, 0);
+  if (fd < 0)
+    return errno == ENOENT ? 0 : NABD_SYSERR;
+
+  close(fd);
+  return 1;
+}
+
 /*
  * Push a message (non-blocking) - HOT PATH
  */
 int nabd_push(nabd_t *q, const void *data, size_t len) {
-  if (NABD_UNLIKELY(!q || !data))
+  if (NABD_UNLIKELY(!q || !data || q->reserved))
     return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
 
   size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
   if (NABD_UNLIKELY(len > max_payload))
@@ -288,9 +745,16 @@ int nabd_push(nabd_t *q, const void *data, size_t len) {
   /* Load tail (consumer position) with acquire to sync */
   uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
 
-  /* Check if full */
-  if (NABD_UNLIKELY(head - tail >= q->capacity)) {
-    return NABD_FULL;
+  /* Check if full (broadcast never waits for consumers) */
+  if (NABD_UNLIKELY(q->broadcast)) {
+    tail = head;
+    bcast_claim(q, head);
+  } else if (NABD_UNLIKELY(head - tail >= q->capacity)) {
+    if (!q->overwrite) {
+      NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
+      return NABD_FULL;
+    }
+    tail = ovw_evict(q, head);
   }
 
   /* Get slot and prefetch for writing */
@@ -306,20 +770,183 @@ int nabd_push(nabd_t *q, const void *data, size_t len) {
   /* Fill header */
   hdr->length = (uint16_t)len;
   hdr->flags = 0;
-  hdr->sequence = (uint32_t)head;
+  slot_seq_store(hdr, (uint32_t)head);
+
+  NABD_COUNTER_ADD(&q->ctrl->bytes_pushed, len);
+  NABD_COUNTER_MAX(&q->ctrl->high_water, head + 1 - tail);
 
   /* Publish: release store to head */
   NABD_STORE_RELEASE(&q->ctrl->head, head + 1);
+  nabd_notify_push(q, head);
 
   return NABD_OK;
 }
 
+/*
+ * Push a message that may span several slots (non-blocking)
+ */
+int nabd_push_large(nabd_t *q, const void *data, size_t len) {
+  if (NABD_UNLIKELY(!q || !data || q->reserved || q->broadcast ||
+                    q->overwrite))
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
+  if (NABD_LIKELY(len <= max_payload))
+    return nabd_push(q, data, len);
+
+  size_t slots = (len + max_payload - 1) / max_payload;
+  if (slots > q->capacity)
+    return NABD_TOOBIG;
+
+  uint64_t head = NABD_LOAD_RELAXED(&q->ctrl->head);
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+
+  /* All or nothing: a partial message is never published */
+  if (head + slots - tail > q->capacity) {
+    NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
+    return NABD_FULL;
+  }
+
+  const uint8_t *src = (const uint8_t *)data;
+  size_t left = len;
+  for (size_t i = 0; i < slots; i++) {
+    size_t chunk = left < max_payload ? left : max_payload;
+    nabd_slot_header_t *hdr = get_slot_header(q, head + i);
+
+    memcpy(get_slot_payload(q, head + i), src, chunk);
+    src += chunk;
+    left -= chunk;
+
+    hdr->length = (uint16_t)chunk;
+    hdr->flags = i + 1 < slots ? NABD_SLOT_MORE : 0;
+    slot_seq_store(hdr, (uint32_t)(head + i));
+  }
+
+  NABD_COUNTER_ADD(&q->ctrl->bytes_pushed, len);
+  NABD_COUNTER_MAX(&q->ctrl->high_water, head + slots - tail);
+  NABD_STORE_RELEASE(&q->ctrl->head, head + slots);
+  nabd_notify_push(q, head);
+
+  return NABD_OK;
+}
+
+/*
+ * Push a batch of messages (non-blocking)
+ */
+int nabd_push_batch(nabd_t *q, const void *data, const size_t *lens,
+                    size_t count, size_t *pushed) {
+  if (NABD_UNLIKELY(!q || !data || !lens || !pushed))
+    return NABD_INVALID;
+
+  *pushed = 0;
+  if (NABD_UNLIKELY(q->reserved))
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
+  uint64_t head = NABD_LOAD_RELAXED(&q->ctrl->head);
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+
+  const uint8_t *src = (const uint8_t *)data;
+  const uint8_t *start = src;
+  int ret = NABD_OK;
+  size_t i;
+  for (i = 0; i < count; i++) {
+    if (NABD_UNLIKELY(lens[i] > max_payload)) {
+      ret = NABD_TOOBIG;
+      break;
+    }
+
+    if (NABD_UNLIKELY(q->broadcast)) {
+      tail = head + i;
+      bcast_claim(q, head + i);
+    } else if (NABD_UNLIKELY(head + i - tail >= q->capacity)) {
+      /* Refresh tail once the cached view says full */
+      tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+      if (head + i - tail >= q->capacity) {
+        if (!q->overwrite) {
+          ret = NABD_FULL;
+          break;
+        }
+        /* Earlier slots of the batch are unpublished; only older go */
+        tail = ovw_evict(q, head + i);
+      }
+    }
+
+    void *slot = get_slot(q, head + i);
+    nabd_slot_header_t *hdr = (nabd_slot_header_t *)slot;
+
+    memcpy((uint8_t *)slot + sizeof(nabd_slot_header_t), src, lens[i]);
+    src += lens[i];
+
+    hdr->length = (uint16_t)lens[i];
+    hdr->flags = 0;
+    slot_seq_store(hdr, (uint32_t)(head + i));
+  }
+
+  if (ret == NABD_FULL)
+    NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
+
+  /* Publish the whole batch with a single release store */
+  if (i > 0) {
+    NABD_COUNTER_ADD(&q->ctrl->bytes_pushed, (uint64_t)(src - start));
+    NABD_COUNTER_MAX(&q->ctrl->high_water, head + i - tail);
+    NABD_STORE_RELEASE(&q->ctrl->head, head + i);
+    nabd_notify_push(q, head);
+  }
+
+  *pushed = i;
+  return ret;
+}
+
+/*
+ * Push a batch of messages, all or none (non-blocking)
+ */
+int nabd_push_batch_atomic(nabd_t *q, const void *data, const size_t *lens,
+                           size_t count) {
+  if (NABD_UNLIKELY(!q || !data || !lens))
+    return NABD_INVALID;
+  if (NABD_UNLIKELY(q->reserved || count > q->capacity))
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
+  for (size_t i = 0; i < count; i++) {
+    if (NABD_UNLIKELY(lens[i] > max_payload))
+      return NABD_TOOBIG;
+  }
+
+  /* We are the only producer, so the room seen here can only grow */
+  if (!q->broadcast && !q->overwrite) {
+    uint64_t head = NABD_LOAD_RELAXED(&q->ctrl->head);
+    uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+    if (head + count - tail > q->capacity) {
+      NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
+      return NABD_FULL;
+    }
+  }
+
+  size_t pushed;
+  return nabd_push_batch(q, data, lens, count, &pushed);
+}
+
 /*
  * Pop a message (non-blocking) - HOT PATH
  */
 int nabd_pop(nabd_t *q, void *buf, size_t *len) {
   if (NABD_UNLIKELY(!q || !buf || !len))
     return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  if (NABD_UNLIKELY(q->broadcast))
+    return bcast_pop(q, buf, len);
+  if (NABD_UNLIKELY(q->overwrite))
+    return ovw_pop(q, buf, len);
 
   /* Load tail (our position) - relaxed ok, it's our variable */
   uint64_t tail = NABD_LOAD_RELAXED(&q->ctrl->tail);
@@ -329,6 +956,8 @@ int nabd_pop(nabd_t *q, void *buf, size_t *len) {
 
   /* Check if empty */
   if (NABD_UNLIKELY(tail == head)) {
+    NABD_COUNTER_ADD(&q->ctrl->empty_events, 1);
+    nabd_notify_empty(q);
     return NABD_EMPTY;
   }
 
@@ -344,6 +973,16 @@ int nabd_pop(nabd_t *q, void *buf, size_t *len) {
   nabd_slot_header_t *hdr = (nabd_slot_header_t *)slot;
   void *payload = (uint8_t *)slot + sizeof(nabd_slot_header_t);
 
+  if (NABD_UNLIKELY(hdr->flags & NABD_SLOT_MORE)) {
+    size_t slots;
+    int ret = chunk_copy(q, tail, head, buf, len, &slots);
+    if (ret != NABD_OK)
+      return ret;
+    NABD_COUNTER_ADD(&q->ctrl->bytes_popped, *len);
+    NABD_STORE_RELEASE(&q->ctrl->tail, tail + slots);
+    return NABD_OK;
+  }
+
   size_t msg_len = hdr->length;
 
   /* Check buffer size */
@@ -355,20 +994,139 @@ int nabd_pop(nabd_t *q, void *buf, size_t *len) {
   memcpy(buf, payload, msg_len);
   *len = msg_len;
 
+  NABD_COUNTER_ADD(&q->ctrl->bytes_popped, msg_len);
+
   /* Signal consumption: release store to tail */
   NABD_STORE_RELEASE(&q->ctrl->tail, tail + 1);
 
   return NABD_OK;
 }
 
+/*
+ * Helper: Pop up to count messages, into bufs[i] when bufs is set and at
+ * buf + i * stride otherwise. Returns the code that ended the batch.
+ */
+static int pop_batch(nabd_t *q, uint8_t *buf, size_t stride,
+                     void *const *bufs, const size_t *sizes, size_t *lens,
+                     size_t count, size_t *popped) {
+  *popped = 0;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  if (NABD_UNLIKELY(q->broadcast || q->overwrite)) {
+    /* Each slot is validated on its own, so read them one by one */
+    int ret = NABD_OK;
+    size_t i;
+    for (i = 0; i < count; i++) {
+      void *dst = bufs ? bufs[i] : buf + i * stride;
+      lens[i] = bufs ? sizes[i] : stride;
+      ret = q->broadcast ? bcast_pop(q, dst, &lens[i])
+                         : ovw_pop(q, dst, &lens[i]);
+      if (ret != NABD_OK)
+        break;
+    }
+    *popped = i;
+    return ret;
+  }
+
+  uint64_t tail = NABD_LOAD_RELAXED(&q->ctrl->tail);
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+
+  if (NABD_UNLIKELY(tail == head)) {
+    NABD_COUNTER_ADD(&q->ctrl->empty_events, 1);
+    nabd_notify_empty(q);
+    return NABD_EMPTY;
+  }
+
+  uint64_t avail = head - tail;
+  if (count > avail)
+    count = avail;
+
+  int ret = NABD_OK;
+  uint64_t bytes = 0;
+  size_t i;
+  for (i = 0; i < count; i++) {
+    void *slot = get_slot(q, tail + i);
+    nabd_slot_header_t *hdr = (nabd_slot_header_t *)slot;
+
+    if (NABD_LIKELY(i + 1 < count)) {
+      NABD_PREFETCH_READ(get_slot(q, tail + i + 1));
+    }
+
+    if (NABD_UNLIKELY(hdr->flags & NABD_SLOT_MORE)) {
+      /* A chunked message ends the batch, or is popped on its own */
+      if (i > 0)
+        break;
+      size_t slots;
+      lens[0] = bufs ? sizes[0] : stride;
+      ret = chunk_copy(q, tail, head, bufs ? bufs[0] : buf, &lens[0], &slots);
+      if (ret == NABD_OK) {
+        NABD_COUNTER_ADD(&q->ctrl->bytes_popped, lens[0]);
+        NABD_STORE_RELEASE(&q->ctrl->tail, tail + slots);
+        *popped = 1;
+      }
+      return ret;
+    }
+
+    size_t msg_len = hdr->length;
+    if (NABD_UNLIKELY(msg_len > (bufs ? sizes[i] : stride))) {
+      ret = NABD_TOOBIG;
+      break;
+    }
+
+    if (msg_len)
+      memcpy(bufs ? bufs[i] : buf + i * stride,
+             (uint8_t *)slot + sizeof(nabd_slot_header_t), msg_len);
+    lens[i] = msg_len;
+    bytes += msg_len;
+  }
+
+  /* Release the whole batch with a single store */
+  if (i > 0) {
+    NABD_COUNTER_ADD(&q->ctrl->bytes_popped, bytes);
+    NABD_STORE_RELEASE(&q->ctrl->tail, tail + i);
+  }
+
+  *popped = i;
+  return ret;
+}
+
+/*
+ * Pop a batch of messages (non-blocking)
+ */
+int nabd_pop_batch(nabd_t *q, void *buf, size_t stride, size_t *lens,
+                   size_t count, size_t *popped) {
+  if (NABD_UNLIKELY(!q || !buf || !lens || !popped))
+    return NABD_INVALID;
+
+  int ret = pop_batch(q, buf, stride, NULL, NULL, lens, count, popped);
+  return (*popped > 0 && ret != NABD_LAPPED) ? NABD_OK : ret;
+}
+
+/*
+ * Pop a batch of messages into separate buffers (non-blocking)
+ */
+int nabd_pop_batchv(nabd_t *q, void *const *bufs, const size_t *sizes,
+                    size_t *lens, size_t count, size_t *popped) {
+  if (NABD_UNLIKELY(!q || !bufs || !sizes || !lens || !popped))
+    return NABD_INVALID;
+
+  int ret = pop_batch(q, NULL, 0, bufs, sizes, lens, count, popped);
+  return (*popped > 0 && ret == NABD_EMPTY) ? NABD_OK : ret;
+}
+
 /*
  * Reserve a slot for zero-copy write
  */
 int nabd_reserve(nabd_t *q, size_t len, void **slot) {
   if (!q || !slot)
     return NABD_INVALID;
-  if (q->reserved)
+  /* Overwriting must evict or claim before the slot is written, which an
+   * abort could not undo */
+  if (q->reserved || q->broadcast || q->overwrite)
     return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
 
   size_t max_payload = q->slot_size - sizeof(nabd_slot_header_t);
   if (len > max_payload)
@@ -378,6 +1136,7 @@ int nabd_reserve(nabd_t *q, size_t len, void **slot) {
   uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_acquire);
 
   if (head - tail >= q->capacity) {
+    NABD_COUNTER_ADD(&q->ctrl->full_events, 1);
     return NABD_FULL;
   }
 
@@ -398,22 +1157,53 @@ int nabd_commit(nabd_t *q, size_t len) {
   nabd_slot_header_t *hdr = get_slot_header(q, q->reserve_pos);
   hdr->length = (uint16_t)len;
   hdr->flags = 0;
-  hdr->sequence = (uint32_t)q->reserve_pos;
+  slot_seq_store(hdr, (uint32_t)q->reserve_pos);
+
+  NABD_COUNTER_ADD(&q->ctrl->bytes_pushed, len);
+  NABD_COUNTER_MAX(&q->ctrl->high_water,
+                   q->reserve_pos + 1 - NABD_LOAD_RELAXED(&q->ctrl->tail));
 
   atomic_store_explicit(&q->ctrl->head, q->reserve_pos + 1,
                         memory_order_release);
+  nabd_notify_push(q, q->reserve_pos);
 
   q->reserved = 0;
 
   return NABD_OK;
 }
 
+/*
+ * Abandon a reserved slot
+ */
+int nabd_abort(nabd_t *q) {
+  if (!q || !q->reserved)
+    return NABD_INVALID;
+
+  /* Nothing was published; the slot is simply reused by the next push */
+  q->reserved = 0;
+
+  return NABD_OK;
+}
+
+/*
+ * Order every earlier load and store before every later one
+ */
+int nabd_flush(nabd_t *q) {
+  if (!q)
+    return NABD_INVALID;
+
+  NABD_BARRIER();
+  return NABD_OK;
+}
+
 /*
  * Peek at next message
  */
 int nabd_peek(nabd_t *q, const void **data, size_t *len) {
-  if (!q || !data || !len)
+  if (!q || !data || !len || q->broadcast || q->overwrite)
     return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
 
   uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_relaxed);
   uint64_t head = atomic_load_explicit(&q->ctrl->head, memory_order_acquire);
@@ -423,25 +1213,139 @@ int nabd_peek(nabd_t *q, const void **data, size_t *len) {
   }
 
   nabd_slot_header_t *hdr = get_slot_header(q, tail);
+  if (hdr->flags & NABD_SLOT_MORE)
+    return NABD_TOOBIG;
   *data = get_slot_payload(q, tail);
   *len = hdr->length;
 
   return NABD_OK;
 }
 
+/*
+ * Peek at the message at an absolute position
+ */
+int nabd_peek_at(nabd_t *q, uint64_t pos, const void **data, size_t *len) {
+  if (!q || !data || !len || q->broadcast || q->overwrite)
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_relaxed);
+  uint64_t head = atomic_load_explicit(&q->ctrl->head, memory_order_acquire);
+
+  if (pos < tail)
+    return NABD_INVALID;
+  if (pos >= head)
+    return NABD_EMPTY;
+
+  nabd_slot_header_t *hdr = get_slot_header(q, pos);
+  if (hdr->flags & NABD_SLOT_MORE)
+    return NABD_TOOBIG;
+  *data = get_slot_payload(q, pos);
+  *len = hdr->length;
+
+  return NABD_OK;
+}
+
+/*
+ * Move the consumer position
+ */
+int nabd_seek(nabd_t *q, uint64_t pos) {
+  if (!q || q->overwrite)
+    return NABD_INVALID;
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  if (q->broadcast) {
+    uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+    if (pos > head)
+      return NABD_INVALID;
+    if (pos + q->capacity <= head)
+      return NABD_LAPPED;
+    q->cursor = pos;
+    return NABD_OK;
+  }
+
+  /* Skipping ahead only frees slots; going back takes them from a producer
+   * that may be pushing right now, so the tail moves by compare-and-swap */
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+  for (;;) {
+    uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+    if (pos > head)
+      return NABD_INVALID;
+    if (pos < tail && pos + q->capacity <= head)
+      return NABD_LAPPED;
+    if (atomic_compare_exchange_weak_explicit(&q->ctrl->tail, &tail, pos,
+                                              memory_order_acq_rel,
+                                              memory_order_acquire))
+      break;
+  }
+  if (pos >= tail)
+    return NABD_OK;
+
+  /* A push that read the old tail may have reused a slot from pos on
+   * before the store was visible; if so, put the tail back */
+  NABD_BARRIER();
+  if (pos + q->capacity <= NABD_LOAD_ACQUIRE(&q->ctrl->head)) {
+    uint64_t expected = pos;
+    atomic_compare_exchange_strong_explicit(&q->ctrl->tail, &expected, tail,
+                                            memory_order_acq_rel,
+                                            memory_order_acquire);
+    return NABD_LAPPED;
+  }
+  return NABD_OK;
+}
+
 /*
  * Release a peeked message
  */
 int nabd_release(nabd_t *q) {
-  if (!q)
+  if (!q || q->broadcast || q->overwrite)
     return NABD_INVALID;
 
   uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_relaxed);
+  NABD_COUNTER_ADD(&q->ctrl->bytes_popped, get_slot_header(q, tail)->length);
   atomic_store_explicit(&q->ctrl->tail, tail + 1, memory_order_release);
 
   return NABD_OK;
 }
 
+/*
+ * Discard all pending messages
+ */
+int nabd_drain(nabd_t *q, uint64_t *discarded) {
+  if (!q)
+    return NABD_INVALID;
+
+  if (q->broadcast) {
+    uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+    if (discarded)
+      *discarded = nabd_lag(q);
+    q->cursor = head;
+    return NABD_OK;
+  }
+
+  uint64_t tail = NABD_LOAD_ACQUIRE(&q->ctrl->tail);
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+
+  if (q->overwrite) {
+    /* The producer may move tail too; never move it backwards */
+    uint64_t cur = tail;
+    while (cur < head && !atomic_compare_exchange_weak_explicit(
+                             &q->ctrl->tail, &cur, head, memory_order_acq_rel,
+                             memory_order_acquire))
+      ;
+    q->cursor = cur > head ? cur : head;
+  } else {
+    NABD_STORE_RELEASE(&q->ctrl->tail, head);
+  }
+
+  if (discarded)
+    *discarded = head - tail;
+
+  return NABD_OK;
+}
+
 /*
  * Get queue statistics
  */
@@ -453,7 +1357,14 @@ int nabd_stats(nabd_t *q, nabd_stats_t *stats) {
   stats->tail = atomic_load_explicit(&q->ctrl->tail, memory_order_relaxed);
   stats->capacity = q->capacity;
   stats->slot_size = q->slot_size;
+
+  /* Broadcast has no shared tail: report this consumer's own position */
+  if (q->broadcast)
+    stats->tail = (q->flags & NABD_CONSUMER) ? q->cursor : stats->head;
+
   stats->used = stats->head - stats->tail;
+  if (stats->used > q->capacity)
+    stats->used = q->capacity;
 
   return NABD_OK;
 }
@@ -465,6 +1376,9 @@ int nabd_empty(nabd_t *q) {
   if (!q)
     return NABD_INVALID;
 
+  if (q->broadcast)
+    return q->cursor == NABD_LOAD_ACQUIRE(&q->ctrl->head) ? 1 : 0;
+
   uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_relaxed);
   uint64_t head = atomic_load_explicit(&q->ctrl->head, memory_order_acquire);
 
@@ -478,12 +1392,200 @@ int nabd_full(nabd_t *q) {
   if (!q)
     return NABD_INVALID;
 
+  if (q->broadcast)
+    return 0;
+
   uint64_t head = atomic_load_explicit(&q->ctrl->head, memory_order_relaxed);
   uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_acquire);
 
   return (head - tail >= q->capacity) ? 1 : 0;
 }
 
+/*
+ * Get how many pushes fit before the queue is full
+ */
+uint64_t nabd_free(nabd_t *q) {
+  if (!q)
+    return 0;
+
+  if (q->broadcast || q->overwrite)
+    return q->capacity;
+
+  /* Tail first: a pop or push between the loads only lowers the result */
+  uint64_t tail = atomic_load_explicit(&q->ctrl->tail, memory_order_acquire);
+  uint64_t head = atomic_load_explicit(&q->ctrl->head, memory_order_acquire);
+
+  uint64_t used = head - tail;
+  return used < q->capacity ? q->capacity - used : 0;
+}
+
+/*
+ * Get how far this handle's consumer is behind the producer
+ */
+uint64_t nabd_lag(nabd_t *q) {
+  if (!q)
+    return 0;
+
+  uint64_t head = NABD_LOAD_ACQUIRE(&q->ctrl->head);
+  uint64_t tail =
+      q->broadcast ? q->cursor : NABD_LOAD_RELAXED(&q->ctrl->tail);
+
+  return head > tail ? head - tail : 0;
+}
+
+/*
+ * Get the size of the mapping
+ */
+size_t nabd_mapped_size(nabd_t *q) {
+  if (!q)
+    return 0;
+
+  return q->size;
+}
+
+/*
+ * Check the shared header for damage
+ */
+int nabd_check(nabd_t *q, char *why, size_t size) {
+  if (!q || (!why && size > 0))
+    return NABD_INVALID;
+
+  char scratch[1];
+  if (size == 0) {
+    why = scratch;
+    size = sizeof(scratch);
+  }
+  why[0] = '\0';
+
+  nabd_control_t *ctrl = q->ctrl;
+  if (ctrl->magic != NABD_MAGIC) {
+    snprintf(why, size, "bad magic 0x%016llx",
+             (unsigned long long)ctrl->magic);
+    return NABD_CORRUPTED;
+  }
+  if (ctrl->version != NABD_LAYOUT_VERSION) {
+    snprintf(why, size, "layout version %llu, expected %u",
+             (unsigned long long)ctrl->version, NABD_LAYOUT_VERSION);
+    return NABD_CORRUPTED;
+  }
+
+  /* After a resize the header describes a ring this handle has not mapped */
+  if (layout_stale(q))
+    return NABD_RESIZED;
+
+  if (ctrl->capacity != q->capacity || ctrl->slot_size != q->slot_size ||
+      ctrl->buffer_offset != sizeof(nabd_control_t)) {
+    snprintf(why, size,
+             "geometry changed to %llu slots of %llu bytes at offset %llu",
+             (unsigned long long)ctrl->capacity,
+             (unsigned long long)ctrl->slot_size,
+             (unsigned long long)ctrl->buffer_offset);
+    return NABD_CORRUPTED;
+  }
+
+  /* A shrunk segment would fault on the next access to its tail */
+  struct stat st;
+  if (fstat(q->fd, &st) == 0 && (size_t)st.st_size < q->size) {
+    snprintf(why, size, "segment is %lld bytes, expected %zu",
+             (long long)st.st_size, q->size);
+    return NABD_CORRUPTED;
+  }
+
+  /* Broadcast consumers keep private cursors, so the shared tail is unused */
+  if (q->broadcast)
+    return NABD_OK;
+
+  /*
+   * tail <= head <= tail + capacity must always hold. Loading tail on both
+   * sides of head keeps concurrent pushes and pops from tripping either
+   * bound.
+   */
+  uint64_t before = NABD_LOAD_ACQUIRE(&ctrl->tail);
+  uint64_t head = NABD_LOAD_ACQUIRE(&ctrl->head);
+  uint64_t after = NABD_LOAD_ACQUIRE(&ctrl->tail);
+  if (before > head) {
+    snprintf(why, size, "tail %llu is ahead of head %llu",
+             (unsigned long long)before, (unsigned long long)head);
+    return NABD_CORRUPTED;
+  }
+  if (head > after && head - after > q->capacity) {
+    snprintf(why, size, "head %llu is more than %zu slots ahead of tail %llu",
+             (unsigned long long)head, q->capacity,
+             (unsigned long long)after);
+    return NABD_CORRUPTED;
+  }
+
+  return NABD_OK;
+}
+
+/*
+ * Get creation metadata
+ */
+int nabd_info(nabd_t *q, nabd_info_t *info) {
+  if (!q || !info)
+    return NABD_INVALID;
+
+  info->version = q->ctrl->version;
+  info->capacity = q->ctrl->capacity;
+  info->slot_size = q->ctrl->slot_size;
+  info->mode = q->ctrl->mode;
+  info->creator_pid = q->ctrl->creator_pid;
+  info->created_ns = q->ctrl->created_ns;
+
+  return NABD_OK;
+}
+
+/*
+ * Get the creation mode
+ */
+int nabd_mode(nabd_t *q) {
+  if (!q)
+    return NABD_INVALID;
+
+  return (int)q->ctrl->mode;
+}
+
+/*
+ * Get the next message sequence number
+ */
+uint64_t nabd_seq(nabd_t *q) {
+  if (!q)
+    return 0;
+
+  return NABD_LOAD_ACQUIRE(&q->ctrl->next_seq);
+}
+
+/*
+ * Advance the message sequence counter
+ */
+void nabd_seq_advance(nabd_t *q, uint64_t n) {
+  if (!q)
+    return;
+
+  atomic_fetch_add_explicit(&q->ctrl->next_seq, n, memory_order_release);
+}
+
+/*
+ * Set or clear the draining flag
+ */
+int nabd_set_draining(nabd_t *q, int on) {
+  if (!q)
+    return NABD_INVALID;
+
+  NABD_STORE_RELEASE(&q->ctrl->draining, on ? 1 : 0);
+  return NABD_OK;
+}
+
+/*
+ * Check whether a producer is shutting down
+ */
+int nabd_draining(nabd_t *q) {
+  if (!q)
+    return NABD_INVALID;
+
+  return NABD_LOAD_ACQUIRE(&q->ctrl->draining) ? 1 : 0;
+}
+
 /*
  * Get error string
  */
@@ -513,6 +1615,10 @@ const char *nabd_strerror(int err) {
     return "Permission denied";
   case NABD_SYSERR:
     return "System error";
+  case NABD_LAPPED:
+    return "Consumer lapped";
+  case NABD_RESIZED:
+    return "Queue resized";
   default:
     return "Unknown error";
   }
@@ -0,0 +1,142 @@
+/*
+ * NABD - High-Performance Shared Memory IPC
+ *
+ * Event Loop Integration
+ *
+ * Copyright (c) 2025 Mohamed Yasser
+ * Licensed under MIT License
+ */
+
+#include "../include/nabd/notify.h"
+#include "../include/nabd/internal_impl.h"
+#include "../include/nabd/types.h"
+
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <stdio.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+/*
+ * Wakeup protocol
+ *
+ * Producers write one byte to the FIFO when a push finds the queue empty.
+ * A consumer that pops NABD_EMPTY drains the FIFO and re-checks the queue,
+ * writing the byte back if a message slipped in. Both sides put a seq_cst
+ * fence between their index store and the load of the other index, so at
+ * least one of them sees the other's update and no wakeup is lost.
+ */
+
+/*
+ * Build the FIFO path for a queue name
+ */
+static int notify_path(const char *name, char *path, size_t size) {
+  int n = snprintf(path, size, NABD_FIFO_DIR "/%s.notify",
+                   name[0] == '/' ? name + 1 : name);
+  return (n > 0 && (size_t)n < size) ? 0 : -1;
+}
+
+/*
+ * Open the FIFO read-write so it never reports EOF or blocks on open
+ */
+static int notify_open(struct nabd *q) {
+  char path[PATH_MAX];
+  if (notify_path(q->name, path, sizeof(path)) < 0)
+    return -1;
+
+  int created = mkfifo(path, 0666) == 0;
+  if (!created && errno != EEXIST)
+    return -1;
+
+  q->notify_fd = open(path, O_RDWR | O_NONBLOCK | O_CLOEXEC);
+
+  /* Give the FIFO the same permissions and group as the segment */
+  struct stat st;
+  if (created && q->notify_fd >= 0 && fstat(q->fd, &st) == 0) {
+    fchmod(q->notify_fd, st.st_mode & 0777);
+    (void)fchown(q->notify_fd, (uid_t)-1, st.st_gid);
+  }
+
+  return q->notify_fd;
+}
+
+/*
+ * Write one wakeup byte; a full FIFO is already readable
+ */
+static void notify_write(struct nabd *q) {
+  uint8_t b = 1;
+  ssize_t n = write(q->notify_fd, &b, 1);
+  (void)n;
+}
+
+/*
+ * Get a pollable file descriptor for a consumer handle
+ */
+int nabd_notify_fd(nabd_t *q) {
+  if (!q || !(q->flags & NABD_CONSUMER) || q->broadcast)
+    return NABD_INVALID;
+
+  if (q->notify_fd >= 0)
+    return q->notify_fd;
+
+  if (notify_open(q) < 0)
+    return NABD_SYSERR;
+
+  /* Producers start signalling from here on */
+  atomic_store_explicit(&q->ctrl->notify, 1, memory_order_seq_cst);
+
+  /* Cover messages pushed before the producer saw the flag */
+  uint64_t tail = NABD_LOAD_RELAXED(&q->ctrl->tail);
+  if (NABD_LOAD_ACQUIRE(&q->ctrl->head) != tail)
+    notify_write(q);
+
+  return q->notify_fd;
+}
+
+/*
+ * Producer side: signal if the messages from pos went into an empty queue
+ */
+void nabd_notify_signal(struct nabd *q, uint64_t pos) {
+  atomic_thread_fence(memory_order_seq_cst);
+  if (NABD_LOAD_RELAXED(&q->ctrl->tail) != pos)
+    return;
+
+  if (q->notify_fd < 0 && notify_open(q) < 0)
+    return;
+
+  notify_write(q);
+}
+
+/*
+ * Consumer side: drain the FIFO, then re-arm it if the queue refilled
+ */
+void nabd_notify_rearm(struct nabd *q) {
+  uint8_t buf[64];
+  while (read(q->notify_fd, buf, sizeof(buf)) > 0) {
+  }
+
+  atomic_thread_fence(memory_order_seq_cst);
+  uint64_t tail = NABD_LOAD_RELAXED(&q->ctrl->tail);
+  if (NABD_LOAD_ACQUIRE(&q->ctrl->head) != tail)
+    notify_write(q);
+}
+
+/*
+ * Close the handle's FIFO descriptor
+ */
+void nabd_notify_close(struct nabd *q) {
+  if (q->notify_fd >= 0) {
+    close(q->notify_fd);
+    q->notify_fd = -1;
+  }
+}
+
+/*
+ * Remove the FIFO alongside the segment
+ */
+void nabd_notify_unlink(const char *name) {
+  char path[PATH_MAX];
+  if (notify_path(name, path, sizeof(path)) == 0)
+    unlink(path);
+}
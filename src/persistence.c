@@ -79,8 +79,7 @@ int nabd_diagnose(const char *name, nabd_diagnostic_t *diag) {
   }
 
   /* Check version */
-  uint64_t expected_version = (NABD_VERSION_MAJOR << 16) | NABD_VERSION_MINOR;
-  diag->version_ok = (ctrl->version == expected_version);
+  diag->version_ok = (ctrl->version == NABD_LAYOUT_VERSION);
   if (!diag->version_ok) {
     diag->state = NABD_STATE_VERSION_ERR;
     munmap(ptr, sizeof(nabd_control_t));
@@ -7,12 +7,21 @@
 #include "../include/nabd/backpressure.h"
 #include "../include/nabd/metrics.h"
 #include "../include/nabd/nabd.h"
+#include "../include/nabd/notify.h"
 #include "../include/nabd/persistence.h"
 
 #include <assert.h>
+#include <errno.h>
+#include <fcntl.h>
+#include <poll.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <sys/mman.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+#include <time.h>
+#include <unistd.h>
 
 #define QUEUE_NAME "/nabd_test"
 #define TEST(name) static void test_##name(void)
@@ -47,6 +56,103 @@ TEST(open_close) {
   cleanup();
 }
 
+TEST(open_mode) {
+  cleanup();
+
+  /* Invalid permission bits are rejected */
+  errno = 0;
+  assert(nabd_open_mode(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER,
+                        01777) == NULL);
+  assert(errno == EINVAL);
+
+  /* Mode is applied exactly, regardless of umask */
+  mode_t old = umask(077);
+  nabd_t *q = nabd_open_mode(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER,
+                             0640);
+  umask(old);
+  assert(q);
+
+#ifdef __linux__
+  struct stat st;
+  assert(stat("/dev/shm" QUEUE_NAME, &st) == 0);
+  assert((st.st_mode & 0777) == 0640);
+#endif
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(open_group) {
+  cleanup();
+
+  /* The creator's own group is always allowed */
+  gid_t gid = getgid();
+  nabd_t *q = nabd_open_group(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER,
+                              0660, gid);
+  assert(q);
+
+#ifdef __linux__
+  struct stat st;
+  assert(stat("/dev/shm" QUEUE_NAME, &st) == 0);
+  assert(st.st_gid == gid && (st.st_mode & 0777) == 0660);
+  assert(stat("/dev/shm" QUEUE_NAME ".ref", &st) == 0);
+  assert(st.st_gid == gid && (st.st_mode & 0777) == 0660);
+#endif
+
+  /* Attaching ignores the group */
+  nabd_t *c = nabd_open_group(QUEUE_NAME, 0, 0, NABD_CONSUMER, 0, gid + 1);
+  assert(c);
+  nabd_close(c);
+  nabd_close(q);
+  cleanup();
+
+  /* Without privilege a foreign group fails and leaves nothing behind */
+  if (geteuid() != 0 && getegid() != 0) {
+    errno = 0;
+    assert(nabd_open_group(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER,
+                           0660, 0) == NULL);
+    assert(errno == EPERM);
+    assert(nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER) == NULL);
+  }
+  cleanup();
+}
+
+TEST(mlock) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 16, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER |
+                            NABD_MLOCK);
+  if (!q) {
+    /* Not permitted here: the error must come from mlock */
+    assert(errno == EPERM || errno == ENOMEM);
+    return;
+  }
+
+  int val = 7;
+  size_t len = sizeof(val);
+  assert(nabd_push(q, &val, sizeof(val)) == NABD_OK);
+  assert(nabd_pop(q, &val, &len) == NABD_OK);
+  assert(val == 7);
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(exists) {
+  cleanup();
+
+  assert(nabd_exists(QUEUE_NAME) == 0);
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  assert(q);
+  assert(nabd_exists(QUEUE_NAME) == 1);
+  nabd_close(q);
+
+  cleanup();
+  assert(nabd_exists(QUEUE_NAME) == 0);
+}
+
 TEST(push_pop) {
   cleanup();
 
@@ -80,6 +186,9 @@ TEST(empty_full) {
 
   assert(nabd_empty(q) == 1);
   assert(nabd_full(q) == 0);
+  assert(nabd_free(q) == 4);
+  assert(nabd_mapped_size(q) == 256 + 4 * 64);
+  assert(nabd_mapped_size(NULL) == 0);
 
   /* Fill buffer */
   for (int i = 0; i < 4; i++) {
@@ -89,6 +198,7 @@ TEST(empty_full) {
 
   assert(nabd_empty(q) == 0);
   assert(nabd_full(q) == 1);
+  assert(nabd_free(q) == 0);
 
   /* Try to push when full */
   int extra = 999;
@@ -123,6 +233,34 @@ TEST(peek_release) {
   /* Now empty */
   assert(nabd_peek(q, &data, &len) == NABD_EMPTY);
 
+  /* Peek by position */
+  for (val = 0; val < 3; val++) {
+    assert(nabd_push(q, &val, sizeof(val)) == NABD_OK);
+  }
+  assert(nabd_peek_at(q, 3, &data, &len) == NABD_OK);
+  assert(*(int *)data == 2);
+  assert(nabd_peek_at(q, 4, &data, &len) == NABD_EMPTY);
+  assert(nabd_peek_at(q, 0, &data, &len) == NABD_INVALID);
+
+  /* Seeking back replays consumed messages still in the ring */
+  assert(nabd_seek(q, 0) == NABD_OK);
+  assert(nabd_peek(q, &data, &len) == NABD_OK);
+  assert(*(int *)data == 42);
+  assert(nabd_seek(q, 5) == NABD_INVALID);
+  for (val = 0; val < 13; val++) {
+    assert(nabd_seek(q, 4 + val) == NABD_OK);
+    assert(nabd_push(q, &val, sizeof(val)) == NABD_OK);
+  }
+  assert(nabd_seek(q, 1) == NABD_LAPPED);
+  assert(nabd_seek(q, 2) == NABD_OK);
+
+  /* Skipping ahead is never lapped, even to the tail of a full ring */
+  assert(nabd_push(q, &val, sizeof(val)) == NABD_OK);
+  assert(nabd_full(q) == 1);
+  assert(nabd_seek(q, 2) == NABD_OK);
+  assert(nabd_seek(q, 1) == NABD_LAPPED);
+  assert(nabd_seek(q, 3) == NABD_OK);
+
   nabd_close(q);
   cleanup();
 }
@@ -139,6 +277,8 @@ TEST(reserve_commit) {
 
   /* Write directly to slot */
   memcpy(slot, "direct", 7);
+  assert(nabd_flush(q) == NABD_OK);
+  assert(nabd_flush(NULL) == NABD_INVALID);
   assert(nabd_commit(q, 7) == NABD_OK);
 
   /* Pop should get our data */
@@ -147,10 +287,319 @@ TEST(reserve_commit) {
   assert(nabd_pop(q, buf, &len) == NABD_OK);
   assert(strcmp(buf, "direct") == 0);
 
+  /* An aborted reservation publishes nothing and blocks plain pushes */
+  assert(nabd_abort(q) == NABD_INVALID);
+  assert(nabd_reserve(q, 10, &slot) == NABD_OK);
+  assert(nabd_push(q, "x", 1) == NABD_INVALID);
+  assert(nabd_abort(q) == NABD_OK);
+  assert(nabd_empty(q) == 1);
+  assert(nabd_push(q, "x", 1) == NABD_OK);
+  nabd_close(q);
+  cleanup();
+
+  /* Modes that overwrite could not undo an abort, so they never reserve */
+  int modes[] = {NABD_BROADCAST, NABD_OVERWRITE};
+  for (int i = 0; i < 2; i++) {
+    q = nabd_open(QUEUE_NAME, 2, 64, NABD_CREATE | NABD_PRODUCER | modes[i]);
+    assert(q);
+    assert(nabd_push(q, "a", 1) == NABD_OK);
+    assert(nabd_push(q, "b", 1) == NABD_OK);
+    assert(nabd_reserve(q, 10, &slot) == NABD_INVALID);
+    assert(nabd_abort(q) == NABD_INVALID);
+    assert(nabd_push(q, "c", 1) == NABD_OK);
+    nabd_close(q);
+    cleanup();
+  }
+}
+
+TEST(push_large) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 8, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+
+  char big[200], out[256];
+  for (size_t i = 0; i < sizeof(big); i++)
+    big[i] = (char)i;
+
+  /* 200 bytes take 4 slots of 56, leaving 3 free */
+  assert(nabd_push(q, "a", 1) == NABD_OK);
+  assert(nabd_push_large(q, big, sizeof(big)) == NABD_OK);
+  assert(nabd_push_large(q, big, sizeof(big)) == NABD_FULL);
+  assert(nabd_push_large(q, "b", 1) == NABD_OK);
+  assert(nabd_push_large(q, big, 1000) == NABD_TOOBIG);
+
+  const void *data;
+  size_t len = sizeof(out);
+  assert(nabd_pop(q, out, &len) == NABD_OK && len == 1);
+  assert(nabd_peek(q, &data, &len) == NABD_TOOBIG);
+
+  /* Too small a buffer reports the total and leaves it queued */
+  len = 100;
+  assert(nabd_pop(q, out, &len) == NABD_TOOBIG && len == sizeof(big));
+
+  size_t lens[4], popped;
+  assert(nabd_pop_batch(q, out, sizeof(out), lens, 4, &popped) == NABD_OK);
+  assert(popped == 1 && lens[0] == sizeof(big));
+  assert(memcmp(out, big, sizeof(big)) == 0);
+
+  len = sizeof(out);
+  assert(nabd_pop(q, out, &len) == NABD_OK && len == 1 && out[0] == 'b');
+  assert(nabd_empty(q) == 1);
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(push_batch) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 4, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+
+  const char msgs[] = "one\0two\0three\0four\0five";
+  size_t lens[] = {4, 4, 6, 5, 5};
+  size_t pushed;
+
+  /* Only four fit */
+  assert(nabd_push_batch(q, msgs, lens, 5, &pushed) == NABD_FULL);
+  assert(pushed == 4);
+
+  char buf[64];
+  size_t len = sizeof(buf);
+  assert(nabd_pop(q, buf, &len) == NABD_OK);
+  assert(strcmp(buf, "one") == 0);
+
+  /* Oversized message stops the batch at its index */
+  char mixed[3 + 128] = "ok";
+  size_t mixed_lens[] = {3, 128};
+  assert(nabd_push_batch(q, mixed, mixed_lens, 2, &pushed) == NABD_TOOBIG);
+  assert(pushed == 1);
+
+  /* The atomic variant publishes nothing unless the whole batch fits */
+  assert(nabd_push_batch_atomic(q, msgs, lens, 5) == NABD_INVALID);
+  assert(nabd_push_batch_atomic(q, mixed, mixed_lens, 1) == NABD_FULL);
+  len = sizeof(buf);
+  assert(nabd_pop(q, buf, &len) == NABD_OK);
+  assert(nabd_push_batch_atomic(q, mixed, mixed_lens, 2) == NABD_TOOBIG);
+  assert(nabd_push_batch_atomic(q, mixed, mixed_lens, 1) == NABD_OK);
+  assert(nabd_push_batch_atomic(q, mixed, mixed_lens, 1) == NABD_FULL);
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(pop_batch) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 8, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+
+  char buf[4 * 16];
+  size_t lens[4];
+  size_t popped;
+  assert(nabd_pop_batch(q, buf, 16, lens, 4, &popped) == NABD_EMPTY);
+
+  assert(nabd_push(q, "one", 4) == NABD_OK);
+  assert(nabd_push(q, "two", 4) == NABD_OK);
+  assert(nabd_push(q, "a message too long", 19) == NABD_OK);
+
+  /* Stops before the message that does not fit */
+  assert(nabd_pop_batch(q, buf, 16, lens, 4, &popped) == NABD_OK);
+  assert(popped == 2);
+  assert(lens[0] == 4 && strcmp(buf, "one") == 0);
+  assert(lens[1] == 4 && strcmp(buf + 16, "two") == 0);
+
+  assert(nabd_pop_batch(q, buf, 16, lens, 4, &popped) == NABD_TOOBIG);
+  assert(popped == 0);
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(pop_batchv) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 8, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+
+  char small[4], large[32];
+  void *bufs[] = {large, small, large};
+  size_t sizes[] = {sizeof(large), sizeof(small), sizeof(large)};
+  size_t lens[3];
+  size_t popped;
+  assert(nabd_pop_batchv(q, bufs, sizes, lens, 3, &popped) == NABD_EMPTY);
+
+  assert(nabd_push(q, "a longer one", 13) == NABD_OK);
+  assert(nabd_push(q, "too long", 9) == NABD_OK);
+
+  /* The second message does not fit the small buffer and stays queued */
+  assert(nabd_pop_batchv(q, bufs, sizes, lens, 3, &popped) == NABD_TOOBIG);
+  assert(popped == 1);
+  assert(lens[0] == 13 && strcmp(large, "a longer one") == 0);
+
+  assert(nabd_pop_batchv(q, bufs, sizes, lens, 3, &popped) == NABD_OK);
+  assert(popped == 1);
+  assert(lens[0] == 9 && strcmp(large, "too long") == 0);
+
+  nabd_close(q);
+  cleanup();
+}
+
+TEST(drain) {
+  cleanup();
+
+  nabd_t *q = nabd_open(QUEUE_NAME, 8, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+
+  for (int i = 0; i < 5; i++) {
+    assert(nabd_push(q, &i, sizeof(i)) == NABD_OK);
+  }
+
+  uint64_t discarded;
+  assert(nabd_drain(q, &discarded) == NABD_OK);
+  assert(discarded == 5);
+  assert(nabd_empty(q) == 1);
+
   nabd_close(q);
   cleanup();
 }
 
+TEST(broadcast) {
+  cleanup();
+
+  nabd_t *prod = nabd_open(QUEUE_NAME, 8, 64,
+                           NABD_CREATE | NABD_PRODUCER | NABD_BROADCAST);
+  assert(prod);
+  nabd_t *a = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  nabd_t *b = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(a && b);
+
+  /* Both consumers see every message */
+  for (int i = 0; i < 4; i++) {
+    assert(nabd_push(prod, &i, sizeof(i)) == NABD_OK);
+  }
+  for (int i = 0; i < 4; i++) {
+    int val;
+    size_t len = sizeof(val);
+    assert(nabd_pop(a, &val, &len) == NABD_OK);
+    assert(val == i);
+    len = sizeof(val);
+    assert(nabd_pop(b, &val, &len) == NABD_OK);
+    assert(val == i);
+  }
+
+  /* Producer never fills; a slow consumer is lapped */
+  for (int i = 0; i < 20; i++) {
+    assert(nabd_push(prod, &i, sizeof(i)) == NABD_OK);
+  }
+  assert(nabd_lag(a) == 20);
+
+  int val;
+  size_t len = sizeof(val);
+  assert(nabd_pop(a, &val, &len) == NABD_LAPPED);
+  assert(nabd_lag(a) < 8);
+  len = sizeof(val);
+  assert(nabd_pop(a, &val, &len) == NABD_OK);
+  assert(val > 12);
+
+  uint64_t discarded;
+  assert(nabd_drain(b, &discarded) == NABD_OK);
+  assert(discarded == 20);
+  assert(nabd_empty(b) == 1);
+
+  nabd_close(a);
+  nabd_close(b);
+  nabd_close(prod);
+  cleanup();
+}
+
+TEST(overwrite) {
+  cleanup();
+
+  assert(nabd_open(QUEUE_NAME, 8, 64,
+                   NABD_CREATE | NABD_PRODUCER | NABD_BROADCAST |
+                       NABD_OVERWRITE) == NULL);
+  assert(errno == EINVAL);
+
+  nabd_t *prod = nabd_open(QUEUE_NAME, 4, 64,
+                           NABD_CREATE | NABD_PRODUCER | NABD_OVERWRITE);
+  assert(prod);
+  assert(nabd_mode(prod) == NABD_OVERWRITE);
+  nabd_t *cons = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(cons);
+
+  /* Pushing into a full ring drops the oldest messages */
+  for (int i = 0; i < 6; i++) {
+    assert(nabd_push(prod, &i, sizeof(i)) == NABD_OK);
+  }
+
+  int val;
+  size_t len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_LAPPED);
+  for (int i = 2; i < 6; i++) {
+    len = sizeof(val);
+    assert(nabd_pop(cons, &val, &len) == NABD_OK);
+    assert(val == i);
+  }
+  len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_EMPTY);
+
+  /* No loss, no lap */
+  assert(nabd_push(prod, &val, sizeof(val)) == NABD_OK);
+  len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_OK);
+
+  const void *data;
+  assert(nabd_peek(cons, &data, &len) == NABD_INVALID);
+
+  nabd_close(cons);
+  nabd_close(prod);
+  cleanup();
+}
+
+static int fd_readable(int fd) {
+  struct pollfd pfd = {.fd = fd, .events = POLLIN};
+  return poll(&pfd, 1, 0) == 1 && (pfd.revents & POLLIN);
+}
+
+TEST(notify_fd) {
+  cleanup();
+
+  nabd_t *prod = nabd_open(QUEUE_NAME, 8, 64, NABD_CREATE | NABD_PRODUCER);
+  nabd_t *cons = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(prod && cons);
+
+  assert(nabd_notify_fd(prod) == NABD_INVALID);
+  int fd = nabd_notify_fd(cons);
+  assert(fd >= 0);
+  assert(!fd_readable(fd));
+
+  int val = 1;
+  assert(nabd_push(prod, &val, sizeof(val)) == NABD_OK);
+  assert(nabd_push(prod, &val, sizeof(val)) == NABD_OK);
+  assert(fd_readable(fd));
+
+  /* Level-triggered: stays readable until a pop finds the queue empty */
+  size_t len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_OK);
+  assert(fd_readable(fd));
+  len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_OK);
+  len = sizeof(val);
+  assert(nabd_pop(cons, &val, &len) == NABD_EMPTY);
+  assert(!fd_readable(fd));
+
+  nabd_close(cons);
+  nabd_close(prod);
+  cleanup();
+}
+
 TEST(metrics) {
   cleanup();
 
@@ -167,6 +616,11 @@ TEST(metrics) {
   assert(metrics.head == 10);
   assert(metrics.pending == 10);
   assert(metrics.fill_pct > 0);
+  assert(metrics.total_pushed == 10);
+  assert(metrics.bytes_pushed == 10 * sizeof(int));
+  assert(metrics.full_events == 0);
+  assert(metrics.high_water == 10);
+  assert(nabd_high_water(q) == 10);
 
   /* Test format */
   char buf[512];
@@ -177,6 +631,204 @@ TEST(metrics) {
   cleanup();
 }
 
+TEST(seq) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  assert(p);
+  assert(nabd_seq(p) == 0);
+  nabd_seq_advance(p, 3);
+  nabd_close(p);
+
+  /* A reattaching producer continues the sequence */
+  p = nabd_open(QUEUE_NAME, 0, 0, NABD_PRODUCER);
+  assert(p);
+  assert(nabd_seq(p) == 3);
+  nabd_close(p);
+
+  cleanup();
+}
+
+TEST(draining) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  nabd_t *c = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(p && c);
+  assert(nabd_draining(c) == 0);
+
+  assert(nabd_set_draining(p, 1) == NABD_OK);
+  assert(nabd_draining(c) == 1);
+  nabd_close(p);
+
+  /* Consumers attaching later still see it */
+  nabd_t *c2 = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(c2);
+  assert(nabd_draining(c2) == 1);
+  nabd_close(c2);
+
+  /* A new producer clears it */
+  p = nabd_open(QUEUE_NAME, 0, 0, NABD_PRODUCER);
+  assert(p);
+  assert(nabd_draining(c) == 0);
+  assert(nabd_draining(NULL) == NABD_INVALID);
+
+  nabd_close(p);
+  nabd_close(c);
+  cleanup();
+}
+
+TEST(resize) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 4, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  nabd_t *c = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(p && c);
+
+  int val;
+  size_t len;
+  for (int i = 0; i < 4; i++)
+    assert(nabd_push(p, &i, sizeof(i)) == NABD_OK);
+  len = sizeof(val);
+  assert(nabd_pop(c, &val, &len) == NABD_OK && val == 0);
+
+  /* Shrinking needs an empty queue */
+  assert(nabd_resize(p, 2) == NABD_INVALID);
+  nabd_stats_t stats;
+  assert(nabd_resize(p, 13) == NABD_OK);
+  assert(nabd_stats(p, &stats) == NABD_OK && stats.capacity == 16);
+
+  /* The other handle must remap before touching the ring */
+  len = sizeof(val);
+  assert(nabd_pop(c, &val, &len) == NABD_RESIZED);
+  assert(nabd_remap(c) == NABD_OK);
+  assert(nabd_stats(c, &stats) == NABD_OK && stats.capacity == 16);
+
+  for (int i = 4; i < 17; i++)
+    assert(nabd_push(p, &i, sizeof(i)) == NABD_OK);
+  assert(nabd_push(p, &val, sizeof(val)) == NABD_FULL);
+
+  for (int i = 1; i < 17; i++) {
+    len = sizeof(val);
+    assert(nabd_pop(c, &val, &len) == NABD_OK);
+    assert(val == i);
+  }
+
+  assert(nabd_resize(c, 8) == NABD_OK);
+  assert(nabd_remap(p) == NABD_OK);
+  assert(nabd_stats(p, &stats) == NABD_OK && stats.capacity == 8);
+
+  nabd_close(c);
+  nabd_close(p);
+  cleanup();
+}
+
+TEST(info) {
+  cleanup();
+
+  time_t before = time(NULL);
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  nabd_t *c = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(p && c);
+
+  nabd_info_t info;
+  assert(nabd_info(c, &info) == NABD_OK);
+  assert(info.creator_pid == (uint64_t)getpid());
+  assert(info.created_ns / 1000000000ULL >= (uint64_t)before);
+  assert(info.created_ns / 1000000000ULL <= (uint64_t)time(NULL));
+  assert(info.capacity == 16 && info.slot_size == 64 && info.mode == 0);
+
+  nabd_close(c);
+  nabd_close(p);
+  cleanup();
+}
+
+TEST(version_check) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  assert(p);
+
+  nabd_info_t info;
+  assert(nabd_info(p, &info) == NABD_OK);
+  assert(info.version == NABD_LAYOUT_VERSION);
+
+  /* Pretend another release created the queue */
+  int fd = shm_open(QUEUE_NAME, O_RDWR, 0);
+  assert(fd >= 0);
+  uint64_t *hdr = mmap(NULL, 64, PROT_READ | PROT_WRITE, MAP_SHARED, fd, 0);
+  assert(hdr != MAP_FAILED);
+  close(fd);
+
+  hdr[1] = NABD_LAYOUT_VERSION + 1;
+  errno = 0;
+  assert(nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER) == NULL);
+  assert(errno == EPROTO);
+
+  /* Nor is a queue made by 0.1, which had a shorter header */
+  hdr[1] = (0 << 16) | 1;
+  errno = 0;
+  assert(nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER) == NULL);
+  assert(errno == EPROTO);
+
+  hdr[1] = NABD_LAYOUT_VERSION;
+  hdr[0] = 0;
+  errno = 0;
+  assert(nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER) == NULL);
+  assert(errno == EPROTO);
+
+  munmap(hdr, 64);
+  nabd_close(p);
+  cleanup();
+}
+
+TEST(attached) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64, NABD_CREATE | NABD_PRODUCER);
+  nabd_t *c = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(p && c);
+  assert(nabd_attached(p) == 2);
+
+  /* A process that dies without closing is not counted */
+  pid_t pid = fork();
+  assert(pid >= 0);
+  if (pid == 0) {
+    nabd_t *child = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+    _exit(child && nabd_attached(child) == 3 ? 0 : 1);
+  }
+  int status;
+  assert(waitpid(pid, &status, 0) == pid);
+  assert(WIFEXITED(status) && WEXITSTATUS(status) == 0);
+  assert(nabd_attached(c) == 2);
+
+  assert(nabd_unlink_idle(QUEUE_NAME) == 0);
+  nabd_close(c);
+  assert(nabd_attached(p) == 1);
+  assert(nabd_unlink_idle(QUEUE_NAME) == 0);
+  nabd_close(p);
+
+  assert(nabd_unlink_idle(QUEUE_NAME) == 1);
+  assert(nabd_exists(QUEUE_NAME) == 0);
+  assert(nabd_unlink_idle(QUEUE_NAME) == NABD_SYSERR);
+}
+
+TEST(unlink_last) {
+  cleanup();
+
+  nabd_t *p = nabd_open(QUEUE_NAME, 16, 64,
+                        NABD_CREATE | NABD_PRODUCER | NABD_UNLINK_LAST);
+  nabd_t *c = nabd_open(QUEUE_NAME, 0, 0, NABD_CONSUMER);
+  assert(p && c);
+
+  /* The producer closes first; the consumer still holds the queue */
+  nabd_close(p);
+  assert(nabd_exists(QUEUE_NAME) == 1);
+  nabd_close(c);
+  assert(nabd_exists(QUEUE_NAME) == 0);
+}
+
 TEST(fill_level) {
   cleanup();
 
@@ -215,6 +867,49 @@ TEST(diagnose) {
   cleanup();
 }
 
+TEST(check) {
+  cleanup();
+
+  nabd_t *q =
+      nabd_open(QUEUE_NAME, 4, 64, NABD_CREATE | NABD_PRODUCER | NABD_CONSUMER);
+  assert(q);
+  char why[128];
+  assert(nabd_check(q, why, sizeof(why)) == NABD_OK);
+  assert(why[0] == '\0');
+  assert(nabd_check(q, NULL, 0) == NABD_OK);
+  assert(nabd_check(NULL, why, sizeof(why)) == NABD_INVALID);
+
+  nabd_push(q, "x", 1);
+
+  /* Stomp the indices: head is the second line, tail the third */
+  int fd = shm_open(QUEUE_NAME, O_RDWR, 0);
+  assert(fd >= 0);
+  uint64_t *ctrl = mmap(NULL, 256, PROT_READ | PROT_WRITE, MAP_SHARED, fd, 0);
+  assert(ctrl != MAP_FAILED);
+  close(fd);
+
+  ctrl[16] = 7;
+  assert(nabd_check(q, why, sizeof(why)) == NABD_CORRUPTED);
+  assert(strstr(why, "tail 7"));
+
+  ctrl[16] = 0;
+  ctrl[8] = 9;
+  assert(nabd_check(q, why, sizeof(why)) == NABD_CORRUPTED);
+  assert(strstr(why, "head 9"));
+
+  ctrl[8] = 1;
+  ctrl[0] = 0;
+  assert(nabd_check(q, why, sizeof(why)) == NABD_CORRUPTED);
+  assert(strstr(why, "magic"));
+
+  ctrl[0] = NABD_MAGIC;
+  assert(nabd_check(q, why, sizeof(why)) == NABD_OK);
+
+  munmap(ctrl, 256);
+  nabd_close(q);
+  cleanup();
+}
+
 TEST(strerror) {
   assert(strcmp(nabd_strerror(NABD_OK), "Success") == 0);
   assert(strcmp(nabd_strerror(NABD_EMPTY), "Buffer empty") == 0);
@@ -227,13 +922,35 @@ int main(void) {
   printf("===================\n\n");
 
   RUN_TEST(open_close);
+  RUN_TEST(open_mode);
+  RUN_TEST(open_group);
+  RUN_TEST(mlock);
+  RUN_TEST(exists);
   RUN_TEST(push_pop);
   RUN_TEST(empty_full);
   RUN_TEST(peek_release);
   RUN_TEST(reserve_commit);
+  RUN_TEST(push_large);
+  RUN_TEST(push_batch);
+  RUN_TEST(pop_batch);
+  RUN_TEST(pop_batchv);
+  RUN_TEST(drain);
+  RUN_TEST(broadcast);
+  RUN_TEST(overwrite);
+  RUN_TEST(notify_fd);
+  RUN_TEST(seq);
+  RUN_TEST(draining);
+#ifndef __APPLE__
+  RUN_TEST(resize);
+#endif
+  RUN_TEST(info);
+  RUN_TEST(version_check);
+  RUN_TEST(attached);
+  RUN_TEST(unlink_last);
   RUN_TEST(metrics);
   RUN_TEST(fill_level);
   RUN_TEST(diagnose);
+  RUN_TEST(check);
   RUN_TEST(strerror);
 
   printf("\n%d tests passed!\n", passed);